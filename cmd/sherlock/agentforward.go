@@ -0,0 +1,78 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// forwardAgentUsage describes the forward-agent command.
+const forwardAgentUsage = "usage: forward-agent [on|off]"
+
+// agentForwardIndicator is shown in the prompt while the local SSH agent is
+// forwarded, since the remote host can then use its keys.
+const agentForwardIndicator = "(agent fwd)"
+
+// handleForwardAgent shows or changes agent forwarding for the current host.
+func (a *App) handleForwardAgent(args []string) error {
+	if a.sshClient == nil || !a.sshClient.IsOpen() {
+		return errors.New("agent forwarding needs a remote connection")
+	}
+	if len(args) == 0 {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Agent forwarding:"), onOff(a.sshClient.ForwardAgent()))
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New(forwardAgentUsage)
+	}
+
+	var on bool
+	switch strings.ToLower(args[0]) {
+	case "on", "yes", "true":
+		on = true
+	case "off", "no", "false":
+		on = false
+	default:
+		return errors.New(forwardAgentUsage)
+	}
+	if on == a.sshClient.ForwardAgent() {
+		fmt.Println(a.theme.FormatInfo(fmt.Sprintf("Agent forwarding is already %s.", onOff(on))))
+		return nil
+	}
+	if err := a.sshClient.SetForwardAgent(on); err != nil {
+		return err
+	}
+
+	if on {
+		fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Agent forwarding enabled for %s.", a.sshClient.HostInfoString())))
+		fmt.Println(a.theme.FormatWarning("Anyone with root access on the remote host can use your local keys while it is on."))
+	} else {
+		fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Agent forwarding disabled for %s.", a.sshClient.HostInfoString())))
+	}
+	if a.sshClient.ShellMode() == "persistent" {
+		fmt.Println(a.theme.FormatInfo("The persistent shell restarts with the next command; exported variables are reset."))
+	}
+	return nil
+}
+
+// onOff spells a setting for display.
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
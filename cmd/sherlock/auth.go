@@ -0,0 +1,58 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// promptAuth asks a question during authentication, such as a key passphrase
+// or a one-time code. Input is hidden unless the server wants it echoed.
+func (a *App) promptAuth(instruction, question string, echo bool) (string, error) {
+	if instruction != "" {
+		fmt.Println(a.theme.FormatInfo(instruction))
+	}
+	fmt.Print(a.theme.FormatInfo(authQuestion(question)))
+
+	fd := int(os.Stdin.Fd())
+	if !echo && term.IsTerminal(fd) {
+		answer, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("failed to read input: %w", err)
+		}
+		return string(answer), nil
+	}
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimRight(answer, "\r\n"), nil
+}
+
+// authQuestion makes sure a question from the server ends in a space, so the
+// answer is not typed right against it.
+func authQuestion(question string) string {
+	question = strings.TrimRight(question, " ")
+	if question == "" {
+		question = "Response:"
+	}
+	return question + " "
+}
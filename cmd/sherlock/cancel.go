@@ -0,0 +1,97 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// commandContext returns the context for running one command. Ctrl-C
+// cancels it, and it ends after the timeout given with "timeout <duration>",
+// or else after the configured execution timeout, if any.
+func (a *App) commandContext() (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := a.commandTimeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(a.ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(a.ctx)
+	}
+
+	a.cmdMu.Lock()
+	a.cmdCancel = cancel
+	a.cmdMu.Unlock()
+	return ctx, func() {
+		a.cmdMu.Lock()
+		a.cmdCancel = nil
+		a.cmdMu.Unlock()
+		cancel()
+	}
+}
+
+// commandTimeout returns the time limit for a command, or 0 for none.
+func (a *App) commandTimeout() time.Duration {
+	if a.cmdTimeout > 0 {
+		return a.cmdTimeout
+	}
+	return time.Duration(a.cfg.Execution.Timeout) * time.Second
+}
+
+// interruptCommand stops the running command, if any, and reports whether
+// there was one.
+func (a *App) interruptCommand() bool {
+	a.cmdMu.Lock()
+	defer a.cmdMu.Unlock()
+	if a.cmdCancel == nil {
+		return false
+	}
+	fmt.Println(a.theme.FormatWarning("\nInterrupting command... (press Ctrl+C again to disconnect or exit)"))
+	a.cmdCancel()
+	a.cmdCancel = nil
+	return true
+}
+
+// stoppedError describes a command stopped by Ctrl-C or a timeout.
+func (a *App) stoppedError(result *sshclient.ExecuteResult) error {
+	if result.TimedOut {
+		return fmt.Errorf("%w after %s", sshclient.ErrTimedOut, a.commandTimeout())
+	}
+	return result.Error
+}
+
+// splitTimeoutPrefix splits "timeout <duration> <request>" into the duration
+// and the request. The duration needs a unit, e.g. 30s or 5m, so that a
+// request that merely starts with the word is not taken for one.
+func splitTimeoutPrefix(input string) (time.Duration, string, bool) {
+	fields := strings.Fields(input)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "timeout") {
+		return 0, input, false
+	}
+	timeout, err := time.ParseDuration(fields[1])
+	if err != nil || timeout <= 0 {
+		return 0, input, false
+	}
+	rest := strings.TrimSpace(input)
+	rest = strings.TrimSpace(rest[len(fields[0]):])
+	rest = strings.TrimSpace(rest[len(fields[1]):])
+	return timeout, rest, true
+}
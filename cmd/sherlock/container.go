@@ -0,0 +1,123 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/warm3snow/sherlock/internal/agent"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// containerSession is a container entered on the local host or on the host
+// of a session.
+type containerSession struct {
+	client *sshclient.ContainerClient
+	facts  *agent.HostFacts // detected when entered, for offline translation
+}
+
+// container returns the container commands run in, or nil if they run on
+// the host itself.
+func (a *App) container() *containerSession {
+	if s := a.sessions.active; s != nil {
+		return s.container
+	}
+	return a.localContainer
+}
+
+// setContainer makes commands of the active session, or of the local host,
+// run in c, or on the host itself if c is nil.
+func (a *App) setContainer(c *containerSession) {
+	if s := a.sessions.active; s != nil {
+		s.container = c
+	} else {
+		a.localContainer = c
+	}
+	a.refreshHostContext()
+}
+
+// executor returns where commands run: a container, the connected host or
+// the local host.
+func (a *App) executor() sshclient.Executor {
+	if c := a.container(); c != nil {
+		return c.client
+	}
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		return a.sshClient
+	}
+	return a.localClient
+}
+
+// cwd returns the working directory of the place commands run in.
+func (a *App) cwd() string {
+	if c := a.container(); c != nil {
+		return c.client.GetCwd()
+	}
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		return a.sshClient.GetCwd()
+	}
+	return a.localClient.GetCwd()
+}
+
+// enterContainer makes commands run in the container of target, on the
+// connected host or else the local one.
+func (a *App) enterContainer(target *sshclient.ContainerTarget) error {
+	var host sshclient.Executor = a.localClient
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		host = a.sshClient
+	}
+	fmt.Printf("%s %s on %s...\n", a.theme.FormatInfo("Entering"), target, host.HostInfoString())
+
+	client, err := sshclient.NewContainerClient(a.ctx, host, *target)
+	if err != nil {
+		return err
+	}
+	a.setContainer(&containerSession{client: client, facts: agent.DetectHostFacts(a.ctx, client)})
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Commands now run in %s (working directory %s). Use 'disconnect' to leave it.",
+		containerDescription(client.Target()), client.GetCwd())))
+	return nil
+}
+
+// leaveContainer makes commands run on the host again, and reports whether
+// they ran in a container.
+func (a *App) leaveContainer() bool {
+	c := a.container()
+	if c == nil {
+		return false
+	}
+	a.setContainer(nil)
+	fmt.Printf("Left %s; commands now run on %s.\n", c.client.Target(), c.client.Host().HostInfoString())
+	return true
+}
+
+// containerDescription describes a container for people and the model, e.g.
+// "the Docker container web-1".
+func containerDescription(t sshclient.ContainerTarget) string {
+	switch t.Runtime {
+	case sshclient.RuntimeKubernetes:
+		pod := t.Name
+		if t.Namespace != "" {
+			pod = t.Namespace + "/" + t.Name
+		}
+		if t.Container != "" {
+			return fmt.Sprintf("the container %s of the Kubernetes pod %s", t.Container, pod)
+		}
+		return "the Kubernetes pod " + pod
+	case sshclient.RuntimePodman:
+		return "the Podman container " + t.Name
+	default:
+		return "the Docker container " + t.Name
+	}
+}
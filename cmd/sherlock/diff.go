@@ -0,0 +1,224 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// maxDiffCells bounds the table of the line diff; larger changes are shown
+// as one hunk that replaces everything between the common start and end.
+const maxDiffCells = 4 << 20
+
+// diffOp is one line of a diff: ' ' kept, '-' removed or '+' added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits text into lines without their newlines. A missing
+// final newline is marked the way diff marks it.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		if strings.HasSuffix(line, "\n") {
+			lines[i] = strings.TrimSuffix(line, "\n")
+		} else {
+			lines[i] = line + "\n\\ No newline at end of file"
+		}
+	}
+	return lines
+}
+
+// diffLines returns the operations that turn a into b, from the longest
+// common subsequence of their lines.
+func diffLines(a, b []string) []diffOp {
+	// Lines shared at the start and end need no table
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:start] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[start:len(a)-end], b[start:len(b)-end])...)
+	for _, line := range a[len(a)-end:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle diffs the lines between the common start and end.
+func diffMiddle(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff returns the changes from oldText to newText in the unified
+// format of diff -u, or "" if there are none.
+func unifiedDiff(oldText, newText, oldName, newName string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	// oldLine and newLine count the lines before ops[i] in each file
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// A hunk starts diffContext lines before a change and runs until
+		// more than twice that many lines are unchanged
+		first := max(i-diffContext, 0)
+		for k := first; k < i; k++ {
+			oldLine--
+			newLine--
+		}
+		last := i
+		for k := i; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			} else if k-last > 2*diffContext {
+				break
+			}
+		}
+		stop := min(last+diffContext+1, len(ops))
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[first:stop] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, op := range ops[first:stop] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		oldLine += oldCount
+		newLine += newCount
+		i = stop
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start and length of a hunk; start counts the lines
+// before it, and is shown 1-based unless the hunk is empty.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// colorDiff colors a unified diff with the theme: additions as success,
+// removals as errors and hunk headers as info.
+func (a *App) colorDiff(diff string) string {
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "+++"), strings.HasPrefix(text, "---"):
+			text = a.theme.FormatCommand(text)
+		case strings.HasPrefix(text, "@@"):
+			text = a.theme.FormatInfo(text)
+		case strings.HasPrefix(text, "+"):
+			text = a.theme.FormatSuccess(text)
+		case strings.HasPrefix(text, "-"):
+			text = a.theme.FormatError(text)
+		}
+		sb.WriteString(text)
+		if strings.HasSuffix(line, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
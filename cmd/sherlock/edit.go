@@ -0,0 +1,210 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/warm3snow/sherlock/internal/recording"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+const editUsage = "usage: edit [--check <command>] <path>"
+
+// editRequest is a parsed edit command.
+type editRequest struct {
+	path  string
+	check string // command that validates the saved file; {} is its path
+}
+
+// parseEdit parses the arguments of "edit [--check <command>] <path>".
+func parseEdit(line string) (*editRequest, error) {
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	req := &editRequest{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--check" || arg == "-c":
+			if i+1 >= len(args) || args[i+1] == "" {
+				return nil, fmt.Errorf("%s needs a command", arg)
+			}
+			i++
+			req.check = args[i]
+		case strings.HasPrefix(arg, "--check="):
+			req.check = strings.TrimPrefix(arg, "--check=")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			return nil, fmt.Errorf("unknown edit option: %s", arg)
+		case req.path != "":
+			return nil, fmt.Errorf("%s", editUsage)
+		default:
+			req.path = arg
+		}
+	}
+	if req.path == "" {
+		return nil, fmt.Errorf("%s", editUsage)
+	}
+	return req, nil
+}
+
+// checkCommand returns the validation command for the file at p, with {}
+// replaced by its escaped path.
+func checkCommand(check, p string) string {
+	return strings.ReplaceAll(check, "{}", sshclient.ShellEscape(p))
+}
+
+// editorCommand returns the editor to use: $VISUAL, $EDITOR or vi.
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// editFile opens a file of the current host in the local editor, shows the
+// changes for review, and saves them with a backup. If a check command is
+// given and fails on the saved file, the backup is put back.
+func (a *App) editFile(req *editRequest) error {
+	executor := a.executor()
+	file, err := sshclient.OpenEditFile(a.ctx, executor, req.path)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", req.path, err)
+	}
+	if file.Sudo {
+		fmt.Println(a.theme.FormatInfo("The file is not yours to replace; sudo will be used to save it."))
+	}
+
+	// The local copy keeps the name, so the editor picks the right syntax
+	dir, err := os.MkdirTemp("", "sherlock-edit-")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, path.Base(file.Path))
+	if err := os.WriteFile(local, file.Data, 0600); err != nil {
+		return fmt.Errorf("failed to write the local copy: %w", err)
+	}
+
+	var data []byte
+	for {
+		if err := runEditor(local); err != nil {
+			return err
+		}
+		if data, err = os.ReadFile(local); err != nil {
+			return fmt.Errorf("failed to read the local copy: %w", err)
+		}
+		if bytes.Equal(data, file.Data) {
+			fmt.Println(a.theme.FormatInfo("No changes."))
+			return nil
+		}
+
+		fmt.Println()
+		fmt.Print(a.colorDiff(unifiedDiff(string(file.Data), string(data), "a"+file.Path, "b"+file.Path)))
+		fmt.Print("\n" + a.theme.FormatWarning("Save these changes? [y/N/e(dit again)] "))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer == "e" || answer == "edit" {
+			continue
+		}
+		if answer != "y" && answer != "yes" {
+			fmt.Println(a.theme.FormatInfo("Changes discarded."))
+			return nil
+		}
+		break
+	}
+
+	backup, err := file.Save(a.ctx, data)
+	if errors.Is(err, sshclient.ErrFileChanged) {
+		// Keep the edited version, which the temporary directory takes with it
+		kept, keepErr := os.CreateTemp("", "sherlock-edit-*-"+path.Base(file.Path))
+		if keepErr == nil {
+			_, keepErr = kept.Write(data)
+			if closeErr := kept.Close(); keepErr == nil {
+				keepErr = closeErr
+			}
+		}
+		if keepErr != nil {
+			return fmt.Errorf("%s was changed on the host while you were editing it; it was not saved", file.Path)
+		}
+		return fmt.Errorf("%s was changed on the host while you were editing it; it was not saved, and your version is in %s", file.Path, kept.Name())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Saved %s (backup: %s)", file.Path, backup)))
+	a.record(recording.Entry{
+		Kind:   recording.KindEdit,
+		Path:   file.Path,
+		Diff:   unifiedDiff(string(file.Data), string(data), "a"+file.Path, "b"+file.Path),
+		Backup: backup,
+	})
+
+	if req.check == "" {
+		return nil
+	}
+	check := checkCommand(req.check, file.Path)
+	fmt.Println(a.theme.FormatInfo("Checking: " + check))
+	ctx, cancel := a.commandContext()
+	defer cancel()
+	stdout := sshclient.NewOutputBuffer(a.cfg.Output.MaxKeptBytes)
+	stderr := sshclient.NewOutputBuffer(a.cfg.Output.MaxKeptBytes)
+	liveStdout := io.MultiWriter(&themedWriter{w: os.Stdout, format: a.theme.FormatStdout}, stdout)
+	liveStderr := io.MultiWriter(&themedWriter{w: os.Stderr, format: a.theme.FormatStderr}, stderr)
+	start := time.Now()
+	result := executor.ExecuteStream(ctx, check, liveStdout, liveStderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	a.recordCommand(check, result, stdout.Truncated() || stderr.Truncated(), time.Since(start))
+	if result.Error == nil && result.ExitCode == 0 && !result.Interrupted && !result.TimedOut {
+		fmt.Println(a.theme.FormatSuccess("Check passed."))
+		return nil
+	}
+
+	reason := fmt.Sprintf("exit code %d", result.ExitCode)
+	if result.Error != nil {
+		reason = result.Error.Error()
+	} else if result.Interrupted || result.TimedOut {
+		reason = "stopped"
+	}
+	fmt.Println(a.theme.FormatWarning(fmt.Sprintf("Check failed (%s); restoring %s from %s", reason, file.Path, backup)))
+	if err := file.Restore(a.ctx, backup); err != nil {
+		return err
+	}
+	return fmt.Errorf("check failed; %s was restored and the edited version was not kept", file.Path)
+}
+
+// runEditor runs the editor on a local file, attached to the terminal. The
+// editor is run by the shell, so it may carry arguments such as "code -w".
+func runEditor(file string) error {
+	cmd := exec.Command("sh", "-c", editorCommand()+` "$1"`, "sh", file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// hostKeysUsage describes the hostkeys command.
+const hostKeysUsage = "usage: hostkeys [list [host]], hostkeys remove <host[:port]>, hostkeys retrust <host[:port]>"
+
+// confirmHostKey shows the key of an unknown host and asks whether to trust it.
+func (a *App) confirmHostKey(info sshclient.HostKeyInfo) bool {
+	host := info.Host
+	if info.Address != "" && !strings.Contains(info.Address, strings.Trim(host, "[]")) {
+		host += " (" + info.Address + ")"
+	}
+	fmt.Println(a.theme.FormatWarning(fmt.Sprintf("The authenticity of host '%s' can't be established.", host)))
+	fmt.Printf("%s key fingerprint is %s.\n", info.Type, info.Fingerprint)
+	fmt.Println(info.Randomart)
+	fmt.Print(a.theme.FormatWarning("Trust this host and add its key to known_hosts? [y/N]: "))
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// hostKeyError explains a failed host key check and returns the error to
+// report, or nil if err is not about the host key.
+func (a *App) hostKeyError(err error) error {
+	var changed *sshclient.HostKeyChangedError
+	switch {
+	case errors.As(err, &changed):
+		fmt.Println(a.theme.FormatError("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@"))
+		fmt.Println(a.theme.FormatError("@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @"))
+		fmt.Println(a.theme.FormatError("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@"))
+		fmt.Println(a.theme.FormatWarning(changed.Error()))
+		fmt.Println(a.theme.FormatInfo("If the key was changed on purpose, run: hostkeys retrust " + changed.Host))
+		return errors.New("host key verification failed")
+	case errors.Is(err, sshclient.ErrHostKeyRejected):
+		return errors.New("host key not trusted; connection cancelled")
+	}
+	return nil
+}
+
+// handleHostKeys runs the hostkeys command.
+func (a *App) handleHostKeys(args []string) error {
+	if len(args) == 0 {
+		return a.listHostKeys("")
+	}
+	switch args[0] {
+	case "list", "ls":
+		if len(args) > 2 {
+			return errors.New(hostKeysUsage)
+		}
+		filter := ""
+		if len(args) == 2 {
+			filter = args[1]
+		}
+		return a.listHostKeys(filter)
+	case "remove", "rm":
+		if len(args) != 2 {
+			return errors.New(hostKeysUsage)
+		}
+		return a.removeHostKeys(args[1])
+	case "retrust":
+		if len(args) != 2 {
+			return errors.New(hostKeysUsage)
+		}
+		return a.retrustHostKey(args[1])
+	}
+	return errors.New(hostKeysUsage)
+}
+
+// listHostKeys prints the known_hosts entries, only those for host if set.
+func (a *App) listHostKeys(host string) error {
+	files, _ := sshclient.KnownHostsSettings(host)
+	target := ""
+	if host != "" {
+		var err error
+		if target, err = hostKeyTarget(host); err != nil {
+			return err
+		}
+	}
+	entries, err := sshclient.ReadKnownHosts(files...)
+	if err != nil {
+		return fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("%-32s %-11s %-51s %s", "Host", "Type", "Fingerprint", "Location")))
+	shown := 0
+	for _, entry := range entries {
+		if target != "" && !entry.Matches(target) {
+			continue
+		}
+		hosts := strings.Join(entry.Hosts, ",")
+		if entry.Hashed() {
+			hosts = "(hashed)"
+			if target != "" {
+				hosts = sshclient.NormalizeHost(target) + " (hashed)"
+			}
+		}
+		if entry.Marker != "" {
+			hosts = entry.Marker + " " + hosts
+		}
+		fmt.Printf("%-32s %-11s %-51s %s:%d\n", hosts, entry.KeyType(), entry.Fingerprint(), entry.File, entry.Line)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Println(a.theme.FormatInfo("No known host keys found."))
+	}
+	return nil
+}
+
+// removeHostKeys removes the known_hosts entries for host.
+func (a *App) removeHostKeys(host string) error {
+	target, err := hostKeyTarget(host)
+	if err != nil {
+		return err
+	}
+	files, _ := sshclient.KnownHostsSettings(host)
+	removed := 0
+	for _, file := range files {
+		n, err := sshclient.RemoveKnownHost(file, target)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", file, err)
+		}
+		removed += n
+	}
+	if removed == 0 {
+		fmt.Println(a.theme.FormatInfo(fmt.Sprintf("No known host keys for %s.", sshclient.NormalizeHost(target))))
+		return nil
+	}
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Removed %d key(s) for %s.", removed, sshclient.NormalizeHost(target))))
+	return nil
+}
+
+// retrustHostKey fetches the current key of host, shows it next to the known
+// keys and, if the user agrees, replaces them with it. The key is fetched
+// the way a connection reaches the host, through ProxyJump or ProxyCommand.
+func (a *App) retrustHostKey(host string) error {
+	name, port, err := splitHostKeyTarget(host)
+	if err != nil {
+		return err
+	}
+	if port == 0 {
+		port = 22
+	}
+	client, err := sshclient.NewClient(&sshclient.Config{
+		HostInfo:      &sshclient.HostInfo{Host: name, Port: port},
+		HostKeyPrompt: a.confirmHostKey,
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	info := client.HostInfo()
+	target := net.JoinHostPort(info.Host, strconv.Itoa(info.Port))
+	files, hash := sshclient.KnownHostsSettings(host)
+
+	key, err := client.FetchHostKey()
+	if err != nil {
+		return err
+	}
+	entries, err := sshclient.ReadKnownHosts(files...)
+	if err != nil {
+		return fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Marker != "" || !entry.Matches(target) {
+			continue
+		}
+		if bytes.Equal(entry.Key.Marshal(), key.Marshal()) {
+			fmt.Println(a.theme.FormatInfo(fmt.Sprintf("The current key of %s is already trusted (%s:%d).", sshclient.NormalizeHost(target), entry.File, entry.Line)))
+			return nil
+		}
+		fmt.Printf("%s %s %s (%s:%d)\n", a.theme.FormatInfo("Known key:"), entry.KeyType(), entry.Fingerprint(), entry.File, entry.Line)
+	}
+
+	if !a.confirmHostKey(sshclient.NewHostKeyInfo(target, nil, key)) {
+		fmt.Println(a.theme.FormatInfo("Host key not changed."))
+		return nil
+	}
+	for _, file := range files {
+		if _, err := sshclient.RemoveKnownHost(file, target); err != nil {
+			return fmt.Errorf("failed to update %s: %w", file, err)
+		}
+	}
+	if err := sshclient.AddKnownHost(files[0], target, key, hash); err != nil {
+		return fmt.Errorf("failed to update %s: %w", files[0], err)
+	}
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Now trusting the current key of %s.", sshclient.NormalizeHost(target))))
+	return nil
+}
+
+// hostKeyTarget returns the address whose keys are checked when connecting
+// to host, given as host or host:port, after applying ~/.ssh/config.
+func hostKeyTarget(host string) (string, error) {
+	name, port, err := splitHostKeyTarget(host)
+	if err != nil {
+		return "", err
+	}
+	if config, err := sshclient.ParseSSHConfig(); err == nil {
+		if configHost := config.GetHost(name); configHost != nil {
+			if configHost.Hostname != "" {
+				name = configHost.Hostname
+			}
+			if port == 0 {
+				port = configHost.Port
+			}
+		}
+	}
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(name, strconv.Itoa(port)), nil
+}
+
+// splitHostKeyTarget splits host, host:port or [host]:port. The port is 0 if
+// not given.
+func splitHostKeyTarget(target string) (string, int, error) {
+	if host, portStr, err := net.SplitHostPort(target); err == nil {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid port in %q", target)
+		}
+		return host, port, nil
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
+	if host == "" {
+		return "", 0, errors.New(hostKeysUsage)
+	}
+	return host, 0, nil
+}
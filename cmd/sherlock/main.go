@@ -28,7 +28,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/peterh/liner"
 
@@ -36,6 +38,7 @@ import (
 	"github.com/warm3snow/sherlock/internal/ai"
 	"github.com/warm3snow/sherlock/internal/config"
 	"github.com/warm3snow/sherlock/internal/history"
+	"github.com/warm3snow/sherlock/internal/recording"
 	"github.com/warm3snow/sherlock/internal/theme"
 	"github.com/warm3snow/sherlock/pkg/sshclient"
 )
@@ -60,15 +63,28 @@ func stripANSI(s string) string {
 type App struct {
 	cfg            *config.Config
 	aiClient       ai.ModelClient
+	router         *ai.Router
+	inspector      *ai.Inspector
+	debugLLMFile   *os.File
 	agent          *agent.Agent
-	sshClient      *sshclient.Client
+	sshClient      *sshclient.Client // client of the active session, nil when local
+	sessions       sessionManager
+	localContainer *containerSession // container entered on the local host, if any
 	historyManager *history.Manager
 	localClient    *sshclient.LocalClient
+	localFacts     *agent.HostFacts
 	theme          *theme.Theme
 	ctx            context.Context
 	cancel         context.CancelFunc
 	sigChan        chan os.Signal
 	liner          *liner.State
+	lastCommand    string
+	lastResult     *sshclient.ExecuteResult
+	cmdMu          sync.Mutex          // guards cmdCancel
+	cmdCancel      context.CancelFunc  // stops the running command, if any
+	cmdTimeout     time.Duration       // time limit of the current request, if given
+	recorder       *recording.Recorder // transcript of this session, nil when not recorded
+	request        string              // request whose commands are running, for the transcript
 }
 
 func main() {
@@ -82,13 +98,17 @@ func main() {
 	}
 
 	var (
-		configPath   string
-		showVersion  bool
-		showHelp     bool
-		providerFlag string
-		modelFlag    string
-		baseURLFlag  string
-		apiKeyFlag   string
+		configPath    string
+		showVersion   bool
+		showHelp      bool
+		providerFlag  string
+		modelFlag     string
+		baseURLFlag   string
+		apiKeyFlag    string
+		showReasoning bool
+		debugLLMPath  string
+		persistShell  bool
+		record        bool
 	)
 
 	flag.StringVar(&configPath, "config", "", "Path to configuration file")
@@ -101,6 +121,10 @@ func main() {
 	flag.StringVar(&modelFlag, "model", "", "Model name")
 	flag.StringVar(&baseURLFlag, "base-url", "", "Base URL for LLM API")
 	flag.StringVar(&apiKeyFlag, "api-key", "", "API key for LLM provider")
+	flag.BoolVar(&showReasoning, "show-reasoning", false, "Show the reasoning of thinking models")
+	flag.BoolVar(&persistShell, "persistent-shell", false, "Run remote commands in one long-lived shell per connection")
+	flag.BoolVar(&record, "record", false, "Record the session: a transcript of requests and commands, and interactive commands as asciicasts")
+	flag.StringVar(&debugLLMPath, "debug-llm", "", "Write every model call to a JSONL file (secrets redacted)")
 	flag.Parse()
 
 	if showHelp {
@@ -143,6 +167,15 @@ func main() {
 	if apiKeyFlag != "" {
 		cfg.LLM.APIKey = apiKeyFlag
 	}
+	if showReasoning {
+		cfg.UI.ShowReasoning = true
+	}
+	if persistShell {
+		cfg.SSH.PersistentShell = true
+	}
+	if record {
+		cfg.Recording.Enabled = true
+	}
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
@@ -167,15 +200,19 @@ func main() {
 	}
 
 	// Handle signals:
-	// - First Ctrl+C when connected to remote host: disconnect from remote
+	// - Ctrl+C while a command runs: stop the command
+	// - First Ctrl+C when connected to remote host: close the active session
 	// - First Ctrl+C when not connected OR second Ctrl+C: exit sherlock
 	go func() {
 		for {
 			<-sigChan
-			if app.sshClient != nil && app.sshClient.IsConnected() {
+			// Ctrl+C while a command runs stops only the command
+			if app.interruptCommand() {
+				continue
+			}
+			if active := app.sessions.active; active != nil && active.client.IsOpen() {
 				fmt.Println("\nDisconnecting from remote host... (Press Ctrl+C again to exit)")
-				_ = app.sshClient.Close()
-				app.sshClient = nil
+				app.endSession(active)
 			} else {
 				fmt.Println("\nReceived interrupt signal, cleaning up...")
 				app.cleanup()
@@ -184,14 +221,37 @@ func main() {
 		}
 	}()
 
+	// Record model calls for the inspect command and --debug-llm
+	app.inspector = ai.NewInspector(ai.DefaultInspectorSize)
+	app.inspector.AddSecrets(cfg.LLM.APIKey)
+	for _, profile := range cfg.Models {
+		app.inspector.AddSecrets(profile.APIKey)
+	}
+	if debugLLMPath != "" {
+		f, err := os.OpenFile(debugLLMPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to open LLM debug log: %v\n", err)
+			os.Exit(1)
+		}
+		app.debugLLMFile = f
+		app.inspector.SetOutput(f)
+	}
+
 	// Initialize AI client
-	aiClient, err := ai.NewClient(ctx, &cfg.LLM)
+	aiClient, err := ai.NewClient(ctx, &cfg.LLM, ai.WithInspector(app.inspector))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to initialize AI client: %v\n", err)
 		os.Exit(1)
 	}
 	app.aiClient = aiClient
 	app.agent = agent.NewAgent(aiClient)
+	app.agent.SetContextManager(ai.NewContextManager(cfg.LLM.Model, cfg.LLM.ContextWindow, aiClient))
+
+	// Route requests between model profiles if any are configured
+	if len(cfg.Models) > 0 {
+		app.router = ai.NewRouter(cfg, aiClient, ai.WithInspector(app.inspector))
+		app.agent.SetRouter(app.router)
+	}
 
 	// Set custom shell commands from config whitelist
 	if len(cfg.ShellCommands.Whitelist) > 0 {
@@ -206,6 +266,16 @@ func main() {
 	app.historyManager = historyMgr
 	// Initialize local client for local command execution
 	app.localClient = sshclient.NewLocalClient()
+	app.localClient.SetCastRecorder(app.castOpener())
+	app.localClient.SetSudoPrompt(app.promptAuth, time.Duration(cfg.SSH.SudoPasswordTimeout)*time.Second)
+	app.localFacts = agent.DetectHostFacts(ctx, app.localClient)
+	app.agent.SetHostFacts(app.localFacts)
+
+	if cfg.Recording.Enabled {
+		if err := app.startRecording(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to start recording: %v\n", err)
+		}
+	}
 
 	// Run the application
 	if err := app.run(); err != nil {
@@ -233,13 +303,8 @@ func (a *App) run() error {
 	a.liner.SetCtrlCAborts(true)
 
 	for {
-		var hostStr string
-		if a.sshClient != nil && a.sshClient.IsConnected() {
-			hostStr = a.sshClient.HostInfoString()
-		} else {
-			hostStr = a.localClient.HostInfoString()
-		}
-		prompt := a.theme.FormatPrompt("sherlock[", hostStr, "]> ")
+		a.pruneSessions()
+		prompt := a.theme.FormatPrompt("sherlock[", a.promptHost(), "]> ")
 
 		input, err := a.liner.Prompt(prompt)
 		if err == liner.ErrInvalidPrompt {
@@ -270,6 +335,34 @@ func (a *App) run() error {
 }
 
 func (a *App) handleInput(input string) error {
+	// A leading "@profile" sends this request to a specific model profile
+	if a.router != nil {
+		if profile, rest, ok := a.router.SplitProfileOverride(input); ok {
+			if rest == "" {
+				return fmt.Errorf("usage: @%s <request>", profile)
+			}
+			ctx := a.ctx
+			a.ctx = ai.WithProfile(ctx, profile)
+			defer func() { a.ctx = ctx }()
+			input = rest
+		}
+	}
+
+	// A leading "@session" runs this request on another open session
+	if s, rest, ok := a.splitSessionPrefix(input); ok {
+		return a.runOnSession(s, rest)
+	}
+
+	// A leading "timeout <duration>" limits the commands of this request
+	if timeout, rest, ok := splitTimeoutPrefix(input); ok {
+		if rest == "" {
+			return fmt.Errorf("usage: timeout <duration> <request>")
+		}
+		a.cmdTimeout = timeout
+		defer func() { a.cmdTimeout = 0 }()
+		input = rest
+	}
+
 	// Handle built-in commands
 	switch strings.ToLower(input) {
 	case "help":
@@ -281,6 +374,8 @@ func (a *App) handleInput(input string) error {
 		os.Exit(0)
 	case "disconnect":
 		return a.disconnect()
+	case "sessions":
+		return a.showSessions()
 	case "status":
 		a.showStatus()
 		return nil
@@ -288,6 +383,88 @@ func (a *App) handleInput(input string) error {
 		return a.showHistory("")
 	case "hosts":
 		return a.showHosts()
+	case "explain":
+		return a.explainLastOutput()
+	case "inspect":
+		return a.inspect("")
+	}
+
+	// Check for inspect command with a call ID
+	if strings.HasPrefix(strings.ToLower(input), "inspect ") {
+		return a.inspect(strings.TrimSpace(input[len("inspect "):]))
+	}
+
+	// Check for ssh_config commands
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "sshconfig") {
+		return a.handleSSHConfig(fields[1:])
+	}
+
+	// Check for known host key commands
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "hostkeys") {
+		return a.handleHostKeys(fields[1:])
+	}
+
+	// Check for session recording commands
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "record") {
+		return a.handleRecord(fields[1:])
+	}
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "replay") {
+		return a.handleReplay(fields[1:])
+	}
+	if fields := strings.Fields(input); len(fields) >= 2 && strings.EqualFold(fields[0], "sessions") && strings.EqualFold(fields[1], "export") {
+		return a.handleSessionsExport(fields[2:])
+	}
+
+	// Check for session switching commands
+	if fields := strings.Fields(input); len(fields) == 2 && strings.EqualFold(fields[0], "use") {
+		return a.useSession(fields[1])
+	}
+	if fields := strings.Fields(input); len(fields) == 2 && strings.EqualFold(fields[0], "close") {
+		return a.closeSession(fields[1])
+	}
+
+	// Check for agent forwarding toggle
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "forward-agent") {
+		return a.handleForwardAgent(fields[1:])
+	}
+
+	// Check for dropping the kept sudo password
+	if strings.EqualFold(input, "forget-sudo") {
+		return a.forgetSudo()
+	}
+
+	// Check for fan-out across many hosts
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "run-on") {
+		return a.handleRunOn(fields[1:])
+	}
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "roll") {
+		return a.handleRoll(strings.TrimSpace(input[len(fields[0]):]))
+	}
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "runs") {
+		return a.handleRuns(fields[1:])
+	}
+
+	// Check for editing a file in the local editor
+	// "edit the nginx config ..." is left to the model
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "edit") {
+		if req, err := parseEdit(strings.TrimSpace(input[len(fields[0]):])); err == nil {
+			return a.editFile(req)
+		} else if len(fields) == 1 || strings.HasPrefix(fields[1], "-") {
+			return err
+		}
+	}
+
+	// Check for tunnel commands
+	if fields := strings.Fields(input); strings.EqualFold(fields[0], "tunnel") || strings.EqualFold(fields[0], "tunnels") {
+		return a.handleTunnel(fields[1:])
+	}
+
+	// Check for file transfer commands
+	if req, ok, err := parseTransferCommand(input); ok {
+		if err != nil {
+			return err
+		}
+		return a.transferFiles(req)
 	}
 
 	// Check for history command with search query
@@ -328,13 +505,23 @@ func (a *App) handleInput(input string) error {
 func (a *App) handleConnect(input string) error {
 	// Check if input is a numeric ID (connect to saved host by ID)
 	trimmedInput := strings.TrimSpace(input)
+
+	// "connect container:web-1" or "connect pod:ns/name" enters a container
+	if fields := strings.Fields(trimmedInput); len(fields) == 2 && strings.EqualFold(fields[0], "connect") {
+		if target, ok, err := sshclient.ParseContainerTarget(fields[1]); ok {
+			if err != nil {
+				return err
+			}
+			return a.enterContainer(target)
+		}
+	}
 	// Handle "connect <id>" pattern
 	if strings.HasPrefix(strings.ToLower(trimmedInput), "connect ") {
 		idStr := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(trimmedInput), "connect "))
 		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil && a.historyManager != nil {
 			record, err := a.historyManager.GetRecordByID(id)
 			if err == nil {
-				return a.connectToHost(record.Host, record.Port, record.User)
+				return a.connectToHost(record.Host, record.Port, record.User, "")
 			}
 		}
 	}
@@ -346,12 +533,40 @@ func (a *App) handleConnect(input string) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse connection request: %w", err)
 	}
+	a.printProfile(connInfo.Profile)
+	a.printReasoning(connInfo.Reasoning)
 
-	return a.connectToHost(connInfo.Host, connInfo.Port, connInfo.User)
+	return a.connectToHost(connInfo.Host, connInfo.Port, connInfo.User, connInfo.Jump)
 }
 
-func (a *App) connectToHost(host string, port int, user string) error {
-	fmt.Printf("%s %s@%s:%d...\n", a.theme.FormatInfo("Connecting to"), user, host, port)
+// reportConnectionChange shows the status of a dropped connection.
+func (a *App) reportConnectionChange(event sshclient.ConnectionEvent) {
+	switch event.State {
+	case sshclient.ConnectionLost:
+		fmt.Println(a.theme.FormatWarning(fmt.Sprintf("Connection to %s lost, reconnecting...", event.Host)))
+	case sshclient.ConnectionRestored:
+		msg := fmt.Sprintf("Reconnected to %s", event.Host)
+		if event.Cwd != "" {
+			msg += fmt.Sprintf(" (working directory %s restored)", event.Cwd)
+		}
+		fmt.Println(a.theme.FormatSuccess(msg + "."))
+		if a.sshClient != nil {
+			a.printConnectionWarnings(a.sshClient)
+		}
+	case sshclient.ConnectionFailed:
+		fmt.Println(a.theme.FormatError(fmt.Sprintf("Could not reconnect to %s after %d attempt(s): %v", event.Host, event.Attempt, event.Err)))
+		fmt.Println(a.theme.FormatInfo("Disconnected; commands now run locally. Use 'connect' to connect again."))
+	}
+}
+
+// connectToHost connects to a host, through the jump hosts in jump if set
+// (otherwise through ProxyJump or ProxyCommand from ~/.ssh/config, if any).
+func (a *App) connectToHost(host string, port int, user, jump string) error {
+	if jump != "" {
+		fmt.Printf("%s %s@%s:%d via %s...\n", a.theme.FormatInfo("Connecting to"), user, host, port, jump)
+	} else {
+		fmt.Printf("%s %s@%s:%d...\n", a.theme.FormatInfo("Connecting to"), user, host, port)
+	}
 
 	hostInfo := &sshclient.HostInfo{
 		Host: host,
@@ -359,11 +574,23 @@ func (a *App) connectToHost(host string, port int, user string) error {
 		User: user,
 	}
 
+	keepAliveInterval := time.Duration(a.cfg.SSH.KeepAliveInterval) * time.Second
+	autoReconnect := !a.cfg.SSH.NoAutoReconnect
+
 	// Always try key-based authentication first
 	fmt.Println(a.theme.FormatInfo("Attempting key-based authentication..."))
 	clientCfg := &sshclient.Config{
-		HostInfo:       hostInfo,
-		PrivateKeyPath: a.cfg.SSHKey.PrivateKeyPath,
+		HostInfo:            hostInfo,
+		PrivateKeyPath:      a.cfg.SSHKey.PrivateKeyPath,
+		PersistentShell:     a.cfg.SSH.PersistentShell,
+		ProxyJump:           jump,
+		KeepAliveInterval:   keepAliveInterval,
+		AutoReconnect:       &autoReconnect,
+		OnConnectionChange:  a.reportConnectionChange,
+		HostKeyPrompt:       a.confirmHostKey,
+		Prompt:              a.promptAuth,
+		SudoPasswordTimeout: time.Duration(a.cfg.SSH.SudoPasswordTimeout) * time.Second,
+		ForwardAgent:        a.cfg.HostForwardAgent(host),
 	}
 
 	var keyAuthErr error
@@ -373,11 +600,9 @@ func (a *App) connectToHost(host string, port int, user string) error {
 	} else {
 		if connectErr := client.Connect(a.ctx); connectErr == nil {
 			// Key auth succeeded
-			if a.sshClient != nil {
-				_ = a.sshClient.Close()
-			}
-			a.sshClient = client
 			fmt.Printf("%s %s\n", a.theme.FormatSuccess("Successfully connected to"), client.HostInfoString()+" using SSH key")
+			a.printConnectionWarnings(client)
+			a.addSession(host, client)
 
 			// Update history
 			if a.historyManager != nil {
@@ -389,6 +614,11 @@ func (a *App) connectToHost(host string, port int, user string) error {
 		}
 	}
 
+	// A host key problem is not solved by a password
+	if err := a.hostKeyError(keyAuthErr); err != nil {
+		return err
+	}
+
 	// Key auth failed, show the error and fall through to password prompt
 	if keyAuthErr != nil {
 		fmt.Printf("%s %v\n", a.theme.FormatWarning("Key authentication failed:"), keyAuthErr)
@@ -410,9 +640,18 @@ func (a *App) connectToHost(host string, port int, user string) error {
 
 	// Create SSH client with password
 	clientCfg = &sshclient.Config{
-		HostInfo:       hostInfo,
-		Password:       password,
-		PrivateKeyPath: a.cfg.SSHKey.PrivateKeyPath,
+		HostInfo:            hostInfo,
+		Password:            password,
+		PrivateKeyPath:      a.cfg.SSHKey.PrivateKeyPath,
+		PersistentShell:     a.cfg.SSH.PersistentShell,
+		ProxyJump:           jump,
+		KeepAliveInterval:   keepAliveInterval,
+		AutoReconnect:       &autoReconnect,
+		OnConnectionChange:  a.reportConnectionChange,
+		HostKeyPrompt:       a.confirmHostKey,
+		Prompt:              a.promptAuth,
+		SudoPasswordTimeout: time.Duration(a.cfg.SSH.SudoPasswordTimeout) * time.Second,
+		ForwardAgent:        a.cfg.HostForwardAgent(host),
 	}
 
 	client, err = sshclient.NewClient(clientCfg)
@@ -422,16 +661,15 @@ func (a *App) connectToHost(host string, port int, user string) error {
 
 	// Connect
 	if err := client.Connect(a.ctx); err != nil {
+		if err := a.hostKeyError(err); err != nil {
+			return err
+		}
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
-	// Close existing connection if any
-	if a.sshClient != nil {
-		_ = a.sshClient.Close()
-	}
-
-	a.sshClient = client
 	fmt.Printf("%s %s\n", a.theme.FormatSuccess("Successfully connected to"), client.HostInfoString())
+	a.printConnectionWarnings(client)
+	a.addSession(host, client)
 
 	// Optionally add public key to authorized_keys
 	pubKeyAdded := false
@@ -453,6 +691,22 @@ func (a *App) connectToHost(host string, port int, user string) error {
 	return nil
 }
 
+// describeRoute returns the jump hosts or proxy command a connection goes
+// through, or "" for a direct connection.
+func describeRoute(client *sshclient.Client) string {
+	if jumps := client.Jumps(); len(jumps) > 0 {
+		hops := make([]string, len(jumps))
+		for i, jump := range jumps {
+			hops[i] = fmt.Sprintf("%s@%s:%d", jump.User, jump.Host, jump.Port)
+		}
+		return strings.Join(hops, " -> ")
+	}
+	if command := client.ProxyCommand(); command != "" {
+		return "ProxyCommand " + command
+	}
+	return ""
+}
+
 func (a *App) handleDirectCommand(cmd string) error {
 	cmd = strings.TrimSpace(cmd)
 	if cmd == "" {
@@ -468,6 +722,17 @@ func (a *App) handleCommandRequest(input string) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse command request: %w", err)
 	}
+	a.printProfile(cmdInfo.Profile)
+	a.printReasoning(cmdInfo.Reasoning)
+	if cmdInfo.Offline {
+		fmt.Println(a.theme.FormatWarning("Model unavailable; using built-in offline rules."))
+	}
+	a.record(recording.Entry{
+		Kind:        recording.KindRequest,
+		Request:     input,
+		Commands:    cmdInfo.Commands,
+		Description: cmdInfo.Description,
+	})
 
 	fmt.Printf("%s\n", a.theme.FormatTableHeader("Commands to execute:"))
 	for i, cmd := range cmdInfo.Commands {
@@ -488,8 +753,20 @@ func (a *App) handleCommandRequest(input string) error {
 	}
 
 	// Execute commands
+	a.request = input
+	defer func() { a.request = "" }()
 	for _, cmd := range cmdInfo.Commands {
 		fmt.Printf("\n%s %s\n", a.theme.FormatInfo("$"), a.theme.FormatCommand(cmd))
+		// The model asks for file transfers with the built-in commands
+		if req, ok, err := parseTransferCommand(cmd); ok {
+			if err == nil {
+				err = a.transferFiles(req)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		if err := a.executeCommand(cmd); err != nil {
 			return err
 		}
@@ -498,28 +775,136 @@ func (a *App) handleCommandRequest(input string) error {
 	return nil
 }
 
+// printProfile prints the model profile that answered a request, if routing is enabled.
+func (a *App) printProfile(profile string) {
+	if profile != "" {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Model:"), profile)
+	}
+}
+
+// printReasoning prints the model's reasoning dimmed when enabled with --show-reasoning.
+func (a *App) printReasoning(reasoning string) {
+	if !a.cfg.UI.ShowReasoning || reasoning == "" {
+		return
+	}
+	fmt.Println(a.theme.FormatReasoning("Thinking:"))
+	for _, line := range strings.Split(reasoning, "\n") {
+		fmt.Println(a.theme.FormatReasoning("  " + line))
+	}
+	fmt.Println()
+}
+
+// inspect lists recent model calls, or shows one call in detail.
+func (a *App) inspect(arg string) error {
+	records := a.inspector.Records()
+	if len(records) == 0 {
+		fmt.Println(a.theme.FormatInfo("No model calls recorded yet."))
+		return nil
+	}
+
+	if arg == "" {
+		fmt.Println(a.theme.FormatTableHeader("Recent model calls:"))
+		fmt.Println(a.theme.FormatTableBorder(strings.Repeat("-", 70)))
+		fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("%-4s %-8s %-20s %-8s %-7s %s", "ID", "Time", "Model", "Latency", "Tokens", "Request")))
+		fmt.Println(a.theme.FormatTableBorder(strings.Repeat("-", 70)))
+		for _, r := range records {
+			tokens := "-"
+			if r.Usage != nil {
+				tokens = strconv.Itoa(r.Usage.TotalTokens)
+			}
+			request := ""
+			if len(r.Messages) > 0 {
+				request = strings.ReplaceAll(r.Messages[len(r.Messages)-1].Content, "\n", " ")
+			}
+			request = truncateRunes(request, 40)
+			line := fmt.Sprintf("%-4d %-8s %-20s %-8s %-7s %s", r.ID, r.Time.Format("15:04:05"),
+				r.Model, fmt.Sprintf("%dms", r.LatencyMS), tokens, request)
+			if r.Error != "" {
+				line += " " + a.theme.FormatError("[error]")
+			}
+			fmt.Println(a.theme.FormatTableContent(line))
+		}
+		fmt.Println(a.theme.FormatInfo("Use 'inspect <id>' to see a call in detail."))
+		return nil
+	}
+
+	var record *ai.CallRecord
+	if arg == "last" {
+		record = records[len(records)-1]
+	} else {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid call ID: %s", arg)
+		}
+		var ok bool
+		if record, ok = a.inspector.Record(id); !ok {
+			return fmt.Errorf("model call %d is no longer recorded", id)
+		}
+	}
+
+	fmt.Printf("%s %d  %s/%s  %s  %dms\n", a.theme.FormatTableHeader("Call"), record.ID,
+		record.Provider, record.Model, record.Time.Format("2006-01-02 15:04:05"), record.LatencyMS)
+	if record.Usage != nil {
+		fmt.Printf("%s prompt %d, completion %d, total %d\n", a.theme.FormatInfo("Tokens:"),
+			record.Usage.PromptTokens, record.Usage.CompletionTokens, record.Usage.TotalTokens)
+	}
+	for _, msg := range record.Messages {
+		fmt.Println(a.theme.FormatTableHeader("[" + msg.Role + "]"))
+		fmt.Println(msg.Content)
+	}
+	if record.Reasoning != "" {
+		fmt.Println(a.theme.FormatTableHeader("[reasoning]"))
+		fmt.Println(a.theme.FormatReasoning(record.Reasoning))
+	}
+	if record.Error != "" {
+		fmt.Println(a.theme.FormatTableHeader("[error]"))
+		fmt.Println(a.theme.FormatError(record.Error))
+	} else {
+		fmt.Println(a.theme.FormatTableHeader("[raw response]"))
+		fmt.Println(record.Response)
+	}
+	if record.ParseResult != "" {
+		fmt.Println(a.theme.FormatTableHeader("[parse result]"))
+		fmt.Println(record.ParseResult)
+	}
+	return nil
+}
+
 func (a *App) executeCommand(cmd string) error {
 	// Check if this is an interactive command that needs PTY support
 	if sshclient.IsInteractiveCommand(cmd) {
 		return a.executeInteractiveCommand(cmd)
 	}
 
-	var result *sshclient.ExecuteResult
+	// Output is shown as it arrives; only a bounded part is kept for explain
+	stdout := sshclient.NewOutputBuffer(a.cfg.Output.MaxKeptBytes)
+	stderr := sshclient.NewOutputBuffer(a.cfg.Output.MaxKeptBytes)
+	liveStdout := io.MultiWriter(&themedWriter{w: os.Stdout, format: a.theme.FormatStdout}, stdout)
+	liveStderr := io.MultiWriter(&themedWriter{w: os.Stderr, format: a.theme.FormatStderr}, stderr)
 
 	// Use SSH client if connected, otherwise use local client
-	if a.sshClient != nil && a.sshClient.IsConnected() {
-		result = a.sshClient.Execute(a.ctx, cmd)
-	} else {
-		result = a.localClient.Execute(a.ctx, cmd)
+	ctx, cancel := a.commandContext()
+	defer cancel()
+	start := time.Now()
+	result := a.executor().ExecuteStream(ctx, cmd, liveStdout, liveStderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	a.recordCommand(cmd, result, stdout.Truncated() || stderr.Truncated(), time.Since(start))
+
+	a.lastCommand = cmd
+	a.lastResult = result
+
+	if stdout.Truncated() || stderr.Truncated() {
+		limit := a.cfg.Output.MaxKeptBytes
+		if limit <= 0 {
+			limit = sshclient.DefaultOutputLimit
+		}
+		fmt.Println(a.theme.FormatInfo(fmt.Sprintf("(long output: only its start and end, %s per stream, are kept for explain)", formatBytes(int64(limit)))))
 	}
 
-	if result.Stdout != "" {
-		fmt.Print(a.theme.FormatStdout(result.Stdout))
-	}
-	if result.Stderr != "" {
-		fmt.Fprint(os.Stderr, a.theme.FormatStderr(result.Stderr))
+	if result.Interrupted || result.TimedOut {
+		return a.stoppedError(result)
 	}
-
 	if result.Error != nil {
 		return result.Error
 	}
@@ -531,28 +916,65 @@ func (a *App) executeCommand(cmd string) error {
 	return nil
 }
 
+// themedWriter writes command output in the colors of the theme.
+type themedWriter struct {
+	w      io.Writer
+	format func(string) string
+}
+
+func (t *themedWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(t.w, t.format(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// explainLastOutput asks the model to explain the output of the last command.
+func (a *App) explainLastOutput() error {
+	if a.lastResult == nil {
+		fmt.Println(a.theme.FormatInfo("No command output to explain yet. Run a command first."))
+		return nil
+	}
+
+	output := a.lastResult.Stdout
+	if a.lastResult.Stderr != "" {
+		output += "\n[stderr]\n" + a.lastResult.Stderr
+	}
+
+	fmt.Println(a.theme.FormatInfo("Explaining output of: " + a.lastCommand))
+	explanation, err := a.agent.ExplainOutput(a.ctx, a.lastCommand, output, a.lastResult.ExitCode)
+	if err != nil {
+		return fmt.Errorf("failed to explain output: %w", err)
+	}
+
+	if explanation.Truncation != nil {
+		fmt.Println(a.theme.FormatWarning("Note: " + explanation.Truncation.String()))
+	}
+	a.printProfile(explanation.Profile)
+	a.printReasoning(explanation.Reasoning)
+	fmt.Println(explanation.Text)
+	return nil
+}
+
 // executeInteractiveCommand executes an interactive command with PTY support.
 func (a *App) executeInteractiveCommand(cmd string) error {
 	fmt.Println(a.theme.FormatInfo("Running in interactive mode. Press Ctrl+C to exit."))
 
-	// Use SSH client if connected, otherwise use local client
-	if a.sshClient != nil && a.sshClient.IsConnected() {
-		return a.sshClient.ExecuteInteractive(a.ctx, cmd)
-	}
-	return a.localClient.ExecuteInteractive(a.ctx, cmd)
+	return a.executor().ExecuteInteractive(a.ctx, cmd)
 }
 
 func (a *App) disconnect() error {
-	if a.sshClient == nil {
-		fmt.Println("Not connected to any host.")
+	// In a container, disconnect leaves it for its host
+	if a.leaveContainer() {
 		return nil
 	}
-
-	if err := a.sshClient.Close(); err != nil {
-		return fmt.Errorf("failed to disconnect: %w", err)
+	active := a.sessions.active
+	if active == nil {
+		fmt.Println("Not connected to any host.")
+		return nil
 	}
 
-	a.sshClient = nil
+	a.endSession(active)
 	fmt.Println("Disconnected.")
 	return nil
 }
@@ -562,18 +984,39 @@ func (a *App) showStatus() {
 	fmt.Printf("%s %s\n", a.theme.FormatInfo("Version:"), version)
 	fmt.Printf("%s %s\n", a.theme.FormatInfo("LLM Provider:"), a.cfg.LLM.Provider)
 	fmt.Printf("%s %s\n", a.theme.FormatInfo("LLM Model:"), a.cfg.LLM.Model)
+	fmt.Printf("%s %d tokens\n", a.theme.FormatInfo("Context Window:"), ai.NewContextManager(a.cfg.LLM.Model, a.cfg.LLM.ContextWindow, nil).Window())
+	if a.router != nil {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Model Profiles:"), strings.Join(a.router.Profiles(), ", "))
+	}
 	fmt.Printf("%s %s\n", a.theme.FormatInfo("Theme:"), a.cfg.UI.Theme)
 
-	if a.sshClient != nil && a.sshClient.IsConnected() {
-		fmt.Printf("%s %s\n", a.theme.FormatInfo("Connected to:"), a.theme.FormatSuccess(a.sshClient.HostInfoString()+" (remote)"))
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		if a.sshClient.IsConnected() {
+			fmt.Printf("%s %s\n", a.theme.FormatInfo("Connected to:"), a.theme.FormatSuccess(a.sshClient.HostInfoString()+" (remote)"))
+		} else {
+			fmt.Printf("%s %s\n", a.theme.FormatInfo("Connected to:"), a.theme.FormatWarning(a.sshClient.HostInfoString()+" (remote, link lost; the next command reconnects)"))
+		}
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Shell Mode:"), a.sshClient.ShellMode())
+		if route := describeRoute(a.sshClient); route != "" {
+			fmt.Printf("%s %s\n", a.theme.FormatInfo("Via:"), route)
+		}
+		fmt.Printf("%s %d\n", a.theme.FormatInfo("Tunnels:"), len(a.sshClient.Tunnels()))
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Agent Forwarding:"), onOff(a.sshClient.ForwardAgent()))
 	} else {
 		fmt.Printf("%s %s\n", a.theme.FormatInfo("Connected to:"), a.localClient.HostInfoString()+" (local)")
 	}
+	if c := a.container(); c != nil {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Container:"), a.theme.FormatSuccess(containerDescription(c.client.Target())))
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Container Directory:"), c.client.GetCwd())
+	}
 }
 
 func (a *App) cleanup() {
-	if a.sshClient != nil {
-		_ = a.sshClient.Close()
+	for _, s := range a.sessions.sessions {
+		_ = s.client.Close()
+	}
+	if a.router != nil {
+		_ = a.router.Close()
 	}
 	if a.aiClient != nil {
 		_ = a.aiClient.Close()
@@ -581,18 +1024,20 @@ func (a *App) cleanup() {
 	if a.historyManager != nil {
 		_ = a.historyManager.Close()
 	}
+	if a.inspector != nil {
+		a.inspector.Flush()
+	}
+	if a.debugLLMFile != nil {
+		_ = a.debugLLMFile.Close()
+	}
+	a.stopRecording()
 	a.cancel()
 }
 
 // completer provides tab completion for commands and file paths.
 func (a *App) completer(line string) []string {
 	// Get current working directory
-	var cwd string
-	if a.sshClient != nil && a.sshClient.IsConnected() {
-		cwd = a.sshClient.GetCwd()
-	} else {
-		cwd = a.localClient.GetCwd()
-	}
+	cwd := a.cwd()
 
 	// Split the line to find the last token
 	parts := strings.Fields(line)
@@ -603,7 +1048,7 @@ func (a *App) completer(line string) []string {
 
 	// Check if we're completing the first word (command) or arguments
 	lastPart := parts[len(parts)-1]
-	
+
 	// If the line ends with a space, we're starting a new token (likely a path)
 	if strings.HasSuffix(line, " ") {
 		// Complete file paths from current directory
@@ -623,7 +1068,9 @@ func (a *App) completer(line string) []string {
 func (a *App) completeCommands(prefix string) []string {
 	// Built-in commands
 	builtinCommands := []string{
-		"help", "exit", "quit", "disconnect", "status", "history", "hosts", "connect",
+		"help", "exit", "quit", "disconnect", "status", "history", "hosts", "connect", "explain", "inspect",
+		"upload", "download", "tunnel", "sshconfig", "hostkeys", "forward-agent", "sessions", "use", "close",
+		"run-on", "roll", "runs", "forget-sudo", "edit", "record", "replay",
 	}
 
 	// Common shell commands
@@ -638,7 +1085,7 @@ func (a *App) completeCommands(prefix string) []string {
 
 	allCommands := append(builtinCommands, shellCommands...)
 	var completions []string
-	
+
 	prefix = strings.ToLower(prefix)
 	for _, cmd := range allCommands {
 		if strings.HasPrefix(cmd, prefix) {
@@ -696,8 +1143,8 @@ func (a *App) completeFilePaths(cwd, prefix, fullLine string) []string {
 		}
 	}
 
-	// If connected to remote, use remote file listing
-	if a.sshClient != nil && a.sshClient.IsConnected() {
+	// If connected to remote or in a container, list files there
+	if a.executor() != sshclient.Executor(a.localClient) {
 		return a.completeRemoteFilePaths(searchDir, filePrefix, prefix, fullLine)
 	}
 
@@ -728,12 +1175,12 @@ func (a *App) completeFilePaths(cwd, prefix, fullLine string) []string {
 			} else {
 				completion = name
 			}
-			
+
 			// Add trailing slash for directories
 			if entry.IsDir() {
 				completion += "/"
 			}
-			
+
 			completions = append(completions, linePrefix+completion)
 		}
 	}
@@ -746,7 +1193,7 @@ func (a *App) completeRemoteFilePaths(searchDir, filePrefix, prefix, fullLine st
 	var completions []string
 
 	// Execute ls command on remote host to get file list
-	result := a.sshClient.Execute(a.ctx, fmt.Sprintf("ls -1a %s 2>/dev/null", sshclient.ShellEscape(searchDir)))
+	result := a.executor().Execute(a.ctx, fmt.Sprintf("ls -1a %s 2>/dev/null", sshclient.ShellEscape(searchDir)))
 	if result.Error != nil || result.ExitCode != 0 {
 		return completions
 	}
@@ -772,14 +1219,14 @@ func (a *App) completeRemoteFilePaths(searchDir, filePrefix, prefix, fullLine st
 			} else {
 				completion = name
 			}
-			
+
 			// Check if it's a directory (execute stat on remote)
 			checkPath := filepath.Join(searchDir, name)
-			checkResult := a.sshClient.Execute(a.ctx, fmt.Sprintf("test -d %s && echo 'dir'", sshclient.ShellEscape(checkPath)))
+			checkResult := a.executor().Execute(a.ctx, fmt.Sprintf("test -d %s && echo 'dir'", sshclient.ShellEscape(checkPath)))
 			if strings.TrimSpace(checkResult.Stdout) == "dir" {
 				completion += "/"
 			}
-			
+
 			completions = append(completions, linePrefix+completion)
 		}
 	}
@@ -968,6 +1415,10 @@ Options:
   --model <model>         Model name
   --base-url <url>        Base URL for LLM API
   --api-key <key>         API key for LLM provider
+  --show-reasoning        Show the reasoning of thinking models (dimmed)
+  --persistent-shell      Keep one remote shell per connection (env, aliases persist)
+  --record                Record the session to ~/.config/sherlock/recordings
+  --debug-llm <file>      Write every model call to a JSONL file (secrets redacted)
 
 Examples:
   sherlock                           Start interactive mode with default config
@@ -989,15 +1440,50 @@ func (a *App) printCommandHelp() {
 	fmt.Printf("  %s                 %s\n", a.theme.FormatCommand("history"), a.theme.FormatDescription("Show login history"))
 	fmt.Printf("  %s         %s\n", a.theme.FormatCommand("history <query>"), a.theme.FormatDescription("Search login history"))
 	fmt.Printf("  %s              %s\n", a.theme.FormatCommand("disconnect"), a.theme.FormatDescription("Disconnect from remote host (switch to local mode)"))
+	fmt.Printf("  %s                 %s\n", a.theme.FormatCommand("inspect"), a.theme.FormatDescription("List recent model calls"))
+	fmt.Printf("  %s       %s\n", a.theme.FormatCommand("inspect <id|last>"), a.theme.FormatDescription("Show the messages, raw response and parse result of a model call"))
 
 	fmt.Println()
 	fmt.Println(a.theme.FormatTableHeader("Connection:"))
-	fmt.Printf("  %s          %s\n", a.theme.FormatCommand("connect <host>"), a.theme.FormatDescription("Connect to a remote host"))
-	fmt.Printf("  %s            %s\n", a.theme.FormatCommand("connect <id>"), a.theme.FormatDescription("Connect to a saved host by ID"))
-	fmt.Printf("  %s      %s\n", a.theme.FormatCommand("ssh user@host:port"), a.theme.FormatDescription("Connect using SSH-like syntax"))
+	fmt.Printf("  %s           %s\n", a.theme.FormatCommand("connect <host>"), a.theme.FormatDescription("Connect to a remote host"))
+	fmt.Printf("  %s             %s\n", a.theme.FormatCommand("connect <id>"), a.theme.FormatDescription("Connect to a saved host by ID"))
+	fmt.Printf("  %s       %s\n", a.theme.FormatCommand("ssh user@host:port"), a.theme.FormatDescription("Connect using SSH-like syntax"))
+	fmt.Printf("  %s  %s\n", a.theme.FormatCommand("ssh -J jump1,jump2 host"), a.theme.FormatDescription("Connect through jump hosts, or say \"connect to web1 via bastion\""))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("sshconfig resolve <host>"), a.theme.FormatDescription("Show the effective ~/.ssh/config settings for a host"))
+	fmt.Printf("  %s           %s\n", a.theme.FormatCommand("hostkeys [host]"), a.theme.FormatDescription("List trusted host keys from known_hosts"))
+	fmt.Printf("  %s    %s\n", a.theme.FormatCommand("hostkeys remove <host>"), a.theme.FormatDescription("Forget the keys of a host"))
+	fmt.Printf("  %s   %s\n", a.theme.FormatCommand("hostkeys retrust <host>"), a.theme.FormatDescription("Replace the keys of a host with its current key, after confirmation"))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("connect container:<name>"), a.theme.FormatDescription("Run commands in a Docker or Podman container on this host (docker:<name>, podman:<name>)"))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("connect pod:[ns/]<pod>[:c]"), a.theme.FormatDescription("Run commands in a Kubernetes pod with kubectl exec; disconnect leaves the container"))
+	fmt.Printf("  %s   %s\n", a.theme.FormatCommand("forward-agent [on|off]"), a.theme.FormatDescription("Forward the local SSH agent to this host, shown as "+agentForwardIndicator+" in the prompt"))
 	fmt.Printf("  %s\n", a.theme.FormatDescription("Or describe in natural language, e.g., \"connect to server 192.168.1.100 as root\""))
 	fmt.Printf("  %s\n", a.theme.FormatInfo("Note: If you have logged in before with SSH key, no password will be required."))
 
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Sessions:"))
+	fmt.Printf("  %s                %s\n", a.theme.FormatCommand("sessions"), a.theme.FormatDescription("List open connections; connecting to another host keeps the others open"))
+	fmt.Printf("  %s         %s\n", a.theme.FormatCommand("use <name|id>"), a.theme.FormatDescription("Switch to an open session, or 'use local' to run commands locally"))
+	fmt.Printf("  %s       %s\n", a.theme.FormatCommand("close <name|id>"), a.theme.FormatDescription("Close a session"))
+	fmt.Printf("  %s  %s\n", a.theme.FormatCommand("@<session> <request>"), a.theme.FormatDescription("Run one request on another session, e.g., @db1 $df -h"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("The prompt shows the active session and, as +N, how many others are open."))
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Many hosts:"))
+	fmt.Printf("  %s  %s\n", a.theme.FormatCommand("run-on <hosts> <request>"), a.theme.FormatDescription("Translate a request once and run it on many hosts at once, e.g., run-on web* show uptime"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Hosts are a group from the config, a tag, a pattern over saved hosts, or host1,host2."))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Options: --parallel N, --timeout <dur> per host, --json for scripts."))
+	fmt.Printf("  %s    %s\n", a.theme.FormatCommand("roll <hosts> <request>"), a.theme.FormatDescription("Roll a change out batch by batch, pausing when hosts fail"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Options: --batch N|N%, --canary <host>, --check \"$<command>\" or a request, --max-failures N|N%, --on-failure pause|stop."))
+	fmt.Printf("  %s                      %s\n", a.theme.FormatCommand("runs"), a.theme.FormatDescription("List saved rollout reports"))
+	fmt.Printf("  %s                 %s\n", a.theme.FormatCommand("runs <id>"), a.theme.FormatDescription("Show a saved rollout report"))
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Recording:"))
+	fmt.Printf("  %s                    %s\n", a.theme.FormatCommand("record [on|off]"), a.theme.FormatDescription("Record requests, commands and output to a transcript; interactive commands are saved as asciicasts"))
+	fmt.Printf("  %s                      %s\n", a.theme.FormatCommand("replay <file>"), a.theme.FormatDescription("Play a .cast recording back (--speed N, --idle <dur>), or show a .jsonl transcript"))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("sessions export [file] [-o out.md]"), a.theme.FormatDescription("Write a transcript as Markdown, by default the current or newest one"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Start sherlock with --record, or set recording.enabled in the config, to record every session."))
+
 	fmt.Println()
 	fmt.Println(a.theme.FormatTableHeader("Hosts:"))
 	fmt.Printf("  %s                   %s\n", a.theme.FormatCommand("hosts"), a.theme.FormatDescription("Show all saved hosts with IDs"))
@@ -1013,7 +1499,43 @@ func (a *App) printCommandHelp() {
 	fmt.Println(a.theme.FormatTableHeader("Commands (local or remote):"))
 	fmt.Printf("  %s              %s\n", a.theme.FormatCommand("$<command>"), a.theme.FormatDescription("Execute a command directly, e.g., $ls -la"))
 	fmt.Printf("  %s\n", a.theme.FormatDescription("Or describe in natural language, e.g., \"show me disk usage\""))
+	fmt.Printf("  %s                 %s\n", a.theme.FormatCommand("explain"), a.theme.FormatDescription("Explain the output of the last command"))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("timeout <dur> <request>"), a.theme.FormatDescription("Stop the commands of one request after a time, e.g., timeout 30s $find / -name core"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Press Ctrl+C to stop a running command."))
+	fmt.Printf("  %s             %s\n", a.theme.FormatCommand("forget-sudo"), a.theme.FormatDescription("Forget the sudo password, which is otherwise asked once and kept for a while"))
+	fmt.Printf("  %s     %s\n", a.theme.FormatCommand("@<profile> <request>"), a.theme.FormatDescription("Use a specific model profile for one request, e.g., @big why is nginx failing"))
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("File transfer (remote only):"))
+	fmt.Printf("  %s   %s\n", a.theme.FormatCommand("upload [-r] <local...> [remote]"), a.theme.FormatDescription("Upload files or directories (-r) over SFTP"))
+	fmt.Printf("  %s %s\n", a.theme.FormatCommand("download [-r] <remote...> [local]"), a.theme.FormatDescription("Download files or directories (-r) over SFTP"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Globs are supported, partial transfers resume, and modes and times are kept."))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Or use natural language, e.g., \"download the nginx logs\""))
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Editing files:"))
+	fmt.Printf("  %s  %s\n", a.theme.FormatCommand("edit [--check <cmd>] <path>"), a.theme.FormatDescription("Edit a file in $EDITOR, review the diff, and save it with a backup"))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("Owner and mode are kept, and sudo is used if the file needs it."))
+	fmt.Printf("  %s\n", a.theme.FormatDescription("--check runs e.g. \"nginx -t\" or \"visudo -cf {}\" after saving and restores the backup if it fails."))
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Tunnels (remote only):"))
+	fmt.Printf("  %s    %s\n", a.theme.FormatCommand("tunnel add -L [bind:]port:host:hostport"), a.theme.FormatDescription("Forward a local port to host:hostport via the remote host"))
+	fmt.Printf("  %s    %s\n", a.theme.FormatCommand("tunnel add -R [bind:]port:host:hostport"), a.theme.FormatDescription("Forward a remote port to host:hostport via this machine"))
+	fmt.Printf("  %s                  %s\n", a.theme.FormatCommand("tunnel add -D [bind:]port"), a.theme.FormatDescription("Start a local SOCKS5 proxy through the remote host"))
+	fmt.Printf("  %s                                %s\n", a.theme.FormatCommand("tunnel list"), a.theme.FormatDescription("Show tunnels with connection counts and bytes transferred"))
+	fmt.Printf("  %s                      %s\n", a.theme.FormatCommand("tunnel close <id|all>"), a.theme.FormatDescription("Close a tunnel"))
 
 	fmt.Println()
 	fmt.Printf("%s\n", a.theme.FormatInfo("Note: When not connected to a remote host, commands are executed locally."))
 }
+
+// truncateRunes shortens s to at most n runes, ending it with "..." if it
+// was cut, without splitting a multi-byte character.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
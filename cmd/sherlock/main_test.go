@@ -15,7 +15,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/warm3snow/sherlock/internal/config"
+	"github.com/warm3snow/sherlock/internal/history"
+	"github.com/warm3snow/sherlock/internal/recording"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
 )
 
 func TestIsConnectionRequest(t *testing.T) {
@@ -103,3 +115,743 @@ func TestStripANSI(t *testing.T) {
 		})
 	}
 }
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "short", n: 10, want: "short"},
+		{s: "exactly ten", n: 11, want: "exactly ten"},
+		{s: "a long request", n: 10, want: "a long ..."},
+		{s: "查看磁盘使用情况并清理日志文件", n: 10, want: "查看磁盘使用情..."},
+	}
+	for _, tt := range tests {
+		if got := truncateRunes(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseTransferCommand(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantOK      bool
+		wantErr     bool
+		wantUpload  bool
+		wantSources []string
+		wantDest    string
+		wantRecurse bool
+	}{
+		{input: "download -r /var/log/nginx", wantOK: true, wantSources: []string{"/var/log/nginx"}, wantDest: ".", wantRecurse: true},
+		{input: "upload a.conf b.conf /etc/app/", wantOK: true, wantUpload: true, wantSources: []string{"a.conf", "b.conf"}, wantDest: "/etc/app/"},
+		{input: "Download *.log logs", wantOK: true, wantSources: []string{"*.log"}, wantDest: "logs"},
+		{input: "upload", wantOK: true, wantErr: true},
+		{input: "download -x file", wantOK: true, wantErr: true},
+		{input: "downloads folder size", wantOK: false},
+		{input: "ls -la", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			req, ok, err := parseTransferCommand(tt.input)
+			if ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Fatalf("parseTransferCommand(%q) ok = %v, err = %v, want ok = %v, err = %v", tt.input, ok, err, tt.wantOK, tt.wantErr)
+			}
+			if req == nil {
+				return
+			}
+			if req.upload != tt.wantUpload || req.dest != tt.wantDest || req.opts.Recursive != tt.wantRecurse ||
+				strings.Join(req.sources, ",") != strings.Join(tt.wantSources, ",") {
+				t.Errorf("parseTransferCommand(%q) = %+v", tt.input, req)
+			}
+		})
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		done  int64
+		total int64
+		want  string
+	}{
+		{done: 0, total: 2048, want: "f [>                             ]   0% 0B/2.0KB"},
+		{done: 1536, total: 2048, want: "f [======================>       ]  75% 1.5KB/2.0KB"},
+		{done: 5 << 20, total: 5 << 20, want: "f [==============================] 100% 5.0MB/5.0MB"},
+		{done: 0, total: 0, want: "f [==============================] 100% 0B/0B"},
+	}
+
+	for _, tt := range tests {
+		if got := formatProgress("f", tt.done, tt.total); got != tt.want {
+			t.Errorf("formatProgress(%d, %d) = %q, want %q", tt.done, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestParseTunnelAdd(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    string
+		wantErr bool
+	}{
+		{args: "-L 8080:localhost:80", want: "-L 127.0.0.1:8080:localhost:80"},
+		{args: "-R9000:localhost:3000", want: "-R 127.0.0.1:9000:localhost:3000"},
+		{args: "-D 1080", want: "-D 127.0.0.1:1080"},
+		{args: "-L", wantErr: true},
+		{args: "-X 8080:localhost:80", wantErr: true},
+		{args: "-L 8080:localhost:80 extra", wantErr: true},
+		{args: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			spec, err := parseTunnelAdd(strings.Fields(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTunnelAdd(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if !tt.wantErr && spec.String() != tt.want {
+				t.Errorf("parseTunnelAdd(%q) = %q, want %q", tt.args, spec.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSSHConfigHost(t *testing.T) {
+	host := &sshclient.SSHConfigHost{
+		Host:                "web",
+		Hostname:            "web.example.com",
+		Port:                2222,
+		User:                "deploy",
+		IdentityFile:        []string{"/keys/a", "/keys/b"},
+		ServerAliveInterval: 30 * time.Second,
+		ServerAliveCountMax: 3,
+		ForwardAgent:        true,
+		SendEnv:             []string{"LANG", "LC_*"},
+	}
+	want := [][2]string{
+		{"Host", "web"},
+		{"Hostname", "web.example.com"},
+		{"Port", "2222"},
+		{"User", "deploy"},
+		{"IdentityFile", "/keys/a"},
+		{"IdentityFile", "/keys/b"},
+		{"ServerAliveInterval", "30"},
+		{"ServerAliveCountMax", "3"},
+		{"ForwardAgent", "yes"},
+		{"SendEnv", "LANG LC_*"},
+	}
+
+	got := formatSSHConfigHost(host)
+	if len(got) != len(want) {
+		t.Fatalf("formatSSHConfigHost() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("formatSSHConfigHost()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSplitHostKeyTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		wantHost string
+		wantPort int
+		wantErr  bool
+	}{
+		{target: "web1", wantHost: "web1"},
+		{target: "web1:2222", wantHost: "web1", wantPort: 2222},
+		{target: "[web1]:2222", wantHost: "web1", wantPort: 2222},
+		{target: "[2001:db8::1]", wantHost: "2001:db8::1"},
+		{target: "web1:ssh", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		host, port, err := splitHostKeyTarget(tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitHostKeyTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("splitHostKeyTarget(%q) = %q, %d, want %q, %d", tt.target, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+func TestAuthQuestion(t *testing.T) {
+	tests := []struct {
+		question string
+		want     string
+	}{
+		{"Password:", "Password: "},
+		{"Verification code: ", "Verification code: "},
+		{"OTP:   ", "OTP: "},
+		{"", "Response: "},
+	}
+	for _, tt := range tests {
+		if got := authQuestion(tt.question); got != tt.want {
+			t.Errorf("authQuestion(%q) = %q, want %q", tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTimeoutPrefix(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantTimeout time.Duration
+		wantRest    string
+		wantOK      bool
+	}{
+		{input: "timeout 30s $find / -name core", wantTimeout: 30 * time.Second, wantRest: "$find / -name core", wantOK: true},
+		{input: "TIMEOUT 2m show disk usage", wantTimeout: 2 * time.Minute, wantRest: "show disk usage", wantOK: true},
+		{input: "timeout 1m30s", wantTimeout: 90 * time.Second, wantRest: "", wantOK: true},
+		{input: "timeout errors in the nginx log", wantRest: "timeout errors in the nginx log"},
+		{input: "timeout 30 $sleep 40", wantRest: "timeout 30 $sleep 40"},
+		{input: "timeout -5s $ls", wantRest: "timeout -5s $ls"},
+		{input: "$timeout 5 sleep 10", wantRest: "$timeout 5 sleep 10"},
+	}
+	for _, tt := range tests {
+		timeout, rest, ok := splitTimeoutPrefix(tt.input)
+		if timeout != tt.wantTimeout || rest != tt.wantRest || ok != tt.wantOK {
+			t.Errorf("splitTimeoutPrefix(%q) = %v, %q, %v; want %v, %q, %v", tt.input, timeout, rest, ok, tt.wantTimeout, tt.wantRest, tt.wantOK)
+		}
+	}
+}
+
+func TestSessionManager(t *testing.T) {
+	newClient := func(host, user string) *sshclient.Client {
+		client, err := sshclient.NewClient(&sshclient.Config{
+			HostInfo:     &sshclient.HostInfo{Host: host, Port: 22, User: user},
+			Password:     "x",
+			UseSSHConfig: new(bool),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	var m sessionManager
+	web := m.add("web1", newClient("10.0.0.1", "ops"))
+	web2 := m.add("web1", newClient("10.0.0.2", "ops"))
+	db := m.add("db", newClient("10.0.0.3", "root"))
+
+	if web2.name != "web1-2" {
+		t.Errorf("second web1 session named %q, want web1-2", web2.name)
+	}
+	if m.active != db || m.others() != 2 {
+		t.Errorf("active = %v, others = %d; want db and 2", m.active.name, m.others())
+	}
+
+	tests := []struct {
+		ref  string
+		want *session
+	}{
+		{ref: "1", want: web},
+		{ref: "WEB1-2", want: web2},
+		{ref: "10.0.0.3", want: db},
+		{ref: "root@10.0.0.3", want: db},
+		{ref: "4", want: nil},
+		{ref: "cache", want: nil},
+	}
+	for _, tt := range tests {
+		if got := m.find(tt.ref); got != tt.want {
+			t.Errorf("find(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+
+	m.remove(db)
+	if m.active != nil || m.others() != 2 || m.find("db") != nil {
+		t.Errorf("after removing the active session: active = %v, others = %d", m.active, m.others())
+	}
+	if again := m.add("db", newClient("10.0.0.3", "root")); again.id != 4 || again.name != "db" {
+		t.Errorf("new session = %d %q, want 4 db", again.id, again.name)
+	}
+}
+
+func TestParseRunOn(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    runOnRequest
+		wantErr bool
+	}{
+		{name: "plain", args: "web* show uptime", want: runOnRequest{targets: "web*", request: "show uptime"}},
+		{name: "options", args: "--json -p 5 --timeout 30s web1,web2 $uptime", want: runOnRequest{targets: "web1,web2", request: "$uptime", json: true, parallel: 5, timeout: 30 * time.Second}},
+		{name: "no request", args: "web*", wantErr: true},
+		{name: "bad parallel", args: "--parallel 0 web* uptime", wantErr: true},
+		{name: "timeout without unit", args: "--timeout 30 web* uptime", wantErr: true},
+		{name: "unknown option", args: "--fast web* uptime", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRunOn(strings.Fields(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRunOn(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseRunOn(%q) = %+v, want %+v", tt.args, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	cfg := &config.Config{
+		Hosts:  []config.HostConfig{{Pattern: "db*", Tags: []string{"database"}}},
+		Groups: map[string][]string{"web": {"web*", "admin@lb1"}},
+	}
+	known := []history.Record{
+		{Host: "web1", Port: 22, User: "deploy"},
+		{Host: "web2", Port: 2222, User: "deploy"},
+		{Host: "db1", Port: 22, User: "postgres"},
+	}
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "web", want: "deploy@web1 deploy@web2:2222 admin@lb1"},
+		{spec: "database", want: "postgres@db1"},
+		{spec: "*1", want: "deploy@web1 postgres@db1"},
+		{spec: "web2,root@web3:2200,web2", want: "deploy@web2:2222 root@web3:2200"},
+		{spec: "new-host", want: "new-host"},
+		{spec: "cache*", wantErr: true},
+		{spec: ",", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			targets, err := resolveTargets(tt.spec, cfg, known)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveTargets(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			var names []string
+			for _, target := range targets {
+				names = append(names, target.String())
+			}
+			if got := strings.Join(names, " "); got != tt.want {
+				t.Errorf("resolveTargets(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupResults(t *testing.T) {
+	results := []*hostResult{
+		{Host: "a", Stdout: "up 3 days\n"},
+		{Host: "b", Stdout: "up 1 day\n"},
+		{Host: "c", Stdout: "up 3 days\n"},
+		{Host: "d", Stdout: "up 3 days\n", ExitCode: 1},
+		{Host: "e", Error: "connection refused"},
+	}
+
+	var got []string
+	for _, g := range groupResults(results) {
+		got = append(got, strings.Join(g.Hosts, ","))
+	}
+	if want := "a,c b d"; strings.Join(got, " ") != want {
+		t.Errorf("groupResults() = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestHostEntries(t *testing.T) {
+	results := []*hostResult{
+		{Host: "root@a", ExitCode: 1, Stdout: "out\n", Stderr: "err\n", DurationMS: 7, truncated: true},
+		{Host: "b", Error: "connection refused"},
+	}
+	entries := hostEntries("restart nginx", []string{"nginx -t", "systemctl restart nginx"}, results)
+	if len(entries) != 2 {
+		t.Fatalf("hostEntries() returned %d entries, want 2", len(entries))
+	}
+	for i, e := range entries {
+		r := results[i]
+		if e.Kind != recording.KindCommand || e.Host != r.Host || e.Request != "restart nginx" ||
+			e.Command != "nginx -t && systemctl restart nginx" || e.ExitCode == nil || *e.ExitCode != r.ExitCode ||
+			e.Stdout != r.Stdout || e.Stderr != r.Stderr || e.Error != r.Error ||
+			e.DurationMS != r.DurationMS || e.Truncated != r.truncated {
+			t.Errorf("hostEntries()[%d] = %+v, want the result of %s", i, e, r.Host)
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var (
+		mu               sync.Mutex
+		running, maxSeen int
+	)
+	connect := func(ctx context.Context, target runTarget) (sshclient.Executor, error) {
+		if target.Host == "down" {
+			return nil, errors.New("connection refused")
+		}
+		mu.Lock()
+		running++
+		maxSeen = max(maxSeen, running)
+		mu.Unlock()
+		return &countingExecutor{LocalClient: sshclient.NewLocalClient(), done: func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}}, nil
+	}
+
+	targets := []runTarget{{Host: "h1"}, {Host: "h2"}, {Host: "down"}, {Host: "h3"}, {Host: "h4"}}
+	var progress []int
+	results := fanOut(context.Background(), targets, []string{"sleep 0.1; echo hi", "exit 3", "echo unreachable"}, connect, 2, time.Minute, 0,
+		func(done int, r *hostResult) { progress = append(progress, done) })
+
+	if maxSeen > 2 {
+		t.Errorf("%d hosts ran at once, want at most 2", maxSeen)
+	}
+	if len(progress) != len(targets) || progress[len(progress)-1] != len(targets) {
+		t.Errorf("progress = %v, want one call per host", progress)
+	}
+	for i, r := range results {
+		if r.Host != targets[i].String() {
+			t.Errorf("results[%d].Host = %q, want %q", i, r.Host, targets[i].String())
+		}
+		if r.Host == "down" {
+			if r.Error != "connection refused" {
+				t.Errorf("down: Error = %q, want connection refused", r.Error)
+			}
+			continue
+		}
+		if r.Stdout != "hi\n" || r.ExitCode != 3 {
+			t.Errorf("%s: Stdout = %q, ExitCode = %d, want %q, 3", r.Host, r.Stdout, r.ExitCode, "hi\n")
+		}
+	}
+
+	results = fanOut(context.Background(), targets[:1], []string{"sleep 5"}, connect, 1, 200*time.Millisecond, 0, nil)
+	if !results[0].TimedOut {
+		t.Errorf("TimedOut = false, want true for a command over the host timeout")
+	}
+}
+
+// countingExecutor is a local executor that reports when it is closed.
+type countingExecutor struct {
+	*sshclient.LocalClient
+	done func()
+}
+
+func (e *countingExecutor) Close() error {
+	e.done()
+	return nil
+}
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		in      string
+		total   int
+		want    int
+		wantErr bool
+	}{
+		{in: "3", total: 10, want: 3},
+		{in: "25%", total: 10, want: 2},
+		{in: "100%", total: 7, want: 7},
+		{in: "0", total: 10, want: 0},
+		{in: "150%", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "some", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			c, err := parseCount(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCount(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && c.of(tt.total) != tt.want {
+				t.Errorf("parseCount(%q).of(%d) = %d, want %d", tt.in, tt.total, c.of(tt.total), tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "--batch 2 web* restart nginx", want: "--batch|2|web*|restart|nginx"},
+		{in: `--check "$systemctl is-active nginx" web*`, want: "--check|$systemctl is-active nginx|web*"},
+		{in: `--check 'curl -s "localhost"' x`, want: `--check|curl -s "localhost"|x`},
+		{in: `a\ b ""`, want: "a b|"},
+		{in: `--check "unterminated`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := splitArgs(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitArgs(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && strings.Join(got, "|") != tt.want {
+				t.Errorf("splitArgs(%q) = %q, want %q", tt.in, strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoll(t *testing.T) {
+	req, err := parseRoll([]string{"--batch", "25%", "--canary", "web1", "--check", "$true", "--max-failures", "2", "--on-failure", "stop", "web*", "restart", "nginx"})
+	if err != nil {
+		t.Fatalf("parseRoll() error = %v", err)
+	}
+	want := rollRequest{targets: "web*", request: "restart nginx", batch: count{n: 25, percent: true}, canary: "web1", check: "$true", maxFailures: count{n: 2}, stop: true}
+	if *req != want {
+		t.Errorf("parseRoll() = %+v, want %+v", *req, want)
+	}
+
+	for _, args := range []string{"web*", "--batch 0 web* x", "--on-failure retry web* x", "--canary", "--bogus 1 web* x"} {
+		if _, err := parseRoll(strings.Fields(args)); err == nil {
+			t.Errorf("parseRoll(%q) error = nil, want an error", args)
+		}
+	}
+}
+
+func TestPlanBatches(t *testing.T) {
+	targets := []runTarget{{Host: "a"}, {Host: "b"}, {Host: "c"}, {Host: "d"}, {Host: "e"}}
+	tests := []struct {
+		canary  string
+		size    int
+		want    string
+		wantErr bool
+	}{
+		{size: 2, want: "a,b c,d e"},
+		{canary: "c", size: 2, want: "c a,b d,e"},
+		{canary: "a", size: 10, want: "a b,c,d,e"},
+		{canary: "z", size: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.canary+strconv.Itoa(tt.size), func(t *testing.T) {
+			batches, err := planBatches(targets, tt.canary, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planBatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, batch := range batches {
+				var names []string
+				for _, target := range batch {
+					names = append(names, target.Host)
+				}
+				got = append(got, strings.Join(names, ","))
+			}
+			if err == nil && strings.Join(got, " ") != tt.want {
+				t.Errorf("planBatches() = %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestRollout(t *testing.T) {
+	// Hosts named bad* fail the commands, sick* fail the health check
+	connect := func(ctx context.Context, target runTarget) (sshclient.Executor, error) {
+		return &hostExecutor{LocalClient: sshclient.NewLocalClient(), host: target.Host}, nil
+	}
+	batches := func(hosts ...string) [][]runTarget {
+		var out [][]runTarget
+		for _, batch := range hosts {
+			var targets []runTarget
+			for _, h := range strings.Split(batch, ",") {
+				targets = append(targets, runTarget{Host: h})
+			}
+			out = append(out, targets)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		batches     [][]runTarget
+		canary      bool
+		maxFailures int
+		decide      func(failed int) bool
+		wantStatus  string
+		wantOK      int
+		wantFailed  int
+		wantSkipped string
+		wantAsked   int
+	}{
+		{name: "all ok", batches: batches("a", "b,c"), canary: true, wantStatus: rollCompleted, wantOK: 3},
+		{name: "canary fails", batches: batches("bad1", "b,c"), canary: true, maxFailures: 5, wantStatus: rollStopped, wantFailed: 1, wantSkipped: "b c"},
+		{name: "within threshold", batches: batches("a,bad1", "b,c"), maxFailures: 1, wantStatus: rollCompleted, wantOK: 3, wantFailed: 1},
+		{name: "health check", batches: batches("a,sick1", "b"), wantStatus: rollStopped, wantOK: 1, wantFailed: 1, wantSkipped: "b"},
+		{name: "resumed", batches: batches("bad1", "b", "bad2", "c"), decide: func(int) bool { return true }, wantStatus: rollCompleted, wantOK: 2, wantFailed: 2, wantAsked: 2},
+		{name: "aborted", batches: batches("a", "bad1", "c"), decide: func(int) bool { return false }, wantStatus: rollAborted, wantOK: 1, wantFailed: 1, wantSkipped: "c", wantAsked: 1},
+		{name: "last batch fails", batches: batches("a", "bad1"), wantStatus: rollCompleted, wantOK: 1, wantFailed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked := 0
+			ro := &rollout{
+				batches:     tt.batches,
+				commands:    []string{"exit 0"},
+				check:       []string{"echo healthy"},
+				canary:      tt.canary,
+				maxFailures: tt.maxFailures,
+				connect:     connect,
+				timeout:     time.Minute,
+			}
+			if tt.decide != nil {
+				ro.decide = func(failed int) bool {
+					asked++
+					return tt.decide(failed)
+				}
+			}
+			report := &rollReport{}
+			ro.run(context.Background(), report)
+			if report.Status != tt.wantStatus || report.OK != tt.wantOK || report.Failed != tt.wantFailed {
+				t.Errorf("run() = %s, want %s: %d ok, %d failed", report.summary(), tt.wantStatus, tt.wantOK, tt.wantFailed)
+			}
+			if got := strings.Join(report.Skipped, " "); got != tt.wantSkipped {
+				t.Errorf("Skipped = %q, want %q", got, tt.wantSkipped)
+			}
+			if asked != tt.wantAsked {
+				t.Errorf("decide called %d times, want %d", asked, tt.wantAsked)
+			}
+		})
+	}
+}
+
+// hostExecutor is a local executor standing in for a host: on hosts named
+// bad* commands fail, and on hosts named sick* the health check fails.
+type hostExecutor struct {
+	*sshclient.LocalClient
+	host string
+}
+
+func (e *hostExecutor) ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) *sshclient.ExecuteResult {
+	if strings.HasPrefix(e.host, "bad") || (strings.HasPrefix(e.host, "sick") && command == "echo healthy") {
+		command = "exit 1"
+	}
+	return e.LocalClient.ExecuteStream(ctx, command, stdout, stderr)
+}
+
+func TestParseEdit(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    editRequest
+		wantErr bool
+	}{
+		{line: "/etc/hosts", want: editRequest{path: "/etc/hosts"}},
+		{line: `--check "nginx -t" /etc/nginx/nginx.conf`, want: editRequest{path: "/etc/nginx/nginx.conf", check: "nginx -t"}},
+		{line: "/etc/sudoers --check='visudo -cf {}'", want: editRequest{path: "/etc/sudoers", check: "visudo -cf {}"}},
+		{line: "'my file.txt'", want: editRequest{path: "my file.txt"}},
+		{line: "", wantErr: true},
+		{line: "--check", wantErr: true},
+		{line: "-x /etc/hosts", wantErr: true},
+		{line: "the nginx config", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEdit(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEdit(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseEdit(%q) = %+v, want %+v", tt.line, *got, tt.want)
+		}
+	}
+
+	if got := checkCommand("visudo -cf {}", "/etc/my sudoers"); got != "visudo -cf '/etc/my sudoers'" {
+		t.Errorf("checkCommand() = %q", got)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{name: "same", old: "a\nb\n", new: "a\nb\n", want: ""},
+		{
+			name: "change in the middle",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "two hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "added to an empty file",
+			old:  "",
+			new:  "x\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "no newline at end",
+			old:  "x\n",
+			new:  "x",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-x\n+x\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff(tt.old, tt.new, "a", "b"); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReplay(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    replayRequest
+		wantErr bool
+	}{
+		{args: []string{"a.cast"}, want: replayRequest{path: "a.cast", speed: 1, idle: defaultReplayIdle}},
+		{args: []string{"--speed", "2", "--idle", "500ms", "a.cast"}, want: replayRequest{path: "a.cast", speed: 2, idle: 500 * time.Millisecond}},
+		{args: []string{"a.cast", "-i", "0"}, want: replayRequest{path: "a.cast", speed: 1}},
+		{args: nil, wantErr: true},
+		{args: []string{"--speed", "0", "a.cast"}, wantErr: true},
+		{args: []string{"--idle"}, wantErr: true},
+		{args: []string{"a.cast", "b.cast"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseReplay(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReplay(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseReplay(%q) = %+v, want %+v", tt.args, *got, tt.want)
+		}
+	}
+}
+
+func TestReplayCast(t *testing.T) {
+	events := []sshclient.CastEvent{
+		{Time: 0.01, Type: sshclient.CastOutput, Data: "a"},
+		{Time: 0.02, Type: sshclient.CastResize, Data: "100x30"},
+		{Time: 60, Type: sshclient.CastOutput, Data: "b"},
+	}
+	var out strings.Builder
+	start := time.Now()
+	if err := replayCast(context.Background(), &out, events, 2, 50*time.Millisecond); err != nil {
+		t.Fatalf("replayCast() error = %v", err)
+	}
+	if out.String() != "ab" {
+		t.Errorf("replayCast() wrote %q, want %q", out.String(), "ab")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("replayCast() took %v; long pauses should be cut to the idle limit", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := replayCast(ctx, io.Discard, events, 1, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("replayCast() after cancel = %v, want context.Canceled", err)
+	}
+}
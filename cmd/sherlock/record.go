@@ -0,0 +1,367 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warm3snow/sherlock/internal/recording"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+const (
+	replayUsage = "usage: replay [--speed N] [--idle DURATION] <file>"
+	exportUsage = "usage: sessions export [<transcript>] [-o <file.md>]"
+)
+
+// defaultReplayIdle is the longest pause replay keeps between two events.
+const defaultReplayIdle = 2 * time.Second
+
+// handleRecord shows whether the session is recorded, or turns recording
+// on or off.
+func (a *App) handleRecord(args []string) error {
+	switch {
+	case len(args) == 0:
+		if a.recorder == nil {
+			fmt.Println(a.theme.FormatInfo("Recording is off. Use 'record on' to start."))
+		} else {
+			fmt.Printf("%s %s\n", a.theme.FormatInfo("Recording to:"), a.recorder.Path())
+		}
+		return nil
+	case len(args) == 1 && strings.EqualFold(args[0], "on"):
+		if a.recorder != nil {
+			fmt.Printf("%s %s\n", a.theme.FormatInfo("Already recording to:"), a.recorder.Path())
+			return nil
+		}
+		return a.startRecording()
+	case len(args) == 1 && strings.EqualFold(args[0], "off"):
+		if a.recorder == nil {
+			fmt.Println(a.theme.FormatInfo("Recording is already off."))
+			return nil
+		}
+		path := a.recorder.Path()
+		a.stopRecording()
+		fmt.Printf("%s %s\n", a.theme.FormatSuccess("Recording stopped; transcript saved to"), path)
+		return nil
+	}
+	return errors.New("usage: record [on|off]")
+}
+
+// startRecording starts a transcript of this session.
+func (a *App) startRecording() error {
+	recorder, err := recording.NewRecorder(a.cfg.Recording.Dir)
+	if err != nil {
+		return err
+	}
+	a.recorder = recorder
+	fmt.Printf("%s %s\n", a.theme.FormatInfo("Recording to:"), recorder.Path())
+	return nil
+}
+
+// stopRecording closes the transcript, if any.
+func (a *App) stopRecording() {
+	if a.recorder != nil {
+		_ = a.recorder.Close()
+		a.recorder = nil
+	}
+}
+
+// currentHost names where commands run, for the transcript.
+func (a *App) currentHost() string {
+	if a.container() == nil && (a.sshClient == nil || !a.sshClient.IsOpen()) {
+		return "local"
+	}
+	return a.executor().HostInfoString()
+}
+
+// record adds an entry of the current host to the transcript if the
+// session is recorded.
+func (a *App) record(e recording.Entry) {
+	if a.recorder == nil {
+		return
+	}
+	a.recordOn(a.currentHost(), e)
+}
+
+// recordOn adds an entry of host to the transcript if the session is
+// recorded.
+func (a *App) recordOn(host string, e recording.Entry) {
+	if a.recorder == nil {
+		return
+	}
+	e.Host = host
+	if err := a.recorder.Add(e); err != nil {
+		fmt.Println(a.theme.FormatWarning("Failed to record: " + err.Error()))
+	}
+}
+
+// recordCommand adds a command and its result to the transcript.
+func (a *App) recordCommand(cmd string, result *sshclient.ExecuteResult, truncated bool, duration time.Duration) {
+	e := recording.Entry{
+		Kind:       recording.KindCommand,
+		Request:    a.request,
+		Command:    cmd,
+		ExitCode:   &result.ExitCode,
+		Stdout:     result.Stdout,
+		Stderr:     result.Stderr,
+		Truncated:  truncated,
+		DurationMS: duration.Milliseconds(),
+	}
+	if result.Error != nil {
+		e.Error = result.Error.Error()
+	}
+	a.record(e)
+}
+
+// recordHosts adds the outcome of commands on each host of run-on or roll
+// to the transcript.
+func (a *App) recordHosts(request string, commands []string, results []*hostResult) {
+	for _, e := range hostEntries(request, commands, results) {
+		a.recordOn(e.Host, e)
+	}
+}
+
+// hostEntries returns a transcript entry for each host, with the commands
+// joined the way they ran: one after the other until one fails.
+func hostEntries(request string, commands []string, results []*hostResult) []recording.Entry {
+	entries := make([]recording.Entry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, recording.Entry{
+			Kind:       recording.KindCommand,
+			Host:       r.Host,
+			Request:    request,
+			Command:    strings.Join(commands, " && "),
+			ExitCode:   &r.ExitCode,
+			Stdout:     r.Stdout,
+			Stderr:     r.Stderr,
+			Truncated:  r.truncated,
+			DurationMS: r.DurationMS,
+			Error:      r.Error,
+		})
+	}
+	return entries
+}
+
+// castOpener returns the recorder of interactive commands, which records
+// while the session is recorded.
+func (a *App) castOpener() sshclient.CastOpener {
+	return func(command string, width, height int) *sshclient.CastWriter {
+		if a.recorder == nil {
+			return nil
+		}
+		return a.recorder.OpenCast(a.currentHost(), command, width, height)
+	}
+}
+
+// replayRequest is a parsed replay command.
+type replayRequest struct {
+	path  string
+	speed float64
+	idle  time.Duration
+}
+
+// parseReplay parses the arguments of "replay [--speed N] [--idle DURATION] <file>".
+func parseReplay(args []string) (*replayRequest, error) {
+	req := &replayRequest{speed: 1, idle: defaultReplayIdle}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--speed", "-s", "--idle", "-i":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			if arg == "--speed" || arg == "-s" {
+				speed, err := strconv.ParseFloat(args[i], 64)
+				if err != nil || speed <= 0 {
+					return nil, fmt.Errorf("invalid speed: %s", args[i])
+				}
+				req.speed = speed
+			} else {
+				idle, err := time.ParseDuration(args[i])
+				if err != nil || idle < 0 {
+					return nil, fmt.Errorf("invalid idle time: %s", args[i])
+				}
+				req.idle = idle
+			}
+		default:
+			if strings.HasPrefix(arg, "-") || req.path != "" {
+				return nil, errors.New(replayUsage)
+			}
+			req.path = arg
+		}
+	}
+	if req.path == "" {
+		return nil, errors.New(replayUsage)
+	}
+	return req, nil
+}
+
+// handleReplay plays a recording back: an asciicast recording with its
+// timing, or a transcript as the commands and output it holds.
+func (a *App) handleReplay(args []string) error {
+	req, err := parseReplay(args)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(req.path, recording.TranscriptExt) {
+		entries, err := recording.ReadTranscript(req.path)
+		if err != nil {
+			return err
+		}
+		a.printTranscript(entries)
+		return nil
+	}
+
+	file, err := os.Open(req.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header, events, err := sshclient.ReadCast(file)
+	if err != nil {
+		return fmt.Errorf("cannot replay %s: %w", req.path, err)
+	}
+	title := header.Title
+	if title == "" {
+		title = header.Command
+	}
+	fmt.Println(a.theme.FormatInfo(fmt.Sprintf("Replaying %s (%dx%d). Press Ctrl+C to stop.", title, header.Width, header.Height)))
+
+	ctx, cancel := a.commandContext()
+	defer cancel()
+	err = replayCast(ctx, os.Stdout, events, req.speed, req.idle)
+	// Leave the terminal in its normal state whatever the recording did
+	fmt.Print("\x1b[0m\r\n")
+	if ctx.Err() != nil {
+		fmt.Println(a.theme.FormatInfo("Replay stopped."))
+		return nil
+	}
+	return err
+}
+
+// replayCast writes the output events to w with the pauses between them,
+// divided by speed and limited to idle.
+func replayCast(ctx context.Context, w io.Writer, events []sshclient.CastEvent, speed float64, idle time.Duration) error {
+	var last float64
+	for _, e := range events {
+		wait := time.Duration((e.Time - last) / speed * float64(time.Second))
+		last = e.Time
+		if idle > 0 && wait > idle {
+			wait = idle
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if e.Type == sshclient.CastOutput {
+			if _, err := io.WriteString(w, e.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printTranscript shows the requests and commands of a transcript the way
+// they were shown when they ran.
+func (a *App) printTranscript(entries []recording.Entry) {
+	for _, e := range entries {
+		stamp := e.Time.Format("2006-01-02 15:04:05")
+		switch e.Kind {
+		case recording.KindRequest:
+			fmt.Printf("\n%s %s\n", a.theme.FormatInfo(stamp+" "+e.Host+" >"), e.Request)
+			for i, cmd := range e.Commands {
+				fmt.Printf("  %d. %s\n", i+1, a.theme.FormatCommand(cmd))
+			}
+		case recording.KindCommand:
+			fmt.Printf("\n%s %s\n", a.theme.FormatInfo("$"), a.theme.FormatCommand(e.Command))
+			if e.Stdout != "" {
+				fmt.Print(a.theme.FormatStdout(ensureNewline(e.Stdout)))
+			}
+			if e.Stderr != "" {
+				fmt.Print(a.theme.FormatStderr(ensureNewline(e.Stderr)))
+			}
+			if e.Error != "" {
+				fmt.Println(a.theme.FormatError("Error: " + e.Error))
+			} else if e.ExitCode != nil && *e.ExitCode != 0 {
+				fmt.Printf("(exit code: %d)\n", *e.ExitCode)
+			}
+		case recording.KindInteractive:
+			fmt.Printf("\n%s %s %s\n", a.theme.FormatInfo("$"), a.theme.FormatCommand(e.Command),
+				a.theme.FormatDescription("(interactive; replay "+e.Cast+")"))
+		case recording.KindEdit:
+			fmt.Printf("\n%s %s %s\n", a.theme.FormatInfo(stamp+" "+e.Host+" edit"), e.Path,
+				a.theme.FormatDescription("(backup: "+e.Backup+")"))
+			fmt.Print(a.colorDiff(e.Diff))
+		}
+	}
+}
+
+// handleSessionsExport writes a transcript as Markdown: the current one,
+// or the newest one if this session is not recorded.
+func (a *App) handleSessionsExport(args []string) error {
+	var path, output string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-o" || arg == "--output":
+			if i+1 >= len(args) {
+				return errors.New(exportUsage)
+			}
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "-") || path != "":
+			return errors.New(exportUsage)
+		default:
+			path = arg
+		}
+	}
+	if path == "" && a.recorder != nil {
+		path = a.recorder.Path()
+	}
+	if path == "" {
+		latest, err := recording.Latest(a.cfg.Recording.Dir)
+		if err != nil {
+			return fmt.Errorf("%w; use 'record on' to record this session", err)
+		}
+		path = latest
+	}
+
+	entries, err := recording.ReadTranscript(path)
+	if err != nil {
+		return err
+	}
+	markdown := recording.Markdown(entries)
+	if output == "" {
+		fmt.Print(markdown)
+		return nil
+	}
+	if err := os.WriteFile(output, []byte(markdown), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Exported %d entries of %s to %s", len(entries), path, output)))
+	return nil
+}
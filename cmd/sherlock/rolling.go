@@ -0,0 +1,608 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warm3snow/sherlock/internal/history"
+	"github.com/warm3snow/sherlock/internal/recording"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+const rollUsage = "usage: roll [--batch N|N%] [--canary <host>] [--check <command|request>] [--max-failures N|N%] [--on-failure pause|stop] [--timeout DURATION] <hosts> <request>"
+
+// Statuses of a rolling run.
+const (
+	rollCompleted   = "completed"
+	rollStopped     = "stopped"
+	rollAborted     = "aborted"
+	rollInterrupted = "interrupted"
+)
+
+// count is a number of hosts, absolute or as a percentage of all hosts.
+type count struct {
+	n       int
+	percent bool
+}
+
+// parseCount parses N or N%.
+func parseCount(s string) (count, error) {
+	c := count{}
+	if strings.HasSuffix(s, "%") {
+		c.percent = true
+		s = strings.TrimSuffix(s, "%")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || (c.percent && n > 100) {
+		return count{}, fmt.Errorf("invalid count %q: want a number or a percentage such as 25%%", s)
+	}
+	c.n = n
+	return c, nil
+}
+
+// of returns the count for total hosts, rounding percentages down.
+func (c count) of(total int) int {
+	if c.percent {
+		return total * c.n / 100
+	}
+	return c.n
+}
+
+// String returns the count as given.
+func (c count) String() string {
+	if c.percent {
+		return strconv.Itoa(c.n) + "%"
+	}
+	return strconv.Itoa(c.n)
+}
+
+// rollRequest is a parsed roll command.
+type rollRequest struct {
+	targets     string
+	request     string
+	batch       count
+	canary      string
+	check       string
+	maxFailures count
+	stop        bool          // stop instead of pausing when failures pass maxFailures
+	timeout     time.Duration // 0 for the configured value
+}
+
+// parseRoll parses the arguments of roll.
+func parseRoll(args []string) (*rollRequest, error) {
+	req := &rollRequest{batch: count{n: 1}}
+	value := func(flag string) (string, error) {
+		if len(args) == 0 || args[0] == "" {
+			return "", fmt.Errorf("%s needs a value; %s", flag, rollUsage)
+		}
+		v := args[0]
+		args = args[1:]
+		return v, nil
+	}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+		v, err := value(flag)
+		if err != nil {
+			return nil, err
+		}
+		switch flag {
+		case "--batch", "-b":
+			if req.batch, err = parseCount(v); err == nil && req.batch.n == 0 {
+				err = errors.New("--batch must be at least 1")
+			}
+		case "--canary":
+			req.canary = v
+		case "--check":
+			req.check = v
+		case "--max-failures":
+			req.maxFailures, err = parseCount(v)
+		case "--on-failure":
+			switch v {
+			case "pause":
+				req.stop = false
+			case "stop":
+				req.stop = true
+			default:
+				err = fmt.Errorf("invalid --on-failure %q: want pause or stop", v)
+			}
+		case "--timeout", "-t":
+			req.timeout, err = time.ParseDuration(v)
+			if err != nil || req.timeout <= 0 {
+				err = fmt.Errorf("invalid --timeout %q: want a duration such as 30s or 5m", v)
+			}
+		default:
+			err = fmt.Errorf("unknown option %s; %s", flag, rollUsage)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < 2 {
+		return nil, errors.New(rollUsage)
+	}
+	req.targets = args[0]
+	req.request = strings.Join(args[1:], " ")
+	return req, nil
+}
+
+// splitArgs splits a command line into words. Single or double quotes keep
+// spaces in a word, e.g. --check "systemctl is-active nginx".
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// planBatches splits targets into batches of size hosts. A canary, if
+// given, runs alone before everything else.
+func planBatches(targets []runTarget, canary string, size int) ([][]runTarget, error) {
+	rest := targets
+	var batches [][]runTarget
+	if canary != "" {
+		i := -1
+		for j, t := range targets {
+			if strings.EqualFold(t.String(), canary) || strings.EqualFold(t.Host, canary) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("canary %q is not one of the hosts", canary)
+		}
+		batches = append(batches, []runTarget{targets[i]})
+		rest = append(append([]runTarget(nil), targets[:i]...), targets[i+1:]...)
+	}
+	for len(rest) > 0 {
+		n := min(size, len(rest))
+		batches = append(batches, rest[:n])
+		rest = rest[n:]
+	}
+	return batches, nil
+}
+
+// rollBatch is the outcome of one batch.
+type rollBatch struct {
+	Number int           `json:"number"`
+	Canary bool          `json:"canary,omitempty"`
+	Hosts  []*hostResult `json:"hosts"`
+	Checks []*hostResult `json:"checks,omitempty"` // health checks of the hosts that succeeded
+}
+
+// failed returns the number of hosts of the batch whose commands or health
+// check failed.
+func (b *rollBatch) failed() int {
+	n := 0
+	for _, r := range b.Hosts {
+		if r.failed() {
+			n++
+		}
+	}
+	for _, r := range b.Checks {
+		if r.failed() {
+			n++
+		}
+	}
+	return n
+}
+
+// rollReport is the report of a rolling run, as saved to history.
+type rollReport struct {
+	Request     string       `json:"request"`
+	Commands    []string     `json:"commands"`
+	Check       []string     `json:"check,omitempty"`
+	MaxFailures int          `json:"max_failures"`
+	Batches     []*rollBatch `json:"batches"`
+	Skipped     []string     `json:"skipped,omitempty"`
+	Status      string       `json:"status"`
+	OK          int          `json:"ok"`
+	Failed      int          `json:"failed"`
+}
+
+// summary returns a one-line summary of the run.
+func (r *rollReport) summary() string {
+	return fmt.Sprintf("%s: %d ok, %d failed, %d skipped", r.Status, r.OK, r.Failed, len(r.Skipped))
+}
+
+// rollout runs commands batch by batch and stops when too many hosts fail.
+type rollout struct {
+	batches     [][]runTarget
+	commands    []string
+	check       []string // health check run on the hosts of each batch, if any
+	canary      bool     // the first batch is a canary; any failure there trips
+	maxFailures int      // failed hosts allowed before pausing
+	connect     connectFunc
+	timeout     time.Duration
+	keep        int
+
+	// decide is asked whether to resume when failures pass the threshold.
+	// Without it, the run stops.
+	decide func(failed int) bool
+	// progress is called as each host finishes a step of a batch.
+	progress func(batch int, step string, done int, r *hostResult)
+}
+
+// run runs the batches in order and returns the report.
+func (ro *rollout) run(ctx context.Context, report *rollReport) {
+	report.Status = rollCompleted
+	report.MaxFailures = ro.maxFailures
+	failed := 0
+	for i, targets := range ro.batches {
+		if ctx.Err() != nil {
+			report.Status = rollInterrupted
+			ro.skip(report, i)
+			break
+		}
+
+		batch := &rollBatch{Number: i + 1, Canary: ro.canary && i == 0}
+		report.Batches = append(report.Batches, batch)
+		batch.Hosts = fanOut(ctx, targets, ro.commands, ro.connect, len(targets), ro.timeout, ro.keep, ro.progressFunc(i+1, "run"))
+		if len(ro.check) > 0 {
+			var healthy []runTarget
+			for j, r := range batch.Hosts {
+				if !r.failed() {
+					healthy = append(healthy, targets[j])
+				}
+			}
+			batch.Checks = fanOut(ctx, healthy, ro.check, ro.connect, len(targets), ro.timeout, ro.keep, ro.progressFunc(i+1, "check"))
+		}
+
+		batchFailed := batch.failed()
+		failed += batchFailed
+		if ctx.Err() != nil {
+			report.Status = rollInterrupted
+			ro.skip(report, i+1)
+			break
+		}
+		tripped := batchFailed > 0 && (batch.Canary || failed > ro.maxFailures)
+		if !tripped || i == len(ro.batches)-1 {
+			continue
+		}
+		if ro.decide == nil {
+			report.Status = rollStopped
+			ro.skip(report, i+1)
+			break
+		}
+		if !ro.decide(failed) {
+			report.Status = rollAborted
+			ro.skip(report, i+1)
+			break
+		}
+	}
+
+	for _, batch := range report.Batches {
+		bad := batch.failed()
+		report.Failed += bad
+		report.OK += len(batch.Hosts) - bad
+	}
+}
+
+// skip records the hosts of the batches from i on as skipped.
+func (ro *rollout) skip(report *rollReport, i int) {
+	for _, targets := range ro.batches[i:] {
+		for _, t := range targets {
+			report.Skipped = append(report.Skipped, t.String())
+		}
+	}
+}
+
+func (ro *rollout) progressFunc(batch int, step string) func(done int, r *hostResult) {
+	if ro.progress == nil {
+		return nil
+	}
+	return func(done int, r *hostResult) { ro.progress(batch, step, done, r) }
+}
+
+// handleRoll runs a request on many hosts batch by batch.
+func (a *App) handleRoll(line string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	req, err := parseRoll(args)
+	if err != nil {
+		return err
+	}
+
+	var known []history.Record
+	if a.historyManager != nil {
+		known = a.historyManager.GetRecords()
+	}
+	targets, err := resolveTargets(req.targets, a.cfg, known)
+	if err != nil {
+		return err
+	}
+	batches, err := planBatches(targets, req.canary, max(1, req.batch.of(len(targets))))
+	if err != nil {
+		return err
+	}
+
+	cmdInfo, err := a.agent.ParseCommandRequest(a.ctx, req.request)
+	if err != nil {
+		return fmt.Errorf("failed to parse command request: %w", err)
+	}
+	check, err := a.healthCheckCommands(req.check)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range append(append([]string(nil), cmdInfo.Commands...), check...) {
+		if _, ok, _ := parseTransferCommand(cmd); ok {
+			return errors.New("file transfers cannot be run with roll")
+		}
+		if sshclient.IsInteractiveCommand(cmd) {
+			return fmt.Errorf("interactive command %q cannot be run with roll", cmd)
+		}
+	}
+	a.recordOn(req.targets, recording.Entry{
+		Kind:        recording.KindRequest,
+		Request:     req.request,
+		Commands:    append(append([]string(nil), cmdInfo.Commands...), check...),
+		Description: cmdInfo.Description,
+	})
+
+	maxFailures := req.maxFailures.of(len(targets))
+	fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("Commands to roll out to %d host(s):", len(targets))))
+	for i, cmd := range cmdInfo.Commands {
+		fmt.Printf("  %d. %s\n", i+1, a.theme.FormatCommand(cmd))
+	}
+	fmt.Printf("%s %s\n", a.theme.FormatInfo("Description:"), a.theme.FormatDescription(cmdInfo.Description))
+	if len(check) > 0 {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo("Health check:"), a.theme.FormatCommand(strings.Join(check, " && ")))
+	}
+	for i, batch := range batches {
+		label := fmt.Sprintf("Batch %d:", i+1)
+		if req.canary != "" && i == 0 {
+			label = "Canary:"
+		}
+		fmt.Printf("%s %s\n", a.theme.FormatInfo(label), joinTargets(batch, 10))
+	}
+	action := "pause"
+	if req.stop {
+		action = "stop"
+	}
+	fmt.Printf("%s %s\n", a.theme.FormatInfo("On failure:"), fmt.Sprintf("%s once more than %d host(s) fail", action, maxFailures))
+
+	prompt := "Start the rollout? [y/N]: "
+	if cmdInfo.NeedsConfirm {
+		prompt = "⚠️  This operation may be dangerous. Start the rollout? [y/N]: "
+	}
+	if !a.confirm(prompt) {
+		fmt.Println(a.theme.FormatInfo("Operation cancelled."))
+		return nil
+	}
+
+	timeout := req.timeout
+	if timeout == 0 {
+		timeout = time.Duration(a.cfg.Execution.HostTimeout) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = defaultHostTimeout
+	}
+	ro := &rollout{
+		batches:     batches,
+		commands:    cmdInfo.Commands,
+		check:       check,
+		canary:      req.canary != "",
+		maxFailures: maxFailures,
+		connect:     a.connectTarget,
+		timeout:     timeout,
+		keep:        a.cfg.Output.MaxKeptBytes,
+		progress: func(batch int, step string, done int, r *hostResult) {
+			fmt.Printf("[batch %d/%d %s %d] %s %s\n", batch, len(batches), step, done, r.Host, a.describeHostResult(r))
+		},
+	}
+	if !req.stop {
+		ro.decide = func(failed int) bool {
+			fmt.Println(a.theme.FormatWarning(fmt.Sprintf("%d host(s) failed; the rollout is paused.", failed)))
+			return a.confirm("Resume with the next batch? [y/N]: ")
+		}
+	}
+
+	fmt.Printf("\n%s\n", a.theme.FormatInfo("Rolling out (Ctrl+C to stop)..."))
+	ctx, cancel := a.commandContext()
+	defer cancel()
+	report := &rollReport{Request: req.request, Commands: cmdInfo.Commands, Check: check}
+	ro.run(ctx, report)
+	for _, batch := range report.Batches {
+		a.recordHosts(req.request, report.Commands, batch.Hosts)
+		a.recordHosts(req.request, report.Check, batch.Checks)
+	}
+
+	a.printRollReport(report)
+	a.saveRun("roll", report.Request, report.Status, report.summary(), report)
+	return nil
+}
+
+// healthCheckCommands returns the commands of a health check: "$<command>"
+// is run as is, anything else is translated.
+func (a *App) healthCheckCommands(check string) ([]string, error) {
+	check = strings.TrimSpace(check)
+	switch {
+	case check == "":
+		return nil, nil
+	case strings.HasPrefix(check, "$"):
+		return []string{strings.TrimSpace(check[1:])}, nil
+	}
+	cmdInfo, err := a.agent.ParseCommandRequest(a.ctx, check)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse health check: %w", err)
+	}
+	return cmdInfo.Commands, nil
+}
+
+// confirm asks a yes/no question.
+func (a *App) confirm(question string) bool {
+	fmt.Print("\n" + a.theme.FormatWarning(question))
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// printRollReport shows the outcome of each batch, the output grouped by
+// host, and the failures.
+func (a *App) printRollReport(report *rollReport) {
+	var hosts, checks []*hostResult
+	for _, batch := range report.Batches {
+		hosts = append(hosts, batch.Hosts...)
+		checks = append(checks, batch.Checks...)
+	}
+	a.printOutputGroups(hosts)
+
+	fmt.Println()
+	fmt.Println(a.theme.FormatTableHeader("Batches:"))
+	for _, batch := range report.Batches {
+		label := fmt.Sprintf("batch %d", batch.Number)
+		if batch.Canary {
+			label = "canary"
+		}
+		line := fmt.Sprintf("  %-8s %d host(s), %d failed", label, len(batch.Hosts), batch.failed())
+		if batch.failed() > 0 {
+			fmt.Println(a.theme.FormatWarning(line))
+		} else {
+			fmt.Println(a.theme.FormatSuccess(line))
+		}
+	}
+
+	fmt.Println()
+	summary := report.summary()
+	if report.Status == rollCompleted && report.Failed == 0 {
+		fmt.Println(a.theme.FormatSuccess(summary))
+		return
+	}
+	fmt.Println(a.theme.FormatWarning(summary))
+	a.printFailures(hosts)
+	for _, r := range checks {
+		if r.failed() {
+			fmt.Printf("  %s %s\n", a.theme.FormatCommand(r.Host), a.theme.FormatWarning("health check failed: "+describeFailure(r)))
+		}
+	}
+	if len(report.Skipped) > 0 {
+		fmt.Printf("  %s %s\n", a.theme.FormatInfo("Not run on:"), strings.Join(report.Skipped, ", "))
+	}
+}
+
+// describeFailure says why a host failed.
+func describeFailure(r *hostResult) string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("exit code %d", r.ExitCode)
+}
+
+// saveRun saves the report of a run to history.
+func (a *App) saveRun(kind, request, status, summary string, report any) {
+	if a.historyManager == nil {
+		return
+	}
+	data, err := json.Marshal(report)
+	if err == nil {
+		var id int64
+		id, err = a.historyManager.AddRun(&history.RunRecord{Kind: kind, Request: request, Status: status, Summary: summary, Report: string(data)})
+		if err == nil {
+			fmt.Println(a.theme.FormatInfo(fmt.Sprintf("Report saved as run %d; 'runs %d' shows it again.", id, id)))
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s %v\n", a.theme.FormatWarning("Warning: Failed to save the run report:"), err)
+}
+
+// handleRuns lists the saved runs, or shows one.
+func (a *App) handleRuns(args []string) error {
+	if a.historyManager == nil {
+		return errors.New("history is not available")
+	}
+	if len(args) == 0 {
+		runs := a.historyManager.GetRuns()
+		if len(runs) == 0 {
+			fmt.Println(a.theme.FormatInfo("No saved runs."))
+			return nil
+		}
+		fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("%-5s %-19s %-5s %-40s %s", "ID", "Time", "Kind", "Result", "Request")))
+		for _, run := range runs {
+			fmt.Printf("%-5d %-19s %-5s %-40s %s\n", run.ID, run.Timestamp.Local().Format("2006-01-02 15:04:05"), run.Kind, run.Summary, run.Request)
+		}
+		return nil
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || len(args) > 1 {
+		return errors.New("usage: runs [id]")
+	}
+	run, err := a.historyManager.GetRunByID(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s (%s)\n", a.theme.FormatInfo(fmt.Sprintf("Run %d:", run.ID)), run.Request, run.Timestamp.Local().Format("2006-01-02 15:04:05"))
+	switch run.Kind {
+	case "roll":
+		var report rollReport
+		if err := json.Unmarshal([]byte(run.Report), &report); err != nil {
+			return fmt.Errorf("failed to read run report: %w", err)
+		}
+		a.printRollReport(&report)
+	default:
+		fmt.Println(run.Report)
+	}
+	return nil
+}
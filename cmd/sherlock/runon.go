@@ -0,0 +1,556 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warm3snow/sherlock/internal/config"
+	"github.com/warm3snow/sherlock/internal/history"
+	"github.com/warm3snow/sherlock/internal/recording"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+const runOnUsage = "usage: run-on [--json] [--parallel N] [--timeout DURATION] <group|tag|pattern|host,host,...> <request>"
+
+const (
+	// defaultRunOnParallel is how many hosts run-on works on at once.
+	defaultRunOnParallel = 10
+	// defaultHostTimeout is how long run-on gives each host.
+	defaultHostTimeout = 5 * time.Minute
+)
+
+// runOnRequest is a parsed run-on command.
+type runOnRequest struct {
+	targets  string
+	request  string
+	json     bool
+	parallel int           // 0 for the configured value
+	timeout  time.Duration // 0 for the configured value
+}
+
+// parseRunOn parses the arguments of run-on.
+func parseRunOn(args []string) (*runOnRequest, error) {
+	req := &runOnRequest{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+		switch flag {
+		case "--json":
+			req.json = true
+		case "--parallel", "-p":
+			if len(args) == 0 {
+				return nil, errors.New(runOnUsage)
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid --parallel %q: want a positive number", args[0])
+			}
+			req.parallel = n
+			args = args[1:]
+		case "--timeout", "-t":
+			if len(args) == 0 {
+				return nil, errors.New(runOnUsage)
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid --timeout %q: want a duration such as 30s or 5m", args[0])
+			}
+			req.timeout = d
+			args = args[1:]
+		default:
+			return nil, fmt.Errorf("unknown option %s; %s", flag, runOnUsage)
+		}
+	}
+	if len(args) < 2 {
+		return nil, errors.New(runOnUsage)
+	}
+	req.targets = args[0]
+	req.request = strings.Join(args[1:], " ")
+	return req, nil
+}
+
+// runTarget is a host to run on.
+type runTarget struct {
+	Host string
+	Port int
+	User string // empty for the ssh_config or local user
+}
+
+// String returns [user@]host[:port], leaving out the default port.
+func (t runTarget) String() string {
+	s := t.Host
+	if t.User != "" {
+		s = t.User + "@" + s
+	}
+	if t.Port != 0 && t.Port != 22 {
+		s += ":" + strconv.Itoa(t.Port)
+	}
+	return s
+}
+
+// resolveTargets expands a comma-separated list of groups, tags, glob
+// patterns and [user@]host[:port] into hosts. Groups come from the config;
+// tags and patterns are matched against the hosts in the history, which
+// also supply the user and port of hosts given by name only.
+func resolveTargets(spec string, cfg *config.Config, known []history.Record) ([]runTarget, error) {
+	var targets []runTarget
+	seen := make(map[string]bool)
+	add := func(t runTarget) {
+		if key := t.String(); !seen[key] {
+			seen[key] = true
+			targets = append(targets, t)
+		}
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		members, isGroup := cfg.Groups[part]
+		if !isGroup {
+			members = []string{part}
+		}
+		for _, member := range members {
+			found, err := expandTarget(member, cfg, known, !isGroup)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range found {
+				add(t)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hosts in %q", spec)
+	}
+	return targets, nil
+}
+
+// expandTarget resolves one group member or list entry. Tags are only
+// looked up for list entries, not for group members.
+func expandTarget(entry string, cfg *config.Config, known []history.Record, tags bool) ([]runTarget, error) {
+	if strings.ContainsAny(entry, "*?[") {
+		var found []runTarget
+		for _, r := range known {
+			hostMatch, _ := path.Match(entry, r.Host)
+			userMatch, _ := path.Match(entry, r.User+"@"+r.Host)
+			if hostMatch || userMatch {
+				found = append(found, runTarget{Host: r.Host, Port: r.Port, User: r.User})
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no known host matches %q; connect to hosts once to add them to the history", entry)
+		}
+		return found, nil
+	}
+
+	if tags {
+		var found []runTarget
+		for _, r := range known {
+			if slices.Contains(cfg.HostTags(r.Host), entry) {
+				found = append(found, runTarget{Host: r.Host, Port: r.Port, User: r.User})
+			}
+		}
+		if len(found) > 0 {
+			return found, nil
+		}
+	}
+
+	hosts, err := sshclient.ParseJumpSpec(entry)
+	if err != nil || len(hosts) != 1 {
+		return nil, fmt.Errorf("invalid host %q", entry)
+	}
+	t := runTarget{Host: hosts[0].Host, Port: hosts[0].Port, User: hosts[0].User}
+	if t.User == "" {
+		for _, r := range known {
+			if strings.EqualFold(r.Host, t.Host) && (t.Port == 22 || t.Port == r.Port) {
+				t.User, t.Port = r.User, r.Port
+				break
+			}
+		}
+	}
+	return []runTarget{t}, nil
+}
+
+// hostResult is the outcome of a request on one host.
+type hostResult struct {
+	Host       string        `json:"host"`
+	ExitCode   int           `json:"exit_code"`
+	Stdout     string        `json:"stdout"`
+	Stderr     string        `json:"stderr"`
+	Error      string        `json:"error,omitempty"`
+	TimedOut   bool          `json:"timed_out,omitempty"`
+	DurationMS int64         `json:"duration_ms"`
+	duration   time.Duration // for the progress line
+	truncated  bool          // the middle of long output was dropped
+}
+
+// failed reports whether the host could not run the commands or one failed.
+func (r *hostResult) failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// connectFunc opens a connection to a target.
+type connectFunc func(ctx context.Context, t runTarget) (sshclient.Executor, error)
+
+// fanOut runs commands on every target, on at most parallel hosts at once,
+// stopping at the first failing command of a host. Each host gets timeout to
+// connect and run everything. progress is called as each host finishes, one
+// call at a time. Results are in the order of targets.
+func fanOut(ctx context.Context, targets []runTarget, commands []string, connect connectFunc,
+	parallel int, timeout time.Duration, keep int, progress func(done int, r *hostResult)) []*hostResult {
+	results := make([]*hostResult, len(targets))
+	sem := make(chan struct{}, parallel)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := runOnHost(ctx, t, commands, connect, timeout, keep)
+			results[i] = r
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if progress != nil {
+				progress(done, r)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runOnHost connects to one target and runs the commands there.
+func runOnHost(ctx context.Context, t runTarget, commands []string, connect connectFunc, timeout time.Duration, keep int) *hostResult {
+	start := time.Now()
+	r := &hostResult{Host: t.String()}
+	defer func() {
+		r.duration = time.Since(start)
+		r.DurationMS = r.duration.Milliseconds()
+	}()
+	if ctx.Err() != nil {
+		r.Error = sshclient.ErrInterrupted.Error()
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	executor, err := connect(ctx, t)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			r.TimedOut = true
+			err = fmt.Errorf("connection timed out after %s", timeout)
+		}
+		r.Error = err.Error()
+		return r
+	}
+	defer executor.Close()
+
+	stdout := sshclient.NewOutputBuffer(keep)
+	stderr := sshclient.NewOutputBuffer(keep)
+	for _, cmd := range commands {
+		result := executor.ExecuteStream(ctx, cmd, stdout, stderr)
+		r.ExitCode = result.ExitCode
+		if result.TimedOut {
+			r.TimedOut = true
+			r.Error = fmt.Sprintf("%v after %s", sshclient.ErrTimedOut, timeout)
+		} else if result.Error != nil {
+			r.Error = result.Error.Error()
+		}
+		if r.failed() {
+			break
+		}
+	}
+	r.Stdout = stdout.String()
+	r.Stderr = stderr.String()
+	r.truncated = stdout.Truncated() || stderr.Truncated()
+	return r
+}
+
+// outputGroup is a set of hosts whose commands gave the same output.
+type outputGroup struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Hosts    []string
+}
+
+// groupResults groups the hosts that ran the commands by identical output
+// and exit code, largest group first. Hosts that could not run them are
+// left out; they are listed with the failures.
+func groupResults(results []*hostResult) []*outputGroup {
+	var groups []*outputGroup
+	byKey := make(map[string]*outputGroup)
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		key := fmt.Sprintf("%d\x00%s\x00%s", r.ExitCode, r.Stdout, r.Stderr)
+		g, ok := byKey[key]
+		if !ok {
+			g = &outputGroup{Stdout: r.Stdout, Stderr: r.Stderr, ExitCode: r.ExitCode}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.Hosts = append(g.Hosts, r.Host)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
+
+// runOnReport is the JSON output of run-on --json.
+type runOnReport struct {
+	Request  string        `json:"request"`
+	Commands []string      `json:"commands"`
+	Hosts    []*hostResult `json:"hosts"`
+	OK       int           `json:"ok"`
+	Failed   int           `json:"failed"`
+}
+
+// handleRunOn translates a request once and runs it on many hosts at once.
+func (a *App) handleRunOn(args []string) error {
+	req, err := parseRunOn(args)
+	if err != nil {
+		return err
+	}
+
+	var known []history.Record
+	if a.historyManager != nil {
+		known = a.historyManager.GetRecords()
+	}
+	targets, err := resolveTargets(req.targets, a.cfg, known)
+	if err != nil {
+		return err
+	}
+
+	// With --json, only the report goes to stdout
+	var out io.Writer = os.Stdout
+	if req.json {
+		out = os.Stderr
+	}
+
+	cmdInfo, err := a.agent.ParseCommandRequest(a.ctx, req.request)
+	if err != nil {
+		return fmt.Errorf("failed to parse command request: %w", err)
+	}
+	for _, cmd := range cmdInfo.Commands {
+		if _, ok, _ := parseTransferCommand(cmd); ok {
+			return errors.New("file transfers cannot be run with run-on")
+		}
+		if sshclient.IsInteractiveCommand(cmd) {
+			return fmt.Errorf("interactive command %q cannot be run with run-on", cmd)
+		}
+	}
+	a.recordOn(req.targets, recording.Entry{
+		Kind:        recording.KindRequest,
+		Request:     req.request,
+		Commands:    cmdInfo.Commands,
+		Description: cmdInfo.Description,
+	})
+
+	fmt.Fprintln(out, a.theme.FormatTableHeader(fmt.Sprintf("Commands to execute on %d host(s):", len(targets))))
+	for i, cmd := range cmdInfo.Commands {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, a.theme.FormatCommand(cmd))
+	}
+	fmt.Fprintf(out, "%s %s\n", a.theme.FormatInfo("Description:"), a.theme.FormatDescription(cmdInfo.Description))
+	fmt.Fprintf(out, "%s %s\n", a.theme.FormatInfo("Hosts:"), joinTargets(targets, 10))
+
+	if cmdInfo.NeedsConfirm {
+		fmt.Fprint(out, "\n"+a.theme.FormatWarning(fmt.Sprintf("⚠️  This operation may be dangerous and runs on %d host(s). Continue? [y/N]: ", len(targets))))
+		reader := bufio.NewReader(os.Stdin)
+		confirm, _ := reader.ReadString('\n')
+		confirm = strings.TrimSpace(strings.ToLower(confirm))
+		if confirm != "y" && confirm != "yes" {
+			fmt.Fprintln(out, a.theme.FormatInfo("Operation cancelled."))
+			return nil
+		}
+	}
+
+	parallel := req.parallel
+	if parallel == 0 {
+		parallel = a.cfg.Execution.Parallel
+	}
+	if parallel <= 0 {
+		parallel = defaultRunOnParallel
+	}
+	timeout := req.timeout
+	if timeout == 0 {
+		timeout = time.Duration(a.cfg.Execution.HostTimeout) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = defaultHostTimeout
+	}
+
+	fmt.Fprintf(out, "\n%s\n", a.theme.FormatInfo(fmt.Sprintf("Running on %d host(s), %d at a time (Ctrl+C to stop)...", len(targets), min(parallel, len(targets)))))
+	ctx, cancel := a.commandContext()
+	defer cancel()
+	results := fanOut(ctx, targets, cmdInfo.Commands, a.connectTarget, parallel, timeout, a.cfg.Output.MaxKeptBytes,
+		func(done int, r *hostResult) {
+			fmt.Fprintf(out, "[%d/%d] %s %s\n", done, len(targets), r.Host, a.describeHostResult(r))
+		})
+
+	a.recordHosts(req.request, cmdInfo.Commands, results)
+
+	report := &runOnReport{Request: req.request, Commands: cmdInfo.Commands, Hosts: results}
+	for _, r := range results {
+		if r.failed() {
+			report.Failed++
+		} else {
+			report.OK++
+		}
+	}
+
+	if req.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	a.printRunOnReport(report)
+	return nil
+}
+
+// describeHostResult returns the outcome of a host for the progress line.
+func (a *App) describeHostResult(r *hostResult) string {
+	elapsed := r.duration.Round(100 * time.Millisecond)
+	switch {
+	case r.Error != "":
+		return a.theme.FormatError(fmt.Sprintf("error: %s (%s)", r.Error, elapsed))
+	case r.ExitCode != 0:
+		return a.theme.FormatWarning(fmt.Sprintf("exit %d (%s)", r.ExitCode, elapsed))
+	default:
+		return a.theme.FormatSuccess(fmt.Sprintf("ok (%s)", elapsed))
+	}
+}
+
+// printRunOnReport shows the output of each group of hosts with the same
+// output, then the failures.
+func (a *App) printRunOnReport(report *runOnReport) {
+	a.printOutputGroups(report.Hosts)
+
+	fmt.Println()
+	summary := fmt.Sprintf("%d host(s): %d ok, %d failed", len(report.Hosts), report.OK, report.Failed)
+	if report.Failed == 0 {
+		fmt.Println(a.theme.FormatSuccess(summary))
+		return
+	}
+	fmt.Println(a.theme.FormatWarning(summary))
+	a.printFailures(report.Hosts)
+}
+
+// printOutputGroups shows the output of each group of hosts with the same
+// output, largest group first.
+func (a *App) printOutputGroups(results []*hostResult) {
+	for _, g := range groupResults(results) {
+		header := fmt.Sprintf("=== %d host(s): %s", len(g.Hosts), strings.Join(g.Hosts, ", "))
+		if g.ExitCode != 0 {
+			header += fmt.Sprintf(" (exit code %d)", g.ExitCode)
+		}
+		fmt.Printf("\n%s\n", a.theme.FormatTableHeader(header))
+		if g.Stdout == "" && g.Stderr == "" {
+			fmt.Println(a.theme.FormatInfo("(no output)"))
+		}
+		if g.Stdout != "" {
+			fmt.Print(a.theme.FormatStdout(ensureNewline(g.Stdout)))
+		}
+		if g.Stderr != "" {
+			fmt.Print(a.theme.FormatStderr(ensureNewline(g.Stderr)))
+		}
+	}
+}
+
+// printFailures lists the hosts that failed and why.
+func (a *App) printFailures(results []*hostResult) {
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Printf("  %s %s\n", a.theme.FormatCommand(r.Host), a.theme.FormatError(r.Error))
+		case r.ExitCode != 0:
+			fmt.Printf("  %s %s\n", a.theme.FormatCommand(r.Host), a.theme.FormatWarning(fmt.Sprintf("exit code %d", r.ExitCode)))
+		}
+	}
+}
+
+// connectTarget opens a connection for run-on. Nothing is asked while many
+// hosts are being connected to, so only keys and the agent are used, and
+// hosts whose key is not known yet are skipped.
+func (a *App) connectTarget(ctx context.Context, t runTarget) (sshclient.Executor, error) {
+	autoReconnect := false
+	client, err := sshclient.NewClient(&sshclient.Config{
+		HostInfo:          &sshclient.HostInfo{Host: t.Host, Port: t.Port, User: t.User},
+		PrivateKeyPath:    a.cfg.SSHKey.PrivateKeyPath,
+		KeepAliveInterval: time.Duration(a.cfg.SSH.KeepAliveInterval) * time.Second,
+		AutoReconnect:     &autoReconnect,
+		HostKeyPrompt:     func(sshclient.HostKeyInfo) bool { return false },
+		ForwardAgent:      a.cfg.HostForwardAgent(t.Host),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(ctx); err != nil {
+		var changed *sshclient.HostKeyChangedError
+		switch {
+		case errors.As(err, &changed):
+			return nil, errors.New("host key has changed; connect to it directly to check")
+		case errors.Is(err, sshclient.ErrHostKeyRejected):
+			return nil, errors.New("host key is not known yet; connect to it once to trust it")
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// joinTargets lists up to limit targets, and how many more there are.
+func joinTargets(targets []runTarget, limit int) string {
+	names := make([]string, 0, limit)
+	for i, t := range targets {
+		if i == limit {
+			return strings.Join(names, ", ") + fmt.Sprintf(" and %d more", len(targets)-limit)
+		}
+		names = append(names, t.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// ensureNewline ends s with a newline.
+func ensureNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
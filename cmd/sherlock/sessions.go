@@ -0,0 +1,330 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/warm3snow/sherlock/internal/agent"
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// session is a live connection kept open while others are used.
+type session struct {
+	id        int
+	name      string // the host as given when connecting, made unique
+	client    *sshclient.Client
+	facts     *agent.HostFacts  // detected on first use, for offline translation
+	container *containerSession // container commands run in, if entered
+}
+
+// sessionManager keeps the open connections. Commands run on the active
+// one, or locally if none is active.
+type sessionManager struct {
+	sessions []*session
+	active   *session
+	lastID   int
+}
+
+// add keeps a new connection under name, or name-2, name-3, ... if name is
+// taken, and makes it the active one.
+func (m *sessionManager) add(name string, client *sshclient.Client) *session {
+	unique := name
+	for n := 2; m.byName(unique) != nil; n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	m.lastID++
+	s := &session{id: m.lastID, name: unique, client: client}
+	m.sessions = append(m.sessions, s)
+	m.active = s
+	return s
+}
+
+// byName returns the session called name, ignoring case.
+func (m *sessionManager) byName(name string) *session {
+	for _, s := range m.sessions {
+		if strings.EqualFold(s.name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// find returns the session with the ID or name ref, or else the only one
+// connected to ref as host or user@host.
+func (m *sessionManager) find(ref string) *session {
+	if id, err := strconv.Atoi(ref); err == nil {
+		for _, s := range m.sessions {
+			if s.id == id {
+				return s
+			}
+		}
+		return nil
+	}
+	if s := m.byName(ref); s != nil {
+		return s
+	}
+	var found *session
+	for _, s := range m.sessions {
+		info := s.client.HostInfo()
+		if strings.EqualFold(info.Host, ref) || strings.EqualFold(info.User+"@"+info.Host, ref) {
+			if found != nil {
+				return nil
+			}
+			found = s
+		}
+	}
+	return found
+}
+
+// byClient returns the session of client.
+func (m *sessionManager) byClient(client *sshclient.Client) *session {
+	for _, s := range m.sessions {
+		if s.client == client {
+			return s
+		}
+	}
+	return nil
+}
+
+// remove forgets a session. If it was active, none is active afterwards.
+func (m *sessionManager) remove(s *session) {
+	for i, other := range m.sessions {
+		if other == s {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			break
+		}
+	}
+	if m.active == s {
+		m.active = nil
+	}
+}
+
+// others returns the number of sessions besides the active one.
+func (m *sessionManager) others() int {
+	if m.active != nil {
+		return len(m.sessions) - 1
+	}
+	return len(m.sessions)
+}
+
+// addSession keeps a new connection and switches to it.
+func (a *App) addSession(name string, client *sshclient.Client) {
+	s := a.sessions.add(name, client)
+	client.SetCastRecorder(a.castOpener())
+	a.sshClient = client
+	a.refreshHostContext()
+	if n := a.sessions.others(); n > 0 {
+		fmt.Println(a.theme.FormatInfo(fmt.Sprintf("Session %d (%s) is now active; %d other session(s) stay open. Use 'sessions' to list them.", s.id, s.name, n)))
+	}
+}
+
+// activate makes s the session commands run on, or the local host if s is nil.
+func (a *App) activate(s *session) {
+	a.sessions.active = s
+	a.sshClient = nil
+	if s != nil {
+		a.sshClient = s.client
+	}
+	a.refreshHostContext()
+}
+
+// pruneSessions forgets sessions whose connection ended, e.g. because
+// reconnecting failed. If the active one ended, commands run locally.
+func (a *App) pruneSessions() {
+	for _, s := range append([]*session(nil), a.sessions.sessions...) {
+		if s.client.IsOpen() {
+			continue
+		}
+		wasActive := a.sessions.active == s
+		a.sessions.remove(s)
+		if wasActive {
+			a.activate(nil)
+		}
+	}
+	// The active client may have been closed outside the manager
+	if a.sshClient != nil && !a.sshClient.IsOpen() {
+		a.activate(nil)
+	}
+}
+
+// showSessions lists the open sessions.
+func (a *App) showSessions() error {
+	a.pruneSessions()
+	if len(a.sessions.sessions) == 0 {
+		fmt.Println(a.theme.FormatInfo("No open sessions. Commands run locally on " + a.localClient.HostInfoString() + "."))
+		return nil
+	}
+
+	fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("  %-4s %-16s %-32s %-7s %s", "ID", "Name", "Host", "Tunnels", "Working directory")))
+	for _, s := range a.sessions.sessions {
+		marker := " "
+		if s == a.sessions.active {
+			marker = "*"
+		}
+		line := fmt.Sprintf("%s %-4d %-16s %-32s %-7d %s", marker, s.id, s.name, s.client.HostInfoString(), len(s.client.Tunnels()), s.client.GetCwd())
+		if s == a.sessions.active {
+			line = a.theme.FormatSuccess(line)
+		}
+		fmt.Println(line)
+	}
+	if a.sessions.active == nil {
+		fmt.Println(a.theme.FormatInfo("No session is active; commands run locally."))
+	}
+	return nil
+}
+
+// useSession switches to the session ref, or to the local host for "local".
+func (a *App) useSession(ref string) error {
+	if strings.EqualFold(ref, "local") {
+		a.activate(nil)
+		fmt.Println(a.theme.FormatSuccess("Commands now run locally on " + a.localClient.HostInfoString() + "."))
+		return nil
+	}
+	a.pruneSessions()
+	s := a.sessions.find(ref)
+	if s == nil {
+		return fmt.Errorf("no session %q; use 'sessions' to list them", ref)
+	}
+	a.activate(s)
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Switched to session %d (%s), %s.", s.id, s.name, s.client.HostInfoString())))
+	return nil
+}
+
+// closeSession closes the session ref.
+func (a *App) closeSession(ref string) error {
+	s := a.sessions.find(ref)
+	if s == nil {
+		return fmt.Errorf("no session %q; use 'sessions' to list them", ref)
+	}
+	a.endSession(s)
+	fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Closed session %d (%s).", s.id, s.name)))
+	return nil
+}
+
+// endSession closes a session and forgets it.
+func (a *App) endSession(s *session) {
+	if n := len(s.client.Tunnels()); n > 0 {
+		fmt.Printf("Closing %d tunnel(s).\n", n)
+	}
+	_ = s.client.Close()
+	wasActive := a.sessions.active == s
+	a.sessions.remove(s)
+	if wasActive {
+		a.activate(nil)
+		if n := len(a.sessions.sessions); n > 0 {
+			fmt.Println(a.theme.FormatInfo(fmt.Sprintf("Commands now run locally; %d other session(s) are open. Use 'use <name>' to switch.", n)))
+		}
+	}
+}
+
+// splitSessionPrefix splits "@<session> <request>" if a session is called
+// that, or connected to that host.
+func (a *App) splitSessionPrefix(input string) (*session, string, bool) {
+	if !strings.HasPrefix(input, "@") {
+		return nil, input, false
+	}
+	ref, rest, _ := strings.Cut(input[1:], " ")
+	s := a.sessions.find(ref)
+	if s == nil {
+		return nil, input, false
+	}
+	return s, strings.TrimSpace(rest), true
+}
+
+// runOnSession handles one request on a session other than the active one.
+func (a *App) runOnSession(s *session, input string) error {
+	if input == "" {
+		return fmt.Errorf("usage: @%s <request>", s.name)
+	}
+	if first := strings.ToLower(strings.Fields(input)[0]); first == "use" || first == "close" ||
+		first == "connect" || first == "ssh" || first == "disconnect" || first == "sessions" {
+		return errors.New("session commands cannot be run with @<session>")
+	}
+	if !s.client.IsOpen() {
+		a.pruneSessions()
+		return fmt.Errorf("session %s is closed", s.name)
+	}
+
+	active := a.sessions.active
+	a.activate(s)
+	defer func() {
+		if active != nil && a.sessions.byClient(active.client) == nil {
+			active = nil
+		}
+		a.activate(active)
+	}()
+	return a.handleInput(input)
+}
+
+// refreshHostContext tells the agent about the current host: the facts used
+// by offline command translation and the tags used by model routing.
+func (a *App) refreshHostContext() {
+	if c := a.container(); c != nil {
+		a.agent.SetHostFacts(c.facts)
+		a.agent.SetContainer(containerDescription(c.client.Target()))
+	} else {
+		a.agent.SetContainer("")
+	}
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		s := a.sessions.byClient(a.sshClient)
+		var facts *agent.HostFacts
+		if s != nil {
+			facts = s.facts
+		}
+		if facts == nil {
+			facts = agent.DetectHostFacts(a.ctx, a.sshClient)
+			if s != nil {
+				s.facts = facts
+			}
+		}
+		if a.container() == nil {
+			a.agent.SetHostFacts(facts)
+		}
+		a.agent.SetHostTags(a.cfg.HostTags(a.sshClient.HostInfo().Host))
+		a.setBecome(a.sshClient.HostInfo().Host)
+		return
+	}
+	if a.container() == nil {
+		a.agent.SetHostFacts(a.localFacts)
+	}
+	a.agent.SetHostTags(nil)
+	a.setBecome("localhost")
+}
+
+// promptHost describes where commands run, for the prompt.
+func (a *App) promptHost() string {
+	var host string
+	if c := a.container(); c != nil {
+		host = c.client.HostInfoString()
+	} else if a.sshClient != nil && a.sshClient.IsOpen() {
+		host = a.sshClient.HostInfoString()
+		if a.sshClient.ForwardAgent() {
+			host += " " + agentForwardIndicator
+		}
+		if !a.sshClient.IsConnected() {
+			host += " (link lost)"
+		}
+	} else {
+		host = a.localClient.HostInfoString()
+	}
+	if n := a.sessions.others(); n > 0 {
+		host += fmt.Sprintf(" +%d", n)
+	}
+	return host
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// sshConfigUsage describes the sshconfig command.
+const sshConfigUsage = "usage: sshconfig resolve [user@]<host>"
+
+// handleSSHConfig runs the sshconfig command.
+func (a *App) handleSSHConfig(args []string) error {
+	if len(args) != 2 || args[0] != "resolve" {
+		return errors.New(sshConfigUsage)
+	}
+	host, user := args[1], ""
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		user, host = host[:at], host[at+1:]
+	}
+
+	config, err := sshclient.ParseSSHConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ssh_config: %w", err)
+	}
+	configHost := config.Resolve(host, user)
+	if configHost == nil {
+		fmt.Println(a.theme.FormatInfo(fmt.Sprintf("No ssh_config settings apply to %s.", host)))
+		return nil
+	}
+	if user != "" {
+		configHost.User = user
+	}
+
+	for _, setting := range formatSSHConfigHost(configHost) {
+		fmt.Printf("%s %s\n", a.theme.FormatInfo(fmt.Sprintf("%-22s", setting[0])), setting[1])
+	}
+	return nil
+}
+
+// formatSSHConfigHost returns the effective settings of a host as name and
+// value pairs, in the spelling of ssh_config. Unset settings are left out.
+func formatSSHConfigHost(h *sshclient.SSHConfigHost) [][2]string {
+	hostname := h.Hostname
+	if hostname == "" {
+		hostname = h.Host
+	}
+	settings := [][2]string{
+		{"Host", h.Host},
+		{"Hostname", hostname},
+		{"Port", strconv.Itoa(h.Port)},
+	}
+	add := func(name, value string) {
+		if value != "" {
+			settings = append(settings, [2]string{name, value})
+		}
+	}
+	seconds := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return strconv.Itoa(int(d / time.Second))
+	}
+	yes := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return ""
+	}
+
+	add("User", h.User)
+	for _, path := range h.IdentityFile {
+		add("IdentityFile", path)
+	}
+	add("IdentitiesOnly", yes(h.IdentitiesOnly))
+	add("ProxyJump", h.ProxyJump)
+	add("ProxyCommand", h.ProxyCommand)
+	add("ConnectTimeout", seconds(h.ConnectTimeout))
+	if h.ServerAliveInterval > 0 {
+		add("ServerAliveInterval", seconds(h.ServerAliveInterval))
+		add("ServerAliveCountMax", strconv.Itoa(h.ServerAliveCountMax))
+	}
+	add("StrictHostKeyChecking", h.StrictHostKeyChecking)
+	add("UserKnownHostsFile", strings.Join(h.UserKnownHostsFile, " "))
+	add("HostKeyAlgorithms", h.HostKeyAlgorithms)
+	add("HashKnownHosts", yes(h.HashKnownHosts))
+	add("ForwardAgent", yes(h.ForwardAgent))
+	for _, forward := range h.LocalForward {
+		add("LocalForward", forward)
+	}
+	add("SendEnv", strings.Join(h.SendEnv, " "))
+	return settings
+}
+
+// printConnectionWarnings prints the problems with the connection settings
+// that did not stop the connection.
+func (a *App) printConnectionWarnings(client *sshclient.Client) {
+	for _, warning := range client.TakeWarnings() {
+		fmt.Printf("%s %s\n", a.theme.FormatWarning("Warning:"), warning)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "fmt"
+
+// setBecome tells the agent how privileged commands are run on host.
+func (a *App) setBecome(host string) {
+	if b := a.cfg.HostBecome(host); b != nil {
+		a.agent.SetBecome(b.Method, b.User)
+		return
+	}
+	a.agent.SetBecome("", "")
+}
+
+// forgetSudo drops the kept sudo password of the current host.
+func (a *App) forgetSudo() error {
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		a.sshClient.ForgetSudoPassword()
+	} else {
+		a.localClient.ForgetSudoPassword()
+	}
+	fmt.Println(a.theme.FormatSuccess("Sudo password forgotten; it will be asked for again when needed."))
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// progressBarWidth is the number of cells of a transfer progress bar.
+const progressBarWidth = 30
+
+// transferRequest is a parsed upload or download command.
+type transferRequest struct {
+	upload  bool
+	sources []string
+	dest    string
+	opts    sshclient.TransferOptions
+}
+
+// parseTransferCommand parses "upload [-r] [--no-resume] <local...> [remote]"
+// and "download [-r] [--no-resume] <remote...> [local]". The destination
+// defaults to the current directory. ok is false if cmd is not a transfer.
+func parseTransferCommand(cmd string) (req *transferRequest, ok bool, err error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return nil, false, nil
+	}
+	name := strings.ToLower(fields[0])
+	if name != "upload" && name != "download" {
+		return nil, false, nil
+	}
+
+	req = &transferRequest{upload: name == "upload"}
+	var args []string
+	for _, field := range fields[1:] {
+		switch field {
+		case "-r", "-R", "--recursive":
+			req.opts.Recursive = true
+		case "--no-resume":
+			req.opts.NoResume = true
+		default:
+			if strings.HasPrefix(field, "-") && len(field) > 1 {
+				return nil, true, fmt.Errorf("unknown %s option: %s", name, field)
+			}
+			args = append(args, field)
+		}
+	}
+
+	switch len(args) {
+	case 0:
+		if req.upload {
+			return nil, true, fmt.Errorf("usage: upload [-r] <local-path...> [remote-path]")
+		}
+		return nil, true, fmt.Errorf("usage: download [-r] <remote-path...> [local-path]")
+	case 1:
+		req.sources, req.dest = args, "."
+	default:
+		req.sources, req.dest = args[:len(args)-1], args[len(args)-1]
+	}
+	return req, true, nil
+}
+
+// transferFiles runs an upload or download on the connected host.
+func (a *App) transferFiles(req *transferRequest) error {
+	if a.sshClient == nil || !a.sshClient.IsOpen() {
+		return fmt.Errorf("file transfer needs a remote host; connect first")
+	}
+
+	var current string
+	req.opts.Progress = func(p sshclient.TransferProgress) {
+		current = p.Path
+		fmt.Printf("\r%s", formatProgress(filepath.Base(p.Path), p.Bytes, p.Total))
+		if p.Done {
+			fmt.Println()
+			current = ""
+		}
+	}
+
+	localCwd := a.localClient.GetCwd()
+	var result *sshclient.TransferResult
+	var err error
+	if req.upload {
+		result, err = a.sshClient.Upload(a.ctx, req.sources, req.dest, localCwd, req.opts)
+	} else {
+		result, err = a.sshClient.Download(a.ctx, req.sources, req.dest, localCwd, req.opts)
+	}
+	if current != "" {
+		// End the progress line of the interrupted file
+		fmt.Println()
+	}
+	if result != nil {
+		summary := fmt.Sprintf("%d file(s), %s transferred", result.Files, formatBytes(result.Bytes))
+		if result.Resumed > 0 {
+			summary += fmt.Sprintf(", %d resumed", result.Resumed)
+		}
+		if result.Skipped > 0 {
+			summary += fmt.Sprintf(", %d already up to date", result.Skipped)
+		}
+		if err == nil {
+			fmt.Println(a.theme.FormatSuccess(summary))
+		} else {
+			fmt.Println(a.theme.FormatWarning(summary))
+		}
+	}
+	return err
+}
+
+// formatProgress renders a progress bar such as
+// "access.log [=========>          ]  45% 1.2MB/2.7MB".
+func formatProgress(name string, done, total int64) string {
+	percent := 100
+	if total > 0 {
+		percent = int(done * 100 / total)
+	}
+	filled := percent * progressBarWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("%s [%s] %3d%% %s/%s", name, bar, percent, formatBytes(done), formatBytes(total))
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// tunnelUsage describes the tunnel command.
+const tunnelUsage = "usage: tunnel add -L [bind:]port:host:hostport | -R [bind:]port:host:hostport | -D [bind:]port, tunnel list, tunnel close <id|all>"
+
+// tunnelFlags maps ssh forwarding flags to tunnel kinds.
+var tunnelFlags = map[string]sshclient.TunnelKind{
+	"-L": sshclient.TunnelLocal,
+	"-R": sshclient.TunnelRemote,
+	"-D": sshclient.TunnelDynamic,
+}
+
+// parseTunnelAdd parses the arguments of "tunnel add", e.g. "-L 8080:localhost:80"
+// or "-L8080:localhost:80".
+func parseTunnelAdd(args []string) (sshclient.TunnelSpec, error) {
+	if len(args) == 0 {
+		return sshclient.TunnelSpec{}, errors.New(tunnelUsage)
+	}
+	flag, spec := args[0], ""
+	if len(flag) > 2 {
+		flag, spec = args[0][:2], args[0][2:]
+		args = args[1:]
+	} else if len(args) > 1 {
+		spec = args[1]
+		args = args[2:]
+	} else {
+		args = nil
+	}
+	kind, ok := tunnelFlags[flag]
+	if !ok || spec == "" || len(args) > 0 {
+		return sshclient.TunnelSpec{}, errors.New(tunnelUsage)
+	}
+	return sshclient.ParseTunnelSpec(kind, spec)
+}
+
+// handleTunnel runs the tunnel command.
+func (a *App) handleTunnel(args []string) error {
+	if len(args) == 0 || args[0] == "list" || args[0] == "ls" {
+		a.listTunnels()
+		return nil
+	}
+	if a.sshClient == nil || !a.sshClient.IsOpen() {
+		return fmt.Errorf("tunnels need a remote host; connect first")
+	}
+
+	switch args[0] {
+	case "add":
+		spec, err := parseTunnelAdd(args[1:])
+		if err != nil {
+			return err
+		}
+		tunnel, err := a.sshClient.AddTunnel(spec)
+		if err != nil {
+			return err
+		}
+		fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Tunnel %d started: %s", tunnel.ID, describeTunnel(tunnel))))
+		return nil
+	case "close", "rm":
+		if len(args) != 2 {
+			return errors.New(tunnelUsage)
+		}
+		if args[1] == "all" {
+			for _, t := range a.sshClient.Tunnels() {
+				_ = a.sshClient.CloseTunnel(t.ID)
+			}
+			fmt.Println(a.theme.FormatSuccess("All tunnels closed."))
+			return nil
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid tunnel ID: %s", args[1])
+		}
+		if err := a.sshClient.CloseTunnel(id); err != nil {
+			return err
+		}
+		fmt.Println(a.theme.FormatSuccess(fmt.Sprintf("Tunnel %d closed.", id)))
+		return nil
+	}
+	return errors.New(tunnelUsage)
+}
+
+// listTunnels prints the running tunnels with their traffic.
+func (a *App) listTunnels() {
+	var tunnels []*sshclient.Tunnel
+	if a.sshClient != nil && a.sshClient.IsOpen() {
+		tunnels = a.sshClient.Tunnels()
+	}
+	if len(tunnels) == 0 {
+		fmt.Println(a.theme.FormatInfo("No tunnels. Add one with: tunnel add -L 8080:localhost:80"))
+		return
+	}
+
+	fmt.Println(a.theme.FormatTableHeader(fmt.Sprintf("%-4s %-44s %-8s %-8s %-10s %-10s %s", "ID", "Forward", "Active", "Total", "Sent", "Received", "Uptime")))
+	for _, t := range tunnels {
+		stats := t.Stats()
+		fmt.Printf("%-4d %-44s %-8d %-8d %-10s %-10s %s\n", t.ID, describeTunnel(t), stats.Active, stats.Total,
+			formatBytes(stats.BytesSent), formatBytes(stats.BytesReceived), time.Since(t.Started).Round(time.Second))
+	}
+}
+
+// describeTunnel returns the direction of a tunnel, e.g.
+// "127.0.0.1:8080 -> localhost:80 (via remote)".
+func describeTunnel(t *sshclient.Tunnel) string {
+	switch t.Spec.Kind {
+	case sshclient.TunnelLocal:
+		return t.Addr() + " -> " + t.Spec.Target + " (via remote)"
+	case sshclient.TunnelRemote:
+		return "remote " + t.Addr() + " -> " + t.Spec.Target
+	default:
+		return "SOCKS5 " + t.Addr()
+	}
+}
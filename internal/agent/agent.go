@@ -19,6 +19,7 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
@@ -35,6 +36,12 @@ import (
 type Agent struct {
 	aiClient            ai.ModelClient
 	customShellCommands map[string]bool
+	contextManager      *ai.ContextManager
+	hostFacts           *HostFacts
+	router              *ai.Router
+	hostTags            []string
+	become              become
+	container           string // the container commands run in, if any
 }
 
 // NewAgent creates a new Agent with the given AI client.
@@ -104,11 +111,27 @@ Set "needs_confirm" to true for potentially dangerous operations like:
 - Stopping/restarting services
 - Any command that could cause data loss
 
+To copy files between the user's machine and the connected remote host, use the
+built-in commands "download [-r] <remote-path...> [local-path]" and
+"upload [-r] <local-path...> [remote-path]" instead of scp or rsync. Use -r for
+directories; glob patterns are allowed. Do not combine them with other commands
+in one string.
+
 Examples:
 - "show me disk usage" -> {"commands": ["df -h"], "description": "Display disk space usage in human-readable format", "needs_confirm": false}
 - "list files in current directory" -> {"commands": ["ls -la"], "description": "List all files including hidden ones with details", "needs_confirm": false}
 - "remove the tmp folder" -> {"commands": ["rm -rf tmp"], "description": "Recursively remove the tmp directory and its contents", "needs_confirm": true}
-- "restart nginx service" -> {"commands": ["sudo systemctl restart nginx"], "description": "Restart the nginx service", "needs_confirm": true}`
+- "restart nginx service" -> {"commands": ["sudo systemctl restart nginx"], "description": "Restart the nginx service", "needs_confirm": true}
+- "download the nginx logs" -> {"commands": ["download -r /var/log/nginx"], "description": "Download the nginx log directory to the current local directory", "needs_confirm": false}
+- "upload app.conf to /etc/myapp" -> {"commands": ["upload app.conf /etc/myapp/"], "description": "Upload app.conf into /etc/myapp", "needs_confirm": true}`
+
+const systemPromptExplain = `You are Sherlock, an AI assistant for SSH remote operations.
+Your task is to explain the output of a shell command to the user.
+
+Be concise. Summarize what the output shows, point out any errors, warnings,
+or unusual values, and suggest a next step if something looks wrong.
+If the output was truncated or summarized, base your answer only on what you can see.
+Answer in the same language as the user's request, in plain text (not JSON).`
 
 // ConnectionInfo represents parsed connection information.
 type ConnectionInfo struct {
@@ -116,6 +139,12 @@ type ConnectionInfo struct {
 	Port  int    `json:"port"`
 	User  string `json:"user"`
 	Error string `json:"error,omitempty"`
+	// Jump is the chain of jump hosts to connect through, as with ssh -J.
+	Jump string `json:"jump,omitempty"`
+	// Reasoning holds the model's thinking process, if it produced any.
+	Reasoning string `json:"-"`
+	// Profile describes the model profile that answered, if routing is enabled.
+	Profile string `json:"-"`
 }
 
 // CommandInfo represents parsed command information.
@@ -124,12 +153,62 @@ type CommandInfo struct {
 	Description  string   `json:"description"`
 	NeedsConfirm bool     `json:"needs_confirm"`
 	Error        string   `json:"error,omitempty"`
+	// Reasoning holds the model's thinking process, if it produced any.
+	Reasoning string `json:"-"`
+	// Offline is true if the commands came from the built-in offline rules
+	// because the model was unreachable.
+	Offline bool `json:"-"`
+	// Profile describes the model profile that answered, if routing is enabled.
+	Profile string `json:"-"`
+}
+
+// errGenerate wraps failures to get a response from the model, as opposed to
+// failures to parse a response.
+var errGenerate = errors.New("failed to generate response")
+
+// SetHostFacts sets the facts about the current host used by offline translation.
+func (a *Agent) SetHostFacts(facts *HostFacts) {
+	a.hostFacts = facts
+}
+
+// SetRouter sets the router that picks a model profile for each request.
+// Without a router, all requests go to the agent's AI client.
+func (a *Agent) SetRouter(router *ai.Router) {
+	a.router = router
+}
+
+// SetHostTags sets the tags of the current host, used for model routing.
+func (a *Agent) SetHostTags(tags []string) {
+	a.hostTags = tags
+}
+
+// diagnosisRe matches requests that ask to investigate a problem.
+var diagnosisRe = regexp.MustCompile(`(?i)\b(?:why|diagnose|troubleshoot|investigate|root cause|figure out)\b|为什么|排查|诊断|原因`)
+
+// selectModel picks the model for a request.
+func (a *Agent) selectModel(ctx context.Context, task ai.TaskType, request string) (*ai.Selection, error) {
+	if a.router == nil {
+		return &ai.Selection{Client: a.aiClient}, nil
+	}
+	return a.router.Select(ctx, ai.Route{Task: task, Request: request, HostTags: a.hostTags})
+}
+
+// profileName describes the selected profile for display, or "" without routing.
+func profileName(sel *ai.Selection) string {
+	if sel.Profile == "" {
+		return ""
+	}
+	return sel.String()
 }
 
 // ParseConnectionRequest parses a natural language connection request.
 func (a *Agent) ParseConnectionRequest(ctx context.Context, request string) (*ConnectionInfo, error) {
+	// Take out the jump hosts so that they are not mistaken for the target
+	request, jump := splitJumpHosts(request)
+
 	// First try to parse common patterns directly
 	if info := parseConnectionDirect(request); info != nil {
+		info.Jump = jump
 		return info, nil
 	}
 
@@ -139,18 +218,18 @@ func (a *Agent) ParseConnectionRequest(ctx context.Context, request string) (*Co
 		schema.UserMessage(request),
 	}
 
-	response, err := a.aiClient.Generate(ctx, messages)
+	sel, err := a.selectModel(ctx, ai.TaskConnection, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate response: %w", err)
+		return nil, err
 	}
 
-	content := strings.TrimSpace(response.Content)
-	content = extractJSON(content)
-
 	var info ConnectionInfo
-	if err := json.Unmarshal([]byte(content), &info); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	reasoning, err := a.generateJSON(ctx, sel.Client, messages, &info)
+	if err != nil {
+		return nil, err
 	}
+	info.Reasoning = reasoning
+	info.Profile = profileName(sel)
 
 	if info.Error != "" {
 		return nil, fmt.Errorf("connection parse error: %s", info.Error)
@@ -159,10 +238,37 @@ func (a *Agent) ParseConnectionRequest(ctx context.Context, request string) (*Co
 	if info.Port == 0 {
 		info.Port = 22
 	}
+	if jump != "" {
+		info.Jump = jump
+	}
 
 	return &info, nil
 }
 
+// jumpHostsRe matches a jump host chain in a connection request: an ssh style
+// "-J spec", or a phrase like "via bastion", "through the jump host gw1:2222"
+// or "通过bastion".
+var jumpHostsRe = regexp.MustCompile(`(?i)(?:\s+-J\s*|\s+(?:via|through)\s+(?:the\s+)?(?:(?:bastion|jump\s*host)\s+)?|\s*(?:经由|通过)\s*)` +
+	`([\w.@:\[\]%-]+(?:,[\w.@:\[\]%-]+)*)(?:\s*跳板机?|\s+(?:jump\s*host|bastion)\b)?`)
+
+// notJumpHosts are words after "via" or "through" that are not hosts, as in
+// "connect via ssh".
+var notJumpHosts = map[string]bool{"ssh": true, "port": true, "password": true, "key": true, "sftp": true}
+
+// splitJumpHosts removes a jump host chain from a connection request and
+// returns the request and the chain.
+func splitJumpHosts(request string) (string, string) {
+	loc := jumpHostsRe.FindStringSubmatchIndex(request)
+	if loc == nil {
+		return request, ""
+	}
+	jump := request[loc[2]:loc[3]]
+	if notJumpHosts[strings.ToLower(jump)] {
+		return request, ""
+	}
+	return strings.TrimSpace(request[:loc[0]] + request[loc[1]:]), jump
+}
+
 // parseConnectionDirect tries to parse common connection patterns directly.
 func parseConnectionDirect(request string) *ConnectionInfo {
 	// Pattern: user@host:port
@@ -381,22 +487,33 @@ func (a *Agent) ParseCommandRequest(ctx context.Context, request string) (*Comma
 
 	// Fall back to AI parsing for natural language requests
 	messages := []*schema.Message{
-		schema.SystemMessage(systemPromptCommand),
+		schema.SystemMessage(systemPromptCommand + a.hostInstruction()),
 		schema.UserMessage(request),
 	}
 
-	response, err := a.aiClient.Generate(ctx, messages)
+	task := ai.TaskCommand
+	if diagnosisRe.MatchString(request) {
+		task = ai.TaskDiagnose
+	}
+	sel, err := a.selectModel(ctx, task, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate response: %w", err)
+		return nil, err
 	}
 
-	content := strings.TrimSpace(response.Content)
-	content = extractJSON(content)
-
 	var info CommandInfo
-	if err := json.Unmarshal([]byte(content), &info); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	reasoning, err := a.generateJSON(ctx, sel.Client, messages, &info)
+	if err != nil {
+		// The model is unreachable: try the built-in offline rules
+		if errors.Is(err, errGenerate) {
+			if offline := translateOffline(request, a.hostFacts); offline != nil {
+				offline.Commands = a.hostCommands(offline.Commands)
+				return offline, nil
+			}
+		}
+		return nil, err
 	}
+	info.Reasoning = reasoning
+	info.Profile = profileName(sel)
 
 	if info.Error != "" {
 		return nil, fmt.Errorf("command parse error: %s", info.Error)
@@ -405,6 +522,110 @@ func (a *Agent) ParseCommandRequest(ctx context.Context, request string) (*Comma
 	return &info, nil
 }
 
+// generateJSON sends messages to the model and decodes the JSON answer into v.
+// It returns the model's reasoning, which is kept out of the JSON parsing.
+func (a *Agent) generateJSON(ctx context.Context, client ai.ModelClient, messages []*schema.Message, v any) (string, error) {
+	ctx = ai.WithCallTrace(ctx)
+	response, err := client.Generate(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errGenerate, err)
+	}
+
+	// Drop any inline <think> blocks the provider left in the content so that
+	// braces in the model's thinking don't confuse the JSON extraction.
+	inlineReasoning, answer := ai.SplitReasoning(response.Content)
+	content := extractJSON(answer)
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		ai.SetParseResult(ctx, fmt.Sprintf("parse error: %v\nextracted: %s", err, content))
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if parsed, err := json.Marshal(v); err == nil {
+		ai.SetParseResult(ctx, string(parsed))
+	}
+
+	reasoning := strings.TrimSpace(response.ReasoningContent)
+	if reasoning == "" {
+		reasoning = inlineReasoning
+	}
+	return reasoning, nil
+}
+
+// SetContextManager sets the context manager used to fit command output into
+// the model's context window.
+func (a *Agent) SetContextManager(cm *ai.ContextManager) {
+	a.contextManager = cm
+}
+
+// getContextManager returns the configured context manager, or one with the
+// default context window if none was set.
+func (a *Agent) getContextManager() *ai.ContextManager {
+	if a.contextManager == nil {
+		a.contextManager = ai.NewContextManager("", 0, a.aiClient)
+	}
+	return a.contextManager
+}
+
+// Explanation represents the model's explanation of a command output.
+type Explanation struct {
+	// Text is the explanation.
+	Text string
+	// Reasoning holds the model's thinking process, if it produced any.
+	Reasoning string
+	// Truncation describes how the output was reduced to fit the context
+	// window. It is nil if the full output was sent.
+	Truncation *ai.TruncationReport
+	// Profile describes the model profile that answered, if routing is enabled.
+	Profile string
+}
+
+// ExplainOutput asks the model to explain the output of a command. Output that
+// doesn't fit the model's context window is reduced first, and the reduction is
+// reported in the returned Explanation.
+func (a *Agent) ExplainOutput(ctx context.Context, command, output string, exitCode int) (*Explanation, error) {
+	sel, err := a.selectModel(ctx, ai.TaskExplain, command)
+	if err != nil {
+		return nil, err
+	}
+
+	// A routed profile has its own model and context window
+	cm := a.getContextManager()
+	if sel.Profile != "" {
+		cm = ai.NewContextManager(sel.Config.Model, sel.Config.ContextWindow, sel.Client)
+	}
+	fitted, report := cm.Fit(ctx, command, output)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Command: %s\nExit code: %d\n", command, exitCode)
+	if report != nil {
+		fmt.Fprintf(&sb, "Note: the output was reduced to fit (%s).\n", report)
+	}
+	sb.WriteString("Output:\n")
+	sb.WriteString(fitted)
+
+	messages := []*schema.Message{
+		schema.SystemMessage(systemPromptExplain),
+		schema.UserMessage(sb.String()),
+	}
+
+	response, err := sel.Client.Generate(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate response: %w", err)
+	}
+
+	inlineReasoning, text := ai.SplitReasoning(response.Content)
+	reasoning := strings.TrimSpace(response.ReasoningContent)
+	if reasoning == "" {
+		reasoning = inlineReasoning
+	}
+
+	return &Explanation{
+		Text:       text,
+		Reasoning:  reasoning,
+		Truncation: report,
+		Profile:    profileName(sel),
+	}, nil
+}
+
 // extractJSON extracts JSON from a response that may contain markdown code blocks.
 func extractJSON(content string) string {
 	// Try to extract JSON from markdown code blocks
@@ -15,9 +15,34 @@
 package agent
 
 import (
+	"context"
+	"strings"
 	"testing"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
 )
 
+// fakeModelClient is an ai.ModelClient that returns a canned response.
+type fakeModelClient struct {
+	response *schema.Message
+	err      error
+	messages []*schema.Message // the messages of the last call
+}
+
+func (f *fakeModelClient) Generate(_ context.Context, messages []*schema.Message) (*schema.Message, error) {
+	f.messages = messages
+	return f.response, f.err
+}
+
+func (f *fakeModelClient) Stream(_ context.Context, _ []*schema.Message) (*schema.StreamReader[*schema.Message], error) {
+	return nil, f.err
+}
+
+func (f *fakeModelClient) GetModel() model.ChatModel { return nil }
+
+func (f *fakeModelClient) Close() error { return nil }
+
 func TestIsShellCommand(t *testing.T) {
 	// Create a test agent with no custom commands
 	agent := NewAgent(nil)
@@ -375,3 +400,151 @@ func TestParseConnectionDirect(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCommandRequestWithReasoning(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      *schema.Message
+		wantCommand   string
+		wantReasoning string
+	}{
+		{
+			name: "reasoning_content field",
+			response: &schema.Message{
+				Content:          `{"commands": ["df -h"], "description": "disk usage", "needs_confirm": false}`,
+				ReasoningContent: "The user wants {disk} usage.",
+			},
+			wantCommand:   "df -h",
+			wantReasoning: "The user wants {disk} usage.",
+		},
+		{
+			name: "inline think block",
+			response: &schema.Message{
+				Content: "<think>\nMaybe {\"commands\": [\"du\"]}? No, df.\n</think>\n" +
+					`{"commands": ["df -h"], "description": "disk usage", "needs_confirm": false}`,
+			},
+			wantCommand:   "df -h",
+			wantReasoning: `Maybe {"commands": ["du"]}? No, df.`,
+		},
+		{
+			name: "no reasoning",
+			response: &schema.Message{
+				Content: "```json\n{\"commands\": [\"df -h\"], \"description\": \"disk usage\"}\n```",
+			},
+			wantCommand:   "df -h",
+			wantReasoning: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAgent(&fakeModelClient{response: tt.response})
+			info, err := a.ParseCommandRequest(context.Background(), "how full are the disks")
+			if err != nil {
+				t.Fatalf("ParseCommandRequest() error = %v", err)
+			}
+			if len(info.Commands) != 1 || info.Commands[0] != tt.wantCommand {
+				t.Errorf("Commands = %v, want [%s]", info.Commands, tt.wantCommand)
+			}
+			if info.Reasoning != tt.wantReasoning {
+				t.Errorf("Reasoning = %q, want %q", info.Reasoning, tt.wantReasoning)
+			}
+		})
+	}
+}
+
+func TestSplitJumpHosts(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantRequest string
+		wantJump    string
+	}{
+		{input: "ssh -J bastion root@10.0.0.5", wantRequest: "ssh root@10.0.0.5", wantJump: "bastion"},
+		{input: "ssh -Jadmin@gw1:2222,gw2 web1", wantRequest: "ssh web1", wantJump: "admin@gw1:2222,gw2"},
+		{input: "connect to web1 as deploy via bastion", wantRequest: "connect to web1 as deploy", wantJump: "bastion"},
+		{input: "connect to db1 through the jump host ops@gw.example.com", wantRequest: "connect to db1", wantJump: "ops@gw.example.com"},
+		{input: "connect to db1 via gw1 jump host", wantRequest: "connect to db1", wantJump: "gw1"},
+		{input: "通过bastion跳板机连接10.0.0.5", wantRequest: "连接10.0.0.5", wantJump: "bastion"},
+		{input: "connect to web1 via ssh", wantRequest: "connect to web1 via ssh", wantJump: ""},
+		{input: "connect to root@10.0.0.5", wantRequest: "connect to root@10.0.0.5", wantJump: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			request, jump := splitJumpHosts(tt.input)
+			if request != tt.wantRequest || jump != tt.wantJump {
+				t.Errorf("splitJumpHosts(%q) = %q, %q, want %q, %q", tt.input, request, jump, tt.wantRequest, tt.wantJump)
+			}
+		})
+	}
+}
+
+func TestParseConnectionRequestWithJump(t *testing.T) {
+	a := &Agent{}
+	info, err := a.ParseConnectionRequest(context.Background(), "ssh -J admin@bastion root@10.0.0.5:2222")
+	if err != nil {
+		t.Fatalf("ParseConnectionRequest() error = %v", err)
+	}
+	if info.User != "root" || info.Host != "10.0.0.5" || info.Port != 2222 || info.Jump != "admin@bastion" {
+		t.Errorf("ParseConnectionRequest() = %+v, want root@10.0.0.5:2222 via admin@bastion", info)
+	}
+}
+
+func TestBecome(t *testing.T) {
+	tests := []struct {
+		method, user string
+		want         string
+	}{
+		{want: "sudo systemctl restart nginx"},
+		{method: "sudo", user: "deploy", want: "sudo -u deploy systemctl restart nginx"},
+		{method: "doas", want: "doas systemctl restart nginx"},
+		{method: "doas", user: "www", want: "doas -u www systemctl restart nginx"},
+		{method: "su", want: "su - root -c 'systemctl restart nginx'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+"/"+tt.user, func(t *testing.T) {
+			b := become{method: tt.method, user: tt.user}
+			if got := b.rewrite([]string{"sudo systemctl restart nginx", "ls"}); got[0] != tt.want || got[1] != "ls" {
+				t.Errorf("rewrite() = %q, want [%q ls]", got, tt.want)
+			}
+			if instruction := b.instruction(); (instruction == "") != b.isDefault() || (instruction != "" && !strings.Contains(instruction, tt.want)) {
+				t.Errorf("instruction() = %q, want it to show %q", instruction, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandRequestBecome(t *testing.T) {
+	client := &fakeModelClient{response: &schema.Message{Content: `{"commands": ["doas rcctl restart nginx"], "description": "restart"}`}}
+	a := NewAgent(client)
+	a.SetBecome("doas", "")
+	if _, err := a.ParseCommandRequest(context.Background(), "restart nginx"); err != nil {
+		t.Fatalf("ParseCommandRequest() error = %v", err)
+	}
+	if system := client.messages[0].Content; !strings.Contains(system, "doas systemctl restart nginx") {
+		t.Errorf("system prompt does not mention doas:\n%s", system)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	client := &fakeModelClient{response: &schema.Message{Content: `{"commands": ["cat /proc/loadavg"], "description": "load"}`}}
+	a := NewAgent(client)
+	a.SetBecome("doas", "")
+	a.SetContainer("the Docker container web-1")
+	if _, err := a.ParseCommandRequest(context.Background(), "show the load"); err != nil {
+		t.Fatalf("ParseCommandRequest() error = %v", err)
+	}
+	system := client.messages[0].Content
+	if !strings.Contains(system, "inside the Docker container web-1") || strings.Contains(system, "doas") {
+		t.Errorf("system prompt does not describe the container:\n%s", system)
+	}
+	if got := a.hostCommands([]string{"sudo ss -tlnp", "ls"}); got[0] != "ss -tlnp" || got[1] != "ls" {
+		t.Errorf("hostCommands() = %q, want sudo dropped", got)
+	}
+
+	a.SetContainer("")
+	if got := a.hostCommands([]string{"sudo ss -tlnp"}); got[0] != "doas ss -tlnp" {
+		t.Errorf("hostCommands() on the host = %q, want the become method", got)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// become says how privileged commands are run on the current host: with
+// sudo, su or doas, as user. The zero value is sudo as root.
+type become struct {
+	method string
+	user   string
+}
+
+// SetBecome sets how privileged commands are run on the current host.
+// method is sudo, su or doas, and user the user to run them as; empty values
+// mean sudo and root.
+func (a *Agent) SetBecome(method, user string) {
+	a.become = become{method: method, user: user}
+}
+
+// isDefault reports whether privileged commands use plain sudo.
+func (b become) isDefault() bool {
+	return (b.method == "" || b.method == "sudo") && (b.user == "" || b.user == "root")
+}
+
+// wrap returns command run as the become user.
+func (b become) wrap(command string) string {
+	user := b.user
+	if user == "" {
+		user = "root"
+	}
+	switch b.method {
+	case "su":
+		return fmt.Sprintf("su - %s -c %s", user, sshclient.ShellEscape(command))
+	case "doas":
+		if user == "root" {
+			return "doas " + command
+		}
+		return fmt.Sprintf("doas -u %s %s", user, command)
+	default:
+		if user == "root" {
+			return "sudo " + command
+		}
+		return fmt.Sprintf("sudo -u %s %s", user, command)
+	}
+}
+
+// rewrite replaces a leading sudo in commands, as used by the offline rules,
+// with the become method of the host.
+func (b become) rewrite(commands []string) []string {
+	if b.isDefault() {
+		return commands
+	}
+	out := make([]string, len(commands))
+	for i, cmd := range commands {
+		if rest, ok := strings.CutPrefix(cmd, "sudo "); ok {
+			cmd = b.wrap(rest)
+		}
+		out[i] = cmd
+	}
+	return out
+}
+
+// instruction tells the model how to run privileged commands on the host,
+// or returns "" for plain sudo.
+func (b become) instruction() string {
+	if b.isDefault() {
+		return ""
+	}
+	example := b.wrap("systemctl restart nginx")
+	switch b.method {
+	case "su":
+		return fmt.Sprintf("\n\nOn this host, sudo is not used. Run every command that needs privileges with su, "+
+			"quoting the command for the shell, e.g. %s. Do not use sudo.", example)
+	case "doas":
+		return fmt.Sprintf("\n\nOn this host, sudo is not used. Run every command that needs privileges with doas, "+
+			"e.g. %s. Do not use sudo.", example)
+	default:
+		return fmt.Sprintf("\n\nOn this host, run every command that needs privileges as user %s, e.g. %s.", b.user, example)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetContainer tells the agent that commands run in a container, described
+// as e.g. "Docker container web-1". An empty description means a regular
+// host.
+func (a *Agent) SetContainer(description string) {
+	a.container = description
+}
+
+// hostInstruction tells the model about the place commands run in: a
+// container, or how privileged commands are run on the host.
+func (a *Agent) hostInstruction() string {
+	if a.container == "" {
+		return a.become.instruction()
+	}
+	return fmt.Sprintf("\n\nCommands run inside %s, not on a regular host. Assume a minimal image: "+
+		"there is no systemd, service manager or sudo, the shell is POSIX sh (often busybox), and tools such as "+
+		"ps, ss, netstat, lsof, curl, vim or less may be missing. Use what is usually there (cat, ls, grep, sed, "+
+		"awk, env, df), read /proc for processes, memory and sockets (e.g. cat /proc/net/tcp), and remember the "+
+		"main process logs to the container's stdout, which cannot be read from inside. Do not install packages "+
+		"unless asked.", a.container)
+}
+
+// hostCommands adapts commands of the offline rules to the place they run
+// in: sudo is dropped in a container and replaced by the become method on
+// a host.
+func (a *Agent) hostCommands(commands []string) []string {
+	if a.container == "" {
+		return a.become.rewrite(commands)
+	}
+	out := make([]string, len(commands))
+	for i, cmd := range commands {
+		out[i] = strings.TrimPrefix(cmd, "sudo ")
+	}
+	return out
+}
@@ -0,0 +1,400 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// HostFacts describes the target host, so that offline translations can pick
+// commands that exist there.
+type HostFacts struct {
+	// OS is the lower-case kernel name reported by uname -s (linux, darwin, freebsd).
+	OS string
+	// HasSystemd is true if systemd is the running init system.
+	HasSystemd bool
+	// HasSS is true if the ss utility is installed.
+	HasSS bool
+}
+
+// defaultHostFacts is assumed when the host could not be probed.
+var defaultHostFacts = &HostFacts{OS: "linux", HasSystemd: true, HasSS: true}
+
+// hostFactsProbe prints the kernel name followed by one "has:" line per detected feature.
+const hostFactsProbe = `uname -s; [ -d /run/systemd/system ] && echo has:systemd; command -v ss >/dev/null 2>&1 && echo has:ss; true`
+
+// DetectHostFacts probes the host behind the executor for the facts used by
+// offline translation. It returns nil if the probe fails.
+func DetectHostFacts(ctx context.Context, executor sshclient.Executor) *HostFacts {
+	result := executor.Execute(ctx, hostFactsProbe)
+	if result.Error != nil || result.ExitCode != 0 {
+		return nil
+	}
+	return parseHostFacts(result.Stdout)
+}
+
+// parseHostFacts parses the output of hostFactsProbe.
+func parseHostFacts(output string) *HostFacts {
+	facts := &HostFacts{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case line == "has:systemd":
+			facts.HasSystemd = true
+		case line == "has:ss":
+			facts.HasSS = true
+		case facts.OS == "":
+			facts.OS = strings.ToLower(line)
+		}
+	}
+	if facts.OS == "" {
+		return nil
+	}
+	return facts
+}
+
+// offlineIntent maps request patterns to a command template.
+type offlineIntent struct {
+	// patterns match the request in English or Chinese. Capture groups are
+	// passed to build; the first non-empty group is usually the argument.
+	patterns []*regexp.Regexp
+	// build returns the commands and description for the matched request.
+	build func(args []string, facts *HostFacts) ([]string, string)
+	// needsConfirm marks intents that change the host.
+	needsConfirm bool
+}
+
+// serviceNameRe captures a service or unit name.
+const serviceNameRe = `([A-Za-z0-9][\w.@-]*)`
+
+// filePathRe captures an absolute file path.
+const filePathRe = `(/[\w./@~+-]+)`
+
+// offlineStopwords are words that service patterns can capture by accident
+// ("show me status", "restart the server"). A match capturing one is ignored.
+var offlineStopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "me": true, "my": true, "this": true, "that": true,
+	"it": true, "its": true, "all": true, "service": true, "services": true, "server": true,
+	"system": true, "host": true, "machine": true, "status": true, "log": true, "logs": true,
+}
+
+// offlineIntents is the built-in intent library, ordered from most to least specific.
+var offlineIntents = []offlineIntent{
+	{
+		// Restart a service
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\brestart\s+(?:the\s+)?` + serviceNameRe),
+			regexp.MustCompile(`重启\s*` + serviceNameRe),
+		},
+		build: func(args []string, facts *HostFacts) ([]string, string) {
+			svc := firstArg(args)
+			switch {
+			case facts.OS == "darwin":
+				return []string{fmt.Sprintf("sudo launchctl kickstart -k system/%s", svc)}, "Restart the " + svc + " service"
+			case facts.HasSystemd:
+				return []string{fmt.Sprintf("sudo systemctl restart %s", svc)}, "Restart the " + svc + " service"
+			default:
+				return []string{fmt.Sprintf("sudo service %s restart", svc)}, "Restart the " + svc + " service"
+			}
+		},
+		needsConfirm: true,
+	},
+	{
+		// Tail a log file by path
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\blast\s+(\d+)\s+lines\s+of\s+(?:the\s+)?(?:log\s+)?` + filePathRe),
+			regexp.MustCompile(`(?i)\b(?:tail|show|view|read|check)\s+(?:the\s+)?(?:log\s+(?:file\s+)?)?` + filePathRe),
+			regexp.MustCompile(`(?:查看|显示|看看?)\s*` + filePathRe + `\s*的?(?:最后|末尾)?\s*(\d+)?`),
+		},
+		build: func(args []string, _ *HostFacts) ([]string, string) {
+			lines, path := 50, ""
+			for _, arg := range args {
+				if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+					lines = n
+				} else if strings.HasPrefix(arg, "/") {
+					path = arg
+				}
+			}
+			return []string{fmt.Sprintf("tail -n %d %s", lines, sshclient.ShellEscape(path))},
+				fmt.Sprintf("Show the last %d lines of %s", lines, path)
+		},
+	},
+	{
+		// System log
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:system|kernel)\s+logs?\b|\bsyslog\b`),
+			regexp.MustCompile(`系统日志|内核日志`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			switch {
+			case facts.OS == "darwin":
+				return []string{"log show --last 10m --style syslog | tail -n 50"}, "Show recent system log entries"
+			case facts.HasSystemd:
+				return []string{"journalctl -n 50 --no-pager"}, "Show the last 50 system journal entries"
+			default:
+				return []string{"tail -n 50 /var/log/syslog 2>/dev/null || tail -n 50 /var/log/messages"}, "Show the last 50 system log lines"
+			}
+		},
+	},
+	{
+		// Logs of a service
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:logs?\s+(?:of|for)\s+(?:the\s+)?)` + serviceNameRe),
+			regexp.MustCompile(`(?i)\b(?:show|view|tail|check)\s+(?:the\s+)?` + serviceNameRe + `(?:\s+service)?\s+logs?\b`),
+			regexp.MustCompile(`(?:查看|显示|看看?)?\s*` + serviceNameRe + `\s*(?:服务)?\s*的?日志`),
+		},
+		build: func(args []string, facts *HostFacts) ([]string, string) {
+			svc := firstArg(args)
+			switch {
+			case facts.OS == "darwin":
+				return []string{fmt.Sprintf("log show --last 10m --predicate %s | tail -n 50", sshclient.ShellEscape(`process == "`+svc+`"`))}, "Show recent log entries of " + svc
+			case facts.HasSystemd:
+				return []string{fmt.Sprintf("journalctl -u %s -n 50 --no-pager", svc)}, "Show the last 50 journal entries of " + svc
+			default:
+				return []string{fmt.Sprintf("grep -ih %s /var/log/syslog /var/log/messages 2>/dev/null | tail -n 50", sshclient.ShellEscape(svc))}, "Show recent system log lines mentioning " + svc
+			}
+		},
+	},
+	{
+		// Top processes by memory
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:process(?:es)?|programs?|apps?)\b.*\b(?:memory|mem|ram)\b|\b(?:memory|mem|ram)\b.*\b(?:process(?:es)?|hogs?|consum\w*)\b`),
+			regexp.MustCompile(`内存.*(?:进程|程序|占用最)|(?:进程|程序).*内存`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "linux" {
+				return []string{"ps aux --sort=-%mem | head -n 11"}, "Show the 10 processes using the most memory"
+			}
+			return []string{"ps aux -m | head -n 11"}, "Show the 10 processes using the most memory"
+		},
+	},
+	{
+		// Top processes by CPU
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:process(?:es)?|programs?|apps?)\b.*\bcpu\b|\bcpu\b.*\b(?:process(?:es)?|hogs?|usage|consum\w*)\b|\btop\s+processes\b`),
+			regexp.MustCompile(`(?i)cpu.*(?:进程|程序|占用|使用率)|(?:进程|程序).*cpu`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "linux" {
+				return []string{"ps aux --sort=-%cpu | head -n 11"}, "Show the 10 processes using the most CPU"
+			}
+			return []string{"ps aux -r | head -n 11"}, "Show the 10 processes using the most CPU"
+		},
+	},
+	{
+		// Largest files and directories
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:largest|biggest|large|big)\s+(?:files?|dirs?|directories|folders?)\b`),
+			regexp.MustCompile(`大文件|最大的(?:文件|目录)`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"du -ah . 2>/dev/null | sort -rh | head -n 20"}, "Show the 20 largest files and directories under the current directory"
+		},
+	},
+	{
+		// Disk usage
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\bdisks?\b.*\b(?:usage|space|free|full|used)\b|\b(?:free|disk)\s+space\b|\bfilesystems?\b|\bdf\b`),
+			regexp.MustCompile(`磁盘|硬盘|存储空间|剩余空间`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"df -h"}, "Display disk space usage in human-readable format"
+		},
+	},
+	{
+		// Memory usage
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:memory|ram|mem|swap)\b`),
+			regexp.MustCompile(`内存|交换分区`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "darwin" {
+				return []string{"top -l 1 -s 0 | grep PhysMem", "vm_stat"}, "Display memory usage"
+			}
+			return []string{"free -h"}, "Display memory usage in human-readable format"
+		},
+	},
+	{
+		// Listening ports
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:listening|open)\s+ports?\b|\bports?\b.*\b(?:listen\w*|open|in use|used)\b|\blistening\b`),
+			regexp.MustCompile(`端口|监听`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			switch {
+			case facts.OS == "darwin":
+				return []string{"lsof -nP -iTCP -sTCP:LISTEN"}, "List listening TCP ports"
+			case facts.HasSS:
+				return []string{"ss -tulpn"}, "List listening TCP and UDP ports with their processes"
+			default:
+				return []string{"netstat -tulpn"}, "List listening TCP and UDP ports with their processes"
+			}
+		},
+	},
+	{
+		// Service status
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\bstatus\s+of\s+(?:the\s+)?` + serviceNameRe),
+			regexp.MustCompile(`(?i)\bis\s+(?:the\s+)?` + serviceNameRe + `(?:\s+service)?\s+(?:running|up|active|alive)\b`),
+			regexp.MustCompile(`(?i)\b(?:check\s+(?:the\s+)?)?` + serviceNameRe + `\s+(?:service\s+)?status\b`),
+			regexp.MustCompile(`(?:查看|检查)?\s*` + serviceNameRe + `\s*(?:服务)?\s*(?:的)?(?:状态|是否在?运行|有没有在?运行)`),
+		},
+		build: func(args []string, facts *HostFacts) ([]string, string) {
+			svc := firstArg(args)
+			switch {
+			case facts.OS == "darwin":
+				return []string{fmt.Sprintf("launchctl list | grep -i %s", sshclient.ShellEscape(svc))}, "Show the launchd status of " + svc
+			case facts.HasSystemd:
+				return []string{fmt.Sprintf("systemctl status %s --no-pager", svc)}, "Show the status of the " + svc + " service"
+			default:
+				return []string{fmt.Sprintf("service %s status", svc)}, "Show the status of the " + svc + " service"
+			}
+		},
+	},
+	{
+		// Process list
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:running\s+)?process(?:es)?\b`),
+			regexp.MustCompile(`进程`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"ps aux"}, "List all running processes"
+		},
+	},
+	{
+		// Uptime and load
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\buptime\b|\bload\s+average\b|\bsystem\s+load\b`),
+			regexp.MustCompile(`负载|运行时间|运行了多久`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"uptime"}, "Show uptime and load averages"
+		},
+	},
+	{
+		// CPU information
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\bcpu\s+(?:info|information|model|cores?)\b|\bhow\s+many\s+(?:cpus|cores)\b`),
+			regexp.MustCompile(`(?i)cpu\s*信息|几核|核数|处理器`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "darwin" {
+				return []string{"sysctl -n machdep.cpu.brand_string hw.ncpu"}, "Show the CPU model and core count"
+			}
+			return []string{"lscpu"}, "Show CPU information"
+		},
+	},
+	{
+		// OS version
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\b(?:os|system|kernel|distro|distribution)\s+(?:version|info|information|release)\b|\bwhich\s+(?:os|distro|distribution)\b`),
+			regexp.MustCompile(`系统版本|内核版本|操作系统`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "darwin" {
+				return []string{"sw_vers", "uname -a"}, "Show the operating system and kernel version"
+			}
+			return []string{"uname -a", "cat /etc/os-release"}, "Show the operating system and kernel version"
+		},
+	},
+	{
+		// IP addresses
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\bip\s+address(?:es)?\b|\bnetwork\s+interfaces?\b|\bmy\s+ip\b`),
+			regexp.MustCompile(`(?i)ip\s*地址|网卡|网络接口`),
+		},
+		build: func(_ []string, facts *HostFacts) ([]string, string) {
+			if facts.OS == "linux" {
+				return []string{"ip addr show"}, "Show network interfaces and IP addresses"
+			}
+			return []string{"ifconfig"}, "Show network interfaces and IP addresses"
+		},
+	},
+	{
+		// Logged-in users
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\bwho\s+is\s+(?:logged\s+in|online)\b|\blogged[- ]in\s+users\b`),
+			regexp.MustCompile(`登录用户|在线用户|谁在线|谁登录`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"who"}, "Show logged-in users"
+		},
+	},
+	{
+		// List files
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\blist\s+(?:all\s+)?files\b|\bshow\s+(?:all\s+)?files\b`),
+			regexp.MustCompile(`列出文件|文件列表|有哪些文件`),
+		},
+		build: func(_ []string, _ *HostFacts) ([]string, string) {
+			return []string{"ls -la"}, "List all files including hidden ones with details"
+		},
+	},
+}
+
+// firstArg returns the first non-empty capture group.
+func firstArg(args []string) string {
+	for _, arg := range args {
+		if arg != "" {
+			return arg
+		}
+	}
+	return ""
+}
+
+// hasStopword reports whether any captured argument is a stopword.
+func hasStopword(args []string) bool {
+	for _, arg := range args {
+		if offlineStopwords[strings.ToLower(arg)] {
+			return true
+		}
+	}
+	return false
+}
+
+// translateOffline matches a request against the built-in intent library and
+// returns the command for the given host, or nil if no intent matches.
+func translateOffline(request string, facts *HostFacts) *CommandInfo {
+	if facts == nil {
+		facts = defaultHostFacts
+	}
+
+	for _, intent := range offlineIntents {
+		for _, re := range intent.patterns {
+			matches := re.FindStringSubmatch(request)
+			if matches == nil || hasStopword(matches[1:]) {
+				continue
+			}
+
+			commands, description := intent.build(matches[1:], facts)
+			return &CommandInfo{
+				Commands:     commands,
+				Description:  "[offline] " + description,
+				NeedsConfirm: intent.needsConfirm,
+				Offline:      true,
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestTranslateOffline(t *testing.T) {
+	linux := &HostFacts{OS: "linux", HasSystemd: true, HasSS: true}
+	oldLinux := &HostFacts{OS: "linux"}
+	darwin := &HostFacts{OS: "darwin"}
+
+	tests := []struct {
+		name        string
+		request     string
+		facts       *HostFacts
+		wantCommand string
+		wantConfirm bool
+	}{
+		{name: "disk usage", request: "show me disk usage", facts: linux, wantCommand: "df -h"},
+		{name: "disk usage zh", request: "查看磁盘使用情况", facts: linux, wantCommand: "df -h"},
+		{name: "memory", request: "how much memory is free", facts: linux, wantCommand: "free -h"},
+		{name: "memory zh", request: "查看内存", facts: linux, wantCommand: "free -h"},
+		{name: "memory darwin", request: "check memory usage", facts: darwin, wantCommand: "top -l 1 -s 0 | grep PhysMem"},
+		{name: "top memory processes", request: "which processes use the most memory", facts: linux, wantCommand: "ps aux --sort=-%mem | head -n 11"},
+		{name: "listening ports with ss", request: "list listening ports", facts: linux, wantCommand: "ss -tulpn"},
+		{name: "listening ports without ss", request: "what ports are open", facts: oldLinux, wantCommand: "netstat -tulpn"},
+		{name: "service status", request: "is nginx running", facts: linux, wantCommand: "systemctl status nginx --no-pager"},
+		{name: "service status without systemd", request: "check nginx status", facts: oldLinux, wantCommand: "service nginx status"},
+		{name: "service status zh", request: "查看nginx状态", facts: linux, wantCommand: "systemctl status nginx --no-pager"},
+		{name: "service logs", request: "show nginx logs", facts: linux, wantCommand: "journalctl -u nginx -n 50 --no-pager"},
+		{name: "tail log file", request: "tail /var/log/syslog", facts: linux, wantCommand: "tail -n 50 '/var/log/syslog'"},
+		{name: "tail log file with count", request: "last 100 lines of /var/log/nginx/error.log", facts: linux, wantCommand: "tail -n 100 '/var/log/nginx/error.log'"},
+		{name: "tail log file zh", request: "查看/var/log/messages最后20行", facts: linux, wantCommand: "tail -n 20 '/var/log/messages'"},
+		{name: "restart service", request: "restart nginx", facts: linux, wantCommand: "sudo systemctl restart nginx", wantConfirm: true},
+		{name: "restart service zh", request: "重启nginx", facts: oldLinux, wantCommand: "sudo service nginx restart", wantConfirm: true},
+		{name: "uptime", request: "what is the system load", facts: linux, wantCommand: "uptime"},
+		{name: "nil facts uses defaults", request: "list listening ports", facts: nil, wantCommand: "ss -tulpn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := translateOffline(tt.request, tt.facts)
+			if info == nil {
+				t.Fatalf("translateOffline(%q) = nil, want %q", tt.request, tt.wantCommand)
+			}
+			if len(info.Commands) == 0 || info.Commands[0] != tt.wantCommand {
+				t.Errorf("translateOffline(%q) commands = %v, want %q first", tt.request, info.Commands, tt.wantCommand)
+			}
+			if info.NeedsConfirm != tt.wantConfirm {
+				t.Errorf("translateOffline(%q) NeedsConfirm = %v, want %v", tt.request, info.NeedsConfirm, tt.wantConfirm)
+			}
+			if !info.Offline || !strings.HasPrefix(info.Description, "[offline] ") {
+				t.Errorf("translateOffline(%q) = %+v, want it marked as offline", tt.request, info)
+			}
+		})
+	}
+}
+
+func TestTranslateOfflineNoMatch(t *testing.T) {
+	requests := []string{
+		"write a haiku about kubernetes",
+		"restart the server",
+		"show me status",
+	}
+
+	for _, request := range requests {
+		t.Run(request, func(t *testing.T) {
+			if info := translateOffline(request, nil); info != nil {
+				t.Errorf("translateOffline(%q) = %v, want nil", request, info.Commands)
+			}
+		})
+	}
+}
+
+func TestParseHostFacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *HostFacts
+	}{
+		{name: "linux with systemd and ss", output: "Linux\nhas:systemd\nhas:ss\n", want: &HostFacts{OS: "linux", HasSystemd: true, HasSS: true}},
+		{name: "darwin", output: "Darwin\n", want: &HostFacts{OS: "darwin"}},
+		{name: "empty", output: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHostFacts(tt.output)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseHostFacts(%q) = %+v, want %+v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandRequestOfflineFallback(t *testing.T) {
+	a := NewAgent(&fakeModelClient{err: errors.New("dial tcp 127.0.0.1:11434: connection refused")})
+	a.SetHostFacts(&HostFacts{OS: "linux", HasSystemd: true, HasSS: true})
+
+	info, err := a.ParseCommandRequest(context.Background(), "show me disk usage")
+	if err != nil {
+		t.Fatalf("ParseCommandRequest() error = %v", err)
+	}
+	if !info.Offline || len(info.Commands) != 1 || info.Commands[0] != "df -h" {
+		t.Errorf("ParseCommandRequest() = %+v, want offline df -h", info)
+	}
+
+	if _, err := a.ParseCommandRequest(context.Background(), "write a haiku"); err == nil {
+		t.Errorf("ParseCommandRequest() should fail when no offline rule matches")
+	}
+
+	// A reachable model with an unparseable answer does not fall back
+	a = NewAgent(&fakeModelClient{response: schema.AssistantMessage("I cannot help with that.", nil)})
+	if _, err := a.ParseCommandRequest(context.Background(), "show me disk usage"); err == nil {
+		t.Errorf("ParseCommandRequest() should fail on an unparseable response")
+	}
+}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/schema"
 
@@ -41,32 +43,67 @@ type ModelClient interface {
 
 // Client wraps an LLM model client.
 type Client struct {
-	model    model.ChatModel
-	provider config.LLMProviderType
+	model     model.ChatModel
+	provider  config.LLMProviderType
+	modelName string
+	inspector *Inspector
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithInspector records every model call of the client in the inspector.
+func WithInspector(inspector *Inspector) ClientOption {
+	return func(c *Client) {
+		c.inspector = inspector
+	}
 }
 
 // NewClient creates a new AI client based on the configuration.
-func NewClient(ctx context.Context, cfg *config.LLMConfig) (ModelClient, error) {
+func NewClient(ctx context.Context, cfg *config.LLMConfig, opts ...ClientOption) (ModelClient, error) {
+	var client *Client
+	var err error
 	switch cfg.Provider {
 	case config.ProviderOllama:
-		return newOllamaClient(ctx, cfg)
+		client, err = newOllamaClient(ctx, cfg)
 	case config.ProviderOpenAI:
-		return newOpenAIClient(ctx, cfg)
+		client, err = newOpenAIClient(ctx, cfg)
 	case config.ProviderDeepSeek:
-		return newDeepSeekClient(ctx, cfg)
+		client, err = newDeepSeekClient(ctx, cfg)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.modelName = cfg.Model
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client, nil
 }
 
 // Generate generates a response from the model.
 func (c *Client) Generate(ctx context.Context, messages []*schema.Message) (*schema.Message, error) {
-	return c.model.Generate(ctx, messages)
+	return c.model.Generate(c.withCallbacks(ctx), messages)
 }
 
 // Stream generates a streaming response from the model.
 func (c *Client) Stream(ctx context.Context, messages []*schema.Message) (*schema.StreamReader[*schema.Message], error) {
-	return c.model.Stream(ctx, messages)
+	return c.model.Stream(c.withCallbacks(ctx), messages)
+}
+
+// withCallbacks registers the inspector's callbacks handler for one call.
+func (c *Client) withCallbacks(ctx context.Context) context.Context {
+	if c.inspector == nil {
+		return ctx
+	}
+	return callbacks.InitCallbacks(ctx, &callbacks.RunInfo{
+		Name:      c.modelName,
+		Type:      string(c.provider),
+		Component: components.ComponentOfChatModel,
+	}, c.inspector.Handler())
 }
 
 // GetModel returns the underlying model.
@@ -0,0 +1,449 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	// DefaultContextWindow is used for models missing from the context-size table.
+	DefaultContextWindow = 8192
+	// minOutputBudget is the smallest number of tokens given to command output.
+	minOutputBudget = 512
+	// promptOverheadTokens approximates the system prompt and request framing.
+	promptOverheadTokens = 512
+	// maxSummaryChunks caps how many map calls a single summarization may make.
+	// Outputs that would need more chunks are trimmed to that size first.
+	maxSummaryChunks = 8
+)
+
+// modelContextWindows maps model name prefixes to their context size in tokens.
+// Longer prefixes are matched first, so "llama3.1" wins over "llama3".
+var modelContextWindows = map[string]int{
+	// Ollama / local models
+	"qwen2.5":     32768,
+	"qwen3":       40960,
+	"qwen2":       32768,
+	"qwen":        8192,
+	"llama3.1":    131072,
+	"llama3.2":    131072,
+	"llama3.3":    131072,
+	"llama3":      8192,
+	"llama2":      4096,
+	"mistral":     32768,
+	"mixtral":     32768,
+	"gemma2":      8192,
+	"gemma3":      131072,
+	"gemma":       8192,
+	"phi3":        4096,
+	"phi4":        16384,
+	"codellama":   16384,
+	"deepseek-r1": 131072,
+	// DeepSeek
+	"deepseek-chat":     65536,
+	"deepseek-reasoner": 65536,
+	"deepseek-coder":    16384,
+	// OpenAI
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"gpt-4-turbo":   128000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16385,
+	"o1":            200000,
+	"o3":            200000,
+	"o4-mini":       200000,
+}
+
+// ContextWindow returns the context size in tokens for the given model name.
+func ContextWindow(modelName string) int {
+	name := strings.ToLower(modelName)
+	bestLen := 0
+	window := DefaultContextWindow
+	for prefix, size := range modelContextWindows {
+		if strings.HasPrefix(name, prefix) && len(prefix) > bestLen {
+			bestLen = len(prefix)
+			window = size
+		}
+	}
+	return window
+}
+
+// EstimateTokens returns a rough token count for text. ASCII text averages about
+// four characters per token, while CJK and other multi-byte characters are
+// usually one token each.
+func EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		other++
+		i += size
+	}
+	return (ascii+3)/4 + other
+}
+
+// TruncationReport describes how command output was reduced to fit the model's context.
+type TruncationReport struct {
+	// OriginalTokens is the estimated token count before reduction.
+	OriginalTokens int
+	// FinalTokens is the estimated token count sent to the model.
+	FinalTokens int
+	// OriginalLines is the number of lines before reduction.
+	OriginalLines int
+	// FinalLines is the number of lines sent to the model.
+	FinalLines int
+	// Strategies lists the reduction steps that were applied, in order.
+	Strategies []string
+}
+
+// String returns a human-readable summary of the truncation.
+func (r *TruncationReport) String() string {
+	return fmt.Sprintf("output reduced from ~%d to ~%d tokens (%d to %d lines) using %s",
+		r.OriginalTokens, r.FinalTokens, r.OriginalLines, r.FinalLines, strings.Join(r.Strategies, ", "))
+}
+
+// ContextManager fits large command outputs into a model's context window.
+type ContextManager struct {
+	window int
+	client ModelClient
+}
+
+// NewContextManager creates a context manager for the given model. A positive
+// window overrides the built-in context-size table (useful for Ollama, whose
+// effective window is num_ctx). If client is non-nil, very large outputs are
+// summarized with map-reduce instead of only being truncated.
+func NewContextManager(modelName string, window int, client ModelClient) *ContextManager {
+	if window <= 0 {
+		window = ContextWindow(modelName)
+	}
+	return &ContextManager{window: window, client: client}
+}
+
+// Window returns the context window size in tokens.
+func (m *ContextManager) Window() int {
+	return m.window
+}
+
+// OutputBudget returns the number of tokens available for command output,
+// leaving room for the prompt and the model's answer.
+func (m *ContextManager) OutputBudget() int {
+	budget := m.window - m.window/4 - promptOverheadTokens
+	if budget < minOutputBudget {
+		budget = minOutputBudget
+	}
+	return budget
+}
+
+// Fit reduces output so it fits the output budget. It returns the output unchanged
+// and a nil report when no reduction was needed. The cheap strategies (dedup,
+// then map-reduce summarization when a client is available and the output is
+// not too large, then error-line extraction with head/tail) are tried in order.
+func (m *ContextManager) Fit(ctx context.Context, command, output string) (string, *TruncationReport) {
+	budget := m.OutputBudget()
+	originalTokens := EstimateTokens(output)
+	if originalTokens <= budget {
+		return output, nil
+	}
+
+	report := &TruncationReport{
+		OriginalTokens: originalTokens,
+		OriginalLines:  countLines(output),
+	}
+	finish := func(text string) (string, *TruncationReport) {
+		report.FinalTokens = EstimateTokens(text)
+		report.FinalLines = countLines(text)
+		return text, report
+	}
+
+	text, collapsed := DedupLines(output)
+	if collapsed > 0 {
+		report.Strategies = append(report.Strategies, fmt.Sprintf("dedup (%d repeated lines)", collapsed))
+		if EstimateTokens(text) <= budget {
+			return finish(text)
+		}
+	}
+
+	if m.client != nil {
+		input, trimmed := text, false
+		if limit := budget * maxSummaryChunks; EstimateTokens(input) > limit {
+			// Keep the error lines and both ends of an output too large to
+			// summarize whole
+			if extracted, ok := ExtractErrorLines(input, limit); ok {
+				input = extracted
+			}
+			input, trimmed = HeadTail(input, limit), true
+		}
+		if summary, err := m.summarize(ctx, command, input, budget); err == nil {
+			if trimmed {
+				report.Strategies = append(report.Strategies, fmt.Sprintf("pre-trim to %d tokens", budget*maxSummaryChunks))
+			}
+			report.Strategies = append(report.Strategies, "map-reduce summary")
+			return finish(summary)
+		}
+	}
+
+	if extracted, ok := ExtractErrorLines(text, budget); ok {
+		report.Strategies = append(report.Strategies, "error-line extraction")
+		text = extracted
+		if EstimateTokens(text) <= budget {
+			return finish(text)
+		}
+	}
+
+	report.Strategies = append(report.Strategies, "head/tail")
+	return finish(HeadTail(text, budget))
+}
+
+// countLines returns the number of lines in text.
+func countLines(text string) int {
+	if text == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(text, "\n"), "\n") + 1
+}
+
+// digitsRe matches runs of digits, which are masked when comparing lines so that
+// log lines differing only in timestamps or counters are treated as repeats.
+var digitsRe = regexp.MustCompile(`[0-9]+`)
+
+// DedupLines collapses runs of consecutive lines that are identical apart from
+// digits into the first line plus a marker. It returns the result and the number
+// of lines removed.
+func DedupLines(text string) (string, int) {
+	lines := strings.Split(text, "\n")
+	var sb strings.Builder
+	removed := 0
+
+	for i := 0; i < len(lines); {
+		key := digitsRe.ReplaceAllString(lines[i], "#")
+		j := i + 1
+		for j < len(lines) && digitsRe.ReplaceAllString(lines[j], "#") == key {
+			j++
+		}
+
+		sb.WriteString(lines[i])
+		if run := j - i; run > 1 {
+			fmt.Fprintf(&sb, "\n... [%d similar lines omitted]", run-1)
+			removed += run - 1
+		}
+		if j < len(lines) {
+			sb.WriteString("\n")
+		}
+		i = j
+	}
+
+	return sb.String(), removed
+}
+
+// errorLineRe matches lines that usually carry diagnostic value.
+var errorLineRe = regexp.MustCompile(`(?i)(error|fail|fatal|panic|exception|denied|refused|timed? ?out|cannot|unable|critical|warn|oom|killed|segfault|traceback|错误|失败|异常)`)
+
+// ExtractErrorLines keeps lines that look like errors or warnings, plus one line
+// of context around each, together with the first and last few lines of text.
+// It returns false if no such lines were found.
+func ExtractErrorLines(text string, budget int) (string, bool) {
+	lines := strings.Split(text, "\n")
+	keep := make([]bool, len(lines))
+	found := false
+
+	for i, line := range lines {
+		if !errorLineRe.MatchString(line) {
+			continue
+		}
+		found = true
+		for k := i - 1; k <= i+1; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	if !found {
+		return text, false
+	}
+
+	// Keep a little of the beginning and end for orientation
+	const edgeLines = 5
+	for i := 0; i < edgeLines && i < len(lines); i++ {
+		keep[i] = true
+		keep[len(lines)-1-i] = true
+	}
+
+	var sb strings.Builder
+	skipped := 0
+	for i, line := range lines {
+		if !keep[i] {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			fmt.Fprintf(&sb, "... [%d lines omitted]\n", skipped)
+			skipped = 0
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	if skipped > 0 {
+		fmt.Fprintf(&sb, "... [%d lines omitted]\n", skipped)
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n"), true
+}
+
+// HeadTail keeps the beginning and the end of text within budget tokens. The
+// tail gets the larger share because the end of an output usually holds the
+// final status or the most recent log entries.
+func HeadTail(text string, budget int) string {
+	if EstimateTokens(text) <= budget {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	headBudget := budget * 2 / 5
+	tailBudget := budget - headBudget
+
+	head := 0
+	for used := 0; head < len(lines); head++ {
+		t := EstimateTokens(lines[head]) + 1
+		if used+t > headBudget {
+			break
+		}
+		used += t
+	}
+
+	tail := len(lines)
+	for used := 0; tail > head; tail-- {
+		t := EstimateTokens(lines[tail-1]) + 1
+		if used+t > tailBudget {
+			break
+		}
+		used += t
+	}
+
+	// A single enormous line can exceed the whole budget; cut it by characters.
+	if head == 0 && tail == len(lines) {
+		runes := []rune(text)
+		keep := budget * 2
+		if keep*2 >= len(runes) {
+			return text
+		}
+		return string(runes[:keep]) + "\n... [output truncated] ...\n" + string(runes[len(runes)-keep:])
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.Join(lines[:head], "\n"))
+	fmt.Fprintf(&sb, "\n... [%d lines omitted] ...\n", tail-head)
+	sb.WriteString(strings.Join(lines[tail:], "\n"))
+	return sb.String()
+}
+
+const summaryMapPrompt = `You are Sherlock, an AI assistant for SSH remote operations.
+You are given one part of a long command output. Summarize it concisely for a later analysis step.
+Keep error messages, warnings, exit statuses, numbers, paths, and service names verbatim.
+Omit routine or repeated lines. Respond with the summary only.`
+
+const summaryReducePrompt = `You are Sherlock, an AI assistant for SSH remote operations.
+You are given summaries of consecutive parts of one long command output.
+Merge them into a single concise summary in the original order.
+Keep error messages, warnings, numbers, and paths verbatim. Respond with the summary only.`
+
+// summarize condenses text with map-reduce: each chunk is summarized on its own,
+// then the partial summaries are merged until the result fits the budget.
+func (m *ContextManager) summarize(ctx context.Context, command, text string, budget int) (string, error) {
+	chunks := splitByTokens(text, budget)
+	summaries := make([]string, 0, len(chunks))
+
+	for i, chunk := range chunks {
+		user := fmt.Sprintf("Command: %s\nPart %d of %d:\n%s", command, i+1, len(chunks), chunk)
+		summary, err := m.complete(ctx, summaryMapPrompt, user)
+		if err != nil {
+			return "", err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	combined := strings.Join(summaries, "\n\n")
+	for round := 0; EstimateTokens(combined) > budget && len(summaries) > 1; round++ {
+		if round >= maxSummaryChunks {
+			return HeadTail(combined, budget), nil
+		}
+		groups := splitByTokens(combined, budget)
+		summaries = summaries[:0]
+		for _, group := range groups {
+			user := fmt.Sprintf("Command: %s\nPartial summaries:\n%s", command, group)
+			summary, err := m.complete(ctx, summaryReducePrompt, user)
+			if err != nil {
+				return "", err
+			}
+			summaries = append(summaries, summary)
+		}
+		combined = strings.Join(summaries, "\n\n")
+	}
+
+	return "[summary of a large output]\n" + HeadTail(combined, budget), nil
+}
+
+// complete runs a single system+user exchange and returns the answer without reasoning.
+func (m *ContextManager) complete(ctx context.Context, system, user string) (string, error) {
+	resp, err := m.client.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(system),
+		schema.UserMessage(user),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp == nil {
+		return "", ErrNoResponse
+	}
+	_, answer := SplitReasoning(resp.Content)
+	return answer, nil
+}
+
+// splitByTokens splits text on line boundaries into chunks of at most budget tokens.
+func splitByTokens(text string, budget int) []string {
+	var chunks []string
+	var sb strings.Builder
+	used := 0
+
+	for _, line := range strings.Split(text, "\n") {
+		t := EstimateTokens(line) + 1
+		if used+t > budget && sb.Len() > 0 {
+			chunks = append(chunks, sb.String())
+			sb.Reset()
+			used = 0
+		}
+		if t > budget {
+			line = HeadTail(line, budget)
+			t = budget
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+		used += t
+	}
+	if sb.Len() > 0 {
+		chunks = append(chunks, sb.String())
+	}
+	return chunks
+}
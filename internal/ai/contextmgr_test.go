@@ -0,0 +1,266 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// summarizingClient is a ModelClient that answers every request with a short summary.
+type summarizingClient struct {
+	calls  int
+	inputs []string // the last message of each request
+}
+
+func (c *summarizingClient) Generate(_ context.Context, messages []*schema.Message) (*schema.Message, error) {
+	c.calls++
+	c.inputs = append(c.inputs, messages[len(messages)-1].Content)
+	return schema.AssistantMessage(fmt.Sprintf("<think>condensing</think>summary %d", c.calls), nil), nil
+}
+
+func (c *summarizingClient) Stream(_ context.Context, _ []*schema.Message) (*schema.StreamReader[*schema.Message], error) {
+	return nil, ErrNoResponse
+}
+
+func (c *summarizingClient) GetModel() model.ChatModel { return nil }
+
+func (c *summarizingClient) Close() error { return nil }
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{model: "qwen2.5:7b", want: 32768},
+		{model: "llama3.1:8b", want: 131072},
+		{model: "llama3:8b", want: 8192},
+		{model: "deepseek-chat", want: 65536},
+		{model: "gpt-4o-mini", want: 128000},
+		{model: "gpt-4", want: 8192},
+		{model: "GPT-4O", want: 128000},
+		{model: "unknown-model", want: DefaultContextWindow},
+		{model: "", want: DefaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := ContextWindow(tt.model); got != tt.want {
+				t.Errorf("ContextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "short ascii", text: "abc", want: 1},
+		{name: "ascii", text: "abcdefgh", want: 2},
+		{name: "chinese", text: "磁盘使用", want: 4},
+		{name: "mixed", text: "df 磁盘", want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewContextManagerOverride(t *testing.T) {
+	if got := NewContextManager("qwen2.5:7b", 4096, nil).Window(); got != 4096 {
+		t.Errorf("Window() = %d, want 4096", got)
+	}
+	if got := NewContextManager("qwen2.5:7b", 0, nil).Window(); got != 32768 {
+		t.Errorf("Window() = %d, want 32768", got)
+	}
+	if got := NewContextManager("tiny", 100, nil).OutputBudget(); got != minOutputBudget {
+		t.Errorf("OutputBudget() = %d, want %d", got, minOutputBudget)
+	}
+}
+
+func TestDedupLines(t *testing.T) {
+	input := "start\n10:00:01 retrying connection\n10:00:02 retrying connection\n10:00:03 retrying connection\nend"
+	got, removed := DedupLines(input)
+	if removed != 2 {
+		t.Errorf("DedupLines() removed = %d, want 2", removed)
+	}
+	want := "start\n10:00:01 retrying connection\n... [2 similar lines omitted]\nend"
+	if got != want {
+		t.Errorf("DedupLines() = %q, want %q", got, want)
+	}
+
+	if _, removed := DedupLines("a\nb\nc"); removed != 0 {
+		t.Errorf("DedupLines() removed = %d for distinct lines, want 0", removed)
+	}
+}
+
+func TestExtractErrorLines(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("routine line %c", 'a'+i%26))
+	}
+	lines[50] = "nginx: [emerg] bind() failed (98: Address already in use)"
+	text := strings.Join(lines, "\n")
+
+	got, ok := ExtractErrorLines(text, 1000)
+	if !ok {
+		t.Fatal("ExtractErrorLines() should find the error line")
+	}
+	if !strings.Contains(got, "Address already in use") {
+		t.Errorf("ExtractErrorLines() should keep the error line")
+	}
+	if !strings.Contains(got, lines[49]) || !strings.Contains(got, lines[51]) {
+		t.Errorf("ExtractErrorLines() should keep context around the error line")
+	}
+	if !strings.Contains(got, "lines omitted") {
+		t.Errorf("ExtractErrorLines() should mark omitted lines")
+	}
+
+	if _, ok := ExtractErrorLines("all good\nnothing here", 1000); ok {
+		t.Errorf("ExtractErrorLines() should report no error lines")
+	}
+}
+
+func TestHeadTail(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("line %04d with some padding text", i))
+	}
+	text := strings.Join(lines, "\n")
+
+	got := HeadTail(text, 500)
+	if EstimateTokens(got) > 550 {
+		t.Errorf("HeadTail() result has ~%d tokens, want about 500", EstimateTokens(got))
+	}
+	if !strings.HasPrefix(got, "line 0000") {
+		t.Errorf("HeadTail() should keep the head")
+	}
+	if !strings.HasSuffix(got, "line 0999 with some padding text") {
+		t.Errorf("HeadTail() should keep the tail")
+	}
+	if !strings.Contains(got, "lines omitted") {
+		t.Errorf("HeadTail() should mark omitted lines")
+	}
+
+	if got := HeadTail("short", 500); got != "short" {
+		t.Errorf("HeadTail() should not change short text, got %q", got)
+	}
+
+	long := strings.Repeat("x", 10000)
+	if got := HeadTail(long, 100); EstimateTokens(got) > 120 {
+		t.Errorf("HeadTail() of a single long line has ~%d tokens, want about 100", EstimateTokens(got))
+	}
+}
+
+func TestContextManagerFit(t *testing.T) {
+	cm := NewContextManager("", 2048, nil)
+
+	if got, report := cm.Fit(context.Background(), "echo hi", "hi\n"); got != "hi\n" || report != nil {
+		t.Errorf("Fit() should leave small output unchanged, got %q, report %v", got, report)
+	}
+
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("entry %d: request served path=/api/v1/items/%c", i, 'a'+i%26))
+	}
+	lines[2500] = "entry 2500: ERROR upstream timed out"
+	output := strings.Join(lines, "\n")
+
+	got, report := cm.Fit(context.Background(), "journalctl -u api", output)
+	if report == nil {
+		t.Fatal("Fit() should report truncation for large output")
+	}
+	if EstimateTokens(got) > cm.OutputBudget() {
+		t.Errorf("Fit() result has ~%d tokens, budget is %d", EstimateTokens(got), cm.OutputBudget())
+	}
+	if !strings.Contains(got, "upstream timed out") {
+		t.Errorf("Fit() should keep the error line")
+	}
+	if report.OriginalLines != 5000 || report.FinalTokens != EstimateTokens(got) {
+		t.Errorf("Fit() report = %+v, inconsistent with result", report)
+	}
+	if !strings.Contains(report.String(), "error-line extraction") {
+		t.Errorf("report.String() = %q, want it to mention error-line extraction", report.String())
+	}
+}
+
+func TestContextManagerFitSummarizes(t *testing.T) {
+	client := &summarizingClient{}
+	cm := NewContextManager("", 2048, client)
+
+	var lines []string
+	for i := 0; cm.OutputBudget()*3 > EstimateTokens(strings.Join(lines, "\n")); i++ {
+		lines = append(lines, fmt.Sprintf("pkg %c%c upgraded", 'a'+i%26, 'a'+(i/26)%26))
+	}
+
+	got, report := cm.Fit(context.Background(), "apt list --upgradable", strings.Join(lines, "\n"))
+	if report == nil || !strings.Contains(report.String(), "map-reduce summary") {
+		t.Fatalf("Fit() report = %v, want map-reduce summary", report)
+	}
+	if client.calls < 3 {
+		t.Errorf("summarizer called %d times, want at least one call per chunk", client.calls)
+	}
+	if strings.Contains(got, "condensing") {
+		t.Errorf("Fit() summary should not contain model reasoning: %q", got)
+	}
+}
+
+// TestContextManagerFitSummarizesHugeOutput checks that output beyond what
+// the map calls can take is trimmed and then summarized, not just truncated.
+func TestContextManagerFitSummarizesHugeOutput(t *testing.T) {
+	client := &summarizingClient{}
+	cm := NewContextManager("", 2048, client)
+
+	var lines []string
+	for i := 0; cm.OutputBudget()*maxSummaryChunks*4 > EstimateTokens(strings.Join(lines, "\n")); i++ {
+		lines = append(lines, fmt.Sprintf("entry %d: request served path=/api/v1/items/%c", i, 'a'+i%26))
+	}
+	lines[len(lines)/2] = "entry X: ERROR upstream timed out"
+
+	got, report := cm.Fit(context.Background(), "journalctl -u api", strings.Join(lines, "\n"))
+	if report == nil || !strings.Contains(report.String(), "map-reduce summary") || !strings.Contains(report.String(), "pre-trim") {
+		t.Fatalf("Fit() report = %v, want a pre-trim and map-reduce summary", report)
+	}
+	if EstimateTokens(got) > cm.OutputBudget() {
+		t.Errorf("Fit() result has ~%d tokens, budget is %d", EstimateTokens(got), cm.OutputBudget())
+	}
+	maps := 0
+	sawError := false
+	for _, input := range client.inputs {
+		if strings.Contains(input, "Part ") {
+			maps++
+		}
+		sawError = sawError || strings.Contains(input, "upstream timed out")
+	}
+	if maps == 0 || maps > maxSummaryChunks+1 {
+		t.Errorf("summarizer got %d parts, want 1 to about %d", maps, maxSummaryChunks)
+	}
+	if !sawError {
+		t.Error("the error line in the middle of the output was not summarized")
+	}
+}
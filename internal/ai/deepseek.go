@@ -102,8 +102,9 @@ type deepSeekChatResponse struct {
 	Choices []struct {
 		Index   int `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role             string `json:"role"`
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content,omitempty"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
@@ -123,8 +124,9 @@ type deepSeekStreamResponse struct {
 	Choices []struct {
 		Index int `json:"index"`
 		Delta struct {
-			Role    string `json:"role,omitempty"`
-			Content string `json:"content,omitempty"`
+			Role             string `json:"role,omitempty"`
+			Content          string `json:"content,omitempty"`
+			ReasoningContent string `json:"reasoning_content,omitempty"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
@@ -152,9 +154,11 @@ func (m *DeepSeekChatModel) Generate(ctx context.Context, input []*schema.Messag
 	}
 
 	choice := resp.Choices[0]
+	reasoning, content := parseReasoning(choice.Message.ReasoningContent, choice.Message.Content)
 	outMsg := &schema.Message{
-		Role:    schema.RoleType(choice.Message.Role),
-		Content: choice.Message.Content,
+		Role:             schema.RoleType(choice.Message.Role),
+		Content:          content,
+		ReasoningContent: reasoning,
 		ResponseMeta: &schema.ResponseMeta{
 			FinishReason: choice.FinishReason,
 			Usage: &schema.TokenUsage{
@@ -199,15 +203,18 @@ func (m *DeepSeekChatModel) Stream(ctx context.Context, input []*schema.Message,
 			sw.Close()
 		}()
 
+		splitter := &thinkStreamSplitter{}
 		err := m.doStreamRequest(ctx, req, func(resp *deepSeekStreamResponse) error {
 			if len(resp.Choices) == 0 {
 				return nil
 			}
 
 			choice := resp.Choices[0]
+			reasoning, content := splitter.Feed(choice.Delta.Content)
 			outMsg := &schema.Message{
-				Role:    schema.Assistant,
-				Content: choice.Delta.Content,
+				Role:             schema.Assistant,
+				Content:          content,
+				ReasoningContent: choice.Delta.ReasoningContent + reasoning,
 			}
 
 			cbOutput := &model.CallbackOutput{
@@ -221,6 +228,19 @@ func (m *DeepSeekChatModel) Stream(ctx context.Context, input []*schema.Message,
 
 		if err != nil {
 			sw.Send(nil, err)
+			return
+		}
+
+		// Emit any text held back while waiting for a possible tag boundary
+		if reasoning, content := splitter.Flush(); reasoning != "" || content != "" {
+			sw.Send(&model.CallbackOutput{
+				Message: &schema.Message{
+					Role:             schema.Assistant,
+					Content:          content,
+					ReasoningContent: reasoning,
+				},
+				Config: conf,
+			}, nil)
 		}
 	}(ctx, cbInput.Config)
 
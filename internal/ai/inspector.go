@@ -0,0 +1,363 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// DefaultInspectorSize is the number of calls kept by an inspector.
+const DefaultInspectorSize = 50
+
+// RecordedMessage is a message sent to the model.
+type RecordedMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// CallRecord describes one model call.
+type CallRecord struct {
+	// ID numbers the calls from 1.
+	ID int `json:"id"`
+	// Time is when the call started.
+	Time time.Time `json:"time"`
+	// Provider and Model identify the model that was called.
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Messages are the messages sent to the model.
+	Messages []RecordedMessage `json:"messages"`
+	// Response is the raw response content, before any JSON extraction.
+	Response string `json:"response,omitempty"`
+	// Reasoning is the model's thinking, if it produced any.
+	Reasoning string `json:"reasoning,omitempty"`
+	// ParseResult is what the caller made of the response, e.g. the decoded
+	// JSON or the parse error.
+	ParseResult string `json:"parse_result,omitempty"`
+	// Error is set if the call failed.
+	Error string `json:"error,omitempty"`
+	// Usage is the token usage reported by the provider.
+	Usage *model.TokenUsage `json:"usage,omitempty"`
+	// LatencyMS is the duration of the call in milliseconds.
+	LatencyMS int64 `json:"latency_ms"`
+
+	inspector *Inspector
+}
+
+// Inspector keeps a ring buffer of recent model calls for debugging. Secrets
+// are redacted before a call is stored.
+type Inspector struct {
+	mu      sync.Mutex
+	records []*CallRecord // ring buffer
+	next    int           // index of the next slot to write
+	count   int
+	lastID  int
+	secrets []string
+	out     io.Writer
+	pending *CallRecord // finished call waiting for its parse result
+	handler callbacks.Handler
+}
+
+// NewInspector creates an inspector that keeps the last size calls.
+func NewInspector(size int) *Inspector {
+	if size <= 0 {
+		size = DefaultInspectorSize
+	}
+	i := &Inspector{records: make([]*CallRecord, size)}
+	i.handler = callbacks.NewHandlerBuilder().
+		OnStartFn(i.onStart).
+		OnEndFn(i.onEnd).
+		OnErrorFn(i.onError).
+		OnEndWithStreamOutputFn(i.onEndWithStreamOutput).
+		Build()
+	return i
+}
+
+// Handler returns the eino callbacks handler that records model calls.
+func (i *Inspector) Handler() callbacks.Handler {
+	return i.handler
+}
+
+// AddSecrets registers values, such as API keys, that are always redacted.
+func (i *Inspector) AddSecrets(secrets ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, s := range secrets {
+		if len(s) >= 4 {
+			i.secrets = append(i.secrets, s)
+		}
+	}
+}
+
+// SetOutput writes each call as a JSON line to w once it is complete.
+func (i *Inspector) SetOutput(w io.Writer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.out = w
+}
+
+// Records returns the recorded calls, oldest first.
+func (i *Inspector) Records() []*CallRecord {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records := make([]*CallRecord, 0, i.count)
+	start := (i.next - i.count + len(i.records)) % len(i.records)
+	for n := 0; n < i.count; n++ {
+		records = append(records, i.records[(start+n)%len(i.records)])
+	}
+	return records
+}
+
+// Record returns the call with the given ID, if it is still in the buffer.
+func (i *Inspector) Record(id int) (*CallRecord, bool) {
+	for _, r := range i.Records() {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Flush writes the pending call, if any.
+func (i *Inspector) Flush() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.flushLocked()
+}
+
+// recordKey is the context key of the record of the current call.
+type recordKey struct{}
+
+// callTrace lets the caller of a model attach the parse result to the call.
+type callTrace struct {
+	record *CallRecord
+}
+
+// traceKey is the context key of a callTrace.
+type traceKey struct{}
+
+// WithCallTrace returns a context whose next model call can be annotated with
+// SetParseResult.
+func WithCallTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey{}, &callTrace{})
+}
+
+// SetParseResult records what the caller made of the response of the model
+// call made with ctx. It does nothing if the call was not recorded.
+func SetParseResult(ctx context.Context, result string) {
+	trace, ok := ctx.Value(traceKey{}).(*callTrace)
+	if !ok || trace.record == nil || trace.record.inspector == nil {
+		return
+	}
+	trace.record.inspector.setParseResult(trace.record, result)
+}
+
+func (i *Inspector) onStart(ctx context.Context, info *callbacks.RunInfo, input callbacks.CallbackInput) context.Context {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.flushLocked()
+	i.lastID++
+	record := &CallRecord{
+		ID:        i.lastID,
+		Time:      time.Now(),
+		inspector: i,
+	}
+	if info != nil {
+		record.Provider, record.Model = info.Type, info.Name
+	}
+	if in := model.ConvCallbackInput(input); in != nil {
+		for _, msg := range in.Messages {
+			record.Messages = append(record.Messages, RecordedMessage{
+				Role:    string(msg.Role),
+				Content: i.redactLocked(msg.Content),
+			})
+		}
+	}
+
+	if trace, ok := ctx.Value(traceKey{}).(*callTrace); ok {
+		trace.record = record
+	}
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+func (i *Inspector) onEnd(ctx context.Context, _ *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
+	record, ok := ctx.Value(recordKey{}).(*CallRecord)
+	if !ok {
+		return ctx
+	}
+	out := model.ConvCallbackOutput(output)
+	var msg *schema.Message
+	var usage *model.TokenUsage
+	if out != nil {
+		msg, usage = out.Message, out.TokenUsage
+	}
+	i.finish(record, msg, usage, nil)
+	return ctx
+}
+
+func (i *Inspector) onError(ctx context.Context, _ *callbacks.RunInfo, err error) context.Context {
+	if record, ok := ctx.Value(recordKey{}).(*CallRecord); ok {
+		i.finish(record, nil, nil, err)
+	}
+	return ctx
+}
+
+func (i *Inspector) onEndWithStreamOutput(ctx context.Context, _ *callbacks.RunInfo, output *schema.StreamReader[callbacks.CallbackOutput]) context.Context {
+	record, ok := ctx.Value(recordKey{}).(*CallRecord)
+	if !ok {
+		output.Close()
+		return ctx
+	}
+
+	go func() {
+		defer output.Close()
+
+		var content, reasoning strings.Builder
+		var usage *model.TokenUsage
+		for {
+			chunk, err := output.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				i.finish(record, nil, nil, err)
+				return
+			}
+			out := model.ConvCallbackOutput(chunk)
+			if out == nil {
+				continue
+			}
+			if out.Message != nil {
+				content.WriteString(out.Message.Content)
+				reasoning.WriteString(out.Message.ReasoningContent)
+			}
+			if out.TokenUsage != nil {
+				usage = out.TokenUsage
+			}
+		}
+		i.finish(record, &schema.Message{Content: content.String(), ReasoningContent: reasoning.String()}, usage, nil)
+	}()
+	return ctx
+}
+
+// finish completes a call and stores it in the buffer.
+func (i *Inspector) finish(record *CallRecord, msg *schema.Message, usage *model.TokenUsage, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	record.LatencyMS = time.Since(record.Time).Milliseconds()
+	if msg != nil {
+		record.Response = i.redactLocked(msg.Content)
+		record.Reasoning = i.redactLocked(msg.ReasoningContent)
+	}
+	if usage != nil {
+		record.Usage = usage
+	}
+	if err != nil {
+		record.Error = i.redactLocked(err.Error())
+	}
+
+	i.records[i.next] = record
+	i.next = (i.next + 1) % len(i.records)
+	if i.count < len(i.records) {
+		i.count++
+	}
+
+	// Hold the call back until the caller reports its parse result, so that
+	// the debug log gets one complete line per call
+	i.flushLocked()
+	i.pending = record
+}
+
+func (i *Inspector) setParseResult(record *CallRecord, result string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	record.ParseResult = i.redactLocked(result)
+	if i.pending == record {
+		i.flushLocked()
+	}
+}
+
+// flushLocked writes the pending call to the output. The caller holds i.mu.
+func (i *Inspector) flushLocked() {
+	if i.pending == nil {
+		return
+	}
+	record := i.pending
+	i.pending = nil
+	if i.out == nil {
+		return
+	}
+	if data, err := json.Marshal(record); err == nil {
+		_, _ = i.out.Write(append(data, '\n'))
+	}
+}
+
+// secretPatterns match common credentials in prompts and responses. The first
+// group, if any, is kept.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[:=]\s*["']?)[^\s"',;]+`),
+	regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic)\s+)\S+`),
+	regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9._~+/-]{16,}=*`),
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+	regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`),
+}
+
+// redactedText replaces secrets.
+const redactedText = "[REDACTED]"
+
+// Redact replaces credentials in text with [REDACTED].
+func Redact(text string) string {
+	for _, re := range secretPatterns {
+		if re.NumSubexp() == 0 {
+			text = re.ReplaceAllString(text, redactedText)
+			continue
+		}
+		text = re.ReplaceAllStringFunc(text, func(match string) string {
+			groups := re.FindStringSubmatch(match)
+			suffix := ""
+			if strings.HasSuffix(match, "@") {
+				suffix = "@"
+			}
+			return groups[1] + redactedText + suffix
+		})
+	}
+	return text
+}
+
+// redactLocked redacts the registered secrets and credential patterns. The
+// caller holds i.mu.
+func (i *Inspector) redactLocked(text string) string {
+	for _, s := range i.secrets {
+		text = strings.ReplaceAll(text, s, redactedText)
+	}
+	return Redact(text)
+}
@@ -97,8 +97,9 @@ type ollamaChatRequest struct {
 
 // ollamaMessage represents a message in Ollama format.
 type ollamaMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	Thinking string `json:"thinking,omitempty"`
 }
 
 // ollamaChatResponse represents a response from Ollama's chat API.
@@ -131,9 +132,11 @@ func (m *OllamaChatModel) Generate(ctx context.Context, input []*schema.Message,
 		return nil, err
 	}
 
+	reasoning, content := parseReasoning(resp.Message.Thinking, resp.Message.Content)
 	outMsg := &schema.Message{
-		Role:    schema.RoleType(resp.Message.Role),
-		Content: resp.Message.Content,
+		Role:             schema.RoleType(resp.Message.Role),
+		Content:          content,
+		ReasoningContent: reasoning,
 		ResponseMeta: &schema.ResponseMeta{
 			FinishReason: resp.DoneReason,
 			Usage: &schema.TokenUsage{
@@ -178,10 +181,13 @@ func (m *OllamaChatModel) Stream(ctx context.Context, input []*schema.Message, o
 			sw.Close()
 		}()
 
+		splitter := &thinkStreamSplitter{}
 		err := m.doStreamRequest(ctx, req, func(resp *ollamaChatResponse) error {
+			reasoning, content := splitter.Feed(resp.Message.Content)
 			outMsg := &schema.Message{
-				Role:    schema.RoleType(resp.Message.Role),
-				Content: resp.Message.Content,
+				Role:             schema.RoleType(resp.Message.Role),
+				Content:          content,
+				ReasoningContent: resp.Message.Thinking + reasoning,
 			}
 
 			cbOutput := &model.CallbackOutput{
@@ -195,6 +201,19 @@ func (m *OllamaChatModel) Stream(ctx context.Context, input []*schema.Message, o
 
 		if err != nil {
 			sw.Send(nil, err)
+			return
+		}
+
+		// Emit any text held back while waiting for a possible tag boundary
+		if reasoning, content := splitter.Flush(); reasoning != "" || content != "" {
+			sw.Send(&model.CallbackOutput{
+				Message: &schema.Message{
+					Role:             schema.Assistant,
+					Content:          content,
+					ReasoningContent: reasoning,
+				},
+				Config: conf,
+			}, nil)
 		}
 	}(ctx, cbInput.Config)
 
@@ -101,8 +101,9 @@ type openAIChatResponse struct {
 	Choices []struct {
 		Index   int `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role             string `json:"role"`
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content,omitempty"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
@@ -122,8 +123,9 @@ type openAIStreamResponse struct {
 	Choices []struct {
 		Index int `json:"index"`
 		Delta struct {
-			Role    string `json:"role,omitempty"`
-			Content string `json:"content,omitempty"`
+			Role             string `json:"role,omitempty"`
+			Content          string `json:"content,omitempty"`
+			ReasoningContent string `json:"reasoning_content,omitempty"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
@@ -151,9 +153,11 @@ func (m *OpenAIChatModel) Generate(ctx context.Context, input []*schema.Message,
 	}
 
 	choice := resp.Choices[0]
+	reasoning, content := parseReasoning(choice.Message.ReasoningContent, choice.Message.Content)
 	outMsg := &schema.Message{
-		Role:    schema.RoleType(choice.Message.Role),
-		Content: choice.Message.Content,
+		Role:             schema.RoleType(choice.Message.Role),
+		Content:          content,
+		ReasoningContent: reasoning,
 		ResponseMeta: &schema.ResponseMeta{
 			FinishReason: choice.FinishReason,
 			Usage: &schema.TokenUsage{
@@ -198,15 +202,18 @@ func (m *OpenAIChatModel) Stream(ctx context.Context, input []*schema.Message, o
 			sw.Close()
 		}()
 
+		splitter := &thinkStreamSplitter{}
 		err := m.doStreamRequest(ctx, req, func(resp *openAIStreamResponse) error {
 			if len(resp.Choices) == 0 {
 				return nil
 			}
 
 			choice := resp.Choices[0]
+			reasoning, content := splitter.Feed(choice.Delta.Content)
 			outMsg := &schema.Message{
-				Role:    schema.Assistant,
-				Content: choice.Delta.Content,
+				Role:             schema.Assistant,
+				Content:          content,
+				ReasoningContent: choice.Delta.ReasoningContent + reasoning,
 			}
 
 			cbOutput := &model.CallbackOutput{
@@ -220,6 +227,19 @@ func (m *OpenAIChatModel) Stream(ctx context.Context, input []*schema.Message, o
 
 		if err != nil {
 			sw.Send(nil, err)
+			return
+		}
+
+		// Emit any text held back while waiting for a possible tag boundary
+		if reasoning, content := splitter.Flush(); reasoning != "" || content != "" {
+			sw.Send(&model.CallbackOutput{
+				Message: &schema.Message{
+					Role:             schema.Assistant,
+					Content:          content,
+					ReasoningContent: reasoning,
+				},
+				Config: conf,
+			}, nil)
 		}
 	}(ctx, cbInput.Config)
 
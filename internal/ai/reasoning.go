@@ -0,0 +1,181 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"strings"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// SplitReasoning separates inline <think>...</think> blocks, as emitted by
+// reasoning models such as qwen3 or deepseek-r1 served through Ollama, from
+// the answer text. It returns the reasoning and the remaining answer, both trimmed.
+func SplitReasoning(content string) (reasoning, answer string) {
+	var rb, ab strings.Builder
+	rest := content
+
+	// Some chat templates open the think block in the prompt, so the output
+	// only contains the closing tag. Everything before it is reasoning.
+	openIdx := strings.Index(rest, thinkOpenTag)
+	closeIdx := strings.Index(rest, thinkCloseTag)
+	if closeIdx >= 0 && (openIdx < 0 || closeIdx < openIdx) {
+		appendReasoning(&rb, rest[:closeIdx])
+		rest = rest[closeIdx+len(thinkCloseTag):]
+	}
+
+	for {
+		start := strings.Index(rest, thinkOpenTag)
+		if start < 0 {
+			ab.WriteString(rest)
+			break
+		}
+		ab.WriteString(rest[:start])
+		rest = rest[start+len(thinkOpenTag):]
+
+		end := strings.Index(rest, thinkCloseTag)
+		if end < 0 {
+			// Unterminated block (e.g. the response was cut off while thinking)
+			appendReasoning(&rb, rest)
+			break
+		}
+		appendReasoning(&rb, rest[:end])
+		rest = rest[end+len(thinkCloseTag):]
+	}
+
+	return strings.TrimSpace(rb.String()), strings.TrimSpace(ab.String())
+}
+
+// appendReasoning appends a trimmed reasoning block, separating multiple blocks with a newline.
+func appendReasoning(sb *strings.Builder, block string) {
+	block = strings.TrimSpace(block)
+	if block == "" {
+		return
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	sb.WriteString(block)
+}
+
+// thinkStreamSplitter incrementally separates <think> blocks from streamed content.
+// Tags may be split across chunks, so a trailing partial tag is held back until
+// the next chunk arrives. Like SplitReasoning, it takes text before a leading
+// closing tag as reasoning, so text is held back until the first tag decides
+// what it is; without any tag it is the answer, emitted by Flush.
+type thinkStreamSplitter struct {
+	inThink bool
+	decided bool   // a tag was seen, so the leading text is known
+	held    string // text before the first tag
+	pending string
+}
+
+// Feed consumes a chunk of streamed content and returns the reasoning and answer
+// text that can be emitted so far.
+func (s *thinkStreamSplitter) Feed(chunk string) (reasoning, answer string) {
+	var rb, ab strings.Builder
+	buf := s.pending + chunk
+	s.pending = ""
+
+	if !s.decided {
+		buf = s.held + buf
+		s.held = ""
+		openIdx := strings.Index(buf, thinkOpenTag)
+		closeIdx := strings.Index(buf, thinkCloseTag)
+		switch {
+		case closeIdx >= 0 && (openIdx < 0 || closeIdx < openIdx):
+			// The chat template opened the think block in the prompt
+			rb.WriteString(buf[:closeIdx])
+			buf = buf[closeIdx+len(thinkCloseTag):]
+			s.decided = true
+		case openIdx >= 0:
+			s.decided = true
+		default:
+			s.held = buf
+			return "", ""
+		}
+	}
+
+	for buf != "" {
+		tag := thinkOpenTag
+		if s.inThink {
+			tag = thinkCloseTag
+		}
+
+		if idx := strings.Index(buf, tag); idx >= 0 {
+			s.write(&rb, &ab, buf[:idx])
+			buf = buf[idx+len(tag):]
+			s.inThink = !s.inThink
+			continue
+		}
+
+		keep := partialTagSuffix(buf, tag)
+		s.write(&rb, &ab, buf[:len(buf)-keep])
+		s.pending = buf[len(buf)-keep:]
+		break
+	}
+
+	return rb.String(), ab.String()
+}
+
+// Flush returns any text still held back at the end of the stream.
+func (s *thinkStreamSplitter) Flush() (reasoning, answer string) {
+	var rb, ab strings.Builder
+	s.write(&rb, &ab, s.held+s.pending)
+	s.held, s.pending = "", ""
+	return rb.String(), ab.String()
+}
+
+func (s *thinkStreamSplitter) write(rb, ab *strings.Builder, text string) {
+	if s.inThink {
+		rb.WriteString(text)
+	} else {
+		ab.WriteString(text)
+	}
+}
+
+// partialTagSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag.
+func partialTagSuffix(s, tag string) int {
+	maxLen := len(tag) - 1
+	if len(s) < maxLen {
+		maxLen = len(s)
+	}
+	for n := maxLen; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseReasoning combines a provider's dedicated reasoning field (such as
+// DeepSeek's reasoning_content or Ollama's thinking) with any inline <think>
+// blocks found in content.
+func parseReasoning(field, content string) (reasoning, answer string) {
+	inline, answer := SplitReasoning(content)
+	field = strings.TrimSpace(field)
+	switch {
+	case field == "":
+		return inline, answer
+	case inline == "":
+		return field, answer
+	default:
+		return field + "\n" + inline, answer
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"testing"
+)
+
+func TestSplitReasoning(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		wantReasoning string
+		wantAnswer    string
+	}{
+		{name: "no think block", content: `{"commands": ["df -h"]}`, wantReasoning: "", wantAnswer: `{"commands": ["df -h"]}`},
+		{name: "leading think block", content: "<think>\nuser wants disk usage\n</think>\n\n{\"commands\": [\"df -h\"]}", wantReasoning: "user wants disk usage", wantAnswer: `{"commands": ["df -h"]}`},
+		{name: "empty think block", content: "<think>\n\n</think>\n\nhello", wantReasoning: "", wantAnswer: "hello"},
+		{name: "multiple think blocks", content: "<think>a</think>x<think>b</think>y", wantReasoning: "a\nb", wantAnswer: "xy"},
+		{name: "only closing tag", content: "template opened it</think>answer", wantReasoning: "template opened it", wantAnswer: "answer"},
+		{name: "unterminated think block", content: "<think>still thinking", wantReasoning: "still thinking", wantAnswer: ""},
+		{name: "think block with braces", content: "<think>maybe {\"x\": 1}?</think>{\"y\": 2}", wantReasoning: "maybe {\"x\": 1}?", wantAnswer: "{\"y\": 2}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasoning, answer := SplitReasoning(tt.content)
+			if reasoning != tt.wantReasoning {
+				t.Errorf("SplitReasoning(%q) reasoning = %q, want %q", tt.content, reasoning, tt.wantReasoning)
+			}
+			if answer != tt.wantAnswer {
+				t.Errorf("SplitReasoning(%q) answer = %q, want %q", tt.content, answer, tt.wantAnswer)
+			}
+		})
+	}
+}
+
+func TestThinkStreamSplitter(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		wantReasoning string
+		wantAnswer    string
+	}{
+		{name: "no tags", chunks: []string{"hel", "lo"}, wantReasoning: "", wantAnswer: "hello"},
+		{name: "tags in single chunks", chunks: []string{"<think>", "plan", "</think>", "answer"}, wantReasoning: "plan", wantAnswer: "answer"},
+		{name: "tags split across chunks", chunks: []string{"<th", "ink>pl", "an</thi", "nk>ans", "wer"}, wantReasoning: "plan", wantAnswer: "answer"},
+		{name: "angle bracket that is not a tag", chunks: []string{"a <", "b"}, wantReasoning: "", wantAnswer: "a <b"},
+		{name: "partial tag at end of stream", chunks: []string{"done </thi"}, wantReasoning: "", wantAnswer: "done </thi"},
+		{name: "only closing tag", chunks: []string{"pl", "an</thi", "nk>ans", "wer"}, wantReasoning: "plan", wantAnswer: "answer"},
+		{name: "closing tag before a later block", chunks: []string{"a</think>b<think>c", "</think>d"}, wantReasoning: "ac", wantAnswer: "bd"},
+		{name: "answer before a block", chunks: []string{"x", "<think>y</think>z"}, wantReasoning: "y", wantAnswer: "xz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &thinkStreamSplitter{}
+			var gotReasoning, gotAnswer string
+			for _, chunk := range tt.chunks {
+				r, a := s.Feed(chunk)
+				gotReasoning += r
+				gotAnswer += a
+			}
+			r, a := s.Flush()
+			gotReasoning += r
+			gotAnswer += a
+
+			if gotReasoning != tt.wantReasoning {
+				t.Errorf("reasoning = %q, want %q", gotReasoning, tt.wantReasoning)
+			}
+			if gotAnswer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", gotAnswer, tt.wantAnswer)
+			}
+		})
+	}
+}
+
+func TestParseReasoning(t *testing.T) {
+	tests := []struct {
+		name          string
+		field         string
+		content       string
+		wantReasoning string
+		wantAnswer    string
+	}{
+		{name: "field only", field: "deep thoughts", content: "answer", wantReasoning: "deep thoughts", wantAnswer: "answer"},
+		{name: "inline only", field: "", content: "<think>inline</think>answer", wantReasoning: "inline", wantAnswer: "answer"},
+		{name: "both", field: "field", content: "<think>inline</think>answer", wantReasoning: "field\ninline", wantAnswer: "answer"},
+		{name: "neither", field: "", content: "answer", wantReasoning: "", wantAnswer: "answer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasoning, answer := parseReasoning(tt.field, tt.content)
+			if reasoning != tt.wantReasoning {
+				t.Errorf("parseReasoning() reasoning = %q, want %q", reasoning, tt.wantReasoning)
+			}
+			if answer != tt.wantAnswer {
+				t.Errorf("parseReasoning() answer = %q, want %q", answer, tt.wantAnswer)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/warm3snow/sherlock/internal/config"
+)
+
+// TaskType identifies the kind of work a model request does.
+type TaskType string
+
+const (
+	// TaskConnection parses a connection request.
+	TaskConnection TaskType = "connection"
+	// TaskCommand translates a request into shell commands.
+	TaskCommand TaskType = "command"
+	// TaskExplain explains command output.
+	TaskExplain TaskType = "explain"
+	// TaskDiagnose investigates a problem, usually in several steps.
+	TaskDiagnose TaskType = "diagnose"
+)
+
+// defaultComplexTokens is the request length above which a request is complex.
+const defaultComplexTokens = 60
+
+// multiStepRe matches requests that ask for several steps.
+var multiStepRe = regexp.MustCompile(`(?i)\b(?:then|after that|afterwards|step by step|and also)\b|然后|之后|接着`)
+
+// Route describes a model request for routing.
+type Route struct {
+	// Task is the kind of work the request does.
+	Task TaskType
+	// Request is the user's request, used to estimate its complexity.
+	Request string
+	// HostTags are the tags of the host whose data the request contains.
+	HostTags []string
+}
+
+// Selection is the model profile chosen for a request.
+type Selection struct {
+	// Profile is the profile name.
+	Profile string
+	// Config is the profile's LLM configuration.
+	Config *config.LLMConfig
+	// Client talks to the profile's model.
+	Client ModelClient
+	// Reason says why the profile was chosen.
+	Reason string
+}
+
+// String returns the profile, model and reason, e.g. "big (deepseek-chat, complex request)".
+func (s *Selection) String() string {
+	return fmt.Sprintf("%s (%s, %s)", s.Profile, s.Config.Model, s.Reason)
+}
+
+// profileKey is the context key of a per-request profile override.
+type profileKey struct{}
+
+// WithProfile returns a context that routes requests to the named profile.
+func WithProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, profileKey{}, name)
+}
+
+// profileFromContext returns the profile override of the context, if any.
+func profileFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(profileKey{}).(string)
+	return name
+}
+
+// Router picks a model profile for each request according to the routing policy.
+type Router struct {
+	cfg       *config.Config
+	newClient func(ctx context.Context, cfg *config.LLMConfig, opts ...ClientOption) (ModelClient, error)
+	opts      []ClientOption
+
+	mu      sync.Mutex
+	clients map[string]ModelClient
+}
+
+// NewRouter creates a router for the model profiles and routing policy of cfg.
+// The client of the default profile is reused; other clients are created on
+// first use with opts.
+func NewRouter(cfg *config.Config, defaultClient ModelClient, opts ...ClientOption) *Router {
+	return &Router{
+		cfg:       cfg,
+		newClient: NewClient,
+		opts:      opts,
+		clients:   map[string]ModelClient{config.DefaultProfile: defaultClient},
+	}
+}
+
+// Profiles returns the profile names, default first.
+func (r *Router) Profiles() []string {
+	names := make([]string, 0, len(r.cfg.Models))
+	for name := range r.cfg.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{config.DefaultProfile}, names...)
+}
+
+// HasProfile reports whether a profile with the given name exists.
+func (r *Router) HasProfile(name string) bool {
+	_, ok := r.cfg.Profile(name)
+	return ok
+}
+
+// Select picks the profile for a request. A profile override in ctx takes
+// precedence over the policy, but data of local-only hosts is never sent to a
+// non-local model.
+func (r *Router) Select(ctx context.Context, route Route) (*Selection, error) {
+	policy := r.cfg.Routing
+	name, reason := config.DefaultProfile, "default"
+
+	if override := profileFromContext(ctx); override != "" {
+		if !r.HasProfile(override) {
+			return nil, fmt.Errorf("unknown model profile: %s", override)
+		}
+		name, reason = override, "requested"
+	} else {
+		if profile := policy.Tasks[string(route.Task)]; profile != "" {
+			name, reason = profile, string(route.Task)+" task"
+		}
+		if policy.ComplexProfile != "" && isComplex(route.Request, policy.ComplexTokens) {
+			name, reason = policy.ComplexProfile, "complex request"
+		}
+	}
+
+	profile, ok := r.cfg.Profile(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown model profile: %s", name)
+	}
+
+	if tag := localOnlyTag(route.HostTags, policy.LocalOnlyTags); tag != "" && !profile.IsLocal() {
+		if reason == "requested" {
+			return nil, fmt.Errorf("model profile %s is not local; data of %s hosts stays on local models", name, tag)
+		}
+		name = policy.LocalProfile
+		if name == "" {
+			name = config.DefaultProfile
+		}
+		if profile, ok = r.cfg.Profile(name); !ok || !profile.IsLocal() {
+			return nil, fmt.Errorf("no local model profile for %s hosts; set routing.local_profile", tag)
+		}
+		reason = tag + " host"
+	}
+
+	client, err := r.client(ctx, name, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Selection{Profile: name, Config: profile, Client: client, Reason: reason}, nil
+}
+
+// client returns the client of a profile, creating it on first use.
+func (r *Router) client(ctx context.Context, name string, profile *config.LLMConfig) (ModelClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if client, ok := r.clients[name]; ok {
+		return client, nil
+	}
+	client, err := r.newClient(ctx, profile, r.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for model profile %s: %w", name, err)
+	}
+	r.clients[name] = client
+	return client, nil
+}
+
+// Close closes the clients created by the router. The default client is left
+// to its owner.
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, client := range r.clients {
+		if name != config.DefaultProfile {
+			_ = client.Close()
+		}
+	}
+	return nil
+}
+
+// SplitProfileOverride splits a leading "@profile" from input if profile is
+// a known profile name.
+func (r *Router) SplitProfileOverride(input string) (profile, rest string, ok bool) {
+	if !strings.HasPrefix(input, "@") {
+		return "", input, false
+	}
+	name, rest, _ := strings.Cut(input[1:], " ")
+	if !r.HasProfile(name) {
+		return "", input, false
+	}
+	return name, strings.TrimSpace(rest), true
+}
+
+// isComplex reports whether a request is long or asks for several steps.
+func isComplex(request string, threshold int) bool {
+	if threshold <= 0 {
+		threshold = defaultComplexTokens
+	}
+	return EstimateTokens(request) > threshold || multiStepRe.MatchString(request)
+}
+
+// localOnlyTag returns the first host tag that is local-only, or "".
+func localOnlyTag(hostTags, localOnlyTags []string) string {
+	for _, tag := range hostTags {
+		for _, localOnly := range localOnlyTags {
+			if strings.EqualFold(tag, localOnly) {
+				return tag
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/warm3snow/sherlock/internal/config"
+)
+
+func newTestRouter(cfg *config.Config) *Router {
+	r := NewRouter(cfg, &summarizingClient{})
+	r.newClient = func(_ context.Context, _ *config.LLMConfig, _ ...ClientOption) (ModelClient, error) {
+		return &summarizingClient{}, nil
+	}
+	return r
+}
+
+func routingTestConfig() *config.Config {
+	return &config.Config{
+		LLM: config.LLMConfig{Provider: config.ProviderOllama, BaseURL: "http://localhost:11434", Model: "qwen2.5:7b"},
+		Models: map[string]config.LLMConfig{
+			"big":     {Provider: config.ProviderDeepSeek, APIKey: "sk-test", Model: "deepseek-chat"},
+			"onprem":  {Provider: config.ProviderOpenAI, APIKey: "x", BaseURL: "http://llm.internal/v1", Model: "qwen2.5:72b", Local: true},
+			"explain": {Provider: config.ProviderOpenAI, APIKey: "sk-test", Model: "gpt-4o-mini"},
+		},
+		Routing: config.RoutingConfig{
+			Tasks:          map[string]string{"explain": "explain", "diagnose": "big"},
+			ComplexProfile: "big",
+			LocalOnlyTags:  []string{"production"},
+			LocalProfile:   "onprem",
+		},
+	}
+}
+
+func TestRouterSelect(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       Route
+		override    string
+		wantProfile string
+		wantReason  string
+	}{
+		{name: "simple command", route: Route{Task: TaskCommand, Request: "show memory"}, wantProfile: "default", wantReason: "default"},
+		{name: "task mapping", route: Route{Task: TaskExplain, Request: "df -h"}, wantProfile: "explain", wantReason: "explain task"},
+		{name: "diagnosis", route: Route{Task: TaskDiagnose, Request: "why is nginx down"}, wantProfile: "big", wantReason: "diagnose task"},
+		{name: "multi-step request", route: Route{Task: TaskCommand, Request: "stop nginx then clear its cache"}, wantProfile: "big", wantReason: "complex request"},
+		{name: "long request", route: Route{Task: TaskCommand, Request: strings.Repeat("find the files ", 30)}, wantProfile: "big", wantReason: "complex request"},
+		{name: "override", route: Route{Task: TaskCommand, Request: "show memory"}, override: "big", wantProfile: "big", wantReason: "requested"},
+		{name: "production host stays local", route: Route{Task: TaskDiagnose, Request: "why is nginx down", HostTags: []string{"Production"}}, wantProfile: "onprem", wantReason: "Production host"},
+		{name: "production host with local profile", route: Route{Task: TaskCommand, Request: "show memory", HostTags: []string{"production"}}, wantProfile: "default", wantReason: "default"},
+		{name: "other tags are not restricted", route: Route{Task: TaskDiagnose, Request: "why", HostTags: []string{"staging"}}, wantProfile: "big", wantReason: "diagnose task"},
+	}
+
+	r := newTestRouter(routingTestConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.override != "" {
+				ctx = WithProfile(ctx, tt.override)
+			}
+			sel, err := r.Select(ctx, tt.route)
+			if err != nil {
+				t.Fatalf("Select() error = %v", err)
+			}
+			if sel.Profile != tt.wantProfile || sel.Reason != tt.wantReason {
+				t.Errorf("Select() = %s/%s, want %s/%s", sel.Profile, sel.Reason, tt.wantProfile, tt.wantReason)
+			}
+			if sel.Client == nil {
+				t.Errorf("Select() returned no client")
+			}
+		})
+	}
+}
+
+func TestRouterSelectErrors(t *testing.T) {
+	r := newTestRouter(routingTestConfig())
+
+	// An explicit cloud override for a production host is refused
+	ctx := WithProfile(context.Background(), "big")
+	if _, err := r.Select(ctx, Route{Task: TaskCommand, HostTags: []string{"production"}}); err == nil {
+		t.Errorf("Select() should refuse a cloud profile for a production host")
+	}
+
+	if _, err := r.Select(WithProfile(context.Background(), "nope"), Route{Task: TaskCommand}); err == nil {
+		t.Errorf("Select() should fail for an unknown profile")
+	}
+
+	// Without any local profile, production data has nowhere to go
+	cfg := routingTestConfig()
+	cfg.LLM = config.LLMConfig{Provider: config.ProviderOpenAI, APIKey: "sk-test", Model: "gpt-4o"}
+	cfg.Routing.LocalProfile = ""
+	r = newTestRouter(cfg)
+	if _, err := r.Select(context.Background(), Route{Task: TaskCommand, HostTags: []string{"production"}}); err == nil {
+		t.Errorf("Select() should fail without a local profile")
+	}
+}
+
+func TestRouterReusesClients(t *testing.T) {
+	r := newTestRouter(routingTestConfig())
+	ctx := WithProfile(context.Background(), "big")
+
+	first, err := r.Select(ctx, Route{Task: TaskCommand})
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	second, err := r.Select(ctx, Route{Task: TaskCommand})
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	if first.Client != second.Client {
+		t.Errorf("Select() should reuse the client of a profile")
+	}
+}
+
+func TestSplitProfileOverride(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantProfile string
+		wantRest    string
+		wantOK      bool
+	}{
+		{input: "@big why is nginx down", wantProfile: "big", wantRest: "why is nginx down", wantOK: true},
+		{input: "@default show memory", wantProfile: "default", wantRest: "show memory", wantOK: true},
+		{input: "@web-1 uptime", wantRest: "@web-1 uptime"},
+		{input: "show memory", wantRest: "show memory"},
+	}
+
+	r := newTestRouter(routingTestConfig())
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			profile, rest, ok := r.SplitProfileOverride(tt.input)
+			if profile != tt.wantProfile || rest != tt.wantRest || ok != tt.wantOK {
+				t.Errorf("SplitProfileOverride(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.input, profile, rest, ok, tt.wantProfile, tt.wantRest, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRouterProfiles(t *testing.T) {
+	got := strings.Join(newTestRouter(routingTestConfig()).Profiles(), ",")
+	if want := "default,big,explain,onprem"; got != want {
+		t.Errorf("Profiles() = %s, want %s", got, want)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 )
 
@@ -85,6 +86,87 @@ type LLMConfig struct {
 	Model string `json:"model"`
 	// Temperature controls randomness in generation.
 	Temperature float32 `json:"temperature,omitempty"`
+	// ContextWindow overrides the model's context size in tokens. When zero,
+	// it is looked up from the built-in model table.
+	ContextWindow int `json:"context_window,omitempty"`
+	// Local marks the model as running on infrastructure you control, so
+	// data from local-only hosts may be sent to it. Ollama models are always local.
+	Local bool `json:"local,omitempty"`
+}
+
+// IsLocal reports whether data sent to this model stays on infrastructure you control.
+func (l *LLMConfig) IsLocal() bool {
+	return l.Local || l.Provider == ProviderOllama
+}
+
+// Validate validates the LLM configuration.
+func (l *LLMConfig) Validate() error {
+	if l.Provider == "" {
+		return errors.New("LLM provider is required")
+	}
+	if l.Model == "" {
+		return errors.New("LLM model is required")
+	}
+	switch l.Provider {
+	case ProviderOpenAI, ProviderDeepSeek:
+		if l.APIKey == "" {
+			return fmt.Errorf("API key is required for provider %s", l.Provider)
+		}
+	case ProviderOllama:
+		if l.BaseURL == "" {
+			return errors.New("base URL is required for Ollama provider")
+		}
+	default:
+		return fmt.Errorf("unsupported LLM provider: %s", l.Provider)
+	}
+	return nil
+}
+
+// DefaultProfile is the name of the model profile defined by the top-level llm settings.
+const DefaultProfile = "default"
+
+// RoutingConfig holds the policy that picks a model profile for each request.
+type RoutingConfig struct {
+	// Tasks maps a task type (connection, command, explain, diagnose) to a model profile.
+	Tasks map[string]string `json:"tasks,omitempty"`
+	// ComplexProfile is used for long or multi-step requests.
+	ComplexProfile string `json:"complex_profile,omitempty"`
+	// ComplexTokens is the request length in tokens above which a request is
+	// considered complex. Defaults to 60.
+	ComplexTokens int `json:"complex_tokens,omitempty"`
+	// LocalOnlyTags lists host tags whose data must only be sent to local models.
+	LocalOnlyTags []string `json:"local_only_tags,omitempty"`
+	// LocalProfile replaces a non-local profile for local-only hosts. Defaults
+	// to the default profile if that is local.
+	LocalProfile string `json:"local_profile,omitempty"`
+}
+
+// HostConfig holds settings for the hosts matching a pattern.
+type HostConfig struct {
+	// Pattern is a host name or glob pattern, e.g. "*.prod.example.com".
+	Pattern string `json:"pattern"`
+	// Tags label the matching hosts, e.g. "production".
+	Tags []string `json:"tags,omitempty"`
+	// ForwardAgent forwards the local SSH agent to the matching hosts. Unset
+	// leaves it to ForwardAgent in ~/.ssh/config.
+	ForwardAgent *bool `json:"forward_agent,omitempty"`
+	// Become says how privileged commands are run on the matching hosts.
+	Become *BecomeConfig `json:"become,omitempty"`
+}
+
+// Become methods.
+const (
+	BecomeSudo = "sudo"
+	BecomeSu   = "su"
+	BecomeDoas = "doas"
+)
+
+// BecomeConfig says how commands are run as another user.
+type BecomeConfig struct {
+	// Method is sudo, su or doas. Empty means sudo.
+	Method string `json:"method,omitempty"`
+	// User is the user to become. Empty means root.
+	User string `json:"user,omitempty"`
 }
 
 // SSHKeyConfig holds SSH key configuration.
@@ -97,6 +179,56 @@ type SSHKeyConfig struct {
 	AutoAddToRemote bool `json:"auto_add_to_remote"`
 }
 
+// SSHConfig holds SSH session settings.
+type SSHConfig struct {
+	// PersistentShell runs commands in one long-lived shell per connection, so
+	// that exported variables, aliases, umask and limits persist between commands.
+	PersistentShell bool `json:"persistent_shell,omitempty"`
+	// KeepAliveInterval is the interval in seconds of keepalive requests that
+	// detect dropped connections. Zero uses ServerAliveInterval from
+	// ~/.ssh/config, or 30 seconds; a negative value disables keepalives.
+	KeepAliveInterval int `json:"keepalive_interval,omitempty"`
+	// NoAutoReconnect disables restoring a dropped connection on the next command.
+	NoAutoReconnect bool `json:"no_auto_reconnect,omitempty"`
+	// SudoPasswordTimeout is the time in seconds a sudo password is kept in
+	// memory after it was asked for. Zero keeps it for 5 minutes; a negative
+	// value asks for every command.
+	SudoPasswordTimeout int `json:"sudo_password_timeout,omitempty"`
+}
+
+// OutputConfig holds settings for command output.
+type OutputConfig struct {
+	// MaxKeptBytes is how much of the stdout and of the stderr of a command is
+	// kept in memory for explain and follow-up requests; the middle of longer
+	// output is dropped. Output is always shown in full as it arrives. Zero
+	// keeps 1 MiB.
+	MaxKeptBytes int `json:"max_kept_bytes,omitempty"`
+}
+
+// RecordingConfig holds settings for recording sessions.
+type RecordingConfig struct {
+	// Enabled records every session: requests, commands and their output in
+	// a JSONL transcript, and interactive commands as asciicast v2 files.
+	// "record on" starts recording for one session.
+	Enabled bool `json:"enabled,omitempty"`
+	// Dir is where recordings are kept. Empty means
+	// ~/.config/sherlock/recordings.
+	Dir string `json:"dir,omitempty"`
+}
+
+// ExecutionConfig holds settings for running commands.
+type ExecutionConfig struct {
+	// Timeout is the time in seconds after which a running command is
+	// stopped. Zero means no limit. "timeout <duration>" before a request
+	// sets a limit for that request.
+	Timeout int `json:"timeout,omitempty"`
+	// Parallel is how many hosts run-on works on at once. Zero means 10.
+	Parallel int `json:"parallel,omitempty"`
+	// HostTimeout is the time in seconds run-on allows each host to connect
+	// and run the commands. Zero means 5 minutes.
+	HostTimeout int `json:"host_timeout,omitempty"`
+}
+
 // ShellCommandsConfig holds the shell commands whitelist configuration.
 type ShellCommandsConfig struct {
 	// Whitelist contains custom shell commands that can be executed directly without LLM translation.
@@ -119,6 +251,8 @@ const (
 type UIConfig struct {
 	// Theme specifies the UI color theme (default, dracula, solarized).
 	Theme ThemeType `json:"theme,omitempty"`
+	// ShowReasoning displays the thinking process of reasoning models before their answer.
+	ShowReasoning bool `json:"show_reasoning,omitempty"`
 }
 
 // IsValidTheme checks if a theme name is valid.
@@ -137,10 +271,79 @@ type Config struct {
 	LLM LLMConfig `json:"llm"`
 	// SSHKey holds the SSH key configuration.
 	SSHKey SSHKeyConfig `json:"ssh_key"`
+	// SSH holds the SSH session settings.
+	SSH SSHConfig `json:"ssh,omitempty"`
 	// ShellCommands holds the shell commands whitelist configuration.
 	ShellCommands ShellCommandsConfig `json:"shell_commands,omitempty"`
+	// Output holds the command output settings.
+	Output OutputConfig `json:"output,omitempty"`
+	// Execution holds the command execution settings.
+	Execution ExecutionConfig `json:"execution,omitempty"`
+	// Recording holds the session recording settings.
+	Recording RecordingConfig `json:"recording,omitempty"`
 	// UI holds the UI configuration.
 	UI UIConfig `json:"ui,omitempty"`
+	// Models holds additional named model profiles. The top-level LLM settings
+	// are the "default" profile.
+	Models map[string]LLMConfig `json:"models,omitempty"`
+	// Routing selects a model profile for each request.
+	Routing RoutingConfig `json:"routing,omitempty"`
+	// Hosts holds per-host settings.
+	Hosts []HostConfig `json:"hosts,omitempty"`
+	// Groups names lists of hosts for run-on. Members are [user@]host[:port]
+	// or glob patterns matched against the hosts in the history.
+	Groups map[string][]string `json:"groups,omitempty"`
+}
+
+// Profile returns the model profile with the given name.
+func (c *Config) Profile(name string) (*LLMConfig, bool) {
+	if name == DefaultProfile {
+		return &c.LLM, true
+	}
+	profile, ok := c.Models[name]
+	if !ok {
+		return nil, false
+	}
+	return &profile, true
+}
+
+// HostTags returns the tags of all host entries matching host.
+func (c *Config) HostTags(host string) []string {
+	var tags []string
+	for _, h := range c.Hosts {
+		if matched, _ := path.Match(h.Pattern, host); matched {
+			tags = append(tags, h.Tags...)
+		}
+	}
+	return tags
+}
+
+// HostBecome returns how privileged commands are run on host, from the
+// first matching host entry that sets it, or nil if none does.
+func (c *Config) HostBecome(host string) *BecomeConfig {
+	for _, h := range c.Hosts {
+		if h.Become == nil {
+			continue
+		}
+		if matched, _ := path.Match(h.Pattern, host); matched {
+			return h.Become
+		}
+	}
+	return nil
+}
+
+// HostForwardAgent returns whether to forward the SSH agent to host, from
+// the first matching host entry that sets it, or nil if none does.
+func (c *Config) HostForwardAgent(host string) *bool {
+	for _, h := range c.Hosts {
+		if h.ForwardAgent == nil {
+			continue
+		}
+		if matched, _ := path.Match(h.Pattern, host); matched {
+			return h.ForwardAgent
+		}
+	}
+	return nil
 }
 
 // DefaultConfig returns a default configuration.
@@ -174,23 +377,54 @@ func DefaultConfig() *Config {
 
 // Validate validates the configuration.
 func (c *Config) Validate() error {
-	if c.LLM.Provider == "" {
-		return errors.New("LLM provider is required")
+	if err := c.LLM.Validate(); err != nil {
+		return err
 	}
-	if c.LLM.Model == "" {
-		return errors.New("LLM model is required")
+
+	// Validate model profiles and the routing policy
+	for name, profile := range c.Models {
+		if name == DefaultProfile {
+			return fmt.Errorf("model profile name %q is reserved for the llm settings", name)
+		}
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("model profile %q: %w", name, err)
+		}
 	}
-	switch c.LLM.Provider {
-	case ProviderOpenAI, ProviderDeepSeek:
-		if c.LLM.APIKey == "" {
-			return fmt.Errorf("API key is required for provider %s", c.LLM.Provider)
+	routed := []string{c.Routing.ComplexProfile, c.Routing.LocalProfile}
+	for _, name := range c.Routing.Tasks {
+		routed = append(routed, name)
+	}
+	for _, name := range routed {
+		if _, ok := c.Profile(name); name != "" && !ok {
+			return fmt.Errorf("routing refers to unknown model profile: %s", name)
 		}
-	case ProviderOllama:
-		if c.LLM.BaseURL == "" {
-			return errors.New("base URL is required for Ollama provider")
+	}
+
+	if c.Output.MaxKeptBytes < 0 {
+		return fmt.Errorf("output.max_kept_bytes must not be negative: %d", c.Output.MaxKeptBytes)
+	}
+
+	if c.Execution.Timeout < 0 {
+		return fmt.Errorf("execution.timeout must not be negative: %d", c.Execution.Timeout)
+	}
+
+	for _, h := range c.Hosts {
+		if h.Become == nil {
+			continue
 		}
-	default:
-		return fmt.Errorf("unsupported LLM provider: %s", c.LLM.Provider)
+		switch h.Become.Method {
+		case "", BecomeSudo, BecomeSu, BecomeDoas:
+		default:
+			return fmt.Errorf("unsupported become method for %s: %s (valid: sudo, su, doas)", h.Pattern, h.Become.Method)
+		}
+	}
+
+	if c.Execution.Parallel < 0 {
+		return fmt.Errorf("execution.parallel must not be negative: %d", c.Execution.Parallel)
+	}
+
+	if c.Execution.HostTimeout < 0 {
+		return fmt.Errorf("execution.host_timeout must not be negative: %d", c.Execution.HostTimeout)
 	}
 
 	// Validate theme if specified
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -504,3 +505,154 @@ func TestDefaultConfig_HasDraculaTheme(t *testing.T) {
 		t.Errorf("Expected default theme to be dracula, got %s", cfg.UI.Theme)
 	}
 }
+
+func TestValidate_ModelProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		models  map[string]LLMConfig
+		routing RoutingConfig
+		wantErr string
+	}{
+		{
+			name:   "valid profiles and routing",
+			models: map[string]LLMConfig{"big": {Provider: ProviderDeepSeek, APIKey: "sk-test", Model: "deepseek-chat"}},
+			routing: RoutingConfig{
+				Tasks:          map[string]string{"diagnose": "big", "command": DefaultProfile},
+				ComplexProfile: "big",
+			},
+		},
+		{
+			name:    "profile without API key",
+			models:  map[string]LLMConfig{"big": {Provider: ProviderOpenAI, Model: "gpt-4o"}},
+			wantErr: `model profile "big"`,
+		},
+		{
+			name:    "reserved profile name",
+			models:  map[string]LLMConfig{DefaultProfile: {Provider: ProviderOllama, BaseURL: "http://localhost:11434", Model: "x"}},
+			wantErr: "reserved",
+		},
+		{
+			name:    "routing to unknown profile",
+			routing: RoutingConfig{ComplexProfile: "huge"},
+			wantErr: "unknown model profile: huge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Models = tt.models
+			cfg.Routing = tt.routing
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_OutputLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Output.MaxKeptBytes = 4096
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	cfg.Output.MaxKeptBytes = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "max_kept_bytes") {
+		t.Errorf("Validate() error = %v, want it to mention max_kept_bytes", err)
+	}
+}
+
+func TestHostTags(t *testing.T) {
+	cfg := &Config{
+		Hosts: []HostConfig{
+			{Pattern: "*.prod.example.com", Tags: []string{"production"}},
+			{Pattern: "db1.prod.example.com", Tags: []string{"database"}},
+			{Pattern: "10.0.1.*", Tags: []string{"staging"}},
+		},
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "db1.prod.example.com", want: "production,database"},
+		{host: "web.prod.example.com", want: "production"},
+		{host: "10.0.1.7", want: "staging"},
+		{host: "example.com", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := strings.Join(cfg.HostTags(tt.host), ","); got != tt.want {
+				t.Errorf("HostTags(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostForwardAgent(t *testing.T) {
+	yes, no := true, false
+	cfg := &Config{
+		Hosts: []HostConfig{
+			{Pattern: "bastion.example.com", ForwardAgent: &yes},
+			{Pattern: "*.prod.example.com", Tags: []string{"production"}},
+			{Pattern: "*.prod.example.com", ForwardAgent: &no},
+			{Pattern: "*.example.com", ForwardAgent: &yes},
+		},
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "bastion.example.com", want: "true"},
+		{host: "db1.prod.example.com", want: "false"},
+		{host: "build.example.com", want: "true"},
+		{host: "example.org", want: "unset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := "unset"
+			if forward := cfg.HostForwardAgent(tt.host); forward != nil {
+				got = strconv.FormatBool(*forward)
+			}
+			if got != tt.want {
+				t.Errorf("HostForwardAgent(%q) = %s, want %s", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostBecome(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hosts = []HostConfig{
+		{Pattern: "*.bsd.example.com", Become: &BecomeConfig{Method: BecomeDoas}},
+		{Pattern: "app*", Become: &BecomeConfig{User: "deploy"}},
+	}
+
+	if got := cfg.HostBecome("fw.bsd.example.com"); got == nil || got.Method != BecomeDoas {
+		t.Errorf("HostBecome(fw.bsd.example.com) = %+v, want doas", got)
+	}
+	if got := cfg.HostBecome("app1"); got == nil || got.User != "deploy" {
+		t.Errorf("HostBecome(app1) = %+v, want user deploy", got)
+	}
+	if got := cfg.HostBecome("db1"); got != nil {
+		t.Errorf("HostBecome(db1) = %+v, want nil", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	cfg.Hosts[0].Become.Method = "pbrun"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "become") {
+		t.Errorf("Validate() error = %v, want an unsupported become method", err)
+	}
+}
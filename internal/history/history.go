@@ -101,6 +101,16 @@ func (m *Manager) initDB() error {
 	CREATE INDEX IF NOT EXISTS idx_hosts_timestamp ON hosts(timestamp DESC);
 	CREATE INDEX IF NOT EXISTS idx_hosts_host ON hosts(host);
 	CREATE INDEX IF NOT EXISTS idx_hosts_user ON hosts(user);
+	CREATE TABLE IF NOT EXISTS runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		request TEXT NOT NULL,
+		status TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		report TEXT NOT NULL,
+		timestamp DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_runs_timestamp ON runs(timestamp DESC);
 	`
 
 	_, err = db.Exec(createTableSQL)
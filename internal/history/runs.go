@@ -0,0 +1,93 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RunRecord is the saved report of a run across many hosts.
+type RunRecord struct {
+	// ID is the unique identifier.
+	ID int64
+	// Kind is the kind of run, e.g. "roll".
+	Kind string
+	// Request is the request as the user gave it.
+	Request string
+	// Status is how the run ended, e.g. "completed" or "aborted".
+	Status string
+	// Summary is a one-line summary, e.g. "18 ok, 2 failed".
+	Summary string
+	// Report is the full report as JSON.
+	Report string
+	// Timestamp is when the run ended.
+	Timestamp time.Time
+}
+
+// AddRun saves the report of a run and returns its ID.
+func (m *Manager) AddRun(run *RunRecord) (int64, error) {
+	insertSQL := `
+	INSERT INTO runs (kind, request, status, summary, report, timestamp)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	result, err := m.db.Exec(insertSQL, run.Kind, run.Request, run.Status, run.Summary, run.Report, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to save run: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// GetRuns returns the saved runs without their reports, newest first.
+func (m *Manager) GetRuns() []RunRecord {
+	query := `SELECT id, kind, request, status, summary, timestamp FROM runs ORDER BY timestamp DESC`
+	rows, err := m.db.Query(query)
+	if err != nil {
+		return []RunRecord{}
+	}
+	defer rows.Close()
+
+	var runs []RunRecord
+	for rows.Next() {
+		var r RunRecord
+		var timestamp string
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Request, &r.Status, &r.Summary, &timestamp); err != nil {
+			continue
+		}
+		r.Timestamp = parseTimestamp(timestamp)
+		runs = append(runs, r)
+	}
+	return runs
+}
+
+// GetRunByID returns a saved run with its report.
+func (m *Manager) GetRunByID(id int64) (*RunRecord, error) {
+	query := `SELECT id, kind, request, status, summary, report, timestamp FROM runs WHERE id = ?`
+	row := m.db.QueryRow(query, id)
+
+	var r RunRecord
+	var timestamp string
+	err := row.Scan(&r.ID, &r.Kind, &r.Request, &r.Status, &r.Summary, &r.Report, &timestamp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("run not found")
+		}
+		return nil, err
+	}
+
+	r.Timestamp = parseTimestamp(timestamp)
+	return &r, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Markdown renders a transcript for reading and sharing: each request with
+// the commands generated for it, and each command with its output.
+func Markdown(entries []Entry) string {
+	var sb strings.Builder
+	sb.WriteString("# Sherlock session\n\n")
+	if len(entries) == 0 {
+		sb.WriteString("Nothing was recorded.\n")
+		return sb.String()
+	}
+	first, last := entries[0], entries[len(entries)-1]
+	fmt.Fprintf(&sb, "- Recorded by: %s\n", first.User)
+	fmt.Fprintf(&sb, "- Started: %s\n", first.Time.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- Ended: %s\n", last.Time.Format(time.RFC3339))
+	if hosts := entryHosts(entries); len(hosts) > 0 {
+		fmt.Fprintf(&sb, "- Hosts: %s\n", strings.Join(hosts, ", "))
+	}
+
+	for _, e := range entries {
+		sb.WriteString("\n")
+		switch e.Kind {
+		case KindRequest:
+			fmt.Fprintf(&sb, "## %s on %s\n\n", e.Time.Format("15:04:05"), e.Host)
+			fmt.Fprintf(&sb, "> %s\n\n", strings.ReplaceAll(e.Request, "\n", "\n> "))
+			if e.Description != "" {
+				sb.WriteString(e.Description + "\n\n")
+			}
+			sb.WriteString(codeBlock("sh", strings.Join(e.Commands, "\n")))
+		case KindCommand:
+			fmt.Fprintf(&sb, "### `$ %s`\n\n", inlineCode(e.Command))
+			fmt.Fprintf(&sb, "%s on %s, %s\n\n", e.Time.Format("15:04:05"), e.Host, outcome(e))
+			if e.Stdout != "" {
+				sb.WriteString(codeBlock("", e.Stdout))
+			}
+			if e.Stderr != "" {
+				sb.WriteString("stderr:\n\n")
+				sb.WriteString(codeBlock("", e.Stderr))
+			}
+			if e.Truncated {
+				sb.WriteString("_The middle of long output was not kept._\n")
+			}
+		case KindInteractive:
+			fmt.Fprintf(&sb, "### `$ %s` (interactive)\n\n", inlineCode(e.Command))
+			fmt.Fprintf(&sb, "%s on %s, recorded in `%s`; play it with `replay %s`.\n", e.Time.Format("15:04:05"), e.Host, e.Cast, e.Cast)
+		case KindEdit:
+			fmt.Fprintf(&sb, "### `edit %s`\n\n", inlineCode(e.Path))
+			fmt.Fprintf(&sb, "%s on %s, backup in `%s`\n\n", e.Time.Format("15:04:05"), e.Host, e.Backup)
+			sb.WriteString(codeBlock("diff", e.Diff))
+		}
+	}
+	return sb.String()
+}
+
+// entryHosts returns the hosts of the entries, in the order they appear.
+func entryHosts(entries []Entry) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.Host != "" && !seen[e.Host] {
+			seen[e.Host] = true
+			hosts = append(hosts, e.Host)
+		}
+	}
+	return hosts
+}
+
+// outcome describes how a command ended.
+func outcome(e Entry) string {
+	var parts []string
+	if e.Error != "" {
+		parts = append(parts, "error: "+e.Error)
+	} else if e.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit code %d", *e.ExitCode))
+	}
+	if e.DurationMS > 0 {
+		parts = append(parts, (time.Duration(e.DurationMS) * time.Millisecond).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// codeBlock fences text, with a fence longer than any run of backquotes in
+// it.
+func codeBlock(lang, text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return fence + lang + "\n" + text + fence + "\n"
+}
+
+// inlineCode makes text safe inside a backquoted heading.
+func inlineCode(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "`", "'"), "\n", " ")
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recording keeps a record of a Sherlock session: a JSONL
+// transcript of requests and commands, and asciicast v2 recordings of
+// interactive commands next to it.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warm3snow/sherlock/pkg/sshclient"
+)
+
+// Kinds of transcript entries.
+const (
+	KindRequest     = "request"     // a request and the commands generated for it
+	KindCommand     = "command"     // a command and its output
+	KindInteractive = "interactive" // an interactive command, recorded in Cast
+	KindEdit        = "edit"        // an edited file: its diff and backup
+)
+
+// TranscriptExt is the file extension of transcripts.
+const TranscriptExt = ".jsonl"
+
+// Entry is one line of a transcript.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// User is who ran it, as local-user@local-host.
+	User string `json:"user"`
+	// Host is where it ran, as user@host:port or local.
+	Host string `json:"host"`
+	// Request is the request, or for commands the request they were
+	// generated for.
+	Request     string   `json:"request,omitempty"`
+	Commands    []string `json:"commands,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	ExitCode    *int     `json:"exit_code,omitempty"`
+	Stdout      string   `json:"stdout,omitempty"`
+	Stderr      string   `json:"stderr,omitempty"`
+	// Truncated is set if the middle of long output was dropped.
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Cast is the asciicast recording of an interactive command.
+	Cast string `json:"cast,omitempty"`
+	// Path, Diff and Backup are the file, the changes and the backup of an
+	// edit.
+	Path   string `json:"path,omitempty"`
+	Diff   string `json:"diff,omitempty"`
+	Backup string `json:"backup,omitempty"`
+}
+
+// DefaultDir returns the directory recordings are kept in by default.
+func DefaultDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".config", "sherlock", "recordings")
+}
+
+// resolveDir returns dir with a leading ~/ expanded, or DefaultDir if it is
+// empty.
+func resolveDir(dir string) string {
+	if dir == "" {
+		return DefaultDir()
+	}
+	if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, rest)
+		}
+	}
+	return dir
+}
+
+// Recorder writes the transcript of one session and the recordings of its
+// interactive commands. It is safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	dir   string
+	name  string // file name of the transcript without extension
+	file  *os.File
+	user  string
+	casts int
+}
+
+// NewRecorder starts a transcript in dir, named after the current time.
+// Recordings may hold secrets shown by commands, so they are only readable
+// by the user.
+func NewRecorder(dir string) (*Recorder, error) {
+	dir = resolveDir(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	stamp := time.Now().Format("20060102-150405")
+	name := stamp
+	for n := 2; ; n++ {
+		file, err := os.OpenFile(filepath.Join(dir, name+TranscriptExt), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if err == nil {
+			return &Recorder{dir: dir, name: name, file: file, user: localUser()}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create transcript: %w", err)
+		}
+		name = fmt.Sprintf("%s-%d", stamp, n)
+	}
+}
+
+// localUser returns who runs Sherlock, as user@host.
+func localUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + hostname
+}
+
+// Path returns the path of the transcript.
+func (r *Recorder) Path() string {
+	return r.file.Name()
+}
+
+// Add appends an entry to the transcript, filling in the time and user.
+func (r *Recorder) Add(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.User = r.user
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+// OpenCast starts the recording of an interactive command on host and adds
+// it to the transcript. It returns nil if the recording cannot be created,
+// so that the command runs unrecorded rather than not at all.
+func (r *Recorder) OpenCast(host, command string, width, height int) *sshclient.CastWriter {
+	r.mu.Lock()
+	r.casts++
+	path := filepath.Join(r.dir, fmt.Sprintf("%s-%d.cast", r.name, r.casts))
+	r.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil
+	}
+	cast, err := sshclient.NewCastWriter(file, sshclient.CastHeader{
+		Width:   width,
+		Height:  height,
+		Command: command,
+		Title:   host + ": " + command,
+		Env:     map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	if err != nil {
+		file.Close()
+		return nil
+	}
+	_ = r.Add(Entry{Kind: KindInteractive, Host: host, Command: command, Cast: path})
+	return cast
+}
+
+// Close closes the transcript.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// ReadTranscript reads the entries of a transcript.
+func ReadTranscript(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid transcript entry: %w", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Latest returns the path of the newest transcript in dir.
+func Latest(dir string) (string, error) {
+	dir = resolveDir(dir)
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+TranscriptExt))
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", errors.New("no recorded sessions in " + dir)
+	}
+	// Names start with the time they were started
+	sort.Strings(paths)
+	return paths[len(paths)-1], nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	r, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	second, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("second NewRecorder() error = %v", err)
+	}
+	if second.Path() == r.Path() {
+		t.Errorf("two recorders share %s", r.Path())
+	}
+	_ = second.Close()
+
+	code := 0
+	entries := []Entry{
+		{Kind: KindRequest, Host: "root@web1:22", Request: "show disk usage", Commands: []string{"df -h"}},
+		{Kind: KindCommand, Host: "root@web1:22", Request: "show disk usage", Command: "df -h", ExitCode: &code, Stdout: "/dev/sda1 40%\n"},
+	}
+	for _, e := range entries {
+		if err := r.Add(e); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	cast := r.OpenCast("root@web1:22", "top", 80, 24)
+	if cast == nil {
+		t.Fatal("OpenCast() = nil")
+	}
+	_, _ = cast.Write([]byte("load average"))
+	if err := cast.Close(); err != nil {
+		t.Fatalf("closing the cast: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if info, err := os.Stat(r.Path()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("transcript mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	got, err := ReadTranscript(r.Path())
+	if err != nil {
+		t.Fatalf("ReadTranscript() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("ReadTranscript() = %d entries, want 3", len(got))
+	}
+	if got[0].User == "" || got[0].Time.IsZero() {
+		t.Errorf("entry = %+v, want user and time filled in", got[0])
+	}
+	if got[1].ExitCode == nil || *got[1].ExitCode != 0 || got[1].Stdout != "/dev/sda1 40%\n" {
+		t.Errorf("command entry = %+v", got[1])
+	}
+	if got[2].Kind != KindInteractive || got[2].Command != "top" || !strings.HasSuffix(got[2].Cast, ".cast") {
+		t.Errorf("interactive entry = %+v", got[2])
+	}
+	if data, err := os.ReadFile(got[2].Cast); err != nil || !strings.Contains(string(data), "load average") {
+		t.Errorf("cast = %q (%v)", data, err)
+	}
+
+	latest, err := Latest(dir)
+	if err != nil || (latest != r.Path() && latest != second.Path()) {
+		t.Errorf("Latest() = %q, %v", latest, err)
+	}
+	if _, err := Latest(t.TempDir()); err == nil {
+		t.Error("Latest() of an empty directory succeeded")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	code, failed := 0, 2
+	entries := []Entry{
+		{Time: at, Kind: KindRequest, User: "alice@laptop", Host: "root@web1:22", Request: "show disk usage", Commands: []string{"df -h"}, Description: "Shows disk usage"},
+		{Time: at, Kind: KindCommand, User: "alice@laptop", Host: "root@web1:22", Command: "df -h", ExitCode: &code, Stdout: "has ``` fences\n", DurationMS: 12},
+		{Time: at, Kind: KindCommand, User: "alice@laptop", Host: "root@web1:22", Command: "ls /nope", ExitCode: &failed, Stderr: "No such file"},
+		{Time: at, Kind: KindInteractive, User: "alice@laptop", Host: "local", Command: "top", Cast: "/r/1.cast"},
+		{Time: at, Kind: KindEdit, User: "alice@laptop", Host: "root@web1:22", Path: "/etc/hosts", Diff: "-a\n+b\n", Backup: "/etc/hosts.bak"},
+	}
+	got := Markdown(entries)
+	for _, want := range []string{
+		"- Recorded by: alice@laptop\n",
+		"- Hosts: root@web1:22, local\n",
+		"## 10:00:00 on root@web1:22\n\n> show disk usage\n\nShows disk usage\n\n```sh\ndf -h\n```\n",
+		"### `$ df -h`\n\n10:00:00 on root@web1:22, exit code 0, 12ms\n\n````\nhas ``` fences\n````\n",
+		"exit code 2\n\nstderr:\n\n```\nNo such file\n```\n",
+		"### `$ top` (interactive)\n",
+		"replay /r/1.cast",
+		"### `edit /etc/hosts`\n\n10:00:00 on root@web1:22, backup in `/etc/hosts.bak`\n\n```diff\n-a\n+b\n```\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Markdown() does not contain %q:\n%s", want, got)
+		}
+	}
+	if got := Markdown(nil); !strings.Contains(got, "Nothing was recorded") {
+		t.Errorf("Markdown(nil) = %q", got)
+	}
+}
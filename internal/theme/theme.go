@@ -78,6 +78,9 @@ type Theme struct {
 	Stdout string
 	Stderr string
 
+	// Model reasoning color
+	Reasoning string
+
 	// Table colors
 	TableHeader  string
 	TableBorder  string
@@ -104,6 +107,7 @@ func DefaultTheme() *Theme {
 		CommandDesc:     "",
 		Stdout:          "",
 		Stderr:          "",
+		Reasoning:       "",
 		TableHeader:     "",
 		TableBorder:     "",
 		TableContent:    "",
@@ -128,6 +132,7 @@ func DraculaTheme() *Theme {
 		CommandDesc:     BrightWhite,
 		Stdout:          BrightWhite,
 		Stderr:          BrightRed,
+		Reasoning:       Dim + Italic,
 		TableHeader:     BrightMagenta + Bold,
 		TableBorder:     BrightBlack,
 		TableContent:    BrightWhite,
@@ -152,6 +157,7 @@ func SolarizedTheme() *Theme {
 		CommandDesc:     White,
 		Stdout:          White,
 		Stderr:          Red,
+		Reasoning:       Dim + Italic,
 		TableHeader:     Blue + Bold,
 		TableBorder:     BrightBlack,
 		TableContent:    White,
@@ -261,6 +267,14 @@ func (t *Theme) FormatStderr(output string) string {
 	return t.Stderr + output + t.Reset
 }
 
+// FormatReasoning formats the model's reasoning (thinking) text.
+func (t *Theme) FormatReasoning(text string) string {
+	if t.Reasoning == "" {
+		return text
+	}
+	return t.Reasoning + text + t.Reset
+}
+
 // FormatTableHeader formats table header text.
 func (t *Theme) FormatTableHeader(header string) string {
 	if t.TableHeader == "" {
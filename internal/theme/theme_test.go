@@ -294,3 +294,19 @@ func TestThemeFormatHostsSimple(t *testing.T) {
 		t.Errorf("FormatHostsSimple() should contain usage hint")
 	}
 }
+
+func TestThemeFormatReasoning(t *testing.T) {
+	defaultTheme := DefaultTheme()
+	if got := defaultTheme.FormatReasoning("thinking"); got != "thinking" {
+		t.Errorf("DefaultTheme.FormatReasoning() = %q, want %q", got, "thinking")
+	}
+
+	draculaTheme := DraculaTheme()
+	result := draculaTheme.FormatReasoning("thinking")
+	if !strings.HasPrefix(result, Dim) {
+		t.Errorf("DraculaTheme.FormatReasoning() should be dimmed")
+	}
+	if !strings.HasSuffix(result, draculaTheme.Reset) {
+		t.Errorf("DraculaTheme.FormatReasoning() should end with Reset code")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned when agent forwarding is turned on without a local
+// SSH agent.
+var ErrNoAgent = errors.New("no SSH agent is running (SSH_AUTH_SOCK is not set)")
+
+// ForwardAgent reports whether the local SSH agent is forwarded to new
+// sessions.
+func (c *Client) ForwardAgent() bool {
+	return c.forwardAgent
+}
+
+// SetForwardAgent turns forwarding of the local SSH agent on or off. It
+// applies to sessions created from now on; a persistent shell is restarted
+// so that it follows the setting, which loses its exported variables.
+func (c *Client) SetForwardAgent(on bool) error {
+	if on == c.forwardAgent {
+		return nil
+	}
+	if on {
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return ErrNoAgent
+		}
+		if c.isConnected && c.client != nil {
+			if err := c.serveAgent(c.client); err != nil {
+				return err
+			}
+		}
+	}
+	c.forwardAgent = on
+	if c.shell != nil {
+		_ = c.shell.Close()
+		c.shell = nil
+	}
+	return nil
+}
+
+// serveAgent answers agent requests of the remote host on the transport with
+// the local agent. A transport is only set up once.
+func (c *Client) serveAgent(client *ssh.Client) error {
+	if c.agentTransport == client {
+		return nil
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return ErrNoAgent
+	}
+	if err := agent.ForwardToRemote(client, sock); err != nil {
+		return fmt.Errorf("agent forwarding unavailable: %w", err)
+	}
+	c.agentTransport = client
+	return nil
+}
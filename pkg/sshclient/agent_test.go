@@ -0,0 +1,87 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestAgent serves an empty SSH agent and returns its socket path.
+func startTestAgent(t *testing.T) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return sock
+}
+
+func TestSetForwardAgent(t *testing.T) {
+	server := startTestSSHServer(t)
+	var events []ConnectionState
+	client := newTestClient(t, server, true, &events)
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := client.SetForwardAgent(true); !errors.Is(err, ErrNoAgent) {
+		t.Fatalf("SetForwardAgent(true) without agent error = %v, want ErrNoAgent", err)
+	}
+	if client.ForwardAgent() {
+		t.Fatal("forwarding enabled although it failed")
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", startTestAgent(t))
+	for i, on := range []bool{true, false, true} {
+		if err := client.SetForwardAgent(on); err != nil {
+			t.Fatalf("step %d: SetForwardAgent(%v) error = %v", i, on, err)
+		}
+		if client.ForwardAgent() != on {
+			t.Fatalf("step %d: ForwardAgent() = %v, want %v", i, client.ForwardAgent(), on)
+		}
+	}
+	if client.agentTransport != client.client {
+		t.Error("agent requests are not served on the current transport")
+	}
+
+	// A new transport serves the agent again
+	server.DropConnections()
+	waitFor(t, "dropped link", client.linkLost)
+	if err := client.ensureConnected(context.Background()); err != nil {
+		t.Fatalf("ensureConnected() error = %v", err)
+	}
+	if client.agentTransport != client.client {
+		t.Error("agent requests are not served after a reconnect")
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxPassphraseAttempts is how often the passphrase of a key is asked for.
+const maxPassphraseAttempts = 3
+
+// PromptFunc asks the user a question during authentication and returns the
+// answer. The answer is shown while typed only if echo is set. instruction,
+// if not empty, is shown before the question.
+type PromptFunc func(instruction, question string, echo bool) (string, error)
+
+// signerCache holds decrypted keys by path, so that a passphrase is asked for
+// once per process, not for every connection, hop or reconnect.
+var signerCache = struct {
+	sync.Mutex
+	signers map[string]ssh.Signer
+}{signers: make(map[string]ssh.Signer)}
+
+func cachedSigner(keyPath string) ssh.Signer {
+	signerCache.Lock()
+	defer signerCache.Unlock()
+	return signerCache.signers[keyPath]
+}
+
+func cacheSigner(keyPath string, signer ssh.Signer) {
+	signerCache.Lock()
+	defer signerCache.Unlock()
+	signerCache.signers[keyPath] = signer
+}
+
+// loadSigner loads the private key at keyPath. An encrypted key is decrypted
+// with passphrase if given. Otherwise, if prompt is set, a signer is returned
+// that asks for the passphrase only when the server accepts the key, or right
+// away if the public key cannot be read without decrypting.
+func loadSigner(keyPath, passphrase string, prompt PromptFunc) (ssh.Signer, error) {
+	if signer := cachedSigner(keyPath); signer != nil {
+		return signer, nil
+	}
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && prompt != nil {
+		publicKey := missing.PublicKey
+		if publicKey == nil {
+			// Older PEM keys encrypt everything; use the .pub file next to them
+			publicKey, _ = loadPublicKey(keyPath + ".pub")
+		}
+		lazy := &passphraseSigner{path: keyPath, keyData: keyData, publicKey: publicKey, prompt: prompt}
+		if publicKey == nil {
+			return lazy.decrypt()
+		}
+		return lazy, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// loadPublicKey reads a public key in authorized_keys format.
+func loadPublicKey(path string) (ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	return key, err
+}
+
+// passphraseSigner is an encrypted key that is decrypted on first use. The
+// server is asked whether it accepts a public key before anything is signed,
+// so the user is only asked for passphrases of keys that matter.
+type passphraseSigner struct {
+	path      string
+	keyData   []byte
+	publicKey ssh.PublicKey
+	prompt    PromptFunc
+
+	mu     sync.Mutex
+	signer ssh.Signer
+}
+
+func (s *passphraseSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *passphraseSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *passphraseSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+		return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+// decrypt asks for the passphrase until the key decrypts, and caches the
+// result.
+func (s *passphraseSigner) decrypt() (ssh.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signer != nil {
+		return s.signer, nil
+	}
+	if signer := cachedSigner(s.path); signer != nil {
+		s.signer = signer
+		return signer, nil
+	}
+
+	for attempt := 0; attempt < maxPassphraseAttempts; attempt++ {
+		passphrase, err := s.prompt("", fmt.Sprintf("Enter passphrase for key '%s': ", s.path), false)
+		if err != nil {
+			return nil, err
+		}
+		if passphrase == "" {
+			break
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(s.keyData, []byte(passphrase))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key %s: %w", s.path, err)
+		}
+		s.signer = signer
+		cacheSigner(s.path, signer)
+		return signer, nil
+	}
+	return nil, fmt.Errorf("no valid passphrase for key %s", s.path)
+}
+
+// keyboardInteractive answers keyboard-interactive challenges, such as a PAM
+// password followed by a one-time code. A hidden password question is
+// answered with password once, if set; everything else is asked with prompt.
+func keyboardInteractive(password string, prompt PromptFunc) ssh.KeyboardInteractiveChallenge {
+	passwordUsed := false
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		header := strings.TrimSpace(strings.Join([]string{name, instruction}, "\n"))
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if password != "" && !passwordUsed && !echos[i] && strings.Contains(strings.ToLower(question), "password") {
+				answers[i] = password
+				passwordUsed = true
+				continue
+			}
+			if prompt == nil {
+				return nil, fmt.Errorf("server asked %q, but no prompt is available", strings.TrimSpace(question))
+			}
+			answer, err := prompt(header, question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			header = ""
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
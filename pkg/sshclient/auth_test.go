@@ -0,0 +1,199 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeEncryptedKey writes a new ed25519 key encrypted with passphrase and
+// returns its path and public key.
+func writeEncryptedKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "test", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, publicKey
+}
+
+func TestLoadSignerEncryptedKey(t *testing.T) {
+	path, publicKey := writeEncryptedKey(t, "secret")
+
+	// Without a prompt the key is skipped
+	if _, err := loadSigner(path, "", nil); err == nil {
+		t.Fatal("loadSigner without passphrase or prompt should fail")
+	}
+
+	var asked []string
+	answers := []string{"wrong", "secret"}
+	prompt := func(instruction, question string, echo bool) (string, error) {
+		if echo {
+			t.Error("passphrase prompt should not echo")
+		}
+		asked = append(asked, question)
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+
+	signer, err := loadSigner(path, "", prompt)
+	if err != nil {
+		t.Fatalf("loadSigner failed: %v", err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+		t.Error("public key of the lazy signer does not match")
+	}
+	if len(asked) != 0 {
+		t.Fatalf("passphrase asked before signing: %v", asked)
+	}
+
+	data := []byte("challenge")
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := publicKey.Verify(data, sig); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("passphrase asked %d times, want 2", len(asked))
+	}
+
+	// The decrypted key is reused for later connections
+	again, err := loadSigner(path, "", prompt)
+	if err != nil {
+		t.Fatalf("loadSigner failed: %v", err)
+	}
+	if _, err := again.Sign(rand.Reader, data); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("passphrase asked again after it was cached")
+	}
+}
+
+func TestLoadSignerGivesUp(t *testing.T) {
+	path, _ := writeEncryptedKey(t, "secret")
+	calls := 0
+	prompt := func(instruction, question string, echo bool) (string, error) {
+		calls++
+		return "wrong", nil
+	}
+	signer, err := loadSigner(path, "", prompt)
+	if err != nil {
+		t.Fatalf("loadSigner failed: %v", err)
+	}
+	if _, err := signer.Sign(rand.Reader, []byte("data")); err == nil {
+		t.Error("Sign with a wrong passphrase should fail")
+	}
+	if calls != maxPassphraseAttempts {
+		t.Errorf("passphrase asked %d times, want %d", calls, maxPassphraseAttempts)
+	}
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	type prompt struct {
+		instruction string
+		question    string
+		echo        bool
+	}
+	tests := []struct {
+		name      string
+		password  string
+		questions []string
+		echos     []bool
+		want      []string
+		prompts   []prompt
+	}{
+		{
+			name:      "password answered from config",
+			password:  "pw",
+			questions: []string{"Password: "},
+			echos:     []bool{false},
+			want:      []string{"pw"},
+		},
+		{
+			name:      "password then one-time code",
+			password:  "pw",
+			questions: []string{"Password: ", "Verification code: "},
+			echos:     []bool{false, false},
+			want:      []string{"pw", "answer"},
+			prompts:   []prompt{{"Two-factor\nEnter your code", "Verification code: ", false}},
+		},
+		{
+			name:      "echoed question",
+			questions: []string{"Username: "},
+			echos:     []bool{true},
+			want:      []string{"answer"},
+			prompts:   []prompt{{"Two-factor\nEnter your code", "Username: ", true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []prompt
+			challenge := keyboardInteractive(tt.password, func(instruction, question string, echo bool) (string, error) {
+				got = append(got, prompt{instruction, question, echo})
+				return "answer", nil
+			})
+			answers, err := challenge("Two-factor", "Enter your code", tt.questions, tt.echos)
+			if err != nil {
+				t.Fatalf("challenge failed: %v", err)
+			}
+			if !reflect.DeepEqual(answers, tt.want) {
+				t.Errorf("answers = %q, want %q", answers, tt.want)
+			}
+			if !reflect.DeepEqual(got, tt.prompts) {
+				t.Errorf("prompts = %v, want %v", got, tt.prompts)
+			}
+		})
+	}
+}
+
+func TestKeyboardInteractiveWithoutPrompt(t *testing.T) {
+	challenge := keyboardInteractive("", nil)
+	if _, err := challenge("", "", []string{"Code: "}, []bool{false}); err == nil {
+		t.Error("challenge without a prompt should fail")
+	}
+
+	failing := keyboardInteractive("", func(string, string, bool) (string, error) {
+		return "", errors.New("cancelled")
+	})
+	if _, err := failing("", "", []string{"Code: "}, []bool{false}); err == nil {
+		t.Error("challenge should fail when the prompt fails")
+	}
+}
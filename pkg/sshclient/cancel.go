@@ -0,0 +1,93 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// stopGrace is how long a command is given to exit after each signal.
+var stopGrace = time.Second
+
+var (
+	// ErrInterrupted is reported for a command stopped because its context
+	// was cancelled, e.g. by Ctrl-C.
+	ErrInterrupted = errors.New("command interrupted")
+	// ErrTimedOut is reported for a command stopped because the deadline of
+	// its context passed.
+	ErrTimedOut = errors.New("command timed out")
+)
+
+// markStopped records in result that the end of ctx stopped the command.
+func markStopped(ctx context.Context, result *ExecuteResult) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		result.Error = ErrTimedOut
+		return
+	}
+	result.Interrupted = true
+	result.Error = ErrInterrupted
+}
+
+// escalate runs the steps in order, each asking more forcefully for the
+// command to stop, until done is closed. It waits grace after each step and
+// reports whether the command stopped.
+func escalate(steps []func() error, done <-chan struct{}, grace time.Duration) bool {
+	for _, step := range steps {
+		_ = step()
+		select {
+		case <-done:
+			return true
+		case <-time.After(grace):
+		}
+	}
+	return false
+}
+
+// waitSession waits for the command of session, stopping it if ctx ends
+// first: SIGINT, then SIGTERM, then SIGKILL, and finally closing the session
+// for servers that ignore signals. It reports whether ctx stopped it.
+func waitSession(ctx context.Context, session *ssh.Session) (bool, error) {
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = session.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return false, err
+	case <-ctx.Done():
+	}
+	signal := func(sig ssh.Signal) func() error {
+		return func() error { return session.Signal(sig) }
+	}
+	if !escalate([]func() error{signal(ssh.SIGINT), signal(ssh.SIGTERM), signal(ssh.SIGKILL)}, done, stopGrace) {
+		_ = session.Close()
+		<-done
+	}
+	return true, err
+}
+
+// killGroup returns a step that sends sig to the process group pgid.
+func killGroup(pgid int, sig syscall.Signal) func() error {
+	return func() error { return syscall.Kill(-pgid, sig) }
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEscalate(t *testing.T) {
+	tests := []struct {
+		name        string
+		stopAfter   int // step after which done is closed, 0 for never
+		wantSteps   []string
+		wantStopped bool
+	}{
+		{name: "stops on first signal", stopAfter: 1, wantSteps: []string{"INT"}, wantStopped: true},
+		{name: "stops on kill", stopAfter: 3, wantSteps: []string{"INT", "TERM", "KILL"}, wantStopped: true},
+		{name: "never stops", wantSteps: []string{"INT", "TERM", "KILL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			var steps []string
+			step := func(name string) func() error {
+				return func() error {
+					steps = append(steps, name)
+					if len(steps) == tt.stopAfter {
+						close(done)
+					}
+					return nil
+				}
+			}
+			stopped := escalate([]func() error{step("INT"), step("TERM"), step("KILL")}, done, 10*time.Millisecond)
+			if stopped != tt.wantStopped {
+				t.Errorf("escalate() = %v, want %v", stopped, tt.wantStopped)
+			}
+			if !reflect.DeepEqual(steps, tt.wantSteps) {
+				t.Errorf("steps = %v, want %v", steps, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestLocalExecuteStreamStops(t *testing.T) {
+	defer func(grace time.Duration) { stopGrace = grace }(stopGrace)
+	stopGrace = 200 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		command       string
+		cancel        bool
+		wantTimedOut  bool
+		wantInterrupt bool
+	}{
+		{name: "interrupted", command: "sleep 30", cancel: true, wantInterrupt: true},
+		{name: "timed out", command: "sleep 30", wantTimedOut: true},
+		{name: "ignores SIGINT", command: "trap '' INT; sleep 30", cancel: true, wantInterrupt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewLocalClient()
+			client.cwd = t.TempDir()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if tt.cancel {
+				ctx, cancel = context.WithCancel(context.Background())
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			result := client.ExecuteStream(ctx, tt.command, io.Discard, io.Discard)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("command ran for %v after being stopped", elapsed)
+			}
+			if result.TimedOut != tt.wantTimedOut || result.Interrupted != tt.wantInterrupt {
+				t.Errorf("TimedOut = %v, Interrupted = %v; want %v, %v", result.TimedOut, result.Interrupted, tt.wantTimedOut, tt.wantInterrupt)
+			}
+			want := ErrInterrupted
+			if tt.wantTimedOut {
+				want = ErrTimedOut
+			}
+			if !errors.Is(result.Error, want) {
+				t.Errorf("Error = %v, want %v", result.Error, want)
+			}
+		})
+	}
+}
+
+func TestLocalExecuteStreamKillsProcessGroup(t *testing.T) {
+	client := NewLocalClient()
+	client.cwd = t.TempDir()
+	pidFile := filepath.Join(client.cwd, "child.pid")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	result := client.ExecuteStream(ctx, "sleep 30 & echo $! > child.pid; wait", io.Discard, io.Discard)
+	if !result.TimedOut {
+		t.Fatalf("result = %+v, want timed out", result)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	waitFor(t, "background child to exit", func() bool {
+		// An orphan may stay a zombie where nothing reaps it
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return syscall.Kill(pid, 0) != nil
+		}
+		_, after, _ := strings.Cut(string(stat), ") ")
+		return strings.HasPrefix(after, "Z")
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader is the first line of an asciicast v2 recording.
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Asciicast event types.
+const (
+	CastOutput = "o"
+	CastResize = "r"
+)
+
+// CastEvent is one event of a recording: output, or a resize to "WxH".
+type CastEvent struct {
+	Time float64 // seconds since the start
+	Type string
+	Data string
+}
+
+// CastOpener starts the recording of an interactive command in a terminal
+// of the given size. It returns nil if the command is not recorded.
+type CastOpener func(command string, width, height int) *CastWriter
+
+// CastWriter writes a terminal session in the asciicast v2 format: a
+// header line, then one JSON array per event. Keyboard input is not
+// recorded, so typed passwords do not end up in the file. It is safe for
+// concurrent use.
+type CastWriter struct {
+	mu      sync.Mutex
+	w       io.WriteCloser
+	start   time.Time
+	pending []byte // start of a UTF-8 character split across writes
+	err     error
+}
+
+// NewCastWriter writes the header to w and returns a writer for the events.
+// A zero version in header is set to 2 and a zero timestamp to now.
+func NewCastWriter(w io.WriteCloser, header CastHeader) (*CastWriter, error) {
+	start := time.Now()
+	if header.Version == 0 {
+		header.Version = 2
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return &CastWriter{w: w, start: start}, nil
+}
+
+// Write records p as output; it never fails, so that a broken recording
+// does not break the session it records. Err reports the first failure.
+func (c *CastWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := append(c.pending, p...)
+	cut := incompleteRuneStart(data)
+	c.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		c.event(CastOutput, string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// incompleteRuneStart returns where an incomplete UTF-8 character at the
+// end of p starts, or len(p) if there is none.
+func incompleteRuneStart(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+// Resize records that the terminal was resized.
+func (c *CastWriter) Resize(width, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.event(CastResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// Err returns the first error writing the recording.
+func (c *CastWriter) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the underlying file.
+func (c *CastWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) > 0 {
+		c.event(CastOutput, string(c.pending))
+		c.pending = nil
+	}
+	if err := c.w.Close(); c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+// event writes one event; c.mu must be held.
+func (c *CastWriter) event(kind, data string) {
+	if c.err != nil {
+		return
+	}
+	elapsed := time.Since(c.start).Seconds()
+	line, err := json.Marshal([]any{json.Number(strconv.FormatFloat(elapsed, 'f', 6, 64)), kind, data})
+	if err == nil {
+		_, err = c.w.Write(append(line, '\n'))
+	}
+	c.err = err
+}
+
+// ReadCast reads an asciicast v2 recording. Event types other than output
+// and resize, such as input and markers, are skipped.
+func ReadCast(r io.Reader) (*CastHeader, []CastEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("empty recording")
+	}
+	var header CastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []CastEvent
+	for n := 2; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var fields []json.RawMessage
+		if err := json.Unmarshal([]byte(line), &fields); err != nil || len(fields) != 3 {
+			return nil, nil, fmt.Errorf("invalid asciicast event on line %d", n)
+		}
+		var event CastEvent
+		err1 := json.Unmarshal(fields[0], &event.Time)
+		err2 := json.Unmarshal(fields[1], &event.Type)
+		err3 := json.Unmarshal(fields[2], &event.Data)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, nil, fmt.Errorf("invalid asciicast event on line %d: %w", n, err)
+		}
+		if event.Type == CastOutput || event.Type == CastResize {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &header, events, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCastWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cast, err := NewCastWriter(nopWriteCloser{&buf}, CastHeader{Width: 100, Height: 30, Command: "top"})
+	if err != nil {
+		t.Fatalf("NewCastWriter() error = %v", err)
+	}
+	// "é" split across two writes is recorded whole
+	_, _ = cast.Write([]byte("caf\xc3"))
+	_, _ = cast.Write([]byte("\xa9\r\n"))
+	cast.Resize(120, 40)
+	_, _ = cast.Write([]byte("\x1b[2J"))
+	if err := cast.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], `{"version":2,"width":100,"height":30,`) {
+		t.Fatalf("recording =\n%s", buf.String())
+	}
+
+	header, events, err := ReadCast(&buf)
+	if err != nil {
+		t.Fatalf("ReadCast() error = %v", err)
+	}
+	if header.Command != "top" || header.Timestamp == 0 {
+		t.Errorf("header = %+v", header)
+	}
+	want := []CastEvent{
+		{Type: CastOutput, Data: "caf"},
+		{Type: CastOutput, Data: "é\r\n"},
+		{Type: CastResize, Data: "120x40"},
+		{Type: CastOutput, Data: "\x1b[2J"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("ReadCast() = %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != want[i].Type || e.Data != want[i].Data {
+			t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+		}
+		if i > 0 && e.Time < events[i-1].Time {
+			t.Errorf("event %d is earlier than the one before it", i)
+		}
+	}
+}
+
+func TestReadCast(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		events  int
+		wantErr bool
+	}{
+		{name: "input and markers skipped", input: "{\"version\":2,\"width\":80,\"height\":24}\n[0.1,\"i\",\"q\"]\n[0.2,\"o\",\"x\"]\n[0.3,\"m\",\"\"]\n", events: 1},
+		{name: "empty", input: "", wantErr: true},
+		{name: "version 1", input: "{\"version\":1,\"width\":80,\"height\":24}\n", wantErr: true},
+		{name: "bad event", input: "{\"version\":2,\"width\":80,\"height\":24}\n[0.1,\"o\"]\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, events, err := ReadCast(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCast() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(events) != tt.events {
+				t.Errorf("ReadCast() = %d events, want %d", len(events), tt.events)
+			}
+		})
+	}
+}
@@ -25,10 +25,13 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
@@ -70,7 +73,36 @@ type Client struct {
 	sshConfig   *ssh.ClientConfig
 	isConnected bool
 	agentConn   net.Conn // Connection to SSH agent, if used
-	cwd         string   // current working directory on remote host
+	dirState             // working directories on remote host
+
+	persistentShell bool             // run commands in one long-lived shell
+	shell           *persistentShell // the long-lived shell, if started
+	shellErr        error            // why the shell is unusable, if it is
+
+	sftpClient *sftp.Client // SFTP session for file transfers, opened on first use
+
+	tunnelMu     sync.Mutex
+	tunnels      []*Tunnel // running port forwards
+	lastTunnelID int
+
+	jumps        []*jumpHost   // jump hosts to connect through, in order
+	jumpClients  []*ssh.Client // connections to the jump hosts
+	proxyCommand string        // local command used as transport, if set
+
+	connMu            sync.Mutex    // guards client for tunnel goroutines
+	linkDown          chan struct{} // closed when the transport of client ends
+	autoReconnect     bool          // restore a dropped connection on next use
+	onConnection      func(ConnectionEvent)
+	keepAliveInterval time.Duration // interval of keepalive requests, 0 if disabled
+	keepAliveCountMax int           // unanswered keepalives before the link is dead
+	keepAliveStop     chan struct{} // stops the keepalive loop
+	forwardAgent      bool          // forward the local agent to sessions
+	agentTransport    *ssh.Client   // transport whose agent requests are served
+	sendEnv           []string      // patterns of environment variables to send
+	localForwards     []TunnelSpec  // LocalForward tunnels started on connect
+	warnings          []string      // problems that did not stop the connection
+	sudo              *sudoState    // the sudo password, while it is kept
+	openCast          CastOpener    // records interactive commands, if set
 }
 
 // Config holds the configuration for creating a new SSH client.
@@ -87,11 +119,48 @@ type Config struct {
 	Timeout time.Duration
 	// StrictHostKeyChecking enables strict host key checking (like SSH).
 	// When true, connections to unknown hosts are rejected.
-	// When false (default), unknown hosts are accepted but changed keys are rejected.
+	// When false (default), keys of unknown hosts are trusted on first use and
+	// added to known_hosts, but changed keys are rejected.
 	StrictHostKeyChecking bool
+	// HostKeyPrompt, if set, asks the user before trusting the key of an
+	// unknown host. Without it, such keys are trusted and recorded.
+	HostKeyPrompt HostKeyPrompt
+	// Prompt, if set, asks for passphrases of encrypted keys and answers
+	// keyboard-interactive questions such as one-time codes. Without it,
+	// encrypted keys without PrivateKeyPassphrase are skipped.
+	Prompt PromptFunc
+	// SudoPasswordTimeout is how long a sudo password asked for with Prompt
+	// is kept in memory. Zero keeps it for DefaultSudoPasswordTimeout; a
+	// negative value asks for every command.
+	SudoPasswordTimeout time.Duration
 	// UseSSHConfig enables reading SSH config file (~/.ssh/config) for host settings.
 	// Default is true.
 	UseSSHConfig *bool
+	// PersistentShell runs all commands in one long-lived remote shell, so that
+	// environment variables, aliases, umask and limits persist between commands.
+	// If the shell is unusable, commands fall back to one session each.
+	PersistentShell bool
+	// ProxyJump is a comma-separated chain of jump hosts, as with ssh -J, e.g.
+	// "bastion" or "admin@gw1:2222,gw2". It overrides ProxyJump in ssh_config.
+	ProxyJump string
+	// ProxyCommand is a local command whose stdin and stdout are used as the
+	// connection to the host. It overrides ProxyCommand in ssh_config.
+	ProxyCommand string
+	// KeepAliveInterval is the interval of keepalive requests that detect a
+	// dead connection. Zero uses ServerAliveInterval from ssh_config, or 30
+	// seconds; a negative value disables keepalives.
+	KeepAliveInterval time.Duration
+	// AutoReconnect restores a dropped connection before the next command.
+	// Default is true.
+	AutoReconnect *bool
+	// ForwardAgent forwards the local SSH agent to the sessions of the host,
+	// so that it can authenticate onward with local keys. It overrides
+	// ForwardAgent in ssh_config. Anyone with root on the host can use the
+	// agent while it is forwarded.
+	ForwardAgent *bool
+	// OnConnectionChange, if set, is called when a dropped connection is
+	// detected and when reconnecting succeeds or fails.
+	OnConnectionChange func(ConnectionEvent)
 }
 
 // NewClient creates a new SSH client with the given configuration.
@@ -101,6 +170,7 @@ type Config struct {
 // 3. Tries public key authentication with configured and default keys
 // 4. Falls back to password authentication if provided
 // 5. Uses ~/.ssh/known_hosts for host key verification
+// 6. Connects through jump hosts (ProxyJump) or a ProxyCommand if configured
 func NewClient(cfg *Config) (*Client, error) {
 	if cfg.HostInfo == nil {
 		return nil, errors.New("host info is required")
@@ -109,68 +179,211 @@ func NewClient(cfg *Config) (*Client, error) {
 	// Apply SSH config settings if enabled (default: true)
 	useSSHConfig := cfg.UseSSHConfig == nil || *cfg.UseSSHConfig
 	hostInfo := cfg.HostInfo
-	var sshConfigIdentityFiles []string
+	var sshConfig *SSHConfig
+	var configHost *SSHConfigHost
 
 	if useSSHConfig {
-		sshConfig, err := ParseSSHConfig()
-		if err == nil {
-			hostInfo, sshConfigIdentityFiles = applySSHConfig(sshConfig, cfg.HostInfo)
+		if parsed, err := ParseSSHConfig(); err == nil {
+			sshConfig = parsed
+			configHost = sshConfig.Resolve(cfg.HostInfo.Host, cfg.HostInfo.User)
 		}
 	}
+	if configHost == nil {
+		configHost = &SSHConfigHost{Host: cfg.HostInfo.Host, Port: 22, ServerAliveCountMax: 3}
+	}
+	hostInfo, sshConfigIdentityFiles := applyConfigHost(configHost, cfg.HostInfo)
 
+	if hostInfo.User == "" {
+		// As with ssh, log in as the local user by default
+		hostInfo.User = localUsername()
+	}
 	if hostInfo.User == "" {
 		return nil, errors.New("user is required")
 	}
 
+	// Get signers from SSH agent first (highest priority), unless only the
+	// configured identities may be used
+	agentSigners, agentConn := getAgentSigners()
+	if configHost.IdentitiesOnly {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		agentSigners, agentConn = nil, nil
+	}
+
+	authMethods := buildAuthMethods(cfg, agentSigners, sshConfigIdentityFiles, configHost.IdentitiesOnly)
+	if len(authMethods) == 0 {
+		// Close agent connection if we're not going to use it
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, errors.New("at least one authentication method is required")
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = configHost.ConnectTimeout
+	}
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	// Create host key callback using known_hosts file
+	policy := hostKeyPolicy{
+		files:  knownHostsFiles(configHost),
+		hash:   configHost.HashKnownHosts,
+		prompt: cfg.HostKeyPrompt,
+	}
+	var hostKeyCallback ssh.HostKeyCallback
+	switch configHost.StrictHostKeyChecking {
+	case "no", "off":
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case "accept-new":
+		policy.prompt = nil
+	case "yes":
+		policy.strict = true
+	}
+	if cfg.StrictHostKeyChecking {
+		policy.strict = true
+	}
+	if hostKeyCallback == nil || policy.strict {
+		hostKeyCallback = createHostKeyCallback(policy)
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User:              hostInfo.User,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: parseHostKeyAlgorithms(configHost.HostKeyAlgorithms),
+		Timeout:           timeout,
+	}
+
+	// Every jump host authenticates like the target and verifies its own key
+	proxyJump, proxyCommand := cfg.ProxyJump, cfg.ProxyCommand
+	if proxyJump == "" {
+		proxyJump = configHost.ProxyJump
+	}
+	if proxyCommand == "" {
+		proxyCommand = configHost.ProxyCommand
+	}
+	jumpInfos, err := resolveJumpHosts(sshConfig, proxyJump, hostInfo.User)
+	if err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, err
+	}
+	var jumps []*jumpHost
+	for _, jump := range jumpInfos {
+		jumps = append(jumps, &jumpHost{
+			hostInfo: jump.hostInfo,
+			config: &ssh.ClientConfig{
+				User:            jump.hostInfo.User,
+				Auth:            buildAuthMethods(cfg, agentSigners, jump.identityFiles, configHost.IdentitiesOnly),
+				HostKeyCallback: hostKeyCallback,
+				Timeout:         timeout,
+			},
+		})
+	}
+	if len(jumps) > 0 {
+		// ProxyJump takes precedence, as in OpenSSH
+		proxyCommand = ""
+	}
+	if proxyCommand, err = expandProxyCommand(proxyCommand, hostInfo); err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, err
+	}
+
+	keepAliveInterval := cfg.KeepAliveInterval
+	if keepAliveInterval == 0 {
+		keepAliveInterval = configHost.ServerAliveInterval
+	}
+	if keepAliveInterval == 0 {
+		keepAliveInterval = defaultKeepAliveInterval
+	}
+
+	forwardAgent := configHost.ForwardAgent
+	if cfg.ForwardAgent != nil {
+		forwardAgent = *cfg.ForwardAgent
+	}
+
+	client := &Client{
+		hostInfo:          hostInfo,
+		sshConfig:         clientConfig,
+		agentConn:         agentConn,
+		persistentShell:   cfg.PersistentShell,
+		jumps:             jumps,
+		proxyCommand:      proxyCommand,
+		autoReconnect:     cfg.AutoReconnect == nil || *cfg.AutoReconnect,
+		onConnection:      cfg.OnConnectionChange,
+		keepAliveInterval: max(keepAliveInterval, 0),
+		keepAliveCountMax: configHost.ServerAliveCountMax,
+		forwardAgent:      forwardAgent,
+		sendEnv:           configHost.SendEnv,
+		sudo:              newSudoState(cfg.Prompt, cfg.SudoPasswordTimeout),
+	}
+	for _, forward := range configHost.LocalForward {
+		spec, err := ParseTunnelSpec(TunnelLocal, forward)
+		if err != nil {
+			client.warnings = append(client.warnings, fmt.Sprintf("ignoring LocalForward: %v", err))
+			continue
+		}
+		client.localForwards = append(client.localForwards, spec)
+	}
+	return client, nil
+}
+
+// buildAuthMethods returns the authentication methods for one host: a single
+// public key method with the agent, ssh_config, configured and default keys,
+// then password authentication if a password is set, then keyboard-interactive
+// authentication. With identitiesOnly the default keys are skipped.
+func buildAuthMethods(cfg *Config, agentSigners []ssh.Signer, identityFiles []string, identitiesOnly bool) []ssh.AuthMethod {
 	var authMethods []ssh.AuthMethod
-	var agentConn net.Conn
 
 	// Collect all signers into a single slice to avoid multiple auth attempts
 	// This prevents exceeding MaxAuthTries on the server side
-	var allSigners []ssh.Signer
+	allSigners := append([]ssh.Signer(nil), agentSigners...)
+	// Encrypted keys go last: a key whose passphrase is not given ends the
+	// public key method, so every other key should have been offered by then
+	var encryptedSigners []ssh.Signer
 
 	// Track already-tried key paths to avoid duplicates (O(1) lookup)
 	triedPaths := make(map[string]bool)
-
-	// Get signers from SSH agent first (highest priority)
-	agentSigners, conn := getAgentSigners()
-	if len(agentSigners) > 0 {
-		allSigners = append(allSigners, agentSigners...)
-		agentConn = conn
-	}
-
-	// Get signers from identity files in SSH config
-	for _, keyPath := range sshConfigIdentityFiles {
+	addKey := func(keyPath, passphrase string) {
 		if triedPaths[keyPath] {
-			continue
+			return
 		}
-		signer, err := loadPrivateKey(keyPath, "")
-		if err == nil {
-			allSigners = append(allSigners, signer)
-			triedPaths[keyPath] = true
+		signer, err := loadSigner(keyPath, passphrase, cfg.Prompt)
+		if err != nil {
+			return
 		}
+		triedPaths[keyPath] = true
+		if _, ok := signer.(*passphraseSigner); ok {
+			encryptedSigners = append(encryptedSigners, signer)
+			return
+		}
+		allSigners = append(allSigners, signer)
+	}
+
+	// Get signers from identity files in SSH config
+	for _, keyPath := range identityFiles {
+		addKey(keyPath, "")
 	}
 
 	// Get signer from specified key path
-	if cfg.PrivateKeyPath != "" && !triedPaths[cfg.PrivateKeyPath] {
-		signer, err := loadPrivateKey(cfg.PrivateKeyPath, cfg.PrivateKeyPassphrase)
-		if err == nil {
-			allSigners = append(allSigners, signer)
-			triedPaths[cfg.PrivateKeyPath] = true
-		}
+	if cfg.PrivateKeyPath != "" {
+		addKey(cfg.PrivateKeyPath, cfg.PrivateKeyPassphrase)
 	}
 
 	// Get signers from default SSH key paths
-	for _, keyPath := range GetDefaultKeyPaths() {
-		if triedPaths[keyPath] {
-			continue
-		}
-		signer, err := loadPrivateKey(keyPath, "")
-		if err == nil {
-			allSigners = append(allSigners, signer)
-			triedPaths[keyPath] = true
+	if !identitiesOnly {
+		for _, keyPath := range GetDefaultKeyPaths() {
+			addKey(keyPath, "")
 		}
 	}
+	allSigners = append(allSigners, encryptedSigners...)
 
 	// Add a single public key auth method with all signers combined
 	// This ensures all keys are tried within a single authentication attempt,
@@ -184,40 +397,24 @@ func NewClient(cfg *Config) (*Client, error) {
 		authMethods = append(authMethods, ssh.Password(cfg.Password))
 	}
 
-	if len(authMethods) == 0 {
-		// Close agent connection if we're not going to use it
-		if agentConn != nil {
-			agentConn.Close()
-		}
-		return nil, errors.New("at least one authentication method is required")
+	// Keyboard-interactive covers PAM passwords and one-time codes; the
+	// password, if set, answers the password question
+	if cfg.Password != "" || cfg.Prompt != nil {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractive(cfg.Password, cfg.Prompt)))
 	}
 
-	timeout := cfg.Timeout
-	if timeout == 0 {
-		timeout = 30 * time.Second
-	}
-
-	// Create host key callback using known_hosts file
-	hostKeyCallback := CreateHostKeyCallback(cfg.StrictHostKeyChecking)
-
-	sshConfig := &ssh.ClientConfig{
-		User:            hostInfo.User,
-		Auth:            authMethods,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         timeout,
-	}
-
-	return &Client{
-		hostInfo:  hostInfo,
-		sshConfig: sshConfig,
-		agentConn: agentConn,
-	}, nil
+	return authMethods
 }
 
 // applySSHConfig applies settings from SSH config file to the host info.
 // It returns the updated host info and identity files to try.
 func applySSHConfig(sshConfig *SSHConfig, hostInfo *HostInfo) (*HostInfo, []string) {
-	configHost := sshConfig.GetHost(hostInfo.Host)
+	return applyConfigHost(sshConfig.Resolve(hostInfo.Host, hostInfo.User), hostInfo)
+}
+
+// applyConfigHost applies the effective SSH config of a host to the host info.
+// Explicit settings in hostInfo take precedence.
+func applyConfigHost(configHost *SSHConfigHost, hostInfo *HostInfo) (*HostInfo, []string) {
 	if configHost == nil {
 		return hostInfo, nil
 	}
@@ -270,43 +467,74 @@ func getAgentSigners() ([]ssh.Signer, net.Conn) {
 	return signers, conn
 }
 
-// loadPrivateKey loads a private key from a file and returns an ssh.Signer.
-func loadPrivateKey(keyPath, passphrase string) (ssh.Signer, error) {
-	keyData, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key: %w", err)
-	}
-
-	var signer ssh.Signer
-	if passphrase != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(passphrase))
-	} else {
-		signer, err = ssh.ParsePrivateKey(keyData)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
-	}
-
-	return signer, nil
-}
-
 // Connect establishes the SSH connection.
 func (c *Client) Connect(_ context.Context) error {
 	if c.isConnected {
 		return nil
 	}
 
-	addr := fmt.Sprintf("%s:%d", c.hostInfo.Host, c.hostInfo.Port)
-	client, err := ssh.Dial("tcp", addr, c.sshConfig)
+	addr := net.JoinHostPort(c.hostInfo.Host, strconv.Itoa(c.hostInfo.Port))
+	client, err := c.dial(addr, c.sshConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
 
-	c.client = client
+	c.setClient(client)
 	c.isConnected = true
+
+	c.setupConnection(client)
+	for _, spec := range c.localForwards {
+		if _, err := c.AddTunnel(spec); err != nil {
+			c.warnings = append(c.warnings, fmt.Sprintf("LocalForward %s failed: %v", spec, err))
+		}
+	}
 	return nil
 }
 
+// newSession opens a session with the environment variables selected by
+// SendEnv and, if enabled, agent forwarding.
+func (c *Client) newSession() (*ssh.Session, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if c.forwardAgent {
+		_ = agent.RequestAgentForwarding(session)
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if matchEnvPatterns(c.sendEnv, name) {
+			// Servers only accept variables allowed by AcceptEnv
+			_ = session.Setenv(name, value)
+		}
+	}
+	return session, nil
+}
+
+// matchEnvPatterns reports whether the environment variable name is selected
+// by SendEnv patterns. A pattern starting with "-" removes earlier matches.
+func matchEnvPatterns(patterns []string, name string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "-") {
+			if matchHostPattern(pattern[1:], name) {
+				matched = false
+			}
+		} else if matchHostPattern(pattern, name) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// TakeWarnings returns the problems that did not stop the connection since
+// the last call, such as a LocalForward whose port is taken, and clears them.
+func (c *Client) TakeWarnings() []string {
+	warnings := c.warnings
+	c.warnings = nil
+	return warnings
+}
+
 // Close closes the SSH connection.
 func (c *Client) Close() error {
 	// Close agent connection if present
@@ -315,16 +543,40 @@ func (c *Client) Close() error {
 		c.agentConn = nil
 	}
 
+	if c.shell != nil {
+		_ = c.shell.Close()
+		c.shell = nil
+	}
+
+	if c.sftpClient != nil {
+		_ = c.sftpClient.Close()
+		c.sftpClient = nil
+	}
+
+	c.closeTunnels()
+
+	c.stopKeepAlive()
+
 	if !c.isConnected || c.client == nil {
+		c.closeJumps()
 		return nil
 	}
 	c.isConnected = false
-	return c.client.Close()
+	err := c.client.Close()
+	c.closeJumps()
+	return err
 }
 
-// IsConnected returns true if the client is connected.
+// IsConnected returns true if the client is connected and its link has not
+// been found dead, e.g. by a keepalive.
 func (c *Client) IsConnected() bool {
-	return c.isConnected && c.client != nil
+	return c.isConnected && c.client != nil && !c.linkLost()
+}
+
+// IsOpen reports whether the client still stands for its host: it is
+// connected, or its link was lost and the next command reconnects.
+func (c *Client) IsOpen() bool {
+	return c.isConnected && c.client != nil && (c.autoReconnect || !c.linkLost())
 }
 
 // ExecuteResult represents the result of a command execution.
@@ -337,12 +589,21 @@ type ExecuteResult struct {
 	ExitCode int
 	// Error contains any error that occurred during execution.
 	Error error
+	// Interrupted is set if the command was stopped because its context was
+	// cancelled; Error is then ErrInterrupted.
+	Interrupted bool
+	// TimedOut is set if the command was stopped because the deadline of its
+	// context passed; Error is then ErrTimedOut.
+	TimedOut bool
 }
 
 // Executor is the interface for command execution on local or remote hosts.
 type Executor interface {
 	// Execute runs a command and returns the result.
 	Execute(ctx context.Context, command string) *ExecuteResult
+	// ExecuteStream runs a command, writing its output to stdout and stderr
+	// as it arrives. The result holds the exit code and error only.
+	ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) *ExecuteResult
 	// ExecuteInteractive runs an interactive command with PTY support.
 	ExecuteInteractive(ctx context.Context, command string) error
 	// IsConnected returns true if the executor is ready.
@@ -353,91 +614,83 @@ type Executor interface {
 	HostInfoString() string
 }
 
-// Execute executes a command on the remote host.
-func (c *Client) Execute(_ context.Context, command string) *ExecuteResult {
+// Execute executes a command on the remote host and returns its complete
+// output.
+func (c *Client) Execute(ctx context.Context, command string) *ExecuteResult {
+	var stdout, stderr bytes.Buffer
+	result := c.ExecuteStream(ctx, command, &stdout, &stderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
+
+// ExecuteStream executes a command on the remote host, writing its output to
+// stdout and stderr as it arrives, so that long-running commands show
+// progress and large output need not be held in memory. The two writers may
+// be written to concurrently. The result holds the exit code and error only.
+func (c *Client) ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) *ExecuteResult {
 	result := &ExecuteResult{}
 
-	if !c.isConnected {
-		result.Error = errors.New("not connected")
+	if err := c.ensureConnected(ctx); err != nil {
+		result.Error = err
 		return result
 	}
 
-	// Handle cd command specially to track directory changes
-	command = strings.TrimSpace(command)
-	isCdCommand := strings.HasPrefix(command, "cd ") || command == "cd"
-
-	session, err := c.client.NewSession()
+	// A sudo password is fed to the command on stdin of its session, or
+	// written to the persistent shell along with the command
+	sudoPassword, err := c.sudo.passwordFor(ctx, command, c.hostInfo.User+"@"+c.hostInfo.Host, c.runWithStdin)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create session: %w", err)
+		if ctx.Err() != nil {
+			markStopped(ctx, result)
+		} else {
+			result.Error = err
+		}
 		return result
 	}
-	defer session.Close()
-
-	var stdout, stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
 
-	// Prepend cd to the command if we have a tracked working directory
-	fullCommand := command
-	if c.cwd != "" && !isCdCommand {
-		// For regular commands, cd to the tracked directory first
-		fullCommand = fmt.Sprintf("cd %s && %s", ShellEscape(c.cwd), command)
-	} else if isCdCommand {
-		// For cd commands, we need to track the new directory
-		// First, resolve the target directory on the remote host
-		target := strings.TrimSpace(strings.TrimPrefix(command, "cd"))
-		if target == "" {
-			target = "~"
-		}
-		
-		var resolveCmd string
-		if c.cwd != "" && target != "" && !strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "~") {
-			// Relative path - resolve from current directory
-			resolveCmd = fmt.Sprintf("cd %s && cd %s && pwd", ShellEscape(c.cwd), ShellEscape(target))
-		} else {
-			resolveCmd = fmt.Sprintf("cd %s && pwd", ShellEscape(target))
-		}
-		
-		err = session.Run(resolveCmd)
-		if err != nil {
-			result.Stdout = stdout.String()
-			result.Stderr = stderr.String()
-			var exitErr *ssh.ExitError
-			if errors.As(err, &exitErr) {
-				result.ExitCode = exitErr.ExitStatus()
-			} else {
-				result.Error = err
-			}
+	if c.persistentShell {
+		if result, ok := c.executeInShell(ctx, command, sudoPassword, stdout, stderr); ok {
 			return result
 		}
-		
-		// Update the tracked working directory
-		newCwd := strings.TrimSpace(stdout.String())
-		if newCwd != "" {
-			c.cwd = newCwd
-		}
-		return result
 	}
 
-	// Set TERM environment variable to ensure commands like 'clear' work properly.
-	// We prepend the export command to handle cases where the server doesn't accept
-	// environment variables via Setenv (depends on AcceptEnv in sshd_config).
-	termType := os.Getenv("TERM")
-	if termType == "" || !isValidTermType(termType) {
-		termType = "xterm-256color"
+	session, err := c.newSession()
+	if err != nil {
+		result.Error = fmt.Errorf("failed to create session: %w", err)
+		return result
 	}
-	// Use single quotes for additional shell safety, even though isValidTermType
-	// already ensures the value contains only safe characters.
-	fullCommand = fmt.Sprintf("export TERM='%s'; %s", termType, fullCommand)
+	defer session.Close()
 
-	err = session.Run(fullCommand)
-	result.Stdout = stdout.String()
-	result.Stderr = stderr.String()
+	// The command runs in the tracked directories and reports where it
+	// left them, so that cd, cd -, pushd and popd carry over to the next one
+	run := command
+	if sudoPassword != "" {
+		run = c.sudo.withPassword(command)
+		session.Stdin = strings.NewReader(sudoPassword + "\n")
+	}
+	script, dirs := c.dirState.track(run, stdout)
+	session.Stdout = dirs
+	session.Stderr = stderr
 
+	if err = session.Start(execScript(script)); err != nil {
+		result.Error = fmt.Errorf("failed to start command: %w", err)
+		return result
+	}
+	stopped, err := waitSession(ctx, session)
+	_ = dirs.Flush()
+	if stopped {
+		markStopped(ctx, result)
+		return result
+	}
+	if d, ok := dirs.dirs(); ok {
+		c.dirState = d
+	}
 	if err != nil {
 		var exitErr *ssh.ExitError
 		if errors.As(err, &exitErr) {
 			result.ExitCode = exitErr.ExitStatus()
+		} else if c.lostDuring(err) {
+			result.Error = connectionLostError(command)
 		} else {
 			result.Error = err
 		}
@@ -446,6 +699,124 @@ func (c *Client) Execute(_ context.Context, command string) *ExecuteResult {
 	return result
 }
 
+// execScript returns the command that runs a POSIX shell script in a
+// session. The login shell of the user, which may be csh or fish, only
+// execs sh with it.
+//
+// TERM is set to ensure commands like 'clear' work properly, also when the
+// server doesn't accept environment variables via Setenv (depends on
+// AcceptEnv in sshd_config). Single quotes are for additional shell safety,
+// even though isValidTermType already ensures the value contains only safe
+// characters.
+func execScript(script string) string {
+	return "exec sh -c " + ShellEscape(fmt.Sprintf("export TERM='%s'\n%s", getTermType(), script))
+}
+
+// runWithStdin runs a command in its own session with stdin and returns its
+// exit code. Its output is discarded.
+func (c *Client) runWithStdin(ctx context.Context, command, stdin string) (int, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(stdin)
+	if err := session.Start(command); err != nil {
+		return 0, fmt.Errorf("failed to start command: %w", err)
+	}
+	if stopped, err := waitSession(ctx, session); stopped {
+		return 0, ctx.Err()
+	} else if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitStatus(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// ForgetSudoPassword drops the sudo password kept for this host, if any.
+func (c *Client) ForgetSudoPassword() {
+	c.sudo.forget()
+}
+
+// SetCastRecorder records the output of interactive commands with open.
+// A nil open stops recording.
+func (c *Client) SetCastRecorder(open CastOpener) {
+	c.openCast = open
+}
+
+// executeInShell runs a command in the persistent shell, starting it if needed,
+// with sudoPassword for its sudo calls if it is set. It returns false if the
+// shell is unusable and the command should run in its own session instead.
+func (c *Client) executeInShell(ctx context.Context, command, sudoPassword string, stdout, stderr io.Writer) (*ExecuteResult, bool) {
+	if c.shellErr != nil {
+		return nil, false
+	}
+	if c.shell == nil {
+		session, err := c.newSession()
+		if err != nil {
+			c.shellErr = fmt.Errorf("failed to create session: %w", err)
+			return nil, false
+		}
+		shell, err := startPersistentShell(session, c.dirState, func(command string) error {
+			_, err := c.runWithStdin(context.Background(), command, "")
+			return err
+		})
+		if err != nil {
+			c.shellErr = err
+			return nil, false
+		}
+		c.shell = shell
+	} else {
+		// The directories may have been changed outside of the shell
+		c.shell.enter(c.dirState)
+	}
+	if sudoPassword != "" {
+		c.shell.runAround(c.sudo.inShell(sudoPassword))
+	}
+
+	result, dirs, err := c.shell.RunStream(ctx, command, stdout, stderr)
+	if err != nil && !c.shell.Exited() {
+		// The command was stopped; the shell and its state remain
+		markStopped(ctx, result)
+	} else if err != nil {
+		// The shell is gone, e.g. after "exit" or because a stopped command
+		// did not end; the next command starts a new one in the same directory
+		_ = c.shell.Close()
+		c.shell = nil
+		if result == nil {
+			result = &ExecuteResult{}
+		}
+		result.Error = err
+		if ctx.Err() != nil {
+			markStopped(ctx, result)
+		} else if c.lostDuring(err) {
+			result.Error = connectionLostError(command)
+		}
+		return result, true
+	}
+	if dirs.cwd != "" {
+		c.dirState = dirs
+	}
+	return result, true
+}
+
+// ShellMode describes how commands are run: "persistent" in one long-lived
+// shell, or "exec" in one session per command. If the persistent shell was
+// requested but is unusable, the reason is included.
+func (c *Client) ShellMode() string {
+	switch {
+	case !c.persistentShell:
+		return "exec"
+	case c.shellErr != nil:
+		return fmt.Sprintf("exec (persistent shell unavailable: %v)", c.shellErr)
+	default:
+		return "persistent"
+	}
+}
+
 // GetCwd returns the current working directory on the remote host.
 func (c *Client) GetCwd() string {
 	return c.cwd
@@ -453,12 +824,12 @@ func (c *Client) GetCwd() string {
 
 // ExecuteInteractive executes an interactive command (like top, htop) on the remote host
 // with PTY support. It connects the command's stdin/stdout/stderr to the current terminal.
-func (c *Client) ExecuteInteractive(_ context.Context, command string) error {
-	if !c.isConnected {
-		return errors.New("not connected")
+func (c *Client) ExecuteInteractive(ctx context.Context, command string) error {
+	if err := c.ensureConnected(ctx); err != nil {
+		return err
 	}
 
-	session, err := c.client.NewSession()
+	session, err := c.newSession()
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -493,6 +864,15 @@ func (c *Client) ExecuteInteractive(_ context.Context, command string) error {
 	// Set up stdin/stdout/stderr
 	session.Stdout = os.Stdout
 	session.Stderr = os.Stderr
+	var cast *CastWriter
+	if c.openCast != nil {
+		cast = c.openCast(command, width, height)
+	}
+	if cast != nil {
+		defer cast.Close()
+		session.Stdout = io.MultiWriter(os.Stdout, cast)
+		session.Stderr = io.MultiWriter(os.Stderr, cast)
+	}
 
 	stdin, err := session.StdinPipe()
 	if err != nil {
@@ -520,6 +900,9 @@ func (c *Client) ExecuteInteractive(_ context.Context, command string) error {
 				w, h, err := term.GetSize(fd)
 				if err == nil {
 					_ = session.WindowChange(h, w)
+					if cast != nil {
+						cast.Resize(w, h)
+					}
 				}
 			}
 		}
@@ -550,6 +933,9 @@ func (c *Client) ExecuteInteractive(_ context.Context, command string) error {
 			// Command exited with non-zero status, but that's not necessarily an error
 			return nil
 		}
+		if c.lostDuring(err) {
+			return connectionLostError(command)
+		}
 		return err
 	}
 
@@ -597,6 +983,11 @@ func (c *Client) AddPublicKeyToAuthorizedKeys(ctx context.Context, publicKeyPath
 	return nil
 }
 
+// HostInfo returns the remote host information.
+func (c *Client) HostInfo() *HostInfo {
+	return c.hostInfo
+}
+
 // HostInfoString returns a string representation of the host info.
 func (c *Client) HostInfoString() string {
 	if c.hostInfo == nil {
@@ -617,6 +1008,16 @@ func GetDefaultKeyPaths() []string {
 	}
 }
 
+// getTermType returns the local TERM value if it is safe to pass to the remote
+// shell, or xterm-256color.
+func getTermType() string {
+	termType := os.Getenv("TERM")
+	if termType == "" || !isValidTermType(termType) {
+		termType = "xterm-256color"
+	}
+	return termType
+}
+
 // isValidTermType validates that a TERM value contains only safe characters.
 // Valid TERM values should only contain alphanumeric characters, hyphens, and underscores.
 // This prevents potential command injection through malicious TERM values.
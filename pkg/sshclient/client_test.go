@@ -17,6 +17,7 @@ package sshclient
 import (
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -60,11 +61,11 @@ func TestGetAgentSigners(t *testing.T) {
 	}
 }
 
-func TestLoadPrivateKey(t *testing.T) {
+func TestLoadSigner(t *testing.T) {
 	// Test with non-existent file
-	_, err := loadPrivateKey("/nonexistent/path/to/key", "")
+	_, err := loadSigner("/nonexistent/path/to/key", "", nil)
 	if err == nil {
-		t.Error("loadPrivateKey should return error for non-existent file")
+		t.Error("loadSigner should return error for non-existent file")
 	}
 	if !strings.Contains(err.Error(), "failed to read private key") {
 		t.Errorf("Unexpected error message: %v", err)
@@ -319,3 +320,35 @@ func TestIsValidTermType(t *testing.T) {
 		})
 	}
 }
+
+// TestExecScript runs a tracked command the way a session does, through
+// each login shell that is installed.
+func TestExecScript(t *testing.T) {
+	t.Setenv("TERM", "vt100")
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shell := range []string{"sh", "bash", "dash", "zsh", "csh", "tcsh", "fish"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			continue
+		}
+		t.Run(shell, func(t *testing.T) {
+			var stdout strings.Builder
+			script, dirs := dirState{cwd: dir}.track("echo \"$TERM\" 'it'\\''s'; cd ..", &stdout)
+			cmd := exec.Command(shell, "-c", execScript(script))
+			var stderr strings.Builder
+			cmd.Stdout, cmd.Stderr = dirs, &stderr
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("%s -c execScript() error = %v: %s", shell, err, stderr.String())
+			}
+			_ = dirs.Flush()
+			if stdout.String() != "vt100 it's\n" {
+				t.Errorf("stdout = %q, want %q", stdout.String(), "vt100 it's\n")
+			}
+			if d, ok := dirs.dirs(); !ok || d.cwd != filepath.Dir(dir) || d.oldpwd != dir {
+				t.Errorf("dirs() = %+v, %v; want %s after leaving %s", d, ok, filepath.Dir(dir), dir)
+			}
+		})
+	}
+}
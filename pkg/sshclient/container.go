@@ -0,0 +1,290 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Container runtimes.
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeKubernetes = "kubectl"
+)
+
+// ContainerTarget is a container to run commands in: a Docker or Podman
+// container, or a container of a Kubernetes pod.
+type ContainerTarget struct {
+	// Runtime is docker, podman or kubectl; empty picks docker or podman,
+	// whichever is installed.
+	Runtime string
+	// Name is the container or pod name.
+	Name string
+	// Namespace is the namespace of a pod; empty uses the current one.
+	Namespace string
+	// Container is the container of a pod; empty uses its default one.
+	Container string
+}
+
+// String returns the target as it is written to connect to it.
+func (t ContainerTarget) String() string {
+	switch t.Runtime {
+	case RuntimeKubernetes:
+		s := "pod:" + t.Name
+		if t.Namespace != "" {
+			s = "pod:" + t.Namespace + "/" + t.Name
+		}
+		if t.Container != "" {
+			s += ":" + t.Container
+		}
+		return s
+	case "":
+		return "container:" + t.Name
+	default:
+		return t.Runtime + ":" + t.Name
+	}
+}
+
+// containerNameRe matches names of containers, pods and namespaces.
+var containerNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ParseContainerTarget parses container:<name>, docker:<name>,
+// podman:<name> and pod:[<namespace>/]<name>[:<container>]. ok is false if
+// spec names no container.
+func ParseContainerTarget(spec string) (target *ContainerTarget, ok bool, err error) {
+	kind, rest, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found {
+		return nil, false, nil
+	}
+	target = &ContainerTarget{}
+	switch strings.ToLower(kind) {
+	case "container":
+	case "docker":
+		target.Runtime = RuntimeDocker
+	case "podman":
+		target.Runtime = RuntimePodman
+	case "pod", "k8s", "kube":
+		target.Runtime = RuntimeKubernetes
+		if ns, name, found := strings.Cut(rest, "/"); found {
+			target.Namespace, rest = ns, name
+		}
+		if name, container, found := strings.Cut(rest, ":"); found {
+			rest, target.Container = name, container
+			if !containerNameRe.MatchString(container) {
+				return nil, true, fmt.Errorf("invalid container name: %q", container)
+			}
+		}
+		if target.Namespace != "" && !containerNameRe.MatchString(target.Namespace) {
+			return nil, true, fmt.Errorf("invalid namespace: %q", target.Namespace)
+		}
+	default:
+		return nil, false, nil
+	}
+	if !containerNameRe.MatchString(rest) {
+		return nil, true, fmt.Errorf("invalid %s name: %q", strings.ToLower(kind), rest)
+	}
+	target.Name = rest
+	return target, true, nil
+}
+
+// ContainerClient runs commands in a container with docker exec, podman
+// exec or kubectl exec, on the host of another executor: the local host or
+// the connected one. Stopping a command stops the exec client; whether the
+// process in the container goes with it depends on the runtime.
+type ContainerClient struct {
+	host   Executor
+	target ContainerTarget
+	mu     sync.Mutex
+	dirState
+}
+
+// NewContainerClient checks that the container of target is running on the
+// host of host and has a shell, and returns a client for it. A target
+// without a runtime uses docker, or podman if docker is not installed.
+func NewContainerClient(ctx context.Context, host Executor, target ContainerTarget) (*ContainerClient, error) {
+	if target.Runtime == "" {
+		out, err := runCaptured(withoutSudo(ctx), host, "command -v docker >/dev/null 2>&1 && echo docker || { command -v podman >/dev/null 2>&1 && echo podman; }")
+		if err != nil {
+			return nil, err
+		}
+		if target.Runtime = strings.TrimSpace(out); target.Runtime == "" {
+			return nil, fmt.Errorf("neither docker nor podman is installed on %s", host.HostInfoString())
+		}
+	}
+	c := &ContainerClient{host: host, target: target}
+
+	state, err := runCaptured(withoutSudo(ctx), host, c.stateCommand())
+	if err != nil {
+		return nil, fmt.Errorf("cannot find %s: %w", target, err)
+	}
+	if state = strings.TrimSpace(state); state != "true" && state != "Running" {
+		return nil, fmt.Errorf("%s is not running (%s)", target, state)
+	}
+
+	result := c.Execute(ctx, "pwd")
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("cannot run a shell in %s: %s", target, strings.TrimSpace(result.Stderr))
+	}
+	c.cwd = strings.TrimSpace(result.Stdout)
+	return c, nil
+}
+
+// stateCommand prints whether the container is running: true for docker
+// and podman, Running for a pod.
+func (c *ContainerClient) stateCommand() string {
+	t := c.target
+	if t.Runtime == RuntimeKubernetes {
+		return "kubectl get pod " + c.namespaceArg() + ShellEscape(t.Name) + " -o jsonpath='{.status.phase}'"
+	}
+	return t.Runtime + " inspect -f '{{.State.Running}}' " + ShellEscape(t.Name)
+}
+
+func (c *ContainerClient) namespaceArg() string {
+	if c.target.Namespace == "" {
+		return ""
+	}
+	return "-n " + ShellEscape(c.target.Namespace) + " "
+}
+
+// execCommand returns the host command that runs command in the container
+// with sh. With stdin, the input of the host command is passed on to it,
+// and with tty, it gets a terminal as well.
+func (c *ContainerClient) execCommand(command string, stdin, tty bool) string {
+	t := c.target
+	var sb strings.Builder
+	if t.Runtime == RuntimeKubernetes {
+		sb.WriteString("kubectl exec ")
+		if tty {
+			sb.WriteString("-it ")
+		} else if stdin {
+			sb.WriteString("-i ")
+		}
+		sb.WriteString(c.namespaceArg())
+		sb.WriteString(ShellEscape(t.Name))
+		if t.Container != "" {
+			sb.WriteString(" -c " + ShellEscape(t.Container))
+		}
+		sb.WriteString(" --")
+	} else {
+		sb.WriteString(t.Runtime + " exec ")
+		if tty {
+			sb.WriteString("-it -e TERM=" + ShellEscape(getTermType()) + " ")
+		} else if stdin {
+			sb.WriteString("-i ")
+		}
+		sb.WriteString(ShellEscape(t.Name))
+	}
+	sb.WriteString(" sh -c " + ShellEscape(command))
+	return sb.String()
+}
+
+// Execute runs a command in the container and returns its complete output.
+func (c *ContainerClient) Execute(ctx context.Context, command string) *ExecuteResult {
+	var stdout, stderr bytes.Buffer
+	result := c.ExecuteStream(ctx, command, &stdout, &stderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
+
+// ExecuteStream runs a command in the container, writing its output to
+// stdout and stderr as it arrives. Directory changes, like those of cd,
+// cd - and pushd, carry over to later commands.
+func (c *ContainerClient) ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) *ExecuteResult {
+	// sudo in the command is for the container, not for the host
+	ctx = withoutSudo(ctx)
+	c.mu.Lock()
+	script, dirs := c.dirState.track(command, stdout)
+	c.mu.Unlock()
+
+	result := c.host.ExecuteStream(ctx, c.execCommand(script, false, false), dirs, stderr)
+	_ = dirs.Flush()
+	if result.Error == nil {
+		if d, ok := dirs.dirs(); ok {
+			c.mu.Lock()
+			c.dirState = d
+			c.mu.Unlock()
+		}
+	}
+	return result
+}
+
+// ExecuteInteractive runs a command in the container with a terminal, in
+// the tracked working directory.
+func (c *ContainerClient) ExecuteInteractive(ctx context.Context, command string) error {
+	if cwd := c.GetCwd(); cwd != "" {
+		command = "cd " + ShellEscape(cwd) + " && " + command
+	}
+	return c.host.ExecuteInteractive(ctx, c.execCommand(command, true, true))
+}
+
+// runWithStdin runs a command in the container, in the tracked working
+// directory, with stdin as its input and returns its exit code.
+func (c *ContainerClient) runWithStdin(ctx context.Context, command, stdin string) (int, error) {
+	runner, ok := c.host.(stdinRunner)
+	if !ok {
+		return 0, fmt.Errorf("cannot pass input to commands on %s", c.host.HostInfoString())
+	}
+	if cwd := c.GetCwd(); cwd != "" {
+		command = "cd " + ShellEscape(cwd) + " && " + command
+	}
+	return runner.runWithStdin(withoutSudo(ctx), c.execCommand(command, true, false), stdin)
+}
+
+// IsConnected reports whether the host of the container is reachable.
+func (c *ContainerClient) IsConnected() bool {
+	return c.host.IsConnected()
+}
+
+// Close forgets the container; the connection to its host stays open.
+func (c *ContainerClient) Close() error {
+	return nil
+}
+
+// HostInfoString returns the container, and the host it runs on if that is
+// not the local one.
+func (c *ContainerClient) HostInfoString() string {
+	if _, local := c.host.(*LocalClient); local {
+		return c.target.String()
+	}
+	return c.target.String() + " on " + c.host.HostInfoString()
+}
+
+// Target returns the container commands run in.
+func (c *ContainerClient) Target() ContainerTarget {
+	return c.target
+}
+
+// Host returns the executor of the host the container runs on.
+func (c *ContainerClient) Host() Executor {
+	return c.host
+}
+
+// GetCwd returns the working directory in the container.
+func (c *ContainerClient) GetCwd() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwd
+}
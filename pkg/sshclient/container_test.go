@@ -0,0 +1,184 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseContainerTarget(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ContainerTarget
+		notOK   bool
+		wantErr bool
+	}{
+		{spec: "container:web-1", want: ContainerTarget{Name: "web-1"}},
+		{spec: "docker:web-1", want: ContainerTarget{Runtime: RuntimeDocker, Name: "web-1"}},
+		{spec: "podman:db", want: ContainerTarget{Runtime: RuntimePodman, Name: "db"}},
+		{spec: "pod:api-7d9f", want: ContainerTarget{Runtime: RuntimeKubernetes, Name: "api-7d9f"}},
+		{spec: "pod:prod/api-7d9f", want: ContainerTarget{Runtime: RuntimeKubernetes, Namespace: "prod", Name: "api-7d9f"}},
+		{spec: "pod:prod/api-7d9f:sidecar", want: ContainerTarget{Runtime: RuntimeKubernetes, Namespace: "prod", Name: "api-7d9f", Container: "sidecar"}},
+		{spec: "web1", notOK: true},
+		{spec: "web1:2222", notOK: true},
+		{spec: "root@web1:22", notOK: true},
+		{spec: "container:", wantErr: true},
+		{spec: "pod:prod/", wantErr: true},
+		{spec: "docker:web;rm", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, ok, err := ParseContainerTarget(tt.spec)
+		if ok == tt.notOK {
+			t.Errorf("ParseContainerTarget(%q) ok = %v, want %v", tt.spec, ok, !tt.notOK)
+			continue
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseContainerTarget(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if ok && err == nil {
+			if *got != tt.want {
+				t.Errorf("ParseContainerTarget(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+			}
+			if again, _, _ := ParseContainerTarget(got.String()); *again != *got {
+				t.Errorf("String() = %q does not parse back to %+v", got.String(), *got)
+			}
+		}
+	}
+}
+
+func TestContainerExecCommand(t *testing.T) {
+	docker := &ContainerClient{target: ContainerTarget{Runtime: RuntimeDocker, Name: "web-1"}}
+	if got, want := docker.execCommand("cd '/app' && ls -l", false, false), "docker exec 'web-1' sh -c 'cd '\\''/app'\\'' && ls -l'"; got != want {
+		t.Errorf("docker execCommand() = %s, want %s", got, want)
+	}
+	pod := &ContainerClient{target: ContainerTarget{Runtime: RuntimeKubernetes, Namespace: "prod", Name: "api", Container: "app"}}
+	if got, want := pod.execCommand("top", true, true), "kubectl exec -it -n 'prod' 'api' -c 'app' -- sh -c 'top'"; got != want {
+		t.Errorf("kubectl execCommand() = %s, want %s", got, want)
+	}
+	if got, want := pod.execCommand("cat > /tmp/x", true, false), "kubectl exec -i -n 'prod' 'api' -c 'app' -- sh -c 'cat > /tmp/x'"; got != want {
+		t.Errorf("kubectl execCommand() with stdin = %s, want %s", got, want)
+	}
+}
+
+// fakeDocker is a docker that runs "exec" commands on the local host in
+// the directory $CONTAINER_ROOT, and reports every container as running.
+const fakeDocker = `#!/bin/sh
+case "$1" in
+inspect) echo true ;;
+exec)
+	shift
+	while [ $# -gt 0 ]; do
+		case "$1" in -e) shift 2 ;; -*) shift ;; *) break ;; esac
+	done
+	shift
+	cd "$CONTAINER_ROOT" && exec "$@"
+	;;
+esac
+`
+
+func TestContainerClient(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CONTAINER_ROOT", root)
+
+	ctx := context.Background()
+	client, err := NewContainerClient(ctx, NewLocalClient(), ContainerTarget{Name: "web-1"})
+	if err != nil {
+		t.Fatalf("NewContainerClient() error = %v", err)
+	}
+	if client.Target().Runtime != RuntimeDocker || client.GetCwd() != root {
+		t.Errorf("client = %+v in %s, want docker in %s", client.Target(), client.GetCwd(), root)
+	}
+	if got := client.HostInfoString(); got != "docker:web-1" {
+		t.Errorf("HostInfoString() = %q", got)
+	}
+
+	if result := client.Execute(ctx, "cd etc"); result.ExitCode != 0 || client.GetCwd() != filepath.Join(root, "etc") {
+		t.Fatalf("cd etc: exit %d, cwd %s, stderr %q", result.ExitCode, client.GetCwd(), result.Stderr)
+	}
+	if result := client.Execute(ctx, "pwd"); strings.TrimSpace(result.Stdout) != filepath.Join(root, "etc") {
+		t.Errorf("pwd after cd = %q", result.Stdout)
+	}
+	if result := client.Execute(ctx, "cd missing"); result.ExitCode == 0 || client.GetCwd() != filepath.Join(root, "etc") {
+		t.Errorf("cd missing: exit %d, cwd %s; want a failure that keeps the directory", result.ExitCode, client.GetCwd())
+	}
+	if result := client.Execute(ctx, "echo out; echo err >&2; exit 3"); result.Stdout != "out\n" || result.Stderr != "err\n" || result.ExitCode != 3 {
+		t.Errorf("Execute() = %+v", result)
+	}
+
+	if _, err := NewContainerClient(ctx, NewLocalClient(), ContainerTarget{Runtime: RuntimePodman, Name: "db"}); err == nil {
+		t.Error("NewContainerClient() with a missing runtime succeeded")
+	}
+}
+
+// TestContainerEditFile edits a file in a container that has no sudo. The
+// file is not owned by the user when the test runs as root, which would
+// need sudo on a host.
+func TestContainerEditFile(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\necho 'sudo: not found' >&2\nexit 127\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	p := filepath.Join(root, "app.conf")
+	if err := os.WriteFile(p, []byte("port 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() == 0 {
+		if err := os.Chown(p, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CONTAINER_ROOT", root)
+
+	ctx := context.Background()
+	client, err := NewContainerClient(ctx, NewLocalClient(), ContainerTarget{Runtime: RuntimeDocker, Name: "web-1"})
+	if err != nil {
+		t.Fatalf("NewContainerClient() error = %v", err)
+	}
+	file, err := OpenEditFile(ctx, client, "app.conf")
+	if err != nil {
+		t.Fatalf("OpenEditFile() error = %v", err)
+	}
+	if file.Path != p || string(file.Data) != "port 80\n" || file.Sudo {
+		t.Fatalf("OpenEditFile() = path %q data %q sudo %v", file.Path, file.Data, file.Sudo)
+	}
+	backup, err := file.Save(ctx, []byte("port 8080\n"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, _ := os.ReadFile(p); string(got) != "port 8080\n" {
+		t.Errorf("file = %q after Save, want %q", got, "port 8080\n")
+	}
+	if got, _ := os.ReadFile(backup); string(got) != "port 80\n" {
+		t.Errorf("backup = %q, want the old content", got)
+	}
+}
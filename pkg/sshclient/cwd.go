@@ -0,0 +1,218 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// dirState is where the commands of an executor run that does not keep a
+// shell between them: the working directory, the previous one for "cd -"
+// and the directory stack of pushd and popd.
+type dirState struct {
+	cwd    string
+	oldpwd string
+	stack  []string // top first
+}
+
+// dirFunctions make cd report a missing directory the same way in every
+// shell, and provide pushd, popd and dirs on a stack that is carried from
+// one command to the next, also in shells without them.
+const dirFunctions = `__sherlock_nl='
+'
+cd() {
+	if [ $# -eq 1 ] && [ "${1#-}" = "$1" ] && { [ -z "$CDPATH" ] || [ "${1#/}" != "$1" ]; } && [ ! -d "$1" ]; then
+		if [ -e "$1" ]; then
+			printf 'cd: %s: Not a directory\n' "$1" >&2
+		else
+			printf 'cd: %s: No such file or directory\n' "$1" >&2
+		fi
+		return 1
+	fi
+	command cd "$@"
+}
+dirs() {
+	(IFS=$__sherlock_nl; set -f; printf '%s' "$PWD"; for d in $__sherlock_dirs; do printf ' %s' "$d"; done; echo)
+}
+pushd() {
+	if [ $# -eq 0 ]; then
+		if [ -z "$__sherlock_dirs" ]; then
+			echo 'pushd: no other directory' >&2
+			return 1
+		fi
+		set -- "${__sherlock_dirs%%"$__sherlock_nl"*}"
+		cd "$1" || return
+		__sherlock_dirs=$OLDPWD${__sherlock_dirs#"$1"}
+	else
+		cd "$1" || return
+		__sherlock_dirs=$OLDPWD${__sherlock_dirs:+$__sherlock_nl$__sherlock_dirs}
+	fi
+	dirs
+}
+popd() {
+	if [ -z "$__sherlock_dirs" ]; then
+		echo 'popd: directory stack empty' >&2
+		return 1
+	fi
+	set -- "${__sherlock_dirs%%"$__sherlock_nl"*}"
+	cd "$1" || return
+	__sherlock_dirs=${__sherlock_dirs#"$1"}
+	__sherlock_dirs=${__sherlock_dirs#"$__sherlock_nl"}
+	dirs
+}
+`
+
+// restore returns a command list that puts a shell in d; it fails if the
+// working directory cannot be entered.
+func (d dirState) restore() string {
+	s := "__sherlock_dirs=" + ShellEscape(strings.Join(d.stack, "\n"))
+	if d.cwd != "" {
+		s += "; cd " + ShellEscape(d.cwd)
+	}
+	if d.oldpwd != "" {
+		s += " && OLDPWD=" + ShellEscape(d.oldpwd)
+	}
+	return s
+}
+
+// dirReport prints the working directory, the previous one and the
+// directory stack of the shell, one per line.
+const dirReport = `printf '%s\n%s\n' "$PWD" "$OLDPWD"
+[ -z "$__sherlock_dirs" ] || printf '%s\n' "$__sherlock_dirs"
+`
+
+// parseDirs reads the lines printed by dirReport, and returns false if they
+// are not a report.
+func parseDirs(lines []string) (dirState, bool) {
+	if len(lines) < 2 || !path.IsAbs(lines[0]) {
+		return dirState{}, false
+	}
+	d := dirState{cwd: lines[0], oldpwd: lines[1]}
+	if len(lines) > 2 {
+		d.stack = lines[2:]
+	}
+	return d, true
+}
+
+// equal reports whether d and other are the same directories.
+func (d dirState) equal(other dirState) bool {
+	return d.cwd == other.cwd && d.oldpwd == other.oldpwd && slices.Equal(d.stack, other.stack)
+}
+
+// script returns a POSIX shell script that runs command in d and then
+// prints marker, followed by the working directory, the previous one and
+// the directory stack it left the shell in, one per line, on stdout. The
+// exit status of the script is that of command. A command that exits the
+// shell, or a working directory that is gone, leaves no marker.
+func (d dirState) script(command, marker string) string {
+	var sb strings.Builder
+	sb.WriteString(dirFunctions)
+	sb.WriteString(d.restore() + " || exit\n")
+	// eval keeps a syntax error in command from ending the script early
+	sb.WriteString("command eval " + ShellEscape(command) + "\n")
+	sb.WriteString("__sherlock_rc=$?\n")
+	sb.WriteString("echo " + marker + "\n")
+	sb.WriteString(dirReport)
+	sb.WriteString("exit $__sherlock_rc\n")
+	return sb.String()
+}
+
+// track returns the script that runs command in d, and a writer for its
+// stdout that passes the output of command on to stdout and reads where it
+// left the shell.
+func (d dirState) track(command string, stdout io.Writer) (string, *dirWriter) {
+	marker := newMarker()
+	return d.script(command, marker), &dirWriter{w: stdout, marker: []byte(marker)}
+}
+
+// dirWriter passes output on to w up to a marker, and keeps what follows
+// it. Output that may be the start of the marker is held back until more
+// arrives or Flush is called. It is safe for concurrent use.
+type dirWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	marker  []byte
+	pending []byte
+	found   bool
+	trailer bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (w *dirWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.found {
+		w.trailer.Write(p)
+		return len(p), nil
+	}
+	buf := append(w.pending, p...)
+	w.pending = nil
+	if i := bytes.Index(buf, w.marker); i >= 0 {
+		w.found = true
+		w.trailer.Write(buf[i+len(w.marker):])
+		return len(p), w.write(buf[:i])
+	}
+	keep := 0
+	for n := min(len(w.marker)-1, len(buf)); n > 0; n-- {
+		if bytes.HasSuffix(buf, w.marker[:n]) {
+			keep = n
+			break
+		}
+	}
+	w.pending = append([]byte(nil), buf[len(buf)-keep:]...)
+	return len(p), w.write(buf[:len(buf)-keep])
+}
+
+func (w *dirWriter) write(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(p)
+	return err
+}
+
+// Flush passes on output held back because it looked like the start of
+// the marker.
+func (w *dirWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.pending
+	w.pending = nil
+	return w.write(pending)
+}
+
+// tail returns what followed the marker so far, and false if the marker
+// has not been seen.
+func (w *dirWriter) tail() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.trailer.String(), w.found
+}
+
+// dirs returns where the command left the shell, and false if it did not
+// get as far as printing the marker.
+func (w *dirWriter) dirs() (dirState, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.found {
+		return dirState{}, false
+	}
+	return parseDirs(strings.Split(strings.TrimSuffix(strings.TrimPrefix(w.trailer.String(), "\n"), "\n"), "\n"))
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDirWriter(t *testing.T) {
+	const marker = "__MARK__"
+	tests := []struct {
+		name     string
+		writes   []string
+		wantOut  string
+		wantDirs *dirState
+	}{
+		{
+			name:     "marker on its own line",
+			writes:   []string{"hello\n", "__MARK__\n/srv\n/tmp\n"},
+			wantOut:  "hello\n",
+			wantDirs: &dirState{cwd: "/srv", oldpwd: "/tmp"},
+		},
+		{
+			name:     "marker after output without newline",
+			writes:   []string{"partial__MARK__\n/srv\n\n"},
+			wantOut:  "partial",
+			wantDirs: &dirState{cwd: "/srv"},
+		},
+		{
+			name:     "marker split across writes",
+			writes:   []string{"out\n__MA", "RK", "__\n/a\n/b\n/c\n/d\n"},
+			wantOut:  "out\n",
+			wantDirs: &dirState{cwd: "/a", oldpwd: "/b", stack: []string{"/c", "/d"}},
+		},
+		{
+			name:    "start of marker that is not one",
+			writes:  []string{"a __MA", "X\n"},
+			wantOut: "a __MAX\n",
+		},
+		{
+			name:    "no marker",
+			writes:  []string{"exited __MAR"},
+			wantOut: "exited __MAR",
+		},
+		{
+			name:    "malformed trailer",
+			writes:  []string{"__MARK__\nrelative\n/b\n"},
+			wantOut: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := &dirWriter{w: &out, marker: []byte(marker)}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOut)
+			}
+			d, ok := w.dirs()
+			if ok != (tt.wantDirs != nil) {
+				t.Fatalf("dirs() ok = %v, want %v", ok, tt.wantDirs != nil)
+			}
+			if ok && !reflect.DeepEqual(d, *tt.wantDirs) {
+				t.Errorf("dirs() = %+v, want %+v", d, *tt.wantDirs)
+			}
+		})
+	}
+}
+
+// TestExecutorDirs runs the same directory changes on the local host and
+// in a container, which must track them alike.
+func TestExecutorDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b", "with space"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "setdir.sh"), []byte("cd 'with space'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CONTAINER_ROOT", root)
+	t.Setenv("HOME", root)
+	t.Setenv("CDPATH", "")
+
+	at := func(dir string) string { return filepath.Join(root, dir) }
+	steps := []struct {
+		command    string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+		wantCwd    string
+	}{
+		{command: "cd " + ShellEscape(root), wantCwd: root},
+		{command: "cd a && pwd", wantStdout: at("a") + "\n", wantCwd: at("a")},
+		{command: "cd -", wantStdout: root + "\n", wantCwd: root},
+		{command: "cd $HOME/b", wantCwd: at("b")},
+		{command: "pushd ../a", wantStdout: at("a") + " " + at("b") + "\n", wantCwd: at("a")},
+		{command: "pushd", wantStdout: at("b") + " " + at("a") + "\n", wantCwd: at("b")},
+		{command: "popd", wantStdout: at("a") + "\n", wantCwd: at("a")},
+		{command: "popd", wantCode: 1, wantStderr: "popd: directory stack empty", wantCwd: at("a")},
+		{command: "cd .. && . ./setdir.sh", wantCwd: at("with space")},
+		{command: "cd missing; echo after", wantStdout: "after\n", wantStderr: "No such file or directory", wantCwd: at("with space")},
+		{command: "printf partial", wantStdout: "partial", wantCwd: at("with space")},
+		{command: "cd / && exit 4", wantCode: 4, wantCwd: at("with space")},
+	}
+
+	ctx := context.Background()
+	container, err := NewContainerClient(ctx, NewLocalClient(), ContainerTarget{Runtime: RuntimeDocker, Name: "web-1"})
+	if err != nil {
+		t.Fatalf("NewContainerClient() error = %v", err)
+	}
+	executors := []struct {
+		name string
+		e    interface {
+			Executor
+			GetCwd() string
+		}
+	}{
+		{name: "local", e: NewLocalClient()},
+		{name: "container", e: container},
+	}
+
+	for _, tt := range executors {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, step := range steps {
+				result := tt.e.Execute(ctx, step.command)
+				if result.Error != nil {
+					t.Fatalf("%s: error = %v", step.command, result.Error)
+				}
+				if result.ExitCode != step.wantCode {
+					t.Errorf("%s: exit code = %d, want %d (stderr %q)", step.command, result.ExitCode, step.wantCode, result.Stderr)
+				}
+				if result.Stdout != step.wantStdout {
+					t.Errorf("%s: stdout = %q, want %q", step.command, result.Stdout, step.wantStdout)
+				}
+				if !strings.Contains(result.Stderr, step.wantStderr) {
+					t.Errorf("%s: stderr = %q, want it to contain %q", step.command, result.Stderr, step.wantStderr)
+				}
+				if got := tt.e.GetCwd(); got != step.wantCwd {
+					t.Errorf("%s: cwd = %s, want %s", step.command, got, step.wantCwd)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// MaxEditSize is the size of the largest file OpenEditFile opens.
+const MaxEditSize = 10 << 20
+
+// editProbe prints the absolute path of the file $f, its mode, owner, group
+// and size, and whether it can be read and replaced without sudo. Replacing
+// it without sudo needs write access to its directory, and owning it, so
+// that the new file can be given the same owner. A symlink is resolved, so
+// that the file it points to is edited and the link stays in place.
+const editProbe = `case "$f" in /*) ;; *) f="$(pwd)/$f";; esac
+[ -f "$f" ] || { echo "not a regular file: $f" >&2; exit 2; }
+f="$(readlink -f -- "$f" 2>/dev/null || realpath -- "$f" 2>/dev/null || printf '%s' "$f")"
+[ ! -L "$f" ] || { echo "cannot resolve the symlink $f" >&2; exit 2; }
+printf '%s\n' "$f"
+info="$(stat -L -c '%a %u %g %s' "$f" 2>/dev/null || stat -L -f '%Lp %u %g %z' "$f")" || exit 1
+echo "$info"
+if [ -r "$f" ]; then echo r; else echo -; fi
+set -- $info
+if [ -w "$(dirname "$f")" ] && [ "$(id -u)" = "$2" ]; then echo w; else echo -; fi`
+
+// EditFile is a file being edited: its content when it was opened, and
+// what is needed to replace it the same way it was.
+type EditFile struct {
+	// Path is the absolute path of the file.
+	Path string
+	// Data is the content of the file when it was opened.
+	Data []byte
+	// Mode holds the permission bits of the file.
+	Mode os.FileMode
+	// UID and GID are the owner and group of the file.
+	UID, GID int
+	// Sudo is set if reading or replacing the file needs sudo.
+	Sudo bool
+	// ViaSFTP is set if the file was read over SFTP rather than with cat.
+	ViaSFTP bool
+
+	executor Executor
+}
+
+// stdinRunner runs a command with input; both clients implement it.
+type stdinRunner interface {
+	runWithStdin(ctx context.Context, command, stdin string) (int, error)
+}
+
+// sftpProvider is implemented by executors that can open an SFTP session.
+type sftpProvider interface {
+	SFTP() (*sftp.Client, error)
+}
+
+// OpenEditFile reads the file at p for editing. A relative path is relative
+// to the working directory of e, and ~/ to the home directory. The file is
+// read over SFTP if e supports it, and with cat otherwise or if SFTP fails;
+// sudo is used if the file cannot be read or replaced without it.
+func OpenEditFile(ctx context.Context, e Executor, p string) (*EditFile, error) {
+	expr := ShellEscape(p)
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		expr = `"$HOME"/` + ShellEscape(rest)
+	}
+	out, err := runCaptured(ctx, e, "sh -c "+ShellEscape("f="+expr+"\n"+editProbe))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 {
+		return nil, fmt.Errorf("cannot read file information of %s: %q", p, out)
+	}
+	f := &EditFile{Path: lines[0], executor: e}
+	var size int64
+	fields := strings.Fields(lines[1])
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("cannot read file information of %s: %q", p, lines[1])
+	}
+	mode, err1 := strconv.ParseUint(fields[0], 8, 32)
+	uid, err2 := strconv.Atoi(fields[1])
+	gid, err3 := strconv.Atoi(fields[2])
+	size, err4 := strconv.ParseInt(fields[3], 10, 64)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, fmt.Errorf("cannot read file information of %s: %w", p, err)
+	}
+	if size > MaxEditSize {
+		return nil, fmt.Errorf("%s is %d bytes; only files up to %d bytes can be edited", f.Path, size, MaxEditSize)
+	}
+	f.Mode = os.FileMode(mode) & (os.ModePerm | 07000)
+	f.UID, f.GID = uid, gid
+	readable := lines[2] == "r"
+	// commands in a container run as its user, and containers rarely have sudo
+	if _, inContainer := e.(*ContainerClient); !inContainer {
+		f.Sudo = !readable || lines[3] != "w"
+	}
+
+	if readable {
+		if provider, ok := e.(sftpProvider); ok {
+			if data, err := readSFTP(provider, f.Path); err == nil {
+				f.Data, f.ViaSFTP = data, true
+				return f, nil
+			}
+		}
+	}
+	cat := "cat -- " + ShellEscape(f.Path)
+	if !readable {
+		cat = "sudo " + cat
+	}
+	data, err := runCaptured(ctx, e, cat)
+	if err != nil {
+		return nil, err
+	}
+	f.Data = []byte(data)
+	return f, nil
+}
+
+// readSFTP reads a whole file over SFTP.
+func readSFTP(provider sftpProvider, p string) ([]byte, error) {
+	client, err := provider.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, MaxEditSize+1))
+}
+
+// ErrFileChanged is returned by Save if the file no longer has the content
+// it had when it was opened.
+var ErrFileChanged = errors.New("the file was changed since it was opened")
+
+// Save replaces the file with data. The new content is staged in a
+// temporary file, given the mode, owner and group of the file, and renamed
+// over it, so the file is never seen half written. The old content is kept
+// next to it with a timestamp suffix, whose path is returned. If the file
+// was changed since it was opened, it is left alone and ErrFileChanged is
+// returned.
+func (f *EditFile) Save(ctx context.Context, data []byte) (string, error) {
+	if changed, err := f.changed(ctx); err != nil {
+		return "", fmt.Errorf("failed to check %s for changes: %w", f.Path, err)
+	} else if changed {
+		return "", ErrFileChanged
+	}
+	staging, err := runCaptured(ctx, f.executor, `mktemp "${TMPDIR:-/tmp}/sherlock-edit.XXXXXX"`)
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary file: %w", err)
+	}
+	staging = strings.TrimSpace(staging)
+	if err := f.stage(ctx, staging, data); err != nil {
+		_, _ = runCaptured(ctx, f.executor, "rm -f -- "+ShellEscape(staging))
+		return "", err
+	}
+
+	backup := f.Path + ".bak." + time.Now().Format("20060102-150405")
+	script := fmt.Sprintf(`set -e
+f=%s; s=%s; b=%s
+t="$(dirname "$f")/.$(basename "$f").sherlock.$$"
+trap 'rm -f "$t" "$s"' EXIT
+cat "$s" > "$t"
+chmod %o "$t"
+chown %d:%d "$t"
+cp -p "$f" "$b"
+mv -f "$t" "$f"`, ShellEscape(f.Path), ShellEscape(staging), ShellEscape(backup), f.Mode, f.UID, f.GID)
+	if _, err := runCaptured(ctx, f.executor, f.shell(script)); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", f.Path, err)
+	}
+	return backup, nil
+}
+
+// changed reports whether the content of the file differs from Data, by
+// their POSIX cksum.
+func (f *EditFile) changed(ctx context.Context) (bool, error) {
+	out, err := runCaptured(ctx, f.executor, f.shell(`set -- $(cksum < `+ShellEscape(f.Path)+`) && echo "$1 $2"`))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != fmt.Sprintf("%d %d", cksum(f.Data), len(f.Data)), nil
+}
+
+// cksum returns the CRC that the POSIX cksum utility prints for data.
+func cksum(data []byte) uint32 {
+	var crc uint32
+	update := func(b byte) {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	for _, b := range data {
+		update(b)
+	}
+	for n := len(data); n > 0; n >>= 8 {
+		update(byte(n))
+	}
+	return ^crc
+}
+
+// Restore puts the content of backup back in place, the same way Save
+// replaces the file.
+func (f *EditFile) Restore(ctx context.Context, backup string) error {
+	script := fmt.Sprintf(`set -e
+f=%s; b=%s
+t="$(dirname "$f")/.$(basename "$f").sherlock.$$"
+trap 'rm -f "$t"' EXIT
+cp -p "$b" "$t"
+mv -f "$t" "$f"`, ShellEscape(f.Path), ShellEscape(backup))
+	if _, err := runCaptured(ctx, f.executor, f.shell(script)); err != nil {
+		return fmt.Errorf("failed to restore %s from %s: %w", f.Path, backup, err)
+	}
+	return nil
+}
+
+// stage writes data to the temporary file staging, over SFTP if the file
+// was read that way and with cat otherwise.
+func (f *EditFile) stage(ctx context.Context, staging string, data []byte) error {
+	if provider, ok := f.executor.(sftpProvider); ok && f.ViaSFTP {
+		if client, err := provider.SFTP(); err == nil {
+			if file, err := client.OpenFile(staging, os.O_WRONLY|os.O_TRUNC); err == nil {
+				_, err = file.Write(data)
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+				if err == nil {
+					return nil
+				}
+			}
+		}
+	}
+	runner, ok := f.executor.(stdinRunner)
+	if !ok {
+		return errors.New("cannot write files through this connection")
+	}
+	code, err := runner.runWithStdin(ctx, "cat > "+ShellEscape(staging), string(data))
+	if err == nil && code != 0 {
+		err = fmt.Errorf("exit code %d", code)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload the new content: %w", err)
+	}
+	return nil
+}
+
+// shell returns a command that runs script, with sudo if the file needs it.
+func (f *EditFile) shell(script string) string {
+	command := "sh -c " + ShellEscape(script)
+	if f.Sudo {
+		return "sudo " + command
+	}
+	return command
+}
+
+// runCaptured runs a command and returns its output, or an error with its
+// error output if it fails.
+func runCaptured(ctx context.Context, e Executor, command string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	result := e.ExecuteStream(ctx, command, &stdout, &stderr)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.ExitCode != 0 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", fmt.Errorf("exit code %d", result.ExitCode)
+	}
+	return stdout.String(), nil
+}
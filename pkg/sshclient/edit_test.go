@@ -0,0 +1,149 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(p, []byte("port 80\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client := NewLocalClient()
+
+	file, err := OpenEditFile(ctx, client, p)
+	if err != nil {
+		t.Fatalf("OpenEditFile() error = %v", err)
+	}
+	if file.Path != p || string(file.Data) != "port 80\n" || file.Mode != 0640 || file.Sudo {
+		t.Fatalf("OpenEditFile() = path %q data %q mode %o sudo %v", file.Path, file.Data, file.Mode, file.Sudo)
+	}
+
+	backup, err := file.Save(ctx, []byte("port 8080"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, _ := os.ReadFile(p); string(got) != "port 8080" {
+		t.Errorf("file = %q after Save, want %q", got, "port 8080")
+	}
+	if info, err := os.Stat(p); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode after Save = %v (%v), want 0640", info.Mode().Perm(), err)
+	}
+	if got, _ := os.ReadFile(backup); string(got) != "port 80\n" {
+		t.Errorf("backup = %q, want the old content", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("directory has %d entries after Save, want the file and its backup", len(entries))
+	}
+
+	if err := file.Restore(ctx, backup); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got, _ := os.ReadFile(p); string(got) != "port 80\n" {
+		t.Errorf("file = %q after Restore, want the old content", got)
+	}
+
+	if _, err := OpenEditFile(ctx, client, filepath.Join(dir, "missing")); err == nil {
+		t.Error("OpenEditFile() of a missing file succeeded")
+	}
+	if _, err := OpenEditFile(ctx, client, dir); err == nil {
+		t.Error("OpenEditFile() of a directory succeeded")
+	}
+}
+
+func TestEditFileThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "sites-available", "default")
+	if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("listen 80;\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "default")
+	if err := os.Symlink("sites-available/default", link); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	file, err := OpenEditFile(ctx, NewLocalClient(), link)
+	if err != nil {
+		t.Fatalf("OpenEditFile() error = %v", err)
+	}
+	resolved, _ := filepath.EvalSymlinks(target)
+	if file.Path != resolved || file.Mode != 0640 {
+		t.Fatalf("OpenEditFile() = path %s mode %o, want %s mode 640", file.Path, file.Mode, resolved)
+	}
+	backup, err := file.Save(ctx, []byte("listen 8080;\n"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("link after Save = %v (%v), want it kept", info.Mode(), err)
+	}
+	if got, _ := os.ReadFile(target); string(got) != "listen 8080;\n" {
+		t.Errorf("target = %q after Save, want the new content", got)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("target mode after Save = %v (%v), want 0640", info.Mode().Perm(), err)
+	}
+	if filepath.Dir(backup) != filepath.Dir(resolved) {
+		t.Errorf("backup = %s, want it next to the target", backup)
+	}
+}
+
+func TestEditFileChangedMeanwhile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(p, []byte("port 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	file, err := OpenEditFile(ctx, NewLocalClient(), p)
+	if err != nil {
+		t.Fatalf("OpenEditFile() error = %v", err)
+	}
+	if err := os.WriteFile(p, []byte("port 81\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Save(ctx, []byte("port 8080\n")); !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("Save() error = %v, want ErrFileChanged", err)
+	}
+	if got, _ := os.ReadFile(p); string(got) != "port 81\n" {
+		t.Errorf("file = %q after a refused Save, want the change made meanwhile", got)
+	}
+}
+
+func TestCksum(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint32
+	}{
+		{data: "", want: 4294967295},
+		{data: "hello\n", want: 3015617425},
+	}
+	for _, tt := range tests {
+		if got := cksum([]byte(tt.data)); got != tt.want {
+			t.Errorf("cksum(%q) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
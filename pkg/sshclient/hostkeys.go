@@ -0,0 +1,510 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrHostKeyRejected is returned when the user declines to trust the key of
+// an unknown host.
+var ErrHostKeyRejected = errors.New("host key not trusted")
+
+// HostKeyInfo describes the key of a host that is not in known_hosts yet.
+type HostKeyInfo struct {
+	Host        string // host as recorded in known_hosts, e.g. "example.com" or "[example.com]:2222"
+	Address     string // address the key was received from
+	Key         ssh.PublicKey
+	Type        string // key type as OpenSSH names it, e.g. "ED25519"
+	Bits        int
+	Fingerprint string // e.g. "SHA256:..."
+	Randomart   string // OpenSSH's visual host key
+}
+
+// NewHostKeyInfo describes key as presented by hostname (host:port).
+func NewHostKeyInfo(hostname string, remote net.Addr, key ssh.PublicKey) HostKeyInfo {
+	keyType, bits := keyTypeAndBits(key)
+	info := HostKeyInfo{
+		Host:        knownhosts.Normalize(hostname),
+		Key:         key,
+		Type:        keyType,
+		Bits:        bits,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Randomart:   Randomart(key),
+	}
+	if remote != nil {
+		info.Address = remote.String()
+	}
+	return info
+}
+
+// HostKeyPrompt asks the user whether to trust the key of an unknown host.
+type HostKeyPrompt func(info HostKeyInfo) bool
+
+// HostKeyChangedError is returned when a host presents a different key than
+// the one recorded in known_hosts, which may be a man-in-the-middle attack.
+type HostKeyChangedError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var b strings.Builder
+	keyType, _ := keyTypeAndBits(e.Key)
+	fmt.Fprintf(&b, "REMOTE HOST IDENTIFICATION HAS CHANGED for %s!\n", e.Host)
+	b.WriteString("Someone could be eavesdropping on you right now (man-in-the-middle attack),\n")
+	b.WriteString("or the host key has just been changed.\n")
+	fmt.Fprintf(&b, "The %s key sent by the remote host is %s\n", keyType, ssh.FingerprintSHA256(e.Key))
+	for _, known := range e.Known {
+		knownType, _ := keyTypeAndBits(known.Key)
+		fmt.Fprintf(&b, "The known %s key is %s (%s:%d)\n", knownType, ssh.FingerprintSHA256(known.Key), known.Filename, known.Line)
+	}
+	b.WriteString("Host key verification failed.")
+	return b.String()
+}
+
+// hostKeyPolicy decides how host keys are verified.
+type hostKeyPolicy struct {
+	strict bool          // reject unknown hosts
+	files  []string      // known_hosts files; new keys are added to the first
+	hash   bool          // hash host names of new entries
+	prompt HostKeyPrompt // asks before trusting an unknown host; nil trusts it
+}
+
+// createHostKeyCallback returns a callback that checks keys against the
+// known_hosts files. Unknown hosts are rejected in strict mode; otherwise the
+// user is asked, and trusted keys are appended to the first file. A changed
+// key is always rejected with a HostKeyChangedError.
+func createHostKeyCallback(policy hostKeyPolicy) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// Read the files on every check, so that keys trusted for one hop
+		// are known to the next
+		var existing []string
+		for _, path := range policy.files {
+			if _, err := os.Stat(path); err == nil {
+				existing = append(existing, path)
+			}
+		}
+		if len(existing) > 0 {
+			callback, err := knownhosts.New(existing...)
+			if err != nil {
+				return fmt.Errorf("failed to read known_hosts: %w", err)
+			}
+			err = callback(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				// Known key, revoked key or a read error
+				return err
+			}
+			if len(keyErr.Want) > 0 {
+				return &HostKeyChangedError{Host: knownhosts.Normalize(hostname), Key: key, Known: keyErr.Want}
+			}
+		}
+
+		if policy.strict {
+			return fmt.Errorf("host key verification failed: strict host key checking is enabled and host '%s' is not in known_hosts file (%s)",
+				hostname, strings.Join(policy.files, ", "))
+		}
+		if policy.prompt != nil && !policy.prompt(NewHostKeyInfo(hostname, remote, key)) {
+			return ErrHostKeyRejected
+		}
+		if len(policy.files) == 0 {
+			return nil
+		}
+		if err := AddKnownHost(policy.files[0], hostname, key, policy.hash); err != nil {
+			return fmt.Errorf("failed to record host key: %w", err)
+		}
+		return nil
+	}
+}
+
+// knownHostsFiles returns the known_hosts files of a host: UserKnownHostsFile
+// from ssh_config, or ~/.ssh/known_hosts.
+func knownHostsFiles(configHost *SSHConfigHost) []string {
+	if configHost != nil && len(configHost.UserKnownHostsFile) > 0 {
+		return configHost.UserKnownHostsFile
+	}
+	return []string{GetKnownHostsPath()}
+}
+
+// KnownHostsSettings returns the known_hosts files for host and whether new
+// entries are hashed, according to ~/.ssh/config.
+func KnownHostsSettings(host string) (files []string, hash bool) {
+	config, err := ParseSSHConfig()
+	if err != nil {
+		return knownHostsFiles(nil), false
+	}
+	configHost := config.GetHost(host)
+	return knownHostsFiles(configHost), configHost != nil && configHost.HashKnownHosts
+}
+
+// AddKnownHost appends key for hostname (host:port) to the known_hosts file at
+// path, creating the file and its directory if needed. With hash, the host
+// name is stored hashed, as with HashKnownHosts.
+func AddKnownHost(path, hostname string, key ssh.PublicKey, hash bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	line := knownhosts.Line([]string{hostname}, key)
+	if hash {
+		line = knownhosts.HashHostname(knownhosts.Normalize(hostname)) + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// Keep the file valid if its last line has no newline
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		if last, err := readLastByte(path); err == nil && last != '\n' {
+			line = "\n" + line
+		}
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLastByte returns the last byte of a file.
+func readLastByte(path string) (byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, info.Size()-1); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// KnownHost is an entry of a known_hosts file.
+type KnownHost struct {
+	File    string
+	Line    int
+	Marker  string   // "@cert-authority", "@revoked" or empty
+	Hosts   []string // host patterns, or hashed host names
+	Key     ssh.PublicKey
+	Comment string
+}
+
+// Hashed reports whether the host names of the entry are hashed.
+func (k KnownHost) Hashed() bool {
+	return len(k.Hosts) > 0 && strings.HasPrefix(k.Hosts[0], "|1|")
+}
+
+// KeyType returns the type of the key as OpenSSH names it, e.g. "ED25519".
+func (k KnownHost) KeyType() string {
+	keyType, _ := keyTypeAndBits(k.Key)
+	return keyType
+}
+
+// Fingerprint returns the SHA256 fingerprint of the key.
+func (k KnownHost) Fingerprint() string {
+	return ssh.FingerprintSHA256(k.Key)
+}
+
+// Matches reports whether the entry is for host, given as host or host:port.
+// Hashed entries are matched by hashing host.
+func (k KnownHost) Matches(host string) bool {
+	host = NormalizeHost(host)
+	for _, pattern := range k.Hosts {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		if strings.HasPrefix(pattern, "|1|") {
+			if hashedHostMatches(pattern, host) {
+				return true
+			}
+		} else if matchHostPattern(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeHost returns host, given as host or host:port, as it is written in
+// known_hosts: "host" for port 22 and "[host]:port" otherwise.
+func NormalizeHost(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return knownhosts.Normalize(host)
+}
+
+// hashedHostMatches reports whether a hashed host name, |1|salt|hash, is the
+// hash of host.
+func hashedHostMatches(hashed, host string) bool {
+	parts := strings.Split(hashed, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// ReadKnownHosts returns the entries of the known_hosts files. Missing files
+// are skipped.
+func ReadKnownHosts(files ...string) ([]KnownHost, error) {
+	var entries []KnownHost
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		lineNum := 0
+		for scanner.Scan() {
+			lineNum++
+			entry, ok := parseKnownHostLine(scanner.Bytes())
+			if !ok {
+				continue
+			}
+			entry.File, entry.Line = path, lineNum
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// parseKnownHostLine parses a known_hosts line. Blank lines, comments and
+// lines that cannot be parsed are reported as not ok.
+func parseKnownHostLine(line []byte) (KnownHost, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return KnownHost{}, false
+	}
+	marker, hosts, key, comment, _, err := ssh.ParseKnownHosts(line)
+	if err != nil {
+		return KnownHost{}, false
+	}
+	if marker != "" {
+		marker = "@" + marker
+	}
+	return KnownHost{Marker: marker, Hosts: hosts, Key: key, Comment: comment}, true
+}
+
+// RemoveKnownHost removes the entries for host, given as host or host:port,
+// from the known_hosts file at path and returns how many were removed. The
+// previous file is kept as path.old, like ssh-keygen -R does.
+func RemoveKnownHost(path, host string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var kept bytes.Buffer
+	removed := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if entry, ok := parseKnownHostLine(line); ok && entry.Marker == "" && entry.Matches(host) {
+			removed++
+			continue
+		}
+		kept.Write(line)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path+".old", data, info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return removed, nil
+}
+
+// errHostKeyFetched stops the handshake once FetchHostKey has the key.
+var errHostKeyFetched = errors.New("host key fetched")
+
+// FetchHostKey returns the host key the host presents, without
+// authenticating, like ssh-keyscan. The host is reached the way Connect
+// reaches it, through its jump hosts or proxy command; those are checked
+// against known_hosts as usual.
+func (c *Client) FetchHostKey() (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: c.sshConfig.User,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyFetched
+		},
+		HostKeyAlgorithms: c.sshConfig.HostKeyAlgorithms,
+		Timeout:           c.sshConfig.Timeout,
+	}
+	addr := net.JoinHostPort(c.hostInfo.Host, strconv.Itoa(c.hostInfo.Port))
+	client, err := c.dial(addr, config)
+	if err == nil {
+		client.Close()
+	}
+	c.closeJumps()
+	if hostKey == nil {
+		return nil, fmt.Errorf("failed to get the host key of %s: %w", addr, err)
+	}
+	return hostKey, nil
+}
+
+// keyTypeAndBits returns the type of a key as OpenSSH names it, e.g. "RSA" or
+// "ED25519", and its size in bits.
+func keyTypeAndBits(key ssh.PublicKey) (string, int) {
+	var keyType string
+	switch t := key.Type(); {
+	case t == ssh.KeyAlgoED25519:
+		keyType = "ED25519"
+	case t == ssh.KeyAlgoSKED25519:
+		keyType = "ED25519-SK"
+	case t == ssh.KeyAlgoSKECDSA256:
+		keyType = "ECDSA-SK"
+	case strings.HasPrefix(t, "ecdsa-"):
+		keyType = "ECDSA"
+	case t == ssh.KeyAlgoRSA:
+		keyType = "RSA"
+	case t == ssh.InsecureKeyAlgoDSA:
+		keyType = "DSA"
+	default:
+		keyType = strings.ToUpper(t)
+	}
+
+	bits := 0
+	if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+		switch k := cryptoKey.CryptoPublicKey().(type) {
+		case *rsa.PublicKey:
+			bits = k.N.BitLen()
+		case *ecdsa.PublicKey:
+			bits = k.Curve.Params().BitSize
+		}
+	}
+	if bits == 0 && strings.HasPrefix(keyType, "ED25519") {
+		bits = 256
+	}
+	return keyType, bits
+}
+
+// Randomart returns OpenSSH's visual host key for the SHA256 fingerprint of
+// key: the path of a "drunken bishop" walking a 17x9 board.
+func Randomart(key ssh.PublicKey) string {
+	const (
+		width   = 17
+		height  = 9
+		symbols = " .o+=*BOX@%&#/^SE"
+	)
+	start, end := len(symbols)-2, len(symbols)-1
+
+	var field [width][height]int
+	x, y := width/2, height/2
+	digest := sha256.Sum256(key.Marshal())
+	for _, input := range digest {
+		for i := 0; i < 4; i++ {
+			if input&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if input&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = min(max(x, 0), width-1)
+			y = min(max(y, 0), height-1)
+			if field[x][y] < start-1 {
+				field[x][y]++
+			}
+			input >>= 2
+		}
+	}
+	field[width/2][height/2] = start
+	field[x][y] = end
+
+	keyType, bits := keyTypeAndBits(key)
+	title := fmt.Sprintf("[%s %d]", keyType, bits)
+	if len(title) > width {
+		title = "[" + keyType + "]"
+	}
+
+	var b strings.Builder
+	b.WriteString(randomartBorder(title, width))
+	for row := 0; row < height; row++ {
+		b.WriteByte('|')
+		for col := 0; col < width; col++ {
+			b.WriteByte(symbols[min(field[col][row], end)])
+		}
+		b.WriteString("|\n")
+	}
+	b.WriteString(strings.TrimSuffix(randomartBorder("[SHA256]", width), "\n"))
+	return b.String()
+}
+
+// randomartBorder returns a border line of the randomart with a centered label.
+func randomartBorder(label string, width int) string {
+	if len(label) > width {
+		label = label[:width]
+	}
+	left := (width - len(label)) / 2
+	return "+" + strings.Repeat("-", left) + label + strings.Repeat("-", width-left-len(label)) + "+\n"
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestHostKey returns a new ed25519 public key.
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestRandomart(t *testing.T) {
+	// Output of ssh-keygen -lv for this key
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMcGk2oSqTbe4AetJ+nAUsNflypEyISQ39cDnuuXUztu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `+--[ED25519 256]--+
+|...+**=oo        |
+| ooo+o.o .   .   |
+|  E+ o. = . . .  |
+|  ..o .+ + + o   |
+| .  o  .S + o    |
+|  oo o.. o       |
+| o .o.=.o        |
+|  o +=.o .       |
+|   oo+o .        |
++----[SHA256]-----+`
+
+	if got := Randomart(key); got != want {
+		t.Errorf("Randomart() =\n%s\nwant\n%s", got, want)
+	}
+	info := NewHostKeyInfo("web.example.com:22", nil, key)
+	if info.Type != "ED25519" || info.Bits != 256 || info.Fingerprint != "SHA256:eSEWUmBCifncu7geZADlVS/31Zqogt0L5iyDQ0Z1GKE" {
+		t.Errorf("NewHostKeyInfo() = %s %d %s", info.Type, info.Bits, info.Fingerprint)
+	}
+	if info.Host != "web.example.com" {
+		t.Errorf("NewHostKeyInfo().Host = %q, want web.example.com", info.Host)
+	}
+}
+
+func TestHostKeyCallbackTrustOnFirstUse(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 2222}
+	key, otherKey := newTestHostKey(t), newTestHostKey(t)
+
+	var prompts []HostKeyInfo
+	answer := false
+	callback := createHostKeyCallback(hostKeyPolicy{
+		files: []string{knownHosts},
+		prompt: func(info HostKeyInfo) bool {
+			prompts = append(prompts, info)
+			return answer
+		},
+	})
+
+	if err := callback("web:2222", remote, key); !errors.Is(err, ErrHostKeyRejected) {
+		t.Fatalf("declined key: error = %v, want ErrHostKeyRejected", err)
+	}
+	if _, err := os.Stat(knownHosts); err == nil {
+		t.Errorf("a declined key should not be recorded")
+	}
+
+	answer = true
+	if err := callback("web:2222", remote, key); err != nil {
+		t.Fatalf("trusted key: error = %v", err)
+	}
+	if len(prompts) != 2 || prompts[1].Host != "[web]:2222" || prompts[1].Address != "10.0.0.5:2222" {
+		t.Fatalf("prompts = %+v, want two for [web]:2222", prompts)
+	}
+
+	// Known now: no prompt
+	if err := callback("web:2222", remote, key); err != nil || len(prompts) != 2 {
+		t.Errorf("known key: error = %v after %d prompts, want no error and no prompt", err, len(prompts))
+	}
+
+	var changed *HostKeyChangedError
+	err := callback("web:2222", remote, otherKey)
+	if !errors.As(err, &changed) {
+		t.Fatalf("changed key: error = %v, want HostKeyChangedError", err)
+	}
+	if len(changed.Known) != 1 || changed.Known[0].Line != 1 || !strings.Contains(err.Error(), ssh.FingerprintSHA256(key)) {
+		t.Errorf("changed key error should show the known key: %v", err)
+	}
+
+	strict := createHostKeyCallback(hostKeyPolicy{strict: true, files: []string{knownHosts}})
+	if err := strict("db:22", remote, key); err == nil {
+		t.Errorf("strict mode should reject unknown hosts")
+	}
+}
+
+func TestKnownHostsEditing(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte("# comment\nother.example.com "+strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestHostKey(t))))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	webKey := newTestHostKey(t)
+	if err := AddKnownHost(knownHosts, "web.example.com:22", webKey, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddKnownHost(knownHosts, "web.example.com:2222", webKey, false); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadKnownHosts(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("ReadKnownHosts() = %d entries, want 3", len(entries))
+	}
+	hashed := entries[1]
+	if !hashed.Hashed() || hashed.Line != 3 || !hashed.Matches("web.example.com") || hashed.Matches("db.example.com") {
+		t.Errorf("hashed entry = %+v, want a hashed entry for web.example.com on line 3", hashed)
+	}
+	if entries[2].Hosts[0] != "[web.example.com]:2222" || !entries[2].Matches("web.example.com:2222") || entries[2].Matches("web.example.com") {
+		t.Errorf("entry with port = %+v", entries[2])
+	}
+
+	removed, err := RemoveKnownHost(knownHosts, "web.example.com")
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveKnownHost() = %d, %v, want 1", removed, err)
+	}
+	if entries, _ := ReadKnownHosts(knownHosts); len(entries) != 2 {
+		t.Errorf("after removal %d entries remain, want 2", len(entries))
+	}
+	if _, err := os.Stat(knownHosts + ".old"); err != nil {
+		t.Errorf("RemoveKnownHost() should keep a backup: %v", err)
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"web", "web"},
+		{"web:22", "web"},
+		{"web:2222", "[web]:2222"},
+		{"[web]:2222", "[web]:2222"},
+		{"[2001:db8::1]", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHost(tt.host); got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestFetchHostKey(t *testing.T) {
+	server := startTestSSHServer(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	p, _ := strconv.Atoi(port)
+	direct := &Client{
+		hostInfo:  &HostInfo{Host: host, Port: p, User: "ops"},
+		sshConfig: &ssh.ClientConfig{User: "ops", Timeout: 5 * time.Second},
+	}
+	// A host only reachable through its proxy command, like "nc %h %p"
+	proxied := &Client{
+		hostInfo:     &HostInfo{Host: "db.internal", Port: 22, User: "ops"},
+		sshConfig:    &ssh.ClientConfig{User: "ops", Timeout: 5 * time.Second},
+		proxyCommand: fmt.Sprintf(`bash -c 'exec 3<>/dev/tcp/%s/%s; cat <&3 & cat >&3'`, host, port),
+	}
+	for name, client := range map[string]*Client{"direct": direct, "proxy command": proxied} {
+		if name == "proxy command" {
+			if _, err := exec.LookPath("bash"); err != nil {
+				continue
+			}
+		}
+		key, err := client.FetchHostKey()
+		if err != nil {
+			t.Errorf("%s: FetchHostKey() error = %v", name, err)
+			continue
+		}
+		if key.Type() != ssh.KeyAlgoED25519 {
+			t.Errorf("%s: FetchHostKey() = %s key, want ed25519", name, key.Type())
+		}
+	}
+}
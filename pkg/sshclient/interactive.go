@@ -72,6 +72,9 @@ var interactiveCommandsMap = func() map[string]bool {
 		"sqlite3",
 		"mongo",
 		"redis-cli",
+		// Privilege tools that ask for a password at the terminal
+		"su",
+		"doas",
 		// Other interactive tools
 		"less",
 		"more",
@@ -15,7 +15,15 @@
 package sshclient
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestIsInteractiveCommand(t *testing.T) {
@@ -74,3 +82,92 @@ func TestIsInteractiveCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalPTY(t *testing.T) {
+	defer func(grace time.Duration) { stopGrace = grace }(stopGrace)
+	stopGrace = 200 * time.Millisecond
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		command string
+		stdin   string
+		timeout time.Duration
+		want    string
+		wantErr error
+	}{
+		{name: "terminal", command: "test -t 0 && test -t 1 && test -t 2 && echo terminal", want: "terminal"},
+		{name: "size", command: "stty size", want: "30 100"},
+		{name: "session leader", command: `{ [ ! -r /proc/$$/stat ] || [ "$(cut -d' ' -f6 /proc/$$/stat)" = "$$" ]; } && echo leader`, want: "leader"},
+		{name: "working directory", command: "pwd", want: dir},
+		{name: "input", command: "read line; echo got:$line", stdin: "hello\n", want: "got:hello"},
+		{name: "end of input", command: "cat >/dev/null; echo done", stdin: "some input\n", want: "done"},
+		{name: "exit status", command: "echo failed; exit 3", want: "failed"},
+		{name: "stopped", command: "echo started; sleep 10", timeout: 300 * time.Millisecond, want: "started", wantErr: ErrTimedOut},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			c := &LocalClient{dirState: dirState{cwd: dir}}
+			cmd, ptmx, err := c.startPTY(tt.command, 100, 30)
+			if err != nil {
+				t.Fatalf("startPTY() error = %v", err)
+			}
+			defer ptmx.Close()
+
+			var out bytes.Buffer
+			start := time.Now()
+			err = waitPTY(ctx, cmd, ptmx, strings.NewReader(tt.stdin), &out)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("waitPTY() error = %v, want %v", err, tt.wantErr)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("waitPTY() took %v", time.Since(start))
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("output = %q, want it to contain %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestLocalPTYLeavesInput checks that input typed after the command has
+// exited is left for the next reader of stdin, such as the prompt.
+func TestLocalPTYLeavesInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	c := &LocalClient{dirState: dirState{cwd: t.TempDir()}}
+	cmd, ptmx, err := c.startPTY("true", 80, 24)
+	if err != nil {
+		t.Fatalf("startPTY() error = %v", err)
+	}
+	defer ptmx.Close()
+	if err := waitPTY(context.Background(), cmd, ptmx, r, io.Discard); err != nil {
+		t.Fatalf("waitPTY() error = %v", err)
+	}
+
+	if _, err := w.Write([]byte("next\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "next\n" {
+		t.Errorf("input after waitPTY = %q, want %q", got, "next\n")
+	}
+}
@@ -19,12 +19,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
-	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 )
 
@@ -33,9 +38,15 @@ import (
 type LocalClient struct {
 	hostname string
 	username string
-	cwd      string // current working directory
+	dirState // working directories
+	sudo     *sudoState
+	openCast CastOpener
 }
 
+// ptyDrainTimeout is how long output of an interactive command is still
+// read after it exits.
+const ptyDrainTimeout = 500 * time.Millisecond
+
 // NewLocalClient creates a new local client.
 func NewLocalClient() *LocalClient {
 	hostname, err := os.Hostname()
@@ -54,31 +65,82 @@ func NewLocalClient() *LocalClient {
 	return &LocalClient{
 		hostname: hostname,
 		username: username,
-		cwd:      cwd,
+		dirState: dirState{cwd: cwd},
 	}
 }
 
-// Execute executes a command on the local host.
+// Execute executes a command on the local host and returns its complete
+// output.
 func (c *LocalClient) Execute(ctx context.Context, command string) *ExecuteResult {
+	var stdout, stderr bytes.Buffer
+	result := c.ExecuteStream(ctx, command, &stdout, &stderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
+
+// ExecuteStream executes a command on the local host, writing its output to
+// stdout and stderr as it arrives. The result holds the exit code and error
+// only.
+func (c *LocalClient) ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) *ExecuteResult {
 	result := &ExecuteResult{}
 
-	// Handle cd command specially to track directory changes
-	command = strings.TrimSpace(command)
-	if strings.HasPrefix(command, "cd ") || command == "cd" {
-		return c.handleCd(command)
+	// sudo cannot ask at the terminal from the command's own process group,
+	// so the password is asked for here and fed on stdin
+	sudoPassword, err := c.sudo.passwordFor(ctx, command, c.username+"@"+c.hostname, c.runWithStdin)
+	if err != nil {
+		if ctx.Err() != nil {
+			markStopped(ctx, result)
+		} else {
+			result.Error = err
+		}
+		return result
 	}
 
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	cmd.Dir = c.cwd // Execute in the tracked working directory
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// The command runs in the tracked directories and reports where it
+	// left them, so that cd, cd -, pushd and popd carry over to the next one
+	if sudoPassword != "" {
+		command = c.sudo.withPassword(command)
+	}
+	script, dirs := c.dirState.track(command, stdout)
+	cmd := exec.Command("sh", "-c", script)
+	if sudoPassword != "" {
+		cmd.Stdin = strings.NewReader(sudoPassword + "\n")
+	}
+	cmd.Stdout = dirs
+	cmd.Stderr = stderr
+	// Its own process group lets a stop reach the processes the command
+	// started, and keeps Ctrl-C at the terminal from reaching them directly
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	err := cmd.Run()
-	result.Stdout = stdout.String()
-	result.Stderr = stderr.String()
+	if err := cmd.Start(); err != nil {
+		result.Error = err
+		return result
+	}
+	done := make(chan struct{})
+	go func() {
+		err = cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		pgid := cmd.Process.Pid
+		escalate([]func() error{
+			killGroup(pgid, syscall.SIGINT),
+			killGroup(pgid, syscall.SIGTERM),
+			killGroup(pgid, syscall.SIGKILL),
+		}, done, stopGrace)
+		<-done
+		_ = dirs.Flush()
+		markStopped(ctx, result)
+		return result
+	}
 
+	_ = dirs.Flush()
+	if d, ok := dirs.dirs(); ok {
+		c.dirState = d
+	}
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
@@ -91,57 +153,40 @@ func (c *LocalClient) Execute(ctx context.Context, command string) *ExecuteResul
 	return result
 }
 
-// handleCd handles the cd command by changing the tracked working directory.
-func (c *LocalClient) handleCd(command string) *ExecuteResult {
-	result := &ExecuteResult{}
-
-	// Parse the target directory
-	target := strings.TrimSpace(strings.TrimPrefix(command, "cd"))
-	if target == "" || target == "~" {
-		// cd or cd ~ goes to home directory
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			result.Error = fmt.Errorf("cannot get home directory: %w", err)
-			return result
-		}
-		target = homeDir
-	} else if strings.HasPrefix(target, "~/") {
-		// Expand ~ prefix
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			result.Error = fmt.Errorf("cannot get home directory: %w", err)
-			return result
-		}
-		target = filepath.Join(homeDir, target[2:])
-	} else if !filepath.IsAbs(target) {
-		// Make relative path absolute based on current directory
-		target = filepath.Join(c.cwd, target)
+// runWithStdin runs a command with stdin and returns its exit code. Its
+// output is discarded.
+func (c *LocalClient) runWithStdin(ctx context.Context, command, stdin string) (int, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = c.cwd
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
 	}
-
-	// Clean the path to handle . and ..
-	target = filepath.Clean(target)
-
-	// Verify the directory exists and is a directory
-	info, err := os.Stat(target)
-	if err != nil {
-		if os.IsNotExist(err) {
-			result.Stderr = fmt.Sprintf("cd: %s: No such file or directory\n", target)
-			result.ExitCode = 1
-		} else {
-			result.Error = err
-		}
-		return result
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
 	}
+	return 0, err
+}
 
-	if !info.IsDir() {
-		result.Stderr = fmt.Sprintf("cd: %s: Not a directory\n", target)
-		result.ExitCode = 1
-		return result
-	}
+// SetSudoPrompt sets how a sudo password is asked for, and how long it is
+// kept in memory: zero keeps it for DefaultSudoPasswordTimeout, a negative
+// timeout asks for every command. Without a prompt, commands run sudo as is.
+func (c *LocalClient) SetSudoPrompt(prompt PromptFunc, timeout time.Duration) {
+	c.sudo = newSudoState(prompt, timeout)
+}
 
-	// Update the current working directory
-	c.cwd = target
-	return result
+// SetCastRecorder records the output of interactive commands with open.
+// A nil open stops recording.
+func (c *LocalClient) SetCastRecorder(open CastOpener) {
+	c.openCast = open
+}
+
+// ForgetSudoPassword drops the kept sudo password, if any.
+func (c *LocalClient) ForgetSudoPassword() {
+	c.sudo.forget()
 }
 
 // GetCwd returns the current working directory.
@@ -149,32 +194,143 @@ func (c *LocalClient) GetCwd() string {
 	return c.cwd
 }
 
-// ExecuteInteractive executes an interactive command (like top, htop) on the local host
-// with PTY support. It connects the command's stdin/stdout/stderr to the current terminal.
+// ExecuteInteractive runs an interactive command (like top or vim) on the
+// local host in a terminal of its own, connected to the current one. Like
+// on a remote host, the command runs in its own session, follows the size
+// of the terminal and is recorded if a recorder is set.
 func (c *LocalClient) ExecuteInteractive(ctx context.Context, command string) error {
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	cmd.Dir = c.cwd // Execute in the tracked working directory
+	// Get terminal size
+	fd := int(os.Stdin.Fd())
+	width, height := 80, 24
+	if term.IsTerminal(fd) {
+		w, h, err := term.GetSize(fd)
+		if err == nil {
+			width, height = w, h
+		}
+	}
+
+	cmd, ptmx, err := c.startPTY(command, width, height)
+	if err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	defer ptmx.Close()
 
-	// Connect to current terminal
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	var stdout io.Writer = os.Stdout
+	var cast *CastWriter
+	if c.openCast != nil {
+		cast = c.openCast(command, width, height)
+	}
+	if cast != nil {
+		defer cast.Close()
+		stdout = io.MultiWriter(os.Stdout, cast)
+	}
 
-	// Put terminal into raw mode if it's a terminal
-	fd := int(os.Stdin.Fd())
-	var oldState *term.State
-	var err error
+	// Put terminal into raw mode if it's a terminal, so that keys like
+	// Ctrl-C reach the command through its terminal
 	if term.IsTerminal(fd) {
-		oldState, err = term.MakeRaw(fd)
+		oldState, err := term.MakeRaw(fd)
 		if err != nil {
-			// Fallback to non-raw mode if we can't set raw mode
-			return cmd.Run()
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return fmt.Errorf("failed to set raw terminal: %w", err)
 		}
 		defer term.Restore(fd, oldState)
 	}
 
-	// Run the command
-	err = cmd.Run()
+	// Handle window resize
+	sigwinch := make(chan os.Signal, 1)
+	signal.Notify(sigwinch, syscall.SIGWINCH)
+	defer signal.Stop(sigwinch)
+
+	go func() {
+		for range sigwinch {
+			if term.IsTerminal(fd) {
+				w, h, err := term.GetSize(fd)
+				if err == nil {
+					_ = pty.Setsize(ptmx, &pty.Winsize{Rows: uint16(h), Cols: uint16(w)})
+					if cast != nil {
+						cast.Resize(w, h)
+					}
+				}
+			}
+		}
+	}()
+
+	return waitPTY(ctx, cmd, ptmx, os.Stdin, stdout)
+}
+
+// startPTY starts command in the tracked working directory, in a new
+// session whose controlling terminal is a new PTY of the given size. It
+// returns the command and the controlling side of the PTY.
+func (c *LocalClient) startPTY(command string, width, height int) (*exec.Cmd, *os.File, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = c.cwd
+	cmd.Env = append(os.Environ(), "TERM="+getTermType())
+	// StartWithSize makes the command a session leader with the PTY as its
+	// controlling terminal
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: uint16(height), Cols: uint16(width)})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cmd, ptmx, nil
+}
+
+// waitPTY copies stdin to the PTY of cmd and the output of cmd to stdout
+// until cmd exits. If ctx ends first, the session of cmd is hung up on,
+// then terminated and killed. A non-zero exit status is not an error.
+func waitPTY(ctx context.Context, cmd *exec.Cmd, ptmx *os.File, stdin io.Reader, stdout io.Writer) error {
+	stopInput := make(chan struct{})
+	inputDone := make(chan struct{})
+	go func() {
+		copyInput(ptmx, stdin, stopInput)
+		close(inputDone)
+	}()
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(stdout, ptmx)
+		close(copied)
+	}()
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = cmd.Wait()
+		close(done)
+	}()
+	stopped := false
+	select {
+	case <-done:
+	case <-ctx.Done():
+		sid := cmd.Process.Pid
+		escalate([]func() error{
+			killGroup(sid, syscall.SIGHUP),
+			killGroup(sid, syscall.SIGTERM),
+			killGroup(sid, syscall.SIGKILL),
+		}, done, stopGrace)
+		<-done
+		stopped = true
+	}
+
+	// Input typed from now on is for whatever reads stdin next
+	close(stopInput)
+	select {
+	case <-inputDone:
+	case <-time.After(ptyDrainTimeout):
+	}
+
+	// The PTY reports the end of output once every process of the session
+	// has closed it; don't wait for ones left in the background
+	select {
+	case <-copied:
+	case <-time.After(ptyDrainTimeout):
+	}
+
+	if stopped {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimedOut
+		}
+		return ErrInterrupted
+	}
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
@@ -183,10 +339,61 @@ func (c *LocalClient) ExecuteInteractive(ctx context.Context, command string) er
 		}
 		return err
 	}
-
 	return nil
 }
 
+// inputPollInterval is how often copyInput checks whether to stop while
+// there is no input.
+const inputPollInterval = 50 * time.Millisecond
+
+// copyInput copies stdin to dst until stop is closed. A file, like the
+// terminal, is only read once it has input, so nothing is taken from it
+// after stop. The end of redirected input is the end of input for dst
+// too, as Ctrl-D at the start of a line would be.
+func copyInput(dst io.Writer, stdin io.Reader, stop <-chan struct{}) {
+	f, ok := stdin.(*os.File)
+	if !ok {
+		if _, err := io.Copy(dst, stdin); err == nil {
+			_, _ = dst.Write([]byte{4})
+		}
+		return
+	}
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLIN}}
+	buf := make([]byte, 32*1024)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		n, err := unix.Poll(fds, int(inputPollInterval/time.Millisecond))
+		if errors.Is(err, unix.EINTR) || (err == nil && n == 0) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		n, err = f.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			_, _ = dst.Write([]byte{4})
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 // IsConnected always returns true for local client.
 func (c *LocalClient) IsConnected() bool {
 	return true
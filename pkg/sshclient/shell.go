@@ -0,0 +1,367 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// shellStartTimeout bounds the handshake with a new persistent shell.
+const shellStartTimeout = 10 * time.Second
+
+// ErrShellExited is returned when the persistent shell exits, e.g. after the
+// user ran "exit". A new shell is started for the next command.
+var ErrShellExited = errors.New("remote shell exited")
+
+// persistentShell is a long-lived shell on the remote host. Commands are written
+// to its stdin and framed by unique markers, so that their stdout, stderr and
+// exit status can be recovered while the shell keeps its cwd, environment,
+// aliases, umask and limits between commands.
+type persistentShell struct {
+	stdin  io.WriteCloser
+	stdout chan []byte
+	stderr chan []byte
+	close  func() error
+	// runBeside runs a command on the host of the shell, outside of it; it
+	// is used to signal the processes of a command that is stopped
+	runBeside func(command string) error
+	pid       int // process ID of the shell
+
+	mu     sync.Mutex
+	exited bool
+	dirs   dirState // where the last command left the shell
+	moveTo string   // commands that enter other directories before the next command
+	before string   // commands that run untraced before the next command
+	after  string   // commands that run untraced after the next command
+}
+
+// startPersistentShell starts a shell in session, which it takes over, in
+// dirs. runBeside runs a command on the same host in another session.
+func startPersistentShell(session *ssh.Session, dirs dirState, runBeside func(command string) error) (*persistentShell, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open shell stdin: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open shell stdout: %w", err)
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open shell stderr: %w", err)
+	}
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	shell := newPersistentShell(stdin, stdout, stderr, session.Close, runBeside)
+	if err := shell.init(dirs); err != nil {
+		shell.Close()
+		return nil, err
+	}
+	return shell, nil
+}
+
+// newPersistentShell wraps the standard streams of a running shell.
+func newPersistentShell(stdin io.WriteCloser, stdout, stderr io.Reader, closeFn func() error, runBeside func(command string) error) *persistentShell {
+	s := &persistentShell{
+		stdin:     stdin,
+		stdout:    make(chan []byte, 256),
+		stderr:    make(chan []byte, 256),
+		close:     closeFn,
+		runBeside: runBeside,
+	}
+	go readChunks(stdout, s.stdout)
+	go readChunks(stderr, s.stderr)
+	return s
+}
+
+// readChunks sends what r gives as it arrives, without waiting for whole
+// lines, to ch and closes ch at EOF. Progress output redrawn with \r thus
+// shows up at once.
+func readChunks(r io.Reader, ch chan<- []byte) {
+	defer close(ch)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ch <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writerFunc is an io.Writer that calls a function.
+type writerFunc func(p []byte) (int, error)
+
+// Write implements io.Writer.
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// init prepares the shell, puts it in dirs and checks that it follows the
+// framing protocol. The shell gets the cd, pushd and popd of the commands
+// that run in a session of their own, so that both track directories alike.
+func (s *persistentShell) init(dirs dirState) error {
+	termType := getTermType()
+	setup := fmt.Sprintf("export TERM='%s'; shopt -s expand_aliases 2>/dev/null || true\n", termType) +
+		dirFunctions + dirs.restore()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shellStartTimeout)
+	defer cancel()
+	result, _, err := s.Run(ctx, setup)
+	if err != nil {
+		return fmt.Errorf("remote shell is unusable: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("remote shell setup failed: %s", strings.TrimSpace(result.Stderr))
+	}
+
+	// Without its process ID a stopped command takes the shell with it
+	result, _, err = s.Run(ctx, "echo $$")
+	if err == nil && result.ExitCode == 0 {
+		s.pid, _ = strconv.Atoi(strings.TrimSpace(result.Stdout))
+	}
+	return nil
+}
+
+// signalCommand returns a step that sends sig to the processes started by
+// the shell, and the ones they started, deepest first.
+func (s *persistentShell) signalCommand(sig string) func() error {
+	script := fmt.Sprintf("__sherlock_signal() { "+
+		"for p in $(pgrep -P \"$1\" 2>/dev/null || ps -eo pid=,ppid= | awk -v p=\"$1\" '$2 == p { print $1 }'); do "+
+		"__sherlock_signal \"$p\"; kill -%s \"$p\" 2>/dev/null; done; }; __sherlock_signal %d", sig, s.pid)
+	return func() error { return s.runBeside(script) }
+}
+
+// newMarker returns a marker that cannot appear in command output by accident.
+func newMarker() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "__SHERLOCK_" + hex.EncodeToString(b) + "__"
+}
+
+// enter makes the next command run in dirs if the shell is elsewhere, e.g.
+// because a command that ran in another session changed directory.
+func (s *persistentShell) enter(dirs dirState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveTo = ""
+	if !dirs.equal(s.dirs) {
+		s.moveTo = dirs.restore() + "\n"
+	}
+}
+
+// runAround makes the next command run after before, and before after.
+// Neither is traced by set -x nor echoed by set -v, so that they may hold
+// a password.
+func (s *persistentShell) runAround(before, after string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.before, s.after = before, after
+}
+
+// untraced returns script wrapped so that set -x and set -v, which a
+// command may have turned on in the shell, leave it out.
+func untraced(script string) string {
+	if script == "" {
+		return ""
+	}
+	return "{ __sherlock_flags=$-; set +xv; } 2>/dev/null\n" + script +
+		"case $__sherlock_flags in *x*) set -x;; esac; case $__sherlock_flags in *v*) set -v;; esac\n"
+}
+
+// Run executes a command in the shell and returns its result and the
+// directories of the shell afterwards.
+func (s *persistentShell) Run(ctx context.Context, command string) (*ExecuteResult, dirState, error) {
+	var stdout, stderr strings.Builder
+	result, dirs, err := s.RunStream(ctx, command, &stdout, &stderr)
+	if result != nil {
+		result.Stdout = stdout.String()
+		result.Stderr = stderr.String()
+	}
+	return result, dirs, err
+}
+
+// RunStream executes a command in the shell, writing its output to stdout and
+// stderr as it arrives, and returns its exit code and the
+// directories of the shell afterwards; they are zero if unknown. The command runs with stdin from /dev/null so
+// that it cannot consume the framing of the next command, and through
+// "command eval" so that a syntax error neither breaks the framing nor, as it
+// would for a plain eval in a POSIX shell, exits the shell.
+//
+// If ctx ends first, the command is stopped and its result returned with the
+// error of ctx; the shell stays usable. Only if the command does not stop is
+// the shell dropped, and the result nil.
+func (s *persistentShell) RunStream(ctx context.Context, command string, stdout, stderr io.Writer) (*ExecuteResult, dirState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exited {
+		return nil, dirState{}, ErrShellExited
+	}
+
+	// After the exit status, stdout reports the directories of the shell
+	// and ends with the marker again
+	marker := newMarker()
+	script := s.moveTo + untraced(s.before) + fmt.Sprintf("command eval %s </dev/null\n"+
+		"__sherlock_rc=$?\n"+
+		"%s"+
+		"printf '%s %%d\\n' \"$__sherlock_rc\"\n"+
+		"%s"+
+		"printf '%s\\n'\n"+
+		"printf '%s\\n' >&2\n",
+		ShellEscape(command), untraced(s.after), marker, dirReport, marker, marker)
+	s.moveTo, s.before, s.after = "", "", ""
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		s.exited = true
+		return nil, dirState{}, fmt.Errorf("%w: %v", ErrShellExited, err)
+	}
+
+	type streamResult struct {
+		trailer string
+		lines   []string // lines after the marker, up to its repetition
+		ok      bool
+	}
+	// Output that arrives after a cancelled command returned is dropped
+	var writeMu sync.Mutex
+	detached := false
+	write := func(w io.Writer) io.Writer {
+		return writerFunc(func(p []byte) (int, error) {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if !detached {
+				_, _ = w.Write(p)
+			}
+			return len(p), nil
+		})
+	}
+	// Output is passed on as it arrives, except what may be the start of
+	// the marker. The trailer ends with a newline, or on stdout with the
+	// marker on a line of its own after the directories
+	collect := func(ch <-chan []byte, w io.Writer, report bool, done chan<- streamResult) {
+		framed := &dirWriter{w: write(w), marker: []byte(marker)}
+		end := "\n"
+		if report {
+			end = "\n" + marker + "\n"
+		}
+		for chunk := range ch {
+			_, _ = framed.Write(chunk)
+			trailer, found := framed.tail()
+			if !found {
+				continue
+			}
+			i := strings.Index(trailer, end)
+			if i < 0 {
+				continue
+			}
+			rc, rest, _ := strings.Cut(trailer[:i+1], "\n")
+			result := streamResult{trailer: strings.TrimSpace(rc), ok: true}
+			if rest != "" {
+				result.lines = strings.Split(strings.TrimSuffix(rest, "\n"), "\n")
+			}
+			done <- result
+			return
+		}
+		_ = framed.Flush()
+		done <- streamResult{}
+	}
+
+	stdoutDone := make(chan streamResult, 1)
+	stderrDone := make(chan streamResult, 1)
+	go collect(s.stdout, stdout, true, stdoutDone)
+	go collect(s.stderr, stderr, false, stderrDone)
+
+	var out, errOut streamResult
+	done := make(chan struct{})
+	go func() {
+		out, errOut = <-stdoutDone, <-stderrDone
+		close(done)
+	}()
+	stopped := false
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// Stop the processes of the command: SIGINT, then SIGTERM, then
+		// SIGKILL, so that the shell and its state survive. Unlike at a
+		// terminal, the shell goes on with the rest of a command list, whose
+		// next processes the later signals stop
+		var steps []func() error
+		if s.pid > 0 && s.runBeside != nil {
+			steps = []func() error{s.signalCommand("INT"), s.signalCommand("TERM"), s.signalCommand("KILL")}
+		}
+		if !escalate(steps, done, stopGrace) {
+			// The shell is in an unknown state; drop it
+			writeMu.Lock()
+			detached = true
+			writeMu.Unlock()
+			s.exited = true
+			_ = s.close()
+			return nil, dirState{}, ctx.Err()
+		}
+		stopped = true
+	}
+
+	result := &ExecuteResult{}
+	if !out.ok || !errOut.ok {
+		s.exited = true
+		return result, dirState{}, ErrShellExited
+	}
+
+	result.ExitCode, _ = strconv.Atoi(out.trailer)
+	dirs, _ := parseDirs(out.lines)
+	if dirs.cwd != "" {
+		s.dirs = dirs
+	}
+	if stopped {
+		// The shell is still usable
+		return result, dirs, ctx.Err()
+	}
+	return result, dirs, nil
+}
+
+// Exited reports whether the shell has exited.
+func (s *persistentShell) Exited() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exited
+}
+
+// Close terminates the shell.
+func (s *persistentShell) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exited = true
+	_ = s.stdin.Close()
+	return s.close()
+}
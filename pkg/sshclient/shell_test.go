@@ -0,0 +1,278 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startLocalShell runs the persistent shell protocol against a local sh, which
+// behaves like the remote shell of an SSH session without a PTY.
+func startLocalShell(t *testing.T) *persistentShell {
+	t.Helper()
+	return startLocalShellIn(t, dirState{})
+}
+
+// startLocalShellIn is startLocalShell with the shell started in dirs.
+func startLocalShellIn(t *testing.T, dirs dirState) *persistentShell {
+	t.Helper()
+	cmd := exec.Command("sh")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe() error = %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() error = %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatalf("StderrPipe() error = %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+
+	shell := newPersistentShell(stdin, stdout, stderr, func() error {
+		_ = cmd.Process.Kill()
+		return cmd.Wait()
+	}, func(command string) error {
+		return exec.Command("sh", "-c", command).Run()
+	})
+	if err := shell.init(dirs); err != nil {
+		t.Fatalf("init() error = %v", err)
+	}
+	t.Cleanup(func() { _ = shell.Close() })
+	return shell
+}
+
+func runInShell(t *testing.T, shell *persistentShell, command string) (*ExecuteResult, dirState) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, dirs, err := shell.Run(ctx, command)
+	if err != nil {
+		t.Fatalf("Run(%q) error = %v", command, err)
+	}
+	return result, dirs
+}
+
+func TestPersistentShellRun(t *testing.T) {
+	shell := startLocalShell(t)
+
+	tests := []struct {
+		command    string
+		wantStdout string
+		wantStderr string
+		wantCode   int
+	}{
+		{command: "echo hello", wantStdout: "hello\n"},
+		{command: "printf 'no newline'", wantStdout: "no newline"},
+		{command: "printf 'two\\n\\n'", wantStdout: "two\n\n"},
+		{command: "echo oops >&2; exit_code=3; (exit $exit_code)", wantStderr: "oops\n", wantCode: 3},
+		{command: "echo 'unbalanced", wantCode: 2},
+		{command: "cat", wantStdout: ""},
+		{command: "true", wantStdout: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			result, _ := runInShell(t, shell, tt.command)
+			if result.Stdout != tt.wantStdout {
+				t.Errorf("Stdout = %q, want %q", result.Stdout, tt.wantStdout)
+			}
+			if tt.wantStderr != "" && result.Stderr != tt.wantStderr {
+				t.Errorf("Stderr = %q, want %q", result.Stderr, tt.wantStderr)
+			}
+			if (tt.wantCode == 0) != (result.ExitCode == 0) || (tt.wantCode != 2 && result.ExitCode != tt.wantCode) {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestPersistentShellKeepsState(t *testing.T) {
+	shell := startLocalShell(t)
+	dir := t.TempDir()
+
+	runInShell(t, shell, "export SHERLOCK_TEST=persisted")
+	runInShell(t, shell, "umask 027")
+	if _, dirs := runInShell(t, shell, "cd "+ShellEscape(dir)); dirs.cwd != dir {
+		resolved, _ := filepath.EvalSymlinks(dir)
+		if dirs.cwd != resolved {
+			t.Errorf("cwd after cd = %q, want %q", dirs.cwd, dir)
+		}
+	}
+
+	result, _ := runInShell(t, shell, "echo $SHERLOCK_TEST; umask; basename \"$PWD\"")
+	want := "persisted\n0027\n" + filepath.Base(dir) + "\n"
+	if result.Stdout != want {
+		t.Errorf("Stdout = %q, want %q", result.Stdout, want)
+	}
+}
+
+// TestPersistentShellDirs checks that the shell starts in, reports and
+// can be moved to the directories that commands in their own session see.
+func TestPersistentShellDirs(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(dir string) string { return filepath.Join(root, dir) }
+	for _, dir := range []string{"a", "b", "c"} {
+		if err := os.Mkdir(at(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shell := startLocalShellIn(t, dirState{cwd: at("a"), oldpwd: at("b"), stack: []string{at("c")}})
+
+	steps := []struct {
+		command    string
+		wantStdout string
+		wantDirs   dirState
+	}{
+		{command: "dirs", wantStdout: at("a") + " " + at("c") + "\n", wantDirs: dirState{cwd: at("a"), oldpwd: at("b"), stack: []string{at("c")}}},
+		{command: "cd -", wantStdout: at("b") + "\n", wantDirs: dirState{cwd: at("b"), oldpwd: at("a"), stack: []string{at("c")}}},
+		{command: "popd", wantStdout: at("c") + "\n", wantDirs: dirState{cwd: at("c"), oldpwd: at("b")}},
+		{command: "pushd ../a", wantStdout: at("a") + " " + at("c") + "\n", wantDirs: dirState{cwd: at("a"), oldpwd: at("c"), stack: []string{at("c")}}},
+	}
+	for _, step := range steps {
+		result, dirs := runInShell(t, shell, step.command)
+		if result.Stdout != step.wantStdout {
+			t.Errorf("%s: stdout = %q, want %q", step.command, result.Stdout, step.wantStdout)
+		}
+		if !dirs.equal(step.wantDirs) {
+			t.Errorf("%s: dirs = %+v, want %+v", step.command, dirs, step.wantDirs)
+		}
+	}
+
+	// A command in another session moved on to b
+	moved := dirState{cwd: at("b"), oldpwd: at("a")}
+	shell.enter(moved)
+	if result, dirs := runInShell(t, shell, "pwd; dirs"); result.Stdout != at("b")+"\n"+at("b")+"\n" || !dirs.equal(moved) {
+		t.Errorf("after enter(): stdout = %q, dirs = %+v; want %+v", result.Stdout, dirs, moved)
+	}
+	shell.enter(moved)
+	if result, _ := runInShell(t, shell, "cd - >/dev/null; pwd"); result.Stdout != at("a")+"\n" {
+		t.Errorf("cd - after enter() of the same directories: stdout = %q, want %s", result.Stdout, at("a"))
+	}
+}
+
+func TestPersistentShellExit(t *testing.T) {
+	shell := startLocalShell(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := shell.Run(ctx, "exit 0"); !errors.Is(err, ErrShellExited) {
+		t.Errorf("Run(exit) error = %v, want ErrShellExited", err)
+	}
+	if !shell.Exited() {
+		t.Errorf("Exited() = false after exit")
+	}
+	if _, _, err := shell.Run(ctx, "echo hi"); !errors.Is(err, ErrShellExited) {
+		t.Errorf("Run() after exit error = %v, want ErrShellExited", err)
+	}
+}
+
+func TestPersistentShellContextCancel(t *testing.T) {
+	defer func(grace time.Duration) { stopGrace = grace }(stopGrace)
+	stopGrace = 200 * time.Millisecond
+
+	shell := startLocalShell(t)
+	runInShell(t, shell, "export SHERLOCK_TEST=kept; umask 027")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, _, err := shell.Run(ctx, "echo started; sleep 5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Run() took %v to stop", time.Since(start))
+	}
+	if result == nil || shell.Exited() {
+		t.Fatalf("shell should survive an interrupted command, result = %+v", result)
+	}
+	if result.Stdout != "started\n" {
+		t.Errorf("Stdout = %q, want only the output before the stop", result.Stdout)
+	}
+
+	result, _ = runInShell(t, shell, "echo $SHERLOCK_TEST; umask")
+	if result.Stdout != "kept\n0027\n" {
+		t.Errorf("state after interrupt = %q, want kept and 0027", result.Stdout)
+	}
+}
+
+func TestPersistentShellDropsUnstoppableCommand(t *testing.T) {
+	defer func(grace time.Duration) { stopGrace = grace }(stopGrace)
+	stopGrace = 100 * time.Millisecond
+
+	shell := startLocalShell(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// A loop in the shell itself has no process to signal
+	if _, _, err := shell.Run(ctx, "while :; do :; done"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want context.DeadlineExceeded", err)
+	}
+	if !shell.Exited() {
+		t.Errorf("shell should be dropped when the command does not stop")
+	}
+}
+
+func TestPersistentShellRunStream(t *testing.T) {
+	shell := startLocalShell(t)
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{name: "lines", command: "echo one; sleep 0.3; printf two", want: "one\ntwo"},
+		// Progress redrawn with \r has no newline to wait for
+		{name: "progress", command: "printf ' 10%%\\r'; sleep 0.3; printf '100%%\\n'", want: " 10%\r100%\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out outputRecorder
+			var stderr strings.Builder
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			result, _, err := shell.RunStream(ctx, tt.command, &out, &stderr)
+			end := time.Now()
+			if err != nil {
+				t.Fatalf("RunStream() error = %v", err)
+			}
+
+			if result.ExitCode != 0 || stderr.Len() != 0 {
+				t.Errorf("RunStream() exit = %d, stderr = %q", result.ExitCode, stderr.String())
+			}
+			if out.String() != tt.want {
+				t.Fatalf("stdout = %q, want %q", out.String(), tt.want)
+			}
+			if out.first.Sub(start) >= end.Sub(start)-200*time.Millisecond {
+				t.Errorf("first output arrived only at the end of the command")
+			}
+		})
+	}
+}
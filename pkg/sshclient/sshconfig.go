@@ -18,164 +18,508 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"net"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-// SSHConfigHost represents a host entry from SSH config file.
+// maxIncludeDepth limits nested Include directives, to stop include loops.
+const maxIncludeDepth = 16
+
+// SSHConfigHost holds the effective settings of a host from the SSH config file.
 type SSHConfigHost struct {
-	Host         string   // Host pattern (alias)
+	Host         string   // Host alias as given by the user
 	Hostname     string   // Actual hostname or IP
 	Port         int      // SSH port
 	User         string   // Username
 	IdentityFile []string // Paths to identity files (private keys)
+	ProxyJump    string   // Jump hosts, as with ssh -J
+	ProxyCommand string   // Command whose stdio is the connection
+
+	ServerAliveInterval   time.Duration // Keepalive interval, 0 if disabled
+	ServerAliveCountMax   int           // Unanswered keepalives before giving up
+	ConnectTimeout        time.Duration // Connection timeout, 0 for the default
+	StrictHostKeyChecking string        // yes, no, ask or accept-new
+	UserKnownHostsFile    []string      // known_hosts files to use
+	IdentitiesOnly        bool          // Only use the configured identity files
+	ForwardAgent          bool          // Forward the local SSH agent
+	LocalForward          []string      // Local forwards, as with ssh -L
+	SendEnv               []string      // Patterns of environment variables to send
+	HostKeyAlgorithms     string        // Accepted host key algorithms, as written
+	HashKnownHosts        bool          // Hash host names added to known_hosts
 }
 
-// SSHConfig represents the parsed SSH config file.
+// SSHConfig represents the parsed SSH config file. Like OpenSSH, it keeps the
+// Host and Match blocks in file order and resolves a host by walking them,
+// with the first value of each setting winning.
 type SSHConfig struct {
-	hosts map[string]*SSHConfigHost
+	blocks []*configBlock
+}
+
+// configBlock is a run of directives under one Host or Match condition.
+type configBlock struct {
+	// cond is nil for the directives before the first Host or Match line. It
+	// is shared by the parts of a block that is split by an Include, so that
+	// the condition is evaluated once.
+	cond    *blockCondition
+	options []configOption
+}
+
+// blockCondition is the condition of a Host or Match line.
+type blockCondition struct {
+	hostPatterns []string         // Host patterns
+	match        []matchCriterion // Match criteria, if this is a Match line
+}
+
+// matchCriterion is one criterion of a Match line, e.g. "!host *.prod".
+type matchCriterion struct {
+	negate  bool
+	keyword string
+	arg     string
+}
+
+// configOption is a directive such as "Port 2222".
+type configOption struct {
+	key  string   // lowercase keyword
+	args []string // arguments, unquoted
+	raw  string   // everything after the keyword
 }
 
 // ParseSSHConfig parses the SSH config file (~/.ssh/config).
 func ParseSSHConfig() (*SSHConfig, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return &SSHConfig{hosts: make(map[string]*SSHConfigHost)}, nil
+		return &SSHConfig{}, nil
 	}
 
 	configPath := filepath.Join(homeDir, ".ssh", "config")
 	return ParseSSHConfigFile(configPath)
 }
 
-// ParseSSHConfigFile parses an SSH config file at the given path.
+// ParseSSHConfigFile parses an SSH config file at the given path. Relative
+// Include paths are resolved against the directory of the file.
 func ParseSSHConfigFile(configPath string) (*SSHConfig, error) {
-	config := &SSHConfig{hosts: make(map[string]*SSHConfigHost)}
-
-	file, err := os.Open(configPath)
-	if err != nil {
+	config := &SSHConfig{}
+	p := &configParser{config: config, baseDir: filepath.Dir(configPath)}
+	if err := p.parseFile(configPath, p.addBlock(nil), 0); err != nil {
 		if os.IsNotExist(err) {
 			return config, nil // Return empty config if file doesn't exist
 		}
 		return nil, err
 	}
-	defer file.Close()
+	return config, nil
+}
 
-	var currentHost *SSHConfigHost
-	scanner := bufio.NewScanner(file)
+// configParser reads config files into an SSHConfig.
+type configParser struct {
+	config  *SSHConfig
+	baseDir string
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+// addBlock starts a new block with the given condition.
+func (p *configParser) addBlock(cond *blockCondition) *configBlock {
+	block := &configBlock{cond: cond}
+	p.config.blocks = append(p.config.blocks, block)
+	return block
+}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+// parseFile adds the directives of a file, starting in the current block.
+func (p *configParser) parseFile(path string, current *configBlock, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-		// Split line into key and value
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		option, ok := parseConfigLine(scanner.Text())
+		if !ok {
 			continue
 		}
 
-		key := strings.ToLower(parts[0])
-		value := strings.Join(parts[1:], " ")
-
-		switch key {
+		switch option.key {
 		case "host":
-			// Start a new host entry
-			currentHost = &SSHConfigHost{
-				Host: value,
-				Port: 22, // Default port
+			current = p.addBlock(&blockCondition{hostPatterns: option.args})
+		case "match":
+			criteria, err := parseMatchCriteria(option.args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, err)
 			}
-			config.hosts[value] = currentHost
-		case "hostname":
-			if currentHost != nil {
-				currentHost.Hostname = value
+			current = p.addBlock(&blockCondition{match: criteria})
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: too many nested includes", path, lineNo)
 			}
-		case "port":
-			if currentHost != nil {
-				if port, err := strconv.Atoi(value); err == nil {
-					currentHost.Port = port
+			for _, pattern := range option.args {
+				pattern = expandPath(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(p.baseDir, pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					// The included file starts under the condition of the
+					// Include line, until its own first Host or Match
+					if err := p.parseFile(match, p.addBlock(current.cond), depth+1); err != nil && !os.IsNotExist(err) {
+						return err
+					}
 				}
 			}
-		case "user":
-			if currentHost != nil {
-				currentHost.User = value
-			}
-		case "identityfile":
-			if currentHost != nil {
-				// Expand ~ to home directory
-				expandedPath := expandPath(value)
-				currentHost.IdentityFile = append(currentHost.IdentityFile, expandedPath)
+			// The lines after the Include are under its condition again
+			current = p.addBlock(current.cond)
+		default:
+			current.options = append(current.options, option)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseConfigLine splits a config line into keyword and arguments. Both
+// "Key value" and "Key=value" are accepted, and arguments may be quoted.
+func parseConfigLine(line string) (configOption, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return configOption{}, false
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return configOption{}, false
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	if rest == "" {
+		return configOption{}, false
+	}
+	return configOption{key: key, args: splitConfigArgs(rest), raw: rest}, true
+}
+
+// splitConfigArgs splits arguments at whitespace outside double quotes.
+func splitConfigArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
 			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
 		}
 	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
 
-	return config, scanner.Err()
+// parseMatchCriteria parses the arguments of a Match line.
+func parseMatchCriteria(args []string) ([]matchCriterion, error) {
+	var criteria []matchCriterion
+	for i := 0; i < len(args); i++ {
+		c := matchCriterion{keyword: strings.ToLower(args[i])}
+		if strings.HasPrefix(c.keyword, "!") {
+			c.negate, c.keyword = true, c.keyword[1:]
+		}
+		switch c.keyword {
+		case "all", "canonical", "final":
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("Match %s needs an argument", c.keyword)
+			}
+			i++
+			c.arg = args[i]
+		default:
+			return nil, fmt.Errorf("unsupported Match criterion %q", c.keyword)
+		}
+		criteria = append(criteria, c)
+	}
+	if len(criteria) == 0 {
+		return nil, errors.New("Match needs a criterion")
+	}
+	return criteria, nil
 }
 
-// GetHost returns the SSH config for a given host alias or hostname.
-// It first looks for an exact match, then tries pattern matching with wildcards.
-// More specific patterns take priority over less specific ones.
+// GetHost returns the effective SSH config for a given host alias or hostname,
+// or nil if no settings apply to it.
 func (c *SSHConfig) GetHost(host string) *SSHConfigHost {
-	// Try exact match first
-	if h, ok := c.hosts[host]; ok {
-		return h
+	return c.Resolve(host, "")
+}
+
+// Resolve returns the effective SSH config for connecting to host as user,
+// or nil if no settings apply. user may be empty if it is not known yet.
+// Blocks are evaluated in order and the first value of each setting wins,
+// except for IdentityFile, LocalForward and SendEnv, which accumulate.
+func (c *SSHConfig) Resolve(host, user string) *SSHConfigHost {
+	r := &configResolver{
+		alias:   host,
+		user:    user,
+		result:  &SSHConfigHost{Host: host, Port: 22},
+		set:     make(map[string]bool),
+		matched: make(map[*blockCondition]bool),
 	}
 
-	// Try wildcard matching, prioritizing more specific patterns
-	var bestMatch *SSHConfigHost
-	bestSpecificity := -1
+	applied := false
+	for _, block := range c.blocks {
+		if len(block.options) == 0 || !r.matches(block.cond) {
+			continue
+		}
+		for _, option := range block.options {
+			r.apply(option)
+		}
+		applied = true
+	}
+	if !applied {
+		return nil
+	}
+	r.finish()
+	return r.result
+}
+
+// configResolver evaluates the blocks of a config for one host.
+type configResolver struct {
+	alias   string
+	user    string
+	result  *SSHConfigHost
+	set     map[string]bool
+	matched map[*blockCondition]bool
+}
 
-	for pattern, h := range c.hosts {
-		if matchHostPattern(pattern, host) {
-			specificity := patternSpecificity(pattern)
-			if specificity > bestSpecificity {
-				bestMatch = h
-				bestSpecificity = specificity
+// matches reports whether the directives under cond apply.
+func (r *configResolver) matches(cond *blockCondition) bool {
+	if cond == nil {
+		return true
+	}
+	if ok, seen := r.matched[cond]; seen {
+		return ok
+	}
+
+	var ok bool
+	if cond.match == nil {
+		ok = matchPatternList(cond.hostPatterns, r.alias)
+	} else {
+		ok = true
+		for _, c := range cond.match {
+			if r.matchCriterion(c) == c.negate {
+				ok = false
+				break
 			}
 		}
 	}
+	r.matched[cond] = ok
+	return ok
+}
 
-	return bestMatch
+func (r *configResolver) matchCriterion(c matchCriterion) bool {
+	switch c.keyword {
+	case "host":
+		return matchPatternList(strings.Split(c.arg, ","), r.hostname())
+	case "originalhost":
+		return matchPatternList(strings.Split(c.arg, ","), r.alias)
+	case "user":
+		return matchPatternList(strings.Split(c.arg, ","), r.remoteUser())
+	case "localuser":
+		return matchPatternList(strings.Split(c.arg, ","), localUsername())
+	case "exec":
+		cmd := exec.Command("sh", "-c", r.expandTokens(c.arg))
+		return cmd.Run() == nil
+	default:
+		// all, canonical and final: there is a single, final pass
+		return true
+	}
 }
 
-// patternSpecificity returns a score indicating how specific a pattern is.
-// Higher scores mean more specific patterns.
-func patternSpecificity(pattern string) int {
-	// "*" is the least specific
-	if pattern == "*" {
-		return 0
+// hostname returns the target hostname as configured so far.
+func (r *configResolver) hostname() string {
+	if r.result.Hostname != "" {
+		return r.result.Hostname
 	}
-	// Count non-wildcard characters for specificity
-	return len(pattern) - strings.Count(pattern, "*")
+	return r.alias
 }
 
-// matchHostPattern checks if a hostname matches a pattern (with * wildcard support).
-func matchHostPattern(pattern, host string) bool {
-	if pattern == "*" {
-		return true
+// remoteUser returns the user to log in as, as far as it is known.
+func (r *configResolver) remoteUser() string {
+	switch {
+	case r.user != "":
+		return r.user
+	case r.result.User != "":
+		return r.result.User
+	default:
+		return localUsername()
 	}
+}
+
+// expandTokens substitutes the ssh_config tokens %h, %n, %p, %r, %u, %d and %%.
+func (r *configResolver) expandTokens(s string) string {
+	home, _ := os.UserHomeDir()
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", r.hostname(),
+		"%n", r.alias,
+		"%p", strconv.Itoa(r.result.Port),
+		"%r", r.remoteUser(),
+		"%u", localUsername(),
+		"%d", home,
+	).Replace(s)
+}
 
-	// Handle patterns like "*.example.com"
-	if strings.HasPrefix(pattern, "*") {
-		suffix := pattern[1:]
-		return strings.HasSuffix(host, suffix)
+// apply applies a directive unless an earlier block already set it.
+func (r *configResolver) apply(option configOption) {
+	args := option.args
+	switch option.key {
+	case "identityfile":
+		r.result.IdentityFile = append(r.result.IdentityFile, args[0])
+		return
+	case "localforward":
+		if len(args) == 2 {
+			r.result.LocalForward = append(r.result.LocalForward, args[0]+":"+args[1])
+		}
+		return
+	case "sendenv":
+		r.result.SendEnv = append(r.result.SendEnv, args...)
+		return
 	}
 
-	// Handle patterns like "server*"
-	if strings.HasSuffix(pattern, "*") {
-		prefix := pattern[:len(pattern)-1]
-		return strings.HasPrefix(host, prefix)
+	if r.set[option.key] {
+		return
 	}
+	r.set[option.key] = true
 
-	return pattern == host
+	switch option.key {
+	case "hostname":
+		r.result.Hostname = strings.ReplaceAll(args[0], "%h", r.alias)
+	case "port":
+		if port, err := strconv.Atoi(args[0]); err == nil {
+			r.result.Port = port
+		}
+	case "user":
+		r.result.User = args[0]
+	case "proxyjump":
+		r.result.ProxyJump = args[0]
+	case "proxycommand":
+		// Keep the command's own quoting and spacing
+		r.result.ProxyCommand = option.raw
+	case "serveraliveinterval":
+		r.result.ServerAliveInterval = parseSeconds(args[0])
+	case "serveralivecountmax":
+		r.result.ServerAliveCountMax, _ = strconv.Atoi(args[0])
+	case "connecttimeout":
+		r.result.ConnectTimeout = parseSeconds(args[0])
+	case "stricthostkeychecking":
+		r.result.StrictHostKeyChecking = strings.ToLower(args[0])
+	case "userknownhostsfile":
+		r.result.UserKnownHostsFile = args
+	case "identitiesonly":
+		r.result.IdentitiesOnly = parseYes(args[0])
+	case "forwardagent":
+		// Besides yes and no, the value may name an agent socket
+		r.result.ForwardAgent = !strings.EqualFold(args[0], "no")
+	case "hostkeyalgorithms":
+		r.result.HostKeyAlgorithms = args[0]
+	case "hashknownhosts":
+		r.result.HashKnownHosts = parseYes(args[0])
+	default:
+		// Directives that Sherlock does not use
+		delete(r.set, option.key)
+	}
+}
+
+// finish fills in defaults and expands tokens in paths.
+func (r *configResolver) finish() {
+	if r.result.ServerAliveCountMax == 0 {
+		r.result.ServerAliveCountMax = 3
+	}
+	for i, path := range r.result.IdentityFile {
+		r.result.IdentityFile[i] = expandPath(r.expandTokens(path))
+	}
+	for i, path := range r.result.UserKnownHostsFile {
+		r.result.UserKnownHostsFile[i] = expandPath(r.expandTokens(path))
+	}
+}
+
+// parseSeconds parses a time in seconds, as used by ServerAliveInterval and
+// ConnectTimeout.
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// parseYes parses an ssh_config boolean.
+func parseYes(s string) bool {
+	return strings.EqualFold(s, "yes") || strings.EqualFold(s, "true")
+}
+
+// localUsername returns the name of the local user.
+func localUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// matchPatternList reports whether s matches a list of patterns: it must
+// match at least one pattern and none of the negated (!pattern) ones.
+func matchPatternList(patterns []string, s string) bool {
+	found := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchHostPattern(negated, s) {
+				return false
+			}
+			continue
+		}
+		if matchHostPattern(pattern, s) {
+			found = true
+		}
+	}
+	return found
+}
+
+// matchHostPattern checks if a hostname matches a pattern with the * and ?
+// wildcards. Matching is case-insensitive, like host names.
+func matchHostPattern(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	// Position to resume from after the last *
+	starP, starH := -1, 0
+	p, h := 0, 0
+	for h < len(host) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == host[h]):
+			p++
+			h++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starH = p, h
+			p++
+		case starP >= 0:
+			// Let the last * match one more character
+			starH++
+			p, h = starP+1, starH
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
 }
 
 // expandPath expands ~ to the user's home directory.
@@ -194,45 +538,42 @@ func GetKnownHostsPath() string {
 	return filepath.Join(homeDir, ".ssh", "known_hosts")
 }
 
-// CreateHostKeyCallback creates a host key callback that uses known_hosts file.
-// If the known_hosts file doesn't exist or can't be read, it falls back to
-// prompting the user or accepting the key (depending on strictHostKeyChecking).
+// CreateHostKeyCallback creates a host key callback that uses the known_hosts
+// file. With strictHostKeyChecking, unknown hosts are rejected; otherwise their
+// keys are trusted on first use and recorded. Changed keys are always rejected.
 func CreateHostKeyCallback(strictHostKeyChecking bool) ssh.HostKeyCallback {
-	knownHostsPath := GetKnownHostsPath()
+	return createHostKeyCallback(hostKeyPolicy{strict: strictHostKeyChecking, files: []string{GetKnownHostsPath()}})
+}
 
-	// Try to create a callback from known_hosts file
-	callback, err := knownhosts.New(knownHostsPath)
-	if err != nil {
-		// known_hosts file doesn't exist or can't be read
-		if strictHostKeyChecking {
-			// Return a callback that rejects all unknown hosts
-			return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-				return fmt.Errorf("host key verification failed: strict host key checking is enabled and host '%s' is not in known_hosts file (%s)", hostname, knownHostsPath)
+// parseHostKeyAlgorithms turns a HostKeyAlgorithms value into a list of
+// algorithms. A leading "+" appends to the defaults, "-" removes from them and
+// "^" puts the listed algorithms first. Wildcards are allowed in "-" lists.
+func parseHostKeyAlgorithms(value string) []string {
+	if value == "" {
+		return nil
+	}
+	defaults := ssh.SupportedAlgorithms().HostKeys
+	list := strings.Split(value[1:], ",")
+	switch value[0] {
+	case '+':
+		return append(append([]string(nil), defaults...), list...)
+	case '-':
+		var result []string
+		for _, algo := range defaults {
+			if !matchPatternList(list, algo) {
+				result = append(result, algo)
 			}
 		}
-		// Return a callback that accepts all hosts (like ssh with StrictHostKeyChecking=no)
-		return ssh.InsecureIgnoreHostKey()
-	}
-
-	if strictHostKeyChecking {
-		return callback
-	}
-
-	// Return a callback that accepts unknown hosts but validates known ones
-	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		err := callback(hostname, remote, key)
-		if err != nil {
-			// Check if it's a key error (unknown host vs. changed key)
-			var keyErr *knownhosts.KeyError
-			if errors.As(err, &keyErr) {
-				// If it's just an unknown host, accept it
-				if len(keyErr.Want) == 0 {
-					return nil
-				}
-				// If it's a changed key, reject it
-				return err
+		return result
+	case '^':
+		result := append([]string(nil), list...)
+		for _, algo := range defaults {
+			if !matchPatternList(list, algo) {
+				result = append(result, algo)
 			}
 		}
-		return err
+		return result
+	default:
+		return strings.Split(value, ",")
 	}
 }
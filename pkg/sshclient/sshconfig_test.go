@@ -18,6 +18,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestParseSSHConfigFile(t *testing.T) {
@@ -133,6 +136,9 @@ func TestMatchHostPattern(t *testing.T) {
 		{"server*", "myserver", false},
 		{"exact", "exact", true},
 		{"exact", "different", false},
+		{"web?", "web1", true},
+		{"web?", "web12", false},
+		{"*.EXAMPLE.com", "db.example.com", true},
 	}
 
 	for _, tt := range tests {
@@ -145,6 +151,25 @@ func TestMatchHostPattern(t *testing.T) {
 	}
 }
 
+func TestMatchPatternList(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{[]string{"web", "db"}, "db", true},
+		{[]string{"*.example.com", "!bastion.example.com"}, "web.example.com", true},
+		{[]string{"*.example.com", "!bastion.example.com"}, "bastion.example.com", false},
+		{[]string{"!bastion"}, "web", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPatternList(tt.patterns, tt.host); got != tt.want {
+			t.Errorf("matchPatternList(%q, %q) = %v, want %v", tt.patterns, tt.host, got, tt.want)
+		}
+	}
+}
+
 func TestExpandPath(t *testing.T) {
 	homeDir, _ := os.UserHomeDir()
 
@@ -179,18 +204,12 @@ func TestGetKnownHostsPath(t *testing.T) {
 }
 
 func TestApplySSHConfig(t *testing.T) {
-	// Create a mock SSH config
-	config := &SSHConfig{
-		hosts: map[string]*SSHConfigHost{
-			"myalias": {
-				Host:         "myalias",
-				Hostname:     "actual.host.com",
-				Port:         2222,
-				User:         "configuser",
-				IdentityFile: []string{"/path/to/key"},
-			},
-		},
-	}
+	config := writeSSHConfig(t, `Host myalias
+    Hostname actual.host.com
+    Port 2222
+    User configuser
+    IdentityFile /path/to/key
+`)
 
 	t.Run("apply_alias_settings", func(t *testing.T) {
 		hostInfo := &HostInfo{
@@ -258,3 +277,201 @@ func TestApplySSHConfig(t *testing.T) {
 		}
 	})
 }
+
+// writeSSHConfig writes content to a config file in a temporary directory and
+// parses it.
+func writeSSHConfig(t *testing.T, content string) *SSHConfig {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseSSHConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseSSHConfigFile failed: %v", err)
+	}
+	return config
+}
+
+func TestResolveFirstMatchWins(t *testing.T) {
+	config := writeSSHConfig(t, `Host web1 web2 !web3
+    User deploy
+    IdentityFile ~/.ssh/id_web
+    LocalForward 8080 localhost:80
+    SendEnv LANG LC_*
+
+Host web*
+    User other
+    Port=2200
+    IdentityFile ~/.ssh/id_default
+    ServerAliveInterval 15
+    ConnectTimeout 5
+    StrictHostKeyChecking yes
+    UserKnownHostsFile ~/.ssh/known_web /etc/ssh/known_web
+    IdentitiesOnly yes
+    ForwardAgent yes
+    HostKeyAlgorithms ssh-ed25519
+`)
+	homeDir, _ := os.UserHomeDir()
+
+	host := config.Resolve("web1", "")
+	if host == nil {
+		t.Fatal("Expected settings for web1")
+	}
+	if host.User != "deploy" || host.Port != 2200 {
+		t.Errorf("Resolve(web1) user, port = %q, %d, want deploy, 2200", host.User, host.Port)
+	}
+	wantKeys := []string{filepath.Join(homeDir, ".ssh/id_web"), filepath.Join(homeDir, ".ssh/id_default")}
+	if len(host.IdentityFile) != 2 || host.IdentityFile[0] != wantKeys[0] || host.IdentityFile[1] != wantKeys[1] {
+		t.Errorf("Resolve(web1).IdentityFile = %v, want %v", host.IdentityFile, wantKeys)
+	}
+	if host.ServerAliveInterval != 15*time.Second || host.ServerAliveCountMax != 3 || host.ConnectTimeout != 5*time.Second {
+		t.Errorf("Resolve(web1) alive, count, timeout = %v, %d, %v", host.ServerAliveInterval, host.ServerAliveCountMax, host.ConnectTimeout)
+	}
+	if host.StrictHostKeyChecking != "yes" || !host.IdentitiesOnly || !host.ForwardAgent || host.HostKeyAlgorithms != "ssh-ed25519" {
+		t.Errorf("Resolve(web1) = %+v, want strict, identities only, agent forwarding and ed25519 keys", host)
+	}
+	if len(host.UserKnownHostsFile) != 2 || host.UserKnownHostsFile[1] != "/etc/ssh/known_web" {
+		t.Errorf("Resolve(web1).UserKnownHostsFile = %v", host.UserKnownHostsFile)
+	}
+	if len(host.LocalForward) != 1 || host.LocalForward[0] != "8080:localhost:80" {
+		t.Errorf("Resolve(web1).LocalForward = %v, want [8080:localhost:80]", host.LocalForward)
+	}
+	if len(host.SendEnv) != 2 || host.SendEnv[1] != "LC_*" {
+		t.Errorf("Resolve(web1).SendEnv = %v, want [LANG LC_*]", host.SendEnv)
+	}
+
+	// The negated pattern excludes web3 from the first block
+	if host := config.Resolve("web3", ""); host == nil || host.User != "other" {
+		t.Errorf("Resolve(web3) = %+v, want user other", host)
+	}
+}
+
+func TestResolveMatch(t *testing.T) {
+	config := writeSSHConfig(t, `Host db
+    Hostname db.internal.example.com
+
+Match host *.internal.example.com user admin
+    Port 2222
+
+Match host *.internal.example.com !user admin
+    Port 2223
+
+Match exec "exit 1"
+    User never
+
+Match originalhost db exec "test %n = db"
+    ProxyJump bastion
+
+Match all
+    User fallback
+`)
+
+	tests := []struct {
+		host, user string
+		wantPort   int
+		wantUser   string
+		wantJump   string
+	}{
+		{host: "db", user: "admin", wantPort: 2222, wantUser: "fallback", wantJump: "bastion"},
+		{host: "db", user: "ops", wantPort: 2223, wantUser: "fallback", wantJump: "bastion"},
+		{host: "web", user: "admin", wantPort: 22, wantUser: "fallback"},
+	}
+	for _, tt := range tests {
+		host := config.Resolve(tt.host, tt.user)
+		if host == nil {
+			t.Fatalf("Resolve(%q, %q) = nil", tt.host, tt.user)
+		}
+		if host.Port != tt.wantPort || host.User != tt.wantUser || host.ProxyJump != tt.wantJump {
+			t.Errorf("Resolve(%q, %q) port, user, jump = %d, %q, %q, want %d, %q, %q", tt.host, tt.user,
+				host.Port, host.User, host.ProxyJump, tt.wantPort, tt.wantUser, tt.wantJump)
+		}
+	}
+}
+
+func TestParseSSHConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "conf.d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config": `Include conf.d/*.conf
+
+Host web
+    User main
+
+Host *
+    Include defaults
+`,
+		"conf.d/10-web.conf": "Host web\n    Hostname web.example.com\n",
+		"conf.d/20-db.conf":  "Host db\n    Hostname db.example.com\n    User dba\n",
+		"defaults":           "Port 2200\nUser default\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := ParseSSHConfigFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatalf("ParseSSHConfigFile failed: %v", err)
+	}
+
+	tests := []struct {
+		host         string
+		wantHostname string
+		wantUser     string
+		wantPort     int
+	}{
+		{host: "web", wantHostname: "web.example.com", wantUser: "main", wantPort: 2200},
+		{host: "db", wantHostname: "db.example.com", wantUser: "dba", wantPort: 2200},
+		{host: "other", wantUser: "default", wantPort: 2200},
+	}
+	for _, tt := range tests {
+		host := config.GetHost(tt.host)
+		if host == nil {
+			t.Fatalf("GetHost(%q) = nil", tt.host)
+		}
+		if host.Hostname != tt.wantHostname || host.User != tt.wantUser || host.Port != tt.wantPort {
+			t.Errorf("GetHost(%q) = %q %q %d, want %q %q %d", tt.host, host.Hostname, host.User, host.Port,
+				tt.wantHostname, tt.wantUser, tt.wantPort)
+		}
+	}
+}
+
+func TestParseHostKeyAlgorithms(t *testing.T) {
+	defaults := ssh.SupportedAlgorithms().HostKeys
+
+	if got := parseHostKeyAlgorithms(""); got != nil {
+		t.Errorf("parseHostKeyAlgorithms(\"\") = %v, want nil", got)
+	}
+	if got := parseHostKeyAlgorithms("ssh-ed25519,rsa-sha2-512"); len(got) != 2 || got[0] != "ssh-ed25519" {
+		t.Errorf("parseHostKeyAlgorithms(list) = %v", got)
+	}
+	if got := parseHostKeyAlgorithms("^ssh-ed25519"); len(got) != len(defaults) || got[0] != "ssh-ed25519" {
+		t.Errorf("parseHostKeyAlgorithms(^ssh-ed25519) = %v, want ssh-ed25519 first", got)
+	}
+	for _, alg := range parseHostKeyAlgorithms("-ssh-ed25519") {
+		if alg == "ssh-ed25519" {
+			t.Errorf("parseHostKeyAlgorithms(-ssh-ed25519) should remove ssh-ed25519")
+		}
+	}
+}
+
+func TestMatchEnvPatterns(t *testing.T) {
+	patterns := []string{"LC_*", "LANG", "-LC_ALL"}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"LANG", true},
+		{"LC_CTYPE", true},
+		{"LC_ALL", false},
+		{"PATH", false},
+	}
+	for _, tt := range tests {
+		if got := matchEnvPatterns(patterns, tt.name); got != tt.want {
+			t.Errorf("matchEnvPatterns(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultOutputLimit is the number of bytes an OutputBuffer keeps when no
+// limit is given.
+const DefaultOutputLimit = 1 << 20
+
+// OutputBuffer is an io.Writer that keeps at most a fixed number of bytes of
+// what is written to it: the beginning and the end, which hold the command
+// and its outcome, while the middle of long output is dropped. It is safe
+// for concurrent use.
+type OutputBuffer struct {
+	mu      sync.Mutex
+	limit   int
+	head    []byte
+	tail    []byte
+	total   int64
+	dropped int64
+}
+
+// NewOutputBuffer returns an OutputBuffer that keeps up to limit bytes, or
+// DefaultOutputLimit if limit is not positive.
+func NewOutputBuffer(limit int) *OutputBuffer {
+	if limit <= 0 {
+		limit = DefaultOutputLimit
+	}
+	return &OutputBuffer{limit: limit}
+}
+
+// Write implements io.Writer.
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	written := len(p)
+	b.total += int64(written)
+
+	headLimit := b.limit / 2
+	if n := min(headLimit-len(b.head), len(p)); n > 0 {
+		b.head = append(b.head, p[:n]...)
+		p = p[n:]
+	}
+	b.tail = append(b.tail, p...)
+	if tailLimit := b.limit - headLimit; len(b.tail) > tailLimit {
+		excess := len(b.tail) - tailLimit
+		b.dropped += int64(excess)
+		// Compact instead of reslicing forever, so memory stays bounded
+		b.tail = append(b.tail[:0], b.tail[excess:]...)
+	}
+	return written, nil
+}
+
+// String returns the kept output. If output was dropped, a note saying how
+// much takes its place.
+func (b *OutputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped == 0 {
+		return string(b.head) + string(b.tail)
+	}
+	return fmt.Sprintf("%s\n... [%d bytes omitted] ...\n%s", b.head, b.dropped, b.tail)
+}
+
+// Truncated reports whether output was dropped.
+func (b *OutputBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped > 0
+}
+
+// Len returns the number of bytes written, including dropped ones.
+func (b *OutputBuffer) Len() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
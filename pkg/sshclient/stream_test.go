@@ -0,0 +1,132 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		writes        []string
+		want          string
+		wantTruncated bool
+	}{
+		{name: "within limit", limit: 10, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "exactly the limit", limit: 6, writes: []string{"abcdef"}, want: "abcdef"},
+		{
+			name:          "keeps start and end",
+			limit:         6,
+			writes:        []string{"abcd", "efgh", "ij"},
+			want:          "abc\n... [4 bytes omitted] ...\nhij",
+			wantTruncated: true,
+		},
+		{
+			name:          "one large write",
+			limit:         4,
+			writes:        []string{strings.Repeat("x", 100) + "end"},
+			want:          "xx\n... [99 bytes omitted] ...\nnd",
+			wantTruncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewOutputBuffer(tt.limit)
+			total := 0
+			for _, s := range tt.writes {
+				if n, err := b.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+				total += len(s)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if b.Truncated() != tt.wantTruncated {
+				t.Errorf("Truncated() = %v, want %v", b.Truncated(), tt.wantTruncated)
+			}
+			if b.Len() != int64(total) {
+				t.Errorf("Len() = %d, want %d", b.Len(), total)
+			}
+		})
+	}
+}
+
+// outputRecorder records what is written to it and when the first write
+// arrived.
+type outputRecorder struct {
+	mu    sync.Mutex
+	text  strings.Builder
+	first time.Time
+}
+
+// Write implements io.Writer.
+func (r *outputRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.text.Len() == 0 {
+		r.first = time.Now()
+	}
+	return r.text.Write(p)
+}
+
+func (r *outputRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.text.String()
+}
+
+func TestLocalExecuteStream(t *testing.T) {
+	client := NewLocalClient()
+	client.cwd = t.TempDir()
+
+	var out, errOut outputRecorder
+	start := time.Now()
+	result := client.ExecuteStream(context.Background(), "echo first; sleep 0.3; echo second; echo oops >&2; exit 3", &out, &errOut)
+	end := time.Now()
+
+	if result.Error != nil || result.ExitCode != 3 {
+		t.Fatalf("ExecuteStream() = exit %d, error %v; want exit 3", result.ExitCode, result.Error)
+	}
+	if result.Stdout != "" || result.Stderr != "" {
+		t.Errorf("streamed output should not be buffered in the result")
+	}
+	if out.String() != "first\nsecond\n" || errOut.String() != "oops\n" {
+		t.Fatalf("stdout = %q, stderr = %q", out.String(), errOut.String())
+	}
+	if out.first.Sub(start) >= end.Sub(start)-200*time.Millisecond {
+		t.Errorf("first line arrived only at the end of the command")
+	}
+}
+
+func TestLocalExecuteWrapsStream(t *testing.T) {
+	client := NewLocalClient()
+	client.cwd = t.TempDir()
+
+	result := client.Execute(context.Background(), "echo out; echo err >&2")
+	if result.Stdout != "out\n" || result.Stderr != "err\n" || result.ExitCode != 0 {
+		t.Errorf("Execute() = %+v", result)
+	}
+	result = client.Execute(context.Background(), "cd /nonexistent-dir")
+	if result.ExitCode != 1 || !strings.Contains(result.Stderr, "No such file or directory") {
+		t.Errorf("Execute(cd) = %+v", result)
+	}
+}
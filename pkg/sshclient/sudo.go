@@ -0,0 +1,182 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultSudoPasswordTimeout is how long a sudo password is kept when no
+// timeout is given.
+const DefaultSudoPasswordTimeout = 5 * time.Minute
+
+// maxSudoAttempts is how often the sudo password is asked for.
+const maxSudoAttempts = 3
+
+// ErrSudoPassword is reported when no correct sudo password was given.
+var ErrSudoPassword = errors.New("sudo: incorrect password")
+
+// sudoRe matches sudo where a shell starts a command: at the start, after
+// ; & | ( or a backquote, or after then, do or else.
+var sudoRe = regexp.MustCompile("(?:^|[;&|(`\n]|\\$\\(|\\b(?:then|do|else))\\s*sudo(?:\\s|$)")
+
+// NeedsSudo reports whether command runs sudo.
+func NeedsSudo(command string) bool {
+	return sudoRe.MatchString(command)
+}
+
+// sudoValidate asks sudo for the password on stdin, without a prompt, so
+// that the sudo calls of the command that follows find valid credentials.
+const sudoValidate = "sudo -S -p '' -v 2>/dev/null"
+
+// The password of a command is only kept in the shell variable
+// __sherlock_pw: never in a file, the environment or an argument.
+const (
+	// sudoReadPassword reads the password from stdin.
+	sudoReadPassword = "IFS= read -r __sherlock_pw\n"
+	// sudoPrime validates the password, so that the sudo calls that
+	// follow find valid credentials.
+	sudoPrime = `printf '%s\n' "$__sherlock_pw" | sudo -S -p '' -v 2>/dev/null` + "\n"
+	// sudoFeed feeds the password to every sudo call that follows, ahead of
+	// the input of that call, for hosts where sudo keeps no credentials
+	// between calls. It turns off set -x before the password is printed.
+	sudoFeed = `sudo() { { { set +x; } 2>/dev/null; printf '%s\n' "$__sherlock_pw"; cat; } | command sudo -S -p '' "$@"; }` + "\n"
+)
+
+// sudoCheck succeeds if sudo works without a password, e.g. with NOPASSWD
+// or credentials that are still valid.
+const sudoCheck = "sudo -n true 2>/dev/null"
+
+// sudoState holds the sudo password of a host while it is valid. The
+// password is only kept in memory.
+type sudoState struct {
+	mu       sync.Mutex
+	prompt   PromptFunc
+	timeout  time.Duration // how long the password is kept; negative keeps it for one command
+	password string
+	expires  time.Time
+	// noTimestamp is set if sudo keeps no credentials between calls, e.g.
+	// with timestamp_timeout=0
+	noTimestamp bool
+}
+
+// newSudoState returns the sudo state for a host. A zero timeout uses
+// DefaultSudoPasswordTimeout.
+func newSudoState(prompt PromptFunc, timeout time.Duration) *sudoState {
+	if timeout == 0 {
+		timeout = DefaultSudoPasswordTimeout
+	}
+	return &sudoState{prompt: prompt, timeout: timeout}
+}
+
+// noSudoKey marks a context whose commands get no sudo password, because
+// their sudo is not run on this host, e.g. in a container.
+type noSudoKey struct{}
+
+// withoutSudo returns a context whose commands get no sudo password.
+func withoutSudo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noSudoKey{}, true)
+}
+
+// runFunc runs a command with stdin and returns its exit code.
+type runFunc func(ctx context.Context, command, stdin string) (int, error)
+
+// passwordFor returns the sudo password command needs, or "" if it needs
+// none: it does not run sudo on this host, sudo works without a password,
+// or there is no way to ask. The password is asked for at most maxSudoAttempts times and
+// checked with run before it is kept.
+func (s *sudoState) passwordFor(ctx context.Context, command, who string, run runFunc) (string, error) {
+	if s == nil || s.prompt == nil || !NeedsSudo(command) || ctx.Value(noSudoKey{}) != nil {
+		return "", nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.password != "" && time.Now().Before(s.expires) {
+		return s.password, nil
+	}
+	s.password = ""
+	if code, err := run(ctx, sudoCheck, ""); err != nil || code == 0 {
+		return "", err
+	}
+
+	instruction := ""
+	for attempt := 0; attempt < maxSudoAttempts; attempt++ {
+		password, err := s.prompt(instruction, fmt.Sprintf("[sudo] password for %s: ", who), false)
+		if err != nil {
+			return "", err
+		}
+		if password == "" {
+			return "", ErrSudoPassword
+		}
+		code, err := run(ctx, sudoValidate, password+"\n")
+		if err != nil {
+			return "", err
+		}
+		if code == 0 {
+			if code, err := run(ctx, sudoCheck, ""); err == nil && code != 0 {
+				s.noTimestamp = true
+			}
+			if s.timeout > 0 {
+				s.password = password
+				s.expires = time.Now().Add(s.timeout)
+			}
+			return password, nil
+		}
+		instruction = "Sorry, try again."
+	}
+	return "", ErrSudoPassword
+}
+
+// usePassword returns the commands that give the password in
+// __sherlock_pw to the sudo calls after them, and those that undo them.
+func (s *sudoState) usePassword() (use, undo string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.noTimestamp {
+		return sudoFeed, "unset -f sudo\n"
+	}
+	return sudoPrime, ""
+}
+
+// withPassword returns command prefixed so that its sudo calls get the
+// password, which is fed to it on stdin.
+func (s *sudoState) withPassword(command string) string {
+	use, _ := s.usePassword()
+	return sudoReadPassword + use + command
+}
+
+// inShell returns the commands to run in the persistent shell before and
+// after a command, so that its sudo calls get password.
+func (s *sudoState) inShell(password string) (before, after string) {
+	use, undo := s.usePassword()
+	return "__sherlock_pw=" + ShellEscape(password) + "\n" + use, undo + "unset __sherlock_pw\n"
+}
+
+// forget drops the kept password.
+func (s *sudoState) forget() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = ""
+	s.expires = time.Time{}
+}
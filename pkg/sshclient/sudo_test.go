@@ -0,0 +1,284 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNeedsSudo(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{command: "sudo systemctl restart nginx", want: true},
+		{command: "cd /etc && sudo vi hosts", want: true},
+		{command: "true; sudo ls", want: true},
+		{command: "ls || sudo ls", want: true},
+		{command: "if true; then sudo reboot; fi", want: true},
+		{command: "echo $(sudo cat /etc/shadow)", want: true},
+		{command: "echo x | sudo tee /etc/motd", want: true},
+		{command: "echo x|sudo tee -a /etc/hosts", want: true},
+		{command: "cat f | grep x | sudo dd of=/etc/f", want: true},
+		{command: "echo x | tee sudo.log", want: false},
+		{command: "ls sudoers.d", want: false},
+		{command: "echo sudo", want: false},
+		{command: "visudo -c", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := NeedsSudo(tt.command); got != tt.want {
+				t.Errorf("NeedsSudo(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSudo answers the commands sudoState runs: sudo -n fails unless
+// noPassword is set or the password was validated on a host that keeps
+// credentials, and sudo -S accepts the password "secret".
+type fakeSudo struct {
+	noPassword  bool
+	noTimestamp bool
+	validated   bool
+	answers     []string
+	prompts     int
+}
+
+func (f *fakeSudo) prompt(instruction, question string, echo bool) (string, error) {
+	if echo {
+		return "", errors.New("password asked with echo")
+	}
+	answer := f.answers[f.prompts]
+	f.prompts++
+	return answer, nil
+}
+
+func (f *fakeSudo) run(ctx context.Context, command, stdin string) (int, error) {
+	switch command {
+	case sudoCheck:
+		if f.noPassword || (f.validated && !f.noTimestamp) {
+			return 0, nil
+		}
+		return 1, nil
+	case sudoValidate:
+		if stdin == "secret\n" {
+			f.validated = true
+			return 0, nil
+		}
+		return 1, nil
+	}
+	return 127, nil
+}
+
+func TestSudoPasswordFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		noPassword  bool
+		answers     []string
+		want        string
+		wantErr     error
+		wantPrompts int
+	}{
+		{name: "no sudo", command: "ls", want: ""},
+		{name: "nopasswd", command: "sudo ls", noPassword: true, want: ""},
+		{name: "asked", command: "sudo ls", answers: []string{"secret"}, want: "secret", wantPrompts: 1},
+		{name: "retried", command: "sudo ls", answers: []string{"wrong", "secret"}, want: "secret", wantPrompts: 2},
+		{name: "gives up", command: "sudo ls", answers: []string{"a", "b", "c"}, wantErr: ErrSudoPassword, wantPrompts: 3},
+		{name: "cancelled", command: "sudo ls", answers: []string{""}, wantErr: ErrSudoPassword, wantPrompts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeSudo{noPassword: tt.noPassword, answers: tt.answers}
+			s := newSudoState(f.prompt, 0)
+			got, err := s.passwordFor(context.Background(), tt.command, "root@host", f.run)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("passwordFor() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if f.prompts != tt.wantPrompts {
+				t.Errorf("asked %d times, want %d", f.prompts, tt.wantPrompts)
+			}
+		})
+	}
+}
+
+func TestSudoPasswordKept(t *testing.T) {
+	// sudo itself keeps no credentials, so only the kept password saves a prompt
+	f := &fakeSudo{noTimestamp: true, answers: []string{"secret", "secret"}}
+	s := newSudoState(f.prompt, time.Hour)
+	for i := 0; i < 2; i++ {
+		if got, err := s.passwordFor(context.Background(), "sudo ls", "root@host", f.run); err != nil || got != "secret" {
+			t.Fatalf("passwordFor() = %q, %v, want secret", got, err)
+		}
+	}
+	if f.prompts != 1 {
+		t.Errorf("asked %d times while the password is kept, want 1", f.prompts)
+	}
+
+	s.forget()
+	if _, err := s.passwordFor(context.Background(), "sudo ls", "root@host", f.run); err != nil || f.prompts != 2 {
+		t.Errorf("after forget: err = %v, asked %d times, want a second prompt", err, f.prompts)
+	}
+
+	f = &fakeSudo{noTimestamp: true, answers: []string{"secret", "secret"}}
+	s = newSudoState(f.prompt, -1)
+	for i := 0; i < 2; i++ {
+		_, _ = s.passwordFor(context.Background(), "sudo ls", "root@host", f.run)
+	}
+	if f.prompts != 2 {
+		t.Errorf("with a negative timeout asked %d times, want 2", f.prompts)
+	}
+}
+
+func TestLocalExecuteSudo(t *testing.T) {
+	// A stand-in for sudo that wants the password "secret" on stdin once
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+-n) [ -f "$SUDO_STATE" ]; exit $?;;
+-S) read -r pw; [ "$pw" = secret ] || exit 1; touch "$SUDO_STATE"; exit 0;;
+esac
+[ -f "$SUDO_STATE" ] || { echo "sudo: a password is required" >&2; exit 1; }
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SUDO_STATE", filepath.Join(dir, "validated"))
+
+	f := &fakeSudo{answers: []string{"secret"}}
+	c := NewLocalClient()
+	c.SetSudoPrompt(f.prompt, 0)
+
+	var stdout, stderr bytes.Buffer
+	// The password must not reach the command's own stdin
+	result := c.ExecuteStream(context.Background(), "sudo echo ok; cat", &stdout, &stderr)
+	if result.Error != nil || result.ExitCode != 0 {
+		t.Fatalf("ExecuteStream() = exit %d, %v; stderr %q", result.ExitCode, result.Error, stderr.String())
+	}
+	if stdout.String() != "ok\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "ok\n")
+	}
+	if f.prompts != 1 {
+		t.Errorf("asked %d times, want 1", f.prompts)
+	}
+}
+
+func TestSudoWithPassword(t *testing.T) {
+	for _, noTimestamp := range []bool{false, true} {
+		f := &fakeSudo{noTimestamp: noTimestamp, answers: []string{"secret"}}
+		s := newSudoState(f.prompt, 0)
+		if _, err := s.passwordFor(context.Background(), "sudo ls", "root@host", f.run); err != nil {
+			t.Fatalf("passwordFor() error = %v", err)
+		}
+		got := s.withPassword("sudo ls")
+		want := sudoReadPassword + sudoPrime + "sudo ls"
+		if noTimestamp {
+			want = sudoReadPassword + sudoFeed + "sudo ls"
+		}
+		if got != want {
+			t.Errorf("noTimestamp %v: withPassword() = %q, want %q", noTimestamp, got, want)
+		}
+	}
+}
+
+// noTimestampSudo is a stand-in for sudo with timestamp_timeout=0: it keeps
+// no credentials, so every call needs the password "secret".
+const noTimestampSudo = `#!/bin/sh
+case "$1" in
+-n) exit 1;;
+-S)
+	shift 3
+	IFS= read -r pw
+	[ "$pw" = secret ] || { echo "sudo: incorrect password" >&2; exit 1; }
+	[ "$1" != -v ] || exit 0;;
+*) echo "sudo: a terminal is required to read the password" >&2; exit 1;;
+esac
+exec "$@"
+`
+
+// installSudo puts script first on PATH as sudo and returns its directory.
+func installSudo(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestLocalExecuteSudoNoTimestamp(t *testing.T) {
+	dir := installSudo(t, noTimestampSudo)
+
+	f := &fakeSudo{answers: []string{"secret"}}
+	c := NewLocalClient()
+	c.SetSudoPrompt(f.prompt, 0)
+	target := filepath.Join(dir, "motd")
+
+	var stdout, stderr bytes.Buffer
+	result := c.ExecuteStream(context.Background(), "echo hello | sudo tee "+ShellEscape(target)+"; env", &stdout, &stderr)
+	if result.Error != nil || result.ExitCode != 0 {
+		t.Fatalf("ExecuteStream() = exit %d, %v; stderr %q", result.ExitCode, result.Error, stderr.String())
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "hello\n" {
+		t.Errorf("file written through sudo = %q, %v, want hello", data, err)
+	}
+	if strings.Contains(stdout.String(), "secret") {
+		t.Errorf("the password leaked into the environment of the command: %q", stdout.String())
+	}
+	if f.prompts != 1 {
+		t.Errorf("asked %d times, want 1", f.prompts)
+	}
+}
+
+// TestPersistentShellSudo checks that a command given the sudo password
+// runs in the persistent shell with its state, and that the password is
+// neither traced nor left behind.
+func TestPersistentShellSudo(t *testing.T) {
+	dir := installSudo(t, noTimestampSudo)
+	target := filepath.Join(dir, "motd")
+	shell := startLocalShell(t)
+	runInShell(t, shell, "export SHERLOCK_TEST=kept; set -x")
+
+	s := &sudoState{noTimestamp: true}
+	shell.runAround(s.inShell("secret"))
+	result, _ := runInShell(t, shell, "echo hello | sudo tee "+ShellEscape(target)+"; echo $SHERLOCK_TEST")
+	if result.ExitCode != 0 || result.Stdout != "hello\nkept\n" {
+		t.Errorf("sudo command = exit %d, stdout %q, stderr %q", result.ExitCode, result.Stdout, result.Stderr)
+	}
+	if strings.Contains(result.Stderr, "secret") {
+		t.Errorf("the password was traced: %q", result.Stderr)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "hello\n" {
+		t.Errorf("file written through sudo = %q, %v, want hello", data, err)
+	}
+
+	result, _ = runInShell(t, shell, "set +x; echo ${__sherlock_pw-unset}; command -v sudo")
+	if want := "unset\n" + filepath.Join(dir, "sudo") + "\n"; result.Stdout != want {
+		t.Errorf("after the sudo command: stdout %q, want %q", result.Stdout, want)
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// transferBufferSize is the chunk size of file copies.
+const transferBufferSize = 32 * 1024
+
+// TransferProgress reports the progress of one file transfer.
+type TransferProgress struct {
+	// Path is the source path of the file.
+	Path string
+	// Bytes is the number of bytes of the file present at the destination.
+	Bytes int64
+	// Total is the size of the file.
+	Total int64
+	// Done is true when the file is complete.
+	Done bool
+}
+
+// TransferOptions controls an upload or download.
+type TransferOptions struct {
+	// Recursive copies directories with their contents.
+	Recursive bool
+	// NoResume restarts partial transfers from the beginning instead of
+	// appending to a shorter destination file.
+	NoResume bool
+	// Progress, if set, is called as files are transferred.
+	Progress func(p TransferProgress)
+}
+
+// TransferResult summarizes an upload or download.
+type TransferResult struct {
+	// Files is the number of files copied.
+	Files int
+	// Bytes is the number of bytes copied.
+	Bytes int64
+	// Resumed is the number of files continued from a partial copy.
+	Resumed int
+	// Skipped is the number of files already up to date.
+	Skipped int
+}
+
+// fileSystem is the side of a transfer a file is read from or written to.
+type fileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+	Open(name string) (io.ReadSeekCloser, error)
+	// OpenWriter opens name for writing at offset, creating or truncating it
+	// as needed.
+	OpenWriter(name string, offset int64) (io.WriteCloser, error)
+	MkdirAll(name string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, info os.FileInfo) error
+	Join(elem ...string) string
+	Base(name string) string
+}
+
+// localFS is the local file system.
+type localFS struct{}
+
+func (localFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+func (localFS) Open(name string) (io.ReadSeekCloser, error) { return os.Open(name) }
+
+func (localFS) OpenWriter(name string, offset int64) (io.WriteCloser, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(name, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localFS) MkdirAll(name string) error { return os.MkdirAll(name, 0755) }
+
+func (localFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+
+func (localFS) Chtimes(name string, info os.FileInfo) error {
+	return os.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+func (localFS) Join(elem ...string) string { return filepath.Join(elem...) }
+
+func (localFS) Base(name string) string { return filepath.Base(name) }
+
+// remoteFS is the file system of the remote host, accessed over SFTP.
+type remoteFS struct {
+	client *sftp.Client
+}
+
+func (r remoteFS) Stat(name string) (os.FileInfo, error) { return r.client.Stat(name) }
+
+func (r remoteFS) ReadDir(name string) ([]os.FileInfo, error) { return r.client.ReadDir(name) }
+
+func (r remoteFS) Glob(pattern string) ([]string, error) { return r.client.Glob(pattern) }
+
+func (r remoteFS) Open(name string) (io.ReadSeekCloser, error) { return r.client.Open(name) }
+
+func (r remoteFS) OpenWriter(name string, offset int64) (io.WriteCloser, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := r.client.OpenFile(name, flags)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r remoteFS) MkdirAll(name string) error { return r.client.MkdirAll(name) }
+
+func (r remoteFS) Chmod(name string, mode os.FileMode) error { return r.client.Chmod(name, mode) }
+
+func (r remoteFS) Chtimes(name string, info os.FileInfo) error {
+	return r.client.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+func (remoteFS) Join(elem ...string) string { return path.Join(elem...) }
+
+func (remoteFS) Base(name string) string { return path.Base(name) }
+
+// SFTP returns the SFTP client of the connection, opening it on first use.
+func (c *Client) SFTP() (*sftp.Client, error) {
+	if err := c.ensureConnected(context.Background()); err != nil {
+		return nil, err
+	}
+	if c.sftpClient == nil {
+		client, err := sftp.NewClient(c.client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+		}
+		c.sftpClient = client
+	}
+	return c.sftpClient, nil
+}
+
+// Upload copies local files matching the patterns to remoteDest. Relative local
+// paths are resolved against localCwd and relative remote paths against the
+// tracked remote cwd.
+func (c *Client) Upload(ctx context.Context, patterns []string, remoteDest, localCwd string, opts TransferOptions) (*TransferResult, error) {
+	client, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	remoteDest, err = c.remotePath(client, remoteDest)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]string, len(patterns))
+	for i, p := range patterns {
+		sources[i] = localPath(p, localCwd)
+	}
+	return transfer(ctx, localFS{}, remoteFS{client}, sources, remoteDest, opts)
+}
+
+// Download copies remote files matching the patterns to localDest. Relative
+// remote paths are resolved against the tracked remote cwd and relative local
+// paths against localCwd.
+func (c *Client) Download(ctx context.Context, patterns []string, localDest, localCwd string, opts TransferOptions) (*TransferResult, error) {
+	client, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]string, len(patterns))
+	for i, p := range patterns {
+		if sources[i], err = c.remotePath(client, p); err != nil {
+			return nil, err
+		}
+	}
+	return transfer(ctx, remoteFS{client}, localFS{}, sources, localPath(localDest, localCwd), opts)
+}
+
+// remotePath resolves a remote path against the tracked cwd, or the home
+// directory if no cwd is tracked yet.
+func (c *Client) remotePath(client *sftp.Client, p string) (string, error) {
+	if path.IsAbs(p) {
+		return p, nil
+	}
+	// The SFTP server starts in the user's home directory
+	home, err := client.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote home directory: %w", err)
+	}
+	switch {
+	case p == "~":
+		return home, nil
+	case strings.HasPrefix(p, "~/"):
+		return path.Join(home, p[2:]), nil
+	case c.cwd != "":
+		return path.Join(c.cwd, p), nil
+	default:
+		return path.Join(home, p), nil
+	}
+}
+
+// localPath resolves a local path against cwd.
+func localPath(p, cwd string) string {
+	p = expandPath(p)
+	if filepath.IsAbs(p) || cwd == "" {
+		return p
+	}
+	return filepath.Join(cwd, p)
+}
+
+// transfer copies the files matching the source patterns from src to dest. If
+// there are several sources or dest is a directory, the files are copied into
+// dest; otherwise dest is the name of the copy.
+func transfer(ctx context.Context, src, dst fileSystem, patterns []string, dest string, opts TransferOptions) (*TransferResult, error) {
+	var sources []string
+	for _, pattern := range patterns {
+		matches, err := src.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", pattern)
+		}
+		sources = append(sources, matches...)
+	}
+
+	intoDir := len(sources) > 1 || strings.HasSuffix(dest, "/")
+	if info, err := dst.Stat(dest); err == nil && info.IsDir() {
+		intoDir = true
+	}
+	if intoDir {
+		if err := dst.MkdirAll(dest); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dest, err)
+		}
+	}
+
+	result := &TransferResult{}
+	for _, source := range sources {
+		target := dest
+		if intoDir {
+			target = dst.Join(dest, src.Base(source))
+		}
+		if err := copyTree(ctx, src, dst, source, target, opts, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// copyTree copies a file, or a directory if opts.Recursive is set.
+func copyTree(ctx context.Context, src, dst fileSystem, source, target string, opts TransferOptions, result *TransferResult) error {
+	info, err := src.Stat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(ctx, src, dst, source, target, info, opts, result)
+	}
+	if !opts.Recursive {
+		return fmt.Errorf("%s is a directory (use -r to copy directories)", source)
+	}
+
+	if err := dst.MkdirAll(target); err != nil {
+		return fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	entries, err := src.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyTree(ctx, src, dst, src.Join(source, entry.Name()), dst.Join(target, entry.Name()), opts, result); err != nil {
+			return err
+		}
+	}
+	_ = dst.Chmod(target, info.Mode().Perm())
+	_ = dst.Chtimes(target, info)
+	return nil
+}
+
+// copyFile copies one file, resuming a partial copy at the destination and
+// preserving the permissions and modification time. A shorter file at the
+// destination is only taken for a partial copy if it matches the start of
+// the source; otherwise it is replaced.
+func copyFile(ctx context.Context, src, dst fileSystem, source, target string, info os.FileInfo, opts TransferOptions, result *TransferResult) error {
+	report := func(n int64, done bool) {
+		if opts.Progress != nil {
+			opts.Progress(TransferProgress{Path: source, Bytes: n, Total: info.Size(), Done: done})
+		}
+	}
+
+	var offset int64
+	if existing, err := dst.Stat(target); err == nil && existing.Mode().IsRegular() {
+		switch {
+		// SFTP keeps modification times to the second
+		case existing.Size() == info.Size() && existing.ModTime().Unix() == info.ModTime().Unix():
+			result.Skipped++
+			report(info.Size(), true)
+			return nil
+		case !opts.NoResume && existing.Size() < info.Size() && samePrefix(src, dst, source, target, existing.Size()):
+			offset = existing.Size()
+			result.Resumed++
+		}
+	}
+
+	in, err := src.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := dst.OpenWriter(target, offset)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", target, err)
+	}
+
+	written := offset
+	buf := make([]byte, transferBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			out.Close()
+			return err
+		}
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				out.Close()
+				return fmt.Errorf("failed to write %s: %w", target, err)
+			}
+			written += int64(n)
+			result.Bytes += int64(n)
+			report(written, false)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			out.Close()
+			return fmt.Errorf("failed to read %s: %w", source, readErr)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+
+	if err := dst.Chmod(target, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", target, err)
+	}
+	if err := dst.Chtimes(target, info); err != nil {
+		return fmt.Errorf("failed to set times of %s: %w", target, err)
+	}
+
+	result.Files++
+	report(written, true)
+	return nil
+}
+
+// samePrefix reports whether the first n bytes of source and target have
+// the same SHA-256 hash.
+func samePrefix(src, dst fileSystem, source, target string, n int64) bool {
+	hash := func(fs fileSystem, name string) ([]byte, error) {
+		f, err := fs.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.CopyN(h, f, n); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+	want, err := hash(src, source)
+	if err != nil {
+		return false
+	}
+	got, err := hash(dst, target)
+	return err == nil && bytes.Equal(got, want)
+}
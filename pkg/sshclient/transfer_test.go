@@ -0,0 +1,244 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestSFTP connects an SFTP client to an in-process server that serves the
+// local file system.
+func newTestSFTP(t *testing.T) remoteFS {
+	t.Helper()
+	serverRead, clientWrite := io.Pipe()
+	clientRead, serverWrite := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverRead, serverWrite})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientRead, clientWrite)
+	if err != nil {
+		t.Fatalf("NewClientPipe() error = %v", err)
+	}
+	t.Cleanup(func() {
+		// Closing the server ends the client's receive loop
+		server.Close()
+		client.Close()
+	})
+	return remoteFS{client}
+}
+
+func writeTestFile(t *testing.T, name, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error = %v", name, err)
+	}
+	return string(data)
+}
+
+func TestTransferUploadPreservesAttributes(t *testing.T) {
+	remote := newTestSFTP(t)
+	src, dst := t.TempDir(), t.TempDir()
+
+	name := filepath.Join(src, "app.conf")
+	writeTestFile(t, name, "listen 80\n", 0640)
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := transfer(context.Background(), localFS{}, remote, []string{name}, dst+"/", TransferOptions{})
+	if err != nil {
+		t.Fatalf("transfer() error = %v", err)
+	}
+	if result.Files != 1 || result.Bytes != 10 {
+		t.Errorf("result = %+v, want 1 file of 10 bytes", result)
+	}
+
+	target := filepath.Join(dst, "app.conf")
+	if got := readTestFile(t, target); got != "listen 80\n" {
+		t.Errorf("uploaded content = %q", got)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("uploaded mode = %v, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("uploaded mtime = %v, want %v", info.ModTime(), mtime)
+	}
+}
+
+func TestTransferDownloadGlobAndRecursive(t *testing.T) {
+	remote := newTestSFTP(t)
+	src, dst := t.TempDir(), t.TempDir()
+
+	writeTestFile(t, filepath.Join(src, "nginx", "access.log"), "GET /\n", 0644)
+	writeTestFile(t, filepath.Join(src, "nginx", "error.log"), "oops\n", 0644)
+	writeTestFile(t, filepath.Join(src, "nginx", "old", "access.log.1"), "GET /old\n", 0644)
+	writeTestFile(t, filepath.Join(src, "syslog"), "boot\n", 0644)
+
+	// Globs match files only at their level
+	result, err := transfer(context.Background(), remote, localFS{}, []string{filepath.Join(src, "nginx", "*.log")}, filepath.Join(dst, "logs"), TransferOptions{})
+	if err != nil {
+		t.Fatalf("transfer(glob) error = %v", err)
+	}
+	if result.Files != 2 {
+		t.Errorf("transfer(glob) copied %d files, want 2", result.Files)
+	}
+	if got := readTestFile(t, filepath.Join(dst, "logs", "error.log")); got != "oops\n" {
+		t.Errorf("error.log = %q", got)
+	}
+
+	// Directories need the recursive option
+	if _, err := transfer(context.Background(), remote, localFS{}, []string{filepath.Join(src, "nginx")}, dst, TransferOptions{}); err == nil || !strings.Contains(err.Error(), "-r") {
+		t.Errorf("transfer(dir) error = %v, want a hint to use -r", err)
+	}
+	result, err = transfer(context.Background(), remote, localFS{}, []string{filepath.Join(src, "nginx")}, dst, TransferOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("transfer(recursive) error = %v", err)
+	}
+	if result.Files != 3 {
+		t.Errorf("transfer(recursive) copied %d files, want 3", result.Files)
+	}
+	if got := readTestFile(t, filepath.Join(dst, "nginx", "old", "access.log.1")); got != "GET /old\n" {
+		t.Errorf("nested file = %q", got)
+	}
+
+	if _, err := transfer(context.Background(), remote, localFS{}, []string{filepath.Join(src, "*.gz")}, dst, TransferOptions{}); err == nil {
+		t.Errorf("transfer() of an unmatched pattern should fail")
+	}
+}
+
+func TestTransferResumeAndSkip(t *testing.T) {
+	remote := newTestSFTP(t)
+	src, dst := t.TempDir(), t.TempDir()
+
+	name := filepath.Join(src, "backup.tar")
+	content := strings.Repeat("0123456789", 10000)
+	writeTestFile(t, name, content, 0600)
+
+	// A partial copy from an interrupted transfer
+	target := filepath.Join(dst, "backup.tar")
+	writeTestFile(t, target, content[:30000], 0600)
+
+	var last TransferProgress
+	result, err := transfer(context.Background(), localFS{}, remote, []string{name}, target, TransferOptions{
+		Progress: func(p TransferProgress) { last = p },
+	})
+	if err != nil {
+		t.Fatalf("transfer() error = %v", err)
+	}
+	if result.Resumed != 1 || result.Bytes != int64(len(content)-30000) {
+		t.Errorf("result = %+v, want a resumed copy of the remaining bytes", result)
+	}
+	if got := readTestFile(t, target); got != content {
+		t.Errorf("resumed copy differs from the source (%d bytes)", len(got))
+	}
+	if !last.Done || last.Bytes != last.Total {
+		t.Errorf("last progress = %+v, want done", last)
+	}
+
+	result, err = transfer(context.Background(), localFS{}, remote, []string{name}, target, TransferOptions{})
+	if err != nil {
+		t.Fatalf("transfer() again error = %v", err)
+	}
+	if result.Skipped != 1 || result.Bytes != 0 {
+		t.Errorf("result = %+v, want the up-to-date file skipped", result)
+	}
+}
+
+// TestTransferReplacesDifferentFile checks that a shorter file that is not
+// a partial copy of the source is replaced rather than appended to.
+func TestTransferReplacesDifferentFile(t *testing.T) {
+	remote := newTestSFTP(t)
+	src, dst := t.TempDir(), t.TempDir()
+
+	name := filepath.Join(src, "nginx.conf")
+	content := "worker_processes 4;\nevents { worker_connections 1024; }\n"
+	writeTestFile(t, name, content, 0644)
+	target := filepath.Join(dst, "nginx.conf")
+	writeTestFile(t, target, "worker_processes 1;\n", 0644)
+
+	result, err := transfer(context.Background(), localFS{}, remote, []string{name}, target, TransferOptions{})
+	if err != nil {
+		t.Fatalf("transfer() error = %v", err)
+	}
+	if result.Resumed != 0 || result.Bytes != int64(len(content)) {
+		t.Errorf("result = %+v, want a full copy", result)
+	}
+	if got := readTestFile(t, target); got != content {
+		t.Errorf("target = %q, want %q", got, content)
+	}
+}
+
+func TestTransferCancelled(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	name := filepath.Join(src, "big")
+	writeTestFile(t, name, strings.Repeat("x", 4*transferBufferSize), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := transfer(ctx, localFS{}, localFS{}, []string{name}, dst, TransferOptions{}); err != context.Canceled {
+		t.Errorf("transfer() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	tests := []struct {
+		path string
+		cwd  string
+		want string
+	}{
+		{path: "/etc/hosts", cwd: "/tmp", want: "/etc/hosts"},
+		{path: "logs", cwd: "/tmp", want: "/tmp/logs"},
+		{path: "logs", cwd: "", want: "logs"},
+		{path: "~/logs", cwd: "/tmp", want: filepath.Join(home, "logs")},
+	}
+
+	for _, tt := range tests {
+		if got := localPath(tt.path, tt.cwd); got != tt.want {
+			t.Errorf("localPath(%q, %q) = %q, want %q", tt.path, tt.cwd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TunnelKind is the direction of a port forward.
+type TunnelKind string
+
+const (
+	// TunnelLocal listens locally and connects from the remote host (ssh -L).
+	TunnelLocal TunnelKind = "local"
+	// TunnelRemote listens on the remote host and connects locally (ssh -R).
+	TunnelRemote TunnelKind = "remote"
+	// TunnelDynamic is a local SOCKS5 proxy that connects from the remote
+	// host (ssh -D).
+	TunnelDynamic TunnelKind = "dynamic"
+)
+
+// defaultBindAddress is the listen address of a tunnel without an explicit
+// bind address. Like OpenSSH, tunnels only listen on the loopback interface.
+const defaultBindAddress = "127.0.0.1"
+
+// TunnelSpec describes a port forward in OpenSSH syntax.
+type TunnelSpec struct {
+	Kind TunnelKind
+	// Listen is the address the tunnel accepts connections on.
+	Listen string
+	// Target is the address connections are forwarded to. It is empty for
+	// dynamic tunnels, whose clients choose the target.
+	Target string
+}
+
+// String returns the spec in OpenSSH command-line syntax.
+func (s TunnelSpec) String() string {
+	switch s.Kind {
+	case TunnelLocal:
+		return "-L " + s.Listen + ":" + s.Target
+	case TunnelRemote:
+		return "-R " + s.Listen + ":" + s.Target
+	default:
+		return "-D " + s.Listen
+	}
+}
+
+// ParseTunnelSpec parses a forward given as with ssh -L, -R or -D:
+// "[bind:]port:host:hostport" for local and remote tunnels, and "[bind:]port"
+// for dynamic tunnels. IPv6 addresses are written in brackets.
+func ParseTunnelSpec(kind TunnelKind, spec string) (TunnelSpec, error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return TunnelSpec{}, err
+	}
+
+	result := TunnelSpec{Kind: kind}
+	var listenParts []string
+	switch kind {
+	case TunnelLocal, TunnelRemote:
+		if len(parts) != 3 && len(parts) != 4 {
+			return TunnelSpec{}, fmt.Errorf("invalid forward %q: want [bind:]port:host:hostport", spec)
+		}
+		n := len(parts)
+		if _, err := parsePort(parts[n-1]); err != nil {
+			return TunnelSpec{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+		}
+		result.Target = net.JoinHostPort(parts[n-2], parts[n-1])
+		listenParts = parts[:n-2]
+	case TunnelDynamic:
+		if len(parts) != 1 && len(parts) != 2 {
+			return TunnelSpec{}, fmt.Errorf("invalid dynamic forward %q: want [bind:]port", spec)
+		}
+		listenParts = parts
+	default:
+		return TunnelSpec{}, fmt.Errorf("unknown tunnel kind %q", kind)
+	}
+
+	bind, port := defaultBindAddress, listenParts[len(listenParts)-1]
+	if len(listenParts) == 2 {
+		bind = listenParts[0]
+		if bind == "*" || bind == "" {
+			bind = "0.0.0.0"
+		}
+	}
+	if _, err := parsePort(port); err != nil {
+		return TunnelSpec{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+	}
+	result.Listen = net.JoinHostPort(bind, port)
+	return result, nil
+}
+
+// splitForwardSpec splits a forward spec at colons outside brackets.
+func splitForwardSpec(spec string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inBrackets := false
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBrackets:
+			inBrackets = true
+		case r == ']' && inBrackets:
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inBrackets {
+		return nil, fmt.Errorf("invalid forward %q: unbalanced brackets", spec)
+	}
+	return append(parts, current.String()), nil
+}
+
+// parsePort parses a TCP port number.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// TunnelStats is a snapshot of the traffic of a tunnel.
+type TunnelStats struct {
+	// Active is the number of open connections.
+	Active int64
+	// Total is the number of connections accepted so far.
+	Total int64
+	// BytesSent is the number of bytes forwarded from clients to targets.
+	BytesSent int64
+	// BytesReceived is the number of bytes forwarded from targets to clients.
+	BytesReceived int64
+}
+
+// dialFunc opens the far side of a forwarded connection.
+type dialFunc func(network, address string) (net.Conn, error)
+
+// Tunnel is a running port forward. Connections are accepted and forwarded in
+// the background until the tunnel is closed.
+type Tunnel struct {
+	ID      int
+	Spec    TunnelSpec
+	Started time.Time
+
+	listener net.Listener
+	dial     dialFunc
+
+	active        atomic.Int64
+	total         atomic.Int64
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newTunnel starts forwarding the connections accepted by listener.
+func newTunnel(id int, spec TunnelSpec, listener net.Listener, dial dialFunc) *Tunnel {
+	t := &Tunnel{
+		ID:       id,
+		Spec:     spec,
+		Started:  time.Now(),
+		listener: listener,
+		dial:     dial,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	t.wg.Add(1)
+	go t.serve()
+	return t
+}
+
+// Addr returns the address the tunnel listens on. For a spec with port 0 it
+// holds the port that was assigned.
+func (t *Tunnel) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Stats returns the traffic of the tunnel so far.
+func (t *Tunnel) Stats() TunnelStats {
+	return TunnelStats{
+		Active:        t.active.Load(),
+		Total:         t.total.Load(),
+		BytesSent:     t.bytesSent.Load(),
+		BytesReceived: t.bytesReceived.Load(),
+	}
+}
+
+// Close stops the tunnel and closes its open connections.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.listener.Close()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (t *Tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !t.track(conn) {
+			conn.Close()
+			return
+		}
+		t.total.Add(1)
+		t.active.Add(1)
+		t.wg.Add(1)
+		go t.handle(conn)
+	}
+}
+
+// track registers an open connection so that Close can interrupt it. It
+// returns false if the tunnel is closed.
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// handle forwards one accepted connection.
+func (t *Tunnel) handle(conn net.Conn) {
+	defer t.wg.Done()
+	defer t.active.Add(-1)
+	defer t.untrack(conn)
+	defer conn.Close()
+
+	target := t.Spec.Target
+	if t.Spec.Kind == TunnelDynamic {
+		var err error
+		if target, err = socksHandshake(conn); err != nil {
+			return
+		}
+	}
+
+	remote, err := t.dial("tcp", target)
+	if t.Spec.Kind == TunnelDynamic {
+		if replyErr := socksReply(conn, err); replyErr != nil && err == nil {
+			err = replyErr
+		}
+	}
+	if err != nil {
+		if remote != nil {
+			remote.Close()
+		}
+		return
+	}
+	if !t.track(remote) {
+		remote.Close()
+		return
+	}
+	defer t.untrack(remote)
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn, counter *atomic.Int64) {
+		_, _ = io.Copy(&countingWriter{w: dst, n: counter}, src)
+		// Let the other side finish sending its response
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(remote, conn, &t.bytesSent)
+	go pipe(conn, remote, &t.bytesReceived)
+	<-done
+	<-done
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// SOCKS5 protocol constants (RFC 1928).
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+	socksCmdConnect   = 1
+	socksAtypIPv4     = 1
+	socksAtypDomain   = 3
+	socksAtypIPv6     = 4
+	socksSucceeded    = 0
+	socksFailure      = 1
+	socksCmdNotSupp   = 7
+)
+
+// socksHandshake reads a SOCKS5 greeting and CONNECT request from conn and
+// returns the requested target address.
+func socksHandshake(conn net.Conn) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	hasNoAuth := false
+	for _, m := range methods {
+		if m == socksNoAuth {
+			hasNoAuth = true
+		}
+	}
+	if !hasNoAuth {
+		_, _ = conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", errors.New("SOCKS client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	if request[1] != socksCmdConnect {
+		_, _ = conn.Write([]byte{socksVersion, socksCmdNotSupp, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if request[3] == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply tells the SOCKS client whether the connection to the target was
+// established.
+func socksReply(conn net.Conn, dialErr error) error {
+	status := byte(socksSucceeded)
+	if dialErr != nil {
+		status = socksFailure
+	}
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// AddTunnel starts a port forward over the connection.
+func (c *Client) AddTunnel(spec TunnelSpec) (*Tunnel, error) {
+	if err := c.ensureConnected(context.Background()); err != nil {
+		return nil, err
+	}
+
+	var listener net.Listener
+	var dial dialFunc
+	var err error
+	switch spec.Kind {
+	case TunnelLocal, TunnelDynamic:
+		listener, err = net.Listen("tcp", spec.Listen)
+		dial = c.dialRemote
+	case TunnelRemote:
+		listener, err = c.client.Listen("tcp", spec.Listen)
+		dial = net.Dial
+	default:
+		return nil, fmt.Errorf("unknown tunnel kind %q", spec.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", spec.Listen, err)
+	}
+
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	c.lastTunnelID++
+	tunnel := newTunnel(c.lastTunnelID, spec, listener, dial)
+	c.tunnels = append(c.tunnels, tunnel)
+	return tunnel, nil
+}
+
+// Tunnels returns the running tunnels in the order they were added.
+func (c *Client) Tunnels() []*Tunnel {
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	return append([]*Tunnel(nil), c.tunnels...)
+}
+
+// CloseTunnel stops the tunnel with the given ID.
+func (c *Client) CloseTunnel(id int) error {
+	c.tunnelMu.Lock()
+	var tunnel *Tunnel
+	for i, t := range c.tunnels {
+		if t.ID == id {
+			tunnel = t
+			c.tunnels = append(c.tunnels[:i], c.tunnels[i+1:]...)
+			break
+		}
+	}
+	c.tunnelMu.Unlock()
+
+	if tunnel == nil {
+		return fmt.Errorf("no tunnel with ID %d", id)
+	}
+	return tunnel.Close()
+}
+
+// closeTunnels stops all tunnels.
+func (c *Client) closeTunnels() {
+	c.tunnelMu.Lock()
+	tunnels := c.tunnels
+	c.tunnels = nil
+	c.tunnelMu.Unlock()
+
+	for _, t := range tunnels {
+		_ = t.Close()
+	}
+}
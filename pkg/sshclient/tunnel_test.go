@@ -0,0 +1,221 @@
+// Copyright 2024 Sherlock Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTunnelSpec(t *testing.T) {
+	tests := []struct {
+		kind    TunnelKind
+		spec    string
+		want    TunnelSpec
+		wantErr bool
+	}{
+		{kind: TunnelLocal, spec: "8080:localhost:80", want: TunnelSpec{TunnelLocal, "127.0.0.1:8080", "localhost:80"}},
+		{kind: TunnelLocal, spec: "0.0.0.0:5432:db.internal:5432", want: TunnelSpec{TunnelLocal, "0.0.0.0:5432", "db.internal:5432"}},
+		{kind: TunnelLocal, spec: "*:3000:[::1]:3000", want: TunnelSpec{TunnelLocal, "0.0.0.0:3000", "[::1]:3000"}},
+		{kind: TunnelRemote, spec: "9000:127.0.0.1:3000", want: TunnelSpec{TunnelRemote, "127.0.0.1:9000", "127.0.0.1:3000"}},
+		{kind: TunnelDynamic, spec: "1080", want: TunnelSpec{TunnelDynamic, "127.0.0.1:1080", ""}},
+		{kind: TunnelDynamic, spec: "[::1]:1080", want: TunnelSpec{TunnelDynamic, "[::1]:1080", ""}},
+		{kind: TunnelLocal, spec: "8080", wantErr: true},
+		{kind: TunnelLocal, spec: "8080:localhost:http", wantErr: true},
+		{kind: TunnelDynamic, spec: "99999", wantErr: true},
+		{kind: TunnelLocal, spec: "[::1:80:host:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.kind)+" "+tt.spec, func(t *testing.T) {
+			got, err := ParseTunnelSpec(tt.kind, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTunnelSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseTunnelSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+// startEchoServer returns the address of a server that echoes each line.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startTestTunnel runs a tunnel that forwards with plain TCP connections.
+func startTestTunnel(t *testing.T, spec TunnelSpec) *Tunnel {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := newTunnel(1, spec, ln, net.Dial)
+	t.Cleanup(func() { tunnel.Close() })
+	return tunnel
+}
+
+// roundTrip writes a line to conn and reads the echo.
+func roundTrip(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if got != line+"\n" {
+		t.Errorf("echo = %q, want %q", got, line+"\n")
+	}
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Errorf("timed out waiting for %s", what)
+}
+
+func TestTunnelForwardsAndCounts(t *testing.T) {
+	target := startEchoServer(t)
+	tunnel := startTestTunnel(t, TunnelSpec{Kind: TunnelLocal, Target: target})
+
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, conn, "hello")
+
+	stats := tunnel.Stats()
+	if stats.Active != 1 || stats.Total != 1 || stats.BytesSent != 6 || stats.BytesReceived != 6 {
+		t.Errorf("Stats() = %+v, want one active connection with 6 bytes each way", stats)
+	}
+
+	conn.Close()
+	waitFor(t, "the connection to end", func() bool { return tunnel.Stats().Active == 0 })
+}
+
+func TestTunnelCloseEndsConnections(t *testing.T) {
+	target := startEchoServer(t)
+	tunnel := startTestTunnel(t, TunnelSpec{Kind: TunnelLocal, Target: target})
+
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "ping")
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("connection should be closed with the tunnel")
+	}
+	if _, err := net.Dial("tcp", tunnel.Addr()); err == nil {
+		t.Errorf("tunnel should stop listening when closed")
+	}
+}
+
+func TestTunnelSOCKS5(t *testing.T) {
+	target := startEchoServer(t)
+	tunnel := startTestTunnel(t, TunnelSpec{Kind: TunnelDynamic})
+
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("method reply = %v, %v, want no authentication", reply, err)
+	}
+
+	// CONNECT by domain name
+	host, portStr, _ := net.SplitHostPort(target)
+	host = strings.Replace(host, "127.0.0.1", "localhost", 1)
+	port, _ := parsePort(portStr)
+	request := []byte{5, 1, 0, 3, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("connect reply = %v, %v, want success", reply, err)
+	}
+
+	roundTrip(t, conn, "through socks")
+}
+
+func TestSOCKS5RejectsAuthOnlyClients(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := socksHandshake(server)
+		errc <- err
+	}()
+	// Only username/password authentication
+	if _, err := client.Write([]byte{5, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socksNoAcceptable {
+		t.Errorf("method reply = %v, %v, want no acceptable methods", reply, err)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("socksHandshake() should fail")
+	}
+}